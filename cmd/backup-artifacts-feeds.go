@@ -24,14 +24,22 @@ var backupArtifactsFeedsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, backupArtSourceProject, "artifacts/feeds")
+		snap, err := beginSnapshot(sourceOrgName, sourceOrgCfg, backupArtSourceProject, internal.KindArtifactsFeeds, nil)
+		if err != nil {
+			return err
+		}
 
-		return internal.BackupArtifactsFeeds(
+		err = internal.BackupArtifactsFeeds(
 			sourceOrgCfg.URL,
 			backupArtSourceProject,
-			bkp,
+			snap.Path(internal.KindArtifactsFeeds),
 			backupArtResourceGUID,
+			newExecOptions(sourceOrgCfg),
 		)
+		if err == nil {
+			err = writeRefIndex(sourceOrgCfg, backupArtSourceProject, snap.Dir(), backupArtResourceGUID)
+		}
+		return finishSnapshot(snap, internal.KindArtifactsFeeds, err)
 	},
 }
 
@@ -40,9 +48,9 @@ func init() {
 
 	backupArtifactsFeedsCmd.Flags().StringVar(&backupArtSourceOrg, "source-org", "", "Source organization")
 	backupArtifactsFeedsCmd.Flags().StringVar(&backupArtSourceProject, "source-project", "", "Source project")
-	backupArtifactsFeedsCmd.Flags().StringVar(&backupArtResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	backupArtifactsFeedsCmd.Flags().StringVar(&backupArtResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
+	addParallelFlags(backupArtifactsFeedsCmd)
 
 	backupArtifactsFeedsCmd.MarkFlagRequired("source-org")
 	backupArtifactsFeedsCmd.MarkFlagRequired("source-project")
-	backupArtifactsFeedsCmd.MarkFlagRequired("ado-resource-guid")
 }
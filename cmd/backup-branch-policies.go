@@ -26,15 +26,23 @@ var backupBranchPoliciesCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, backupPolSourceProject, "branch-policies")
+		snap, err := beginSnapshot(sourceOrgName, sourceOrgCfg, backupPolSourceProject, internal.KindBranchPolicies, backupPolRepos)
+		if err != nil {
+			return err
+		}
 
-		return internal.BackupBranchPolicies(
+		err = internal.BackupBranchPolicies(
 			sourceOrgCfg.URL,
 			backupPolSourceProject,
-			bkp,
+			snap.Path(internal.KindBranchPolicies),
 			backupPolRepos,
 			backupPolResourceGUID,
+			newExecOptions(sourceOrgCfg),
 		)
+		if err == nil {
+			err = writeRefIndex(sourceOrgCfg, backupPolSourceProject, snap.Dir(), backupPolResourceGUID)
+		}
+		return finishSnapshot(snap, internal.KindBranchPolicies, err)
 	},
 }
 
@@ -43,10 +51,10 @@ func init() {
 
 	backupBranchPoliciesCmd.Flags().StringVar(&backupPolSourceOrg, "source-org", "", "Source organization")
 	backupBranchPoliciesCmd.Flags().StringVar(&backupPolSourceProject, "source-project", "", "Source project")
-	backupBranchPoliciesCmd.Flags().StringSliceVar(&backupPolRepos, "repos", []string{"all"}, "Repo names or 'all' (filters policies by scope.repositoryId, includes repoId=null policies too)")
-	backupBranchPoliciesCmd.Flags().StringVar(&backupPolResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	backupBranchPoliciesCmd.Flags().StringSliceVar(&backupPolRepos, "repos", []string{"all"}, "Repo names, 'all', globs, re:REGEX, !exclusions or @file (filters policies by scope.repositoryId, includes repoId=null policies too)")
+	backupBranchPoliciesCmd.Flags().StringVar(&backupPolResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
+	addParallelFlags(backupBranchPoliciesCmd)
 
 	backupBranchPoliciesCmd.MarkFlagRequired("source-org")
 	backupBranchPoliciesCmd.MarkFlagRequired("source-project")
-	backupBranchPoliciesCmd.MarkFlagRequired("ado-resource-guid")
 }
@@ -26,15 +26,23 @@ var backupBuildDefsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, bldSourceProject, "build-definitions")
+		snap, err := beginSnapshot(sourceOrgName, sourceOrgCfg, bldSourceProject, internal.KindBuildDefinitions, bldNames)
+		if err != nil {
+			return err
+		}
 
-		return internal.BackupBuildDefinitions(
+		err = internal.BackupBuildDefinitions(
 			sourceOrgCfg.URL,
 			bldSourceProject,
-			bkp,
+			snap.Path(internal.KindBuildDefinitions),
 			bldNames,
 			bldResourceGUID,
+			newExecOptions(sourceOrgCfg),
 		)
+		if err == nil {
+			err = writeRefIndex(sourceOrgCfg, bldSourceProject, snap.Dir(), bldResourceGUID)
+		}
+		return finishSnapshot(snap, internal.KindBuildDefinitions, err)
 	},
 }
 
@@ -43,11 +51,11 @@ func init() {
 
 	backupBuildDefsCmd.Flags().StringVar(&bldSourceOrg, "source-org", "", "Source organization")
 	backupBuildDefsCmd.Flags().StringVar(&bldSourceProject, "source-project", "", "Source project")
-	backupBuildDefsCmd.Flags().StringSliceVar(&bldNames, "definitions", []string{}, "Build definition names or 'all'")
-	backupBuildDefsCmd.Flags().StringVar(&bldResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	backupBuildDefsCmd.Flags().StringSliceVar(&bldNames, "definitions", []string{}, "Build definition names, 'all', folders (\\Team\\**), globs, re:REGEX, !exclusions or @file (see README)")
+	backupBuildDefsCmd.Flags().StringVar(&bldResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
+	addParallelFlags(backupBuildDefsCmd)
 
 	backupBuildDefsCmd.MarkFlagRequired("source-org")
 	backupBuildDefsCmd.MarkFlagRequired("source-project")
 	backupBuildDefsCmd.MarkFlagRequired("definitions")
-	backupBuildDefsCmd.MarkFlagRequired("ado-resource-guid")
 }
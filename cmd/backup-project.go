@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var backupProjSourceOrg string
+var backupProjSourceProject string
+var backupProjInclude []string
+var backupProjExclude []string
+var backupProjResourceGUID string
+
+var backupProjectCmd = &cobra.Command{
+	Use:   "backup-project",
+	Short: "Backup every resource kind of a project in one run (repos, policies, pipelines, groups, feeds, wikis)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kinds, err := internal.SelectKinds(backupProjInclude, backupProjExclude)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := mustLoadConfig()
+		if err != nil {
+			return err
+		}
+
+		sourceOrgName, sourceOrgCfg, err := cfg.ResolveOrganizationWithName(backupProjSourceOrg)
+		if err != nil {
+			return err
+		}
+
+		snap, err := internal.NewSnapshot(projectRoot(sourceOrgName, sourceOrgCfg, backupProjSourceProject), sourceOrgCfg, backupProjSourceProject)
+		if err != nil {
+			return fmt.Errorf("create snapshot failed: %w", err)
+		}
+		root := snap.Dir()
+		fmt.Printf("Backing up %s/%s to %s\n", sourceOrgCfg.URL, backupProjSourceProject, root)
+		fmt.Println("Kinds:", strings.Join(kinds, ", "))
+
+		report, runErr := internal.BackupProject(
+			sourceOrgCfg.URL,
+			backupProjSourceProject,
+			root,
+			kinds,
+			backupProjResourceGUID,
+			newExecOptions(sourceOrgCfg),
+		)
+		var ok, failed []string
+		if report != nil {
+			for _, k := range report.Kinds {
+				switch {
+				case k.Status != internal.KindStatusOK:
+					failed = append(failed, k.Kind)
+				case slices.Contains(kinds, k.Kind):
+					ok = append(ok, k.Kind)
+				}
+			}
+		}
+		if err := snap.Finish(ok, failed); err != nil {
+			return fmt.Errorf("finish snapshot failed: %w", err)
+		}
+		if report == nil {
+			return runErr
+		}
+
+		fmt.Println("\nSummary:")
+		for _, k := range report.Kinds {
+			if k.Status == internal.KindStatusOK {
+				fmt.Printf(" ✔ %-20s %s\n", k.Kind, k.Duration)
+			} else {
+				fmt.Printf(" ✖ %-20s %s  %s\n", k.Kind, k.Duration, k.Error)
+			}
+		}
+		fmt.Println("Report:", filepath.Join(root, "backup-report.json"))
+
+		if runErr != nil {
+			return fmt.Errorf("backup-project: %w", runErr)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backupProjectCmd)
+
+	backupProjectCmd.Flags().StringVar(&backupProjSourceOrg, "source-org", "", "Source organization")
+	backupProjectCmd.Flags().StringVar(&backupProjSourceProject, "source-project", "", "Source project")
+	backupProjectCmd.Flags().StringSliceVar(&backupProjInclude, "include", []string{"all"}, "Kinds to back up or 'all': "+strings.Join(internal.AllKinds, ","))
+	backupProjectCmd.Flags().StringSliceVar(&backupProjExclude, "exclude", []string{}, "Kinds to skip")
+	backupProjectCmd.Flags().StringVar(&backupProjResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
+	addParallelFlags(backupProjectCmd)
+
+	backupProjectCmd.MarkFlagRequired("source-org")
+	backupProjectCmd.MarkFlagRequired("source-project")
+}
@@ -25,15 +25,23 @@ var backupReleaseDefinitionsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, orgName, orgCfg, backupRelSourceProject, "release-definitions")
+		snap, err := beginSnapshot(orgName, orgCfg, backupRelSourceProject, internal.KindReleaseDefinitions, backupRelDefinitions)
+		if err != nil {
+			return err
+		}
 
-		return internal.BackupReleaseDefinitions(
+		err = internal.BackupReleaseDefinitions(
 			orgCfg.URL,
 			backupRelSourceProject,
-			bkp,
+			snap.Path(internal.KindReleaseDefinitions),
 			backupRelDefinitions,
 			backupRelAdoResourceGUID,
+			newExecOptions(orgCfg),
 		)
+		if err == nil {
+			err = writeRefIndex(orgCfg, backupRelSourceProject, snap.Dir(), backupRelAdoResourceGUID)
+		}
+		return finishSnapshot(snap, internal.KindReleaseDefinitions, err)
 	},
 }
 
@@ -42,11 +50,11 @@ func init() {
 
 	backupReleaseDefinitionsCmd.Flags().StringVar(&backupRelSourceOrg, "source-org", "", "Source organization")
 	backupReleaseDefinitionsCmd.Flags().StringVar(&backupRelSourceProject, "source-project", "", "Source project")
-	backupReleaseDefinitionsCmd.Flags().StringSliceVar(&backupRelDefinitions, "definitions", []string{}, "Release definition names or 'all'")
-	backupReleaseDefinitionsCmd.Flags().StringVar(&backupRelAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	backupReleaseDefinitionsCmd.Flags().StringSliceVar(&backupRelDefinitions, "definitions", []string{}, "Release definition names, 'all', folders (\\Team\\**), globs, re:REGEX, !exclusions or @file (see README)")
+	backupReleaseDefinitionsCmd.Flags().StringVar(&backupRelAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
+	addParallelFlags(backupReleaseDefinitionsCmd)
 
 	backupReleaseDefinitionsCmd.MarkFlagRequired("source-org")
 	backupReleaseDefinitionsCmd.MarkFlagRequired("source-project")
 	backupReleaseDefinitionsCmd.MarkFlagRequired("definitions")
-	backupReleaseDefinitionsCmd.MarkFlagRequired("ado-resource-guid")
 }
@@ -25,15 +25,23 @@ var backupServiceConnectionsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, backupSCSourceProject, "service-connections")
+		snap, err := beginSnapshot(sourceOrgName, sourceOrgCfg, backupSCSourceProject, internal.KindServiceConnections, backupSCNames)
+		if err != nil {
+			return err
+		}
 
-		return internal.BackupServiceConnections(
+		err = internal.BackupServiceConnections(
 			sourceOrgCfg.URL,
 			backupSCSourceProject,
-			bkp,
+			snap.Path(internal.KindServiceConnections),
 			backupSCNames,
 			backupSCAdoResourceGUID,
+			newExecOptions(sourceOrgCfg),
 		)
+		if err == nil {
+			err = writeRefIndex(sourceOrgCfg, backupSCSourceProject, snap.Dir(), backupSCAdoResourceGUID)
+		}
+		return finishSnapshot(snap, internal.KindServiceConnections, err)
 	},
 }
 
@@ -42,11 +50,11 @@ func init() {
 
 	backupServiceConnectionsCmd.Flags().StringVar(&backupSCSourceOrg, "source-org", "", "Source organization")
 	backupServiceConnectionsCmd.Flags().StringVar(&backupSCSourceProject, "source-project", "", "Source project")
-	backupServiceConnectionsCmd.Flags().StringSliceVar(&backupSCNames, "connections", []string{}, "Service connection names or 'all'")
-	backupServiceConnectionsCmd.Flags().StringVar(&backupSCAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	backupServiceConnectionsCmd.Flags().StringSliceVar(&backupSCNames, "connections", []string{}, "Service connection names, 'all', globs, re:REGEX, !exclusions or @file (see README)")
+	backupServiceConnectionsCmd.Flags().StringVar(&backupSCAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
+	addParallelFlags(backupServiceConnectionsCmd)
 
 	backupServiceConnectionsCmd.MarkFlagRequired("source-org")
 	backupServiceConnectionsCmd.MarkFlagRequired("source-project")
 	backupServiceConnectionsCmd.MarkFlagRequired("connections")
-	backupServiceConnectionsCmd.MarkFlagRequired("ado-resource-guid")
 }
@@ -26,15 +26,23 @@ var backupTaskGroupsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, backupTGSourceProject, "task-groups")
+		snap, err := beginSnapshot(sourceOrgName, sourceOrgCfg, backupTGSourceProject, internal.KindTaskGroups, backupTGroups)
+		if err != nil {
+			return err
+		}
 
-		return internal.BackupTaskGroups(
+		err = internal.BackupTaskGroups(
 			sourceOrgCfg.URL,
 			backupTGSourceProject,
-			bkp,
+			snap.Path(internal.KindTaskGroups),
 			backupTGroups,
 			backupAdoResourceGUID,
+			newExecOptions(sourceOrgCfg),
 		)
+		if err == nil {
+			err = writeRefIndex(sourceOrgCfg, backupTGSourceProject, snap.Dir(), backupAdoResourceGUID)
+		}
+		return finishSnapshot(snap, internal.KindTaskGroups, err)
 	},
 }
 
@@ -59,18 +67,18 @@ func init() {
 		&backupTGroups,
 		"groups",
 		[]string{},
-		"Task group names or 'all'",
+		"Task group names, 'all', globs, re:REGEX, !exclusions or @file (see README)",
 	)
 
 	backupTaskGroupsCmd.Flags().StringVar(
 		&backupAdoResourceGUID,
 		"ado-resource-guid",
 		"",
-		"Azure DevOps AAD resource GUID (required for az rest)",
+		"Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)",
 	)
+	addParallelFlags(backupTaskGroupsCmd)
 
 	backupTaskGroupsCmd.MarkFlagRequired("source-org")
 	backupTaskGroupsCmd.MarkFlagRequired("source-project")
 	backupTaskGroupsCmd.MarkFlagRequired("groups")
-	backupTaskGroupsCmd.MarkFlagRequired("ado-resource-guid")
 }
@@ -25,14 +25,22 @@ var backupVariableGroupsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, orgName, orgCfg, backupVarSourceProject, "variable-groups")
+		snap, err := beginSnapshot(orgName, orgCfg, backupVarSourceProject, internal.KindVariableGroups, backupVarGroups)
+		if err != nil {
+			return err
+		}
 
-		return internal.BackupVariableGroups(
+		err = internal.BackupVariableGroups(
 			orgCfg.URL,
 			backupVarSourceProject,
-			bkp,
+			snap.Path(internal.KindVariableGroups),
 			backupVarGroups,
+			newExecOptions(orgCfg),
 		)
+		if err == nil {
+			err = writeRefIndex(orgCfg, backupVarSourceProject, snap.Dir(), "")
+		}
+		return finishSnapshot(snap, internal.KindVariableGroups, err)
 	},
 }
 
@@ -58,8 +66,9 @@ func init() {
 		&backupVarGroups,
 		"groups",
 		[]string{},
-		"Variable group names or 'all'",
+		"Variable group names, 'all', globs, re:REGEX, !exclusions or @file (see README)",
 	)
+	addParallelFlags(backupVariableGroupsCmd)
 
 	backupVariableGroupsCmd.MarkFlagRequired("source-org")
 	backupVariableGroupsCmd.MarkFlagRequired("source-project")
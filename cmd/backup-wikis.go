@@ -25,15 +25,23 @@ var backupWikisCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, backupWikisSourceProject, "wikis")
+		snap, err := beginSnapshot(sourceOrgName, sourceOrgCfg, backupWikisSourceProject, internal.KindWikis, backupWikisSelected)
+		if err != nil {
+			return err
+		}
 
-		return internal.BackupWikis(
+		err = internal.BackupWikis(
 			sourceOrgCfg.URL,
 			backupWikisSourceProject,
-			bkp,
+			snap.Path(internal.KindWikis),
 			backupWikisSelected,
 			backupWikisResourceGUID,
+			newExecOptions(sourceOrgCfg),
 		)
+		if err == nil {
+			err = writeRefIndex(sourceOrgCfg, backupWikisSourceProject, snap.Dir(), backupWikisResourceGUID)
+		}
+		return finishSnapshot(snap, internal.KindWikis, err)
 	},
 }
 
@@ -42,10 +50,10 @@ func init() {
 
 	backupWikisCmd.Flags().StringVar(&backupWikisSourceOrg, "source-org", "", "Source organization")
 	backupWikisCmd.Flags().StringVar(&backupWikisSourceProject, "source-project", "", "Source project")
-	backupWikisCmd.Flags().StringSliceVar(&backupWikisSelected, "wikis", []string{"all"}, "Wiki names/ids or 'all'")
-	backupWikisCmd.Flags().StringVar(&backupWikisResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	backupWikisCmd.Flags().StringSliceVar(&backupWikisSelected, "wikis", []string{"all"}, "Wiki names/ids, 'all', globs, re:REGEX, !exclusions or @file (see README)")
+	backupWikisCmd.Flags().StringVar(&backupWikisResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
+	addParallelFlags(backupWikisCmd)
 
 	backupWikisCmd.MarkFlagRequired("source-org")
 	backupWikisCmd.MarkFlagRequired("source-project")
-	backupWikisCmd.MarkFlagRequired("ado-resource-guid")
 }
@@ -25,15 +25,23 @@ var backupYamlPipelinesCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, backupYamlSourceProject, "yaml-pipelines")
+		snap, err := beginSnapshot(sourceOrgName, sourceOrgCfg, backupYamlSourceProject, internal.KindYamlPipelines, backupYamlPipelines)
+		if err != nil {
+			return err
+		}
 
-		return internal.BackupYamlPipelines(
+		err = internal.BackupYamlPipelines(
 			sourceOrgCfg.URL,
 			backupYamlSourceProject,
-			bkp,
+			snap.Path(internal.KindYamlPipelines),
 			backupYamlPipelines,
 			backupYamlAdoResourceGUID,
+			newExecOptions(sourceOrgCfg),
 		)
+		if err == nil {
+			err = writeRefIndex(sourceOrgCfg, backupYamlSourceProject, snap.Dir(), backupYamlAdoResourceGUID)
+		}
+		return finishSnapshot(snap, internal.KindYamlPipelines, err)
 	},
 }
 
@@ -56,17 +64,17 @@ func init() {
 		&backupYamlPipelines,
 		"pipelines",
 		[]string{},
-		"Pipeline names or 'all'",
+		"Pipeline names, 'all', folders (\\Team\\**), globs, re:REGEX, !exclusions or @file (see README)",
 	)
 	backupYamlPipelinesCmd.Flags().StringVar(
 		&backupYamlAdoResourceGUID,
 		"ado-resource-guid",
 		"",
-		"Azure DevOps AAD resource GUID (required for az rest)",
+		"Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)",
 	)
+	addParallelFlags(backupYamlPipelinesCmd)
 
 	backupYamlPipelinesCmd.MarkFlagRequired("source-org")
 	backupYamlPipelinesCmd.MarkFlagRequired("source-project")
 	backupYamlPipelinesCmd.MarkFlagRequired("pipelines")
-	backupYamlPipelinesCmd.MarkFlagRequired("ado-resource-guid")
 }
@@ -3,8 +3,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"azdo-vault/internal"
+	"azdo-vault/internal/adoclient"
 
 	"github.com/spf13/cobra"
 )
@@ -16,13 +19,25 @@ var configureCmd = &cobra.Command{
 
 var addOrgName string
 var addOrgUrl string
+var addAuth internal.AuthConfig
+var addRetry internal.RetryConfig
+var addParallelism int
+var addCollectionURL string
+var addHosts adoclient.Hosts
+var addAPIProfile string
+var addBackupRoot string
+var addResourceGUID string
+var addProxy string
+var addRetention internal.RetentionConfig
+var addSigningKeyFile string
+var addEncryption internal.EncryptionConfig
 
 var configureAddCmd = &cobra.Command{
 	Use:   "add",
-	Short: "Add an Azure DevOps {organization} short name (not the full URL) : https://dev.azure.com/{organization}",
+	Short: "Add an Azure DevOps {organization} short name (https://dev.azure.com/{organization}) or an Azure DevOps Server collection URL",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if addOrgName == "" || addOrgUrl == "" {
-			return fmt.Errorf("name and organization are required")
+		if addOrgName == "" || (addOrgUrl == "") == (addCollectionURL == "") {
+			return fmt.Errorf("name and one of organization or url are required")
 		}
 
 		var cfg *internal.Config
@@ -32,9 +47,7 @@ var configureAddCmd = &cobra.Command{
 		if err != nil {
 			// If config file doesn't exist → initialize new config
 			if os.IsNotExist(err) {
-				cfg = &internal.Config{
-					Organizations: make(map[string]internal.OrganizationConfig),
-				}
+				cfg = internal.NewConfig()
 			} else {
 				return err
 			}
@@ -42,24 +55,59 @@ var configureAddCmd = &cobra.Command{
 			cfg = loadedCfg
 		}
 
-		// Ensure Organizations map is initialized
-		if cfg.Organizations == nil {
-			cfg.Organizations = make(map[string]internal.OrganizationConfig)
+		backupRoot := addBackupRoot
+		if backupRoot == "" {
+			backupRoot = internal.DefaultBackupRoot()
 		}
 
-		home, _ := os.UserHomeDir()
+		orgURL := "https://dev.azure.com/" + addOrgUrl
+		if addCollectionURL != "" {
+			orgURL = strings.TrimRight(addCollectionURL, "/")
+		}
 
-		cfg.Organizations[addOrgName] = internal.OrganizationConfig{
-			URL:        "https://dev.azure.com/" + addOrgUrl,
-			BackupRoot: home + "/azdo-vaults",
+		org := internal.OrganizationConfig{
+			URL:            orgURL,
+			BackupRoot:     backupRoot,
+			Parallelism:    addParallelism,
+			APIProfile:     addAPIProfile,
+			ResourceGUID:   addResourceGUID,
+			Proxy:          addProxy,
+			SigningKeyFile: addSigningKeyFile,
+		}
+		if addHosts != (adoclient.Hosts{}) {
+			hosts := addHosts
+			org.Hosts = &hosts
+		}
+		if addRetention != (internal.RetentionConfig{}) {
+			retention := addRetention
+			org.Retention = &retention
+		}
+		if addEncryption != (internal.EncryptionConfig{}) {
+			enc := addEncryption
+			org.Encryption = &enc
+		}
+		if err := internal.ValidateOrganization(&org); err != nil {
+			return err
+		}
+		if !adoclient.IsCloudURL(orgURL) && addAuth.Method != "" && addAuth.Method != internal.AuthMethodPAT {
+			fmt.Printf("⚠ Azure DevOps Server usually only accepts PATs; auth '%s' may not work\n", addAuth.Method)
+		}
+		if addAuth.Method != "" {
+			auth := addAuth
+			org.Auth = &auth
 		}
+		if addRetry != (internal.RetryConfig{}) {
+			retry := addRetry
+			org.Retry = &retry
+		}
+		cfg.Organizations[addOrgName] = org
 
 		// If first organization → set as default
 		if cfg.DefaultOrganization == "" {
 			cfg.DefaultOrganization = addOrgName
-			fmt.Println("✔ First organization added. Set as default:", addOrgName)
+			fmt.Printf("✔ First organization added to profile '%s'. Set as default: %s\n", cfg.ProfileName(), addOrgName)
 		} else {
-			fmt.Println("✔ Organization added:", addOrgName)
+			fmt.Printf("✔ Organization added to profile '%s': %s\n", cfg.ProfileName(), addOrgName)
 		}
 
 		return internal.SaveConfig(cfg)
@@ -100,7 +148,7 @@ var configureListCmd = &cobra.Command{
 		}
 
 		for name := range cfg.Organizations {
-			if name == cfg.DefaultOrganization {
+			if name == cfg.DefaultOrganizationName() {
 				fmt.Println("*", name, "(default)")
 			} else {
 				fmt.Println(" ", name)
@@ -123,19 +171,56 @@ var configureShowCmd = &cobra.Command{
 			return err
 		}
 
-		fmt.Println("Default Organization:", cfg.DefaultOrganization)
+		fmt.Printf("Profile: %s (available: %s)\n", cfg.ProfileName(), strings.Join(cfg.ProfileNames(), ", "))
+		fmt.Println("Default Organization:", cfg.DefaultOrganizationName())
 		fmt.Println()
 		fmt.Println("Organizations:")
 
 		for name, org := range cfg.Organizations {
 			prefix := " "
-			if name == cfg.DefaultOrganization {
+			if name == cfg.DefaultOrganizationName() {
 				prefix = "*"
 			}
 
 			fmt.Printf(" %s %s\n", prefix, name)
 			fmt.Printf("   URL: %s\n", org.URL)
-			fmt.Printf("   BackupRoot: %s\n\n", org.BackupRoot)
+			fmt.Printf("   BackupRoot: %s\n", org.BackupRoot)
+			if org.Auth != nil && org.Auth.Method != "" {
+				fmt.Printf("   Auth: %s\n", org.Auth.Method)
+			} else {
+				fmt.Printf("   Auth: default (AZURE_DEVOPS_EXT_PAT or az login)\n")
+			}
+			if org.Retry != nil {
+				fmt.Printf("   Retry: attempts=%d baseDelay=%s maxDelay=%s retryNonIdempotent=%t\n",
+					org.Retry.MaxAttempts, org.Retry.BaseDelay, org.Retry.MaxDelay, org.Retry.RetryNonIdempotent)
+			}
+			if org.APIProfile != "" {
+				fmt.Printf("   API profile: %s\n", org.APIProfile)
+			}
+			if org.Hosts != nil {
+				fmt.Printf("   Hosts: identity=%s release=%s feeds=%s\n", org.Hosts.Identity, org.Hosts.Release, org.Hosts.Feeds)
+			}
+			if org.Parallelism > 1 {
+				fmt.Printf("   Parallelism: %d\n", org.Parallelism)
+			}
+			if org.ResourceGUID != "" {
+				fmt.Printf("   Resource GUID: %s\n", org.ResourceGUID)
+			}
+			if org.Proxy != "" {
+				fmt.Printf("   Proxy: %s\n", org.Proxy)
+			}
+			if org.SigningKeyFile != "" {
+				fmt.Printf("   Signing key: %s\n", org.SigningKeyFile)
+			}
+			if enc := org.Encryption; enc != nil && enc.KeyFile != "" {
+				fmt.Printf("   Encryption: key file %s\n", enc.KeyFile)
+			} else if enc != nil {
+				fmt.Printf("   Encryption: passphrase from $%s\n", enc.PassphraseEnv)
+			}
+			if org.Retention != nil {
+				fmt.Printf("   Retention: daily=%d weekly=%d monthly=%d\n", org.Retention.KeepDaily, org.Retention.KeepWeekly, org.Retention.KeepMonthly)
+			}
+			fmt.Println()
 		}
 
 		return nil
@@ -159,7 +244,7 @@ var configureRemoveCmd = &cobra.Command{
 		}
 
 		delete(cfg.Organizations, orgName)
-		fmt.Println("Removed organization:", orgName)
+		fmt.Printf("Removed organization from profile '%s': %s\n", cfg.ProfileName(), orgName)
 
 		// If removed org was default → assign new default
 		if cfg.DefaultOrganization == orgName {
@@ -180,6 +265,70 @@ var configureRemoveCmd = &cobra.Command{
 	},
 }
 
+var testOrgName string
+var testProject string
+var testResourceGUID string
+
+var configureTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Check reachability, authentication and permissions of the configured organizations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := mustLoadConfig()
+		if err != nil {
+			return err
+		}
+
+		names := []string{testOrgName}
+		if testOrgName == "" {
+			names = names[:0]
+			for name := range cfg.Organizations {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			// An org defined only through AZDO_VAULT_* variables is tested when it is the default.
+			if def := cfg.DefaultOrganizationName(); def != "" {
+				if _, ok := cfg.Organizations[def]; !ok {
+					names = append(names, def)
+				}
+			}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no organizations configured in profile '%s'", cfg.ProfileName())
+		}
+
+		failed := 0
+		for _, name := range names {
+			_, orgCfg, err := cfg.ResolveOrganizationWithName(name)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%s (%s)\n", name, orgCfg.URL)
+			ok := true
+			for _, r := range internal.CheckOrganization(orgCfg, testProject, testResourceGUID) {
+				if r.OK && r.Detail == "" {
+					fmt.Printf("  ✔ %s\n", r.Name)
+				} else if r.OK {
+					fmt.Printf("  ✔ %s: %s\n", r.Name, r.Detail)
+				} else {
+					fmt.Printf("  ⚠ %s: %s\n", r.Name, r.Detail)
+					ok = false
+				}
+			}
+			if !ok {
+				failed++
+			}
+			fmt.Println()
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d organizations failed the check", failed, len(names))
+		}
+		fmt.Println("✔ All organizations passed")
+		return nil
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(configureCmd)
 
@@ -188,7 +337,39 @@ func init() {
 	configureCmd.AddCommand(configureRemoveCmd)
 	configureCmd.AddCommand(configureDefaultCmd)
 	configureCmd.AddCommand(configureListCmd)
+	configureCmd.AddCommand(configureTestCmd)
+
+	configureTestCmd.Flags().StringVar(&testOrgName, "name", "", "Organization alias to test (default: every organization of the profile)")
+	configureTestCmd.Flags().StringVar(&testProject, "project", "", "Also check read access to this project's repos, pipelines, service connections and feeds")
+	configureTestCmd.Flags().StringVar(&testResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
 	configureAddCmd.Flags().StringVar(&addOrgName, "name", "", "Organization alias")
 	configureAddCmd.Flags().StringVar(&addOrgUrl, "org", "", "Azure DevOps organization short name (not the full URL)")
+	configureAddCmd.Flags().StringVar(&addCollectionURL, "url", "", "Full organization or Azure DevOps Server collection URL, e.g. https://tfs.corp/tfs/DefaultCollection (instead of --org)")
+	configureAddCmd.Flags().StringVar(&addAPIProfile, "api-profile", "", "REST API level: cloud|server-2022|server-2020|server-2019 or a version like 6.0 (default cloud)")
+	configureAddCmd.Flags().StringVar(&addHosts.Identity, "identity-url", "", "Identity service base URL (default vssps.dev.azure.com/{org}, or the collection URL)")
+	configureAddCmd.Flags().StringVar(&addHosts.Release, "release-url", "", "Release service base URL (default vsrm.dev.azure.com/{org}, or the collection URL)")
+	configureAddCmd.Flags().StringVar(&addHosts.Feeds, "feeds-url", "", "Artifacts feeds base URL (default feeds.dev.azure.com/{org}, or the collection URL)")
+	configureAddCmd.Flags().StringVar(&addAuth.Method, "auth", "", "Auth method: pat|azcli|service-principal|workload-identity|managed-identity (default: AZURE_DEVOPS_EXT_PAT or az login)")
+	configureAddCmd.Flags().StringVar(&addAuth.PATEnv, "pat-env", "", "Env var holding the PAT (auth=pat, default AZURE_DEVOPS_EXT_PAT)")
+	configureAddCmd.Flags().StringVar(&addAuth.PATFile, "pat-file", "", "File holding the PAT (auth=pat)")
+	configureAddCmd.Flags().StringVar(&addAuth.TenantID, "tenant-id", "", "Entra tenant ID (service-principal, workload-identity)")
+	configureAddCmd.Flags().StringVar(&addAuth.ClientID, "client-id", "", "Application (client) ID (service-principal, workload-identity, user-assigned managed-identity)")
+	configureAddCmd.Flags().StringVar(&addAuth.ClientSecretEnv, "client-secret-env", "", "Env var holding the client secret (service-principal, default AZURE_CLIENT_SECRET)")
+	configureAddCmd.Flags().StringVar(&addAuth.ClientSecretFile, "client-secret-file", "", "File holding the client secret (service-principal)")
+	configureAddCmd.Flags().StringVar(&addAuth.TokenFile, "federated-token-file", "", "Federated token file (workload-identity, default AZURE_FEDERATED_TOKEN_FILE)")
+	configureAddCmd.Flags().IntVar(&addRetry.MaxAttempts, "retry-attempts", 0, "Max tries per REST call, including the first (default 5)")
+	configureAddCmd.Flags().StringVar(&addRetry.BaseDelay, "retry-base-delay", "", "First retry backoff, doubled per attempt (default 2s)")
+	configureAddCmd.Flags().StringVar(&addRetry.MaxDelay, "retry-max-delay", "", "Upper bound for one retry wait, including Retry-After (default 2m)")
+	configureAddCmd.Flags().BoolVar(&addRetry.RetryNonIdempotent, "retry-non-idempotent", false, "Also retry POST/PATCH on 5xx and network errors (may create duplicates)")
+	configureAddCmd.Flags().IntVar(&addParallelism, "parallelism", 0, "Default number of items processed at once by backup/restore commands (default 1)")
+	configureAddCmd.Flags().StringVar(&addBackupRoot, "backup-root", "", "Folder backups of this org are written to (default $HOME/azdo-vaults)")
+	configureAddCmd.Flags().StringVar(&addResourceGUID, "resource-guid", "", "AAD resource GUID used when a command has no --ado-resource-guid (default: Azure DevOps)")
+	configureAddCmd.Flags().StringVar(&addProxy, "proxy", "", "HTTP(S) proxy URL for REST calls to this org (default: HTTPS_PROXY)")
+	configureAddCmd.Flags().IntVar(&addRetention.KeepDaily, "keep-daily", 0, "Snapshots prune keeps: the newest of each of the last N days")
+	configureAddCmd.Flags().IntVar(&addRetention.KeepWeekly, "keep-weekly", 0, "Snapshots prune keeps: the newest of each of the last N weeks")
+	configureAddCmd.Flags().IntVar(&addRetention.KeepMonthly, "keep-monthly", 0, "Snapshots prune keeps: the newest of each of the last N months")
+	configureAddCmd.Flags().StringVar(&addSigningKeyFile, "signing-key-file", "", "ed25519 private key (PEM) that signs the manifest of every backup snapshot")
+	configureAddCmd.Flags().StringVar(&addEncryption.KeyFile, "encryption-key-file", "", "Encrypt backups with this key: 32 random bytes, raw or base64 (openssl rand -base64 32)")
+	configureAddCmd.Flags().StringVar(&addEncryption.PassphraseEnv, "encryption-passphrase-env", "", "Encrypt backups with a key derived from the passphrase in this env var")
 }
@@ -17,6 +17,20 @@ var createArtifactsFeedsCmd = &cobra.Command{
 	Use:   "create-artifacts-feeds",
 	Short: "Create Azure Artifacts feeds in target org/project from local backup",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindArtifactsFeeds,
+			sourceOrg:     &restoreArtSourceOrg,
+			sourceProject: &restoreArtSourceProject,
+			targetOrg:     &restoreArtTargetOrg,
+			targetProject: &restoreArtTargetProject,
+			resourceGUID:  &restoreArtResourceGUID,
+			names:         &restoreArtSelected,
+			namesFlag:     "feeds",
+			required:      []string{"source-org", "source-project"},
+		}); err != nil {
+			return err
+		}
+
 		cfg, err := mustLoadConfig()
 		if err != nil {
 			return err
@@ -41,9 +55,16 @@ var createArtifactsFeedsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, restoreArtSourceProject, "artifacts/feeds")
+		bkp, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, restoreArtSourceProject, internal.KindArtifactsFeeds)
+		if err != nil {
+			return err
+		}
+
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, restoreArtSourceProject, targetOrg, targetProject); err != nil {
+			return err
+		}
 
-		return internal.RestoreArtifactsFeedsFromBackup(
+		results, err := internal.RestoreArtifactsFeedsFromBackup(
 			sourceOrgCfg.URL,
 			restoreArtSourceProject,
 			targetOrgCfg.URL,
@@ -51,7 +72,9 @@ var createArtifactsFeedsCmd = &cobra.Command{
 			bkp,
 			restoreArtSelected,
 			restoreArtResourceGUID,
+			newRestoreOptions(targetOrgCfg),
 		)
+		return finishRestore(results, err)
 	},
 }
 
@@ -62,10 +85,8 @@ func init() {
 	createArtifactsFeedsCmd.Flags().StringVar(&restoreArtSourceProject, "source-project", "", "Source project (where backup exists)")
 	createArtifactsFeedsCmd.Flags().StringVar(&restoreArtTargetOrg, "target-org", "", "Target organization (defaults to source-org)")
 	createArtifactsFeedsCmd.Flags().StringVar(&restoreArtTargetProject, "target-project", "", "Target project (defaults to source-project)")
-	createArtifactsFeedsCmd.Flags().StringSliceVar(&restoreArtSelected, "feeds", []string{"all"}, "Feed filenames or 'all'")
-	createArtifactsFeedsCmd.Flags().StringVar(&restoreArtResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	createArtifactsFeedsCmd.Flags().StringSliceVar(&restoreArtSelected, "feeds", []string{"all"}, "Feed filenames, 'all', globs, re:REGEX, !exclusions or @file (see README)")
+	createArtifactsFeedsCmd.Flags().StringVar(&restoreArtResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
-	createArtifactsFeedsCmd.MarkFlagRequired("source-org")
-	createArtifactsFeedsCmd.MarkFlagRequired("source-project")
-	createArtifactsFeedsCmd.MarkFlagRequired("ado-resource-guid")
+	addRestoreFlags(createArtifactsFeedsCmd)
 }
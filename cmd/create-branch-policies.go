@@ -17,6 +17,19 @@ var createBranchPoliciesCmd = &cobra.Command{
 	Use:   "create-branch-policies",
 	Short: "Create branch policies in target org/project from local backup",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindBranchPolicies,
+			sourceOrg:     &restorePolSourceOrg,
+			sourceProject: &restorePolSourceProject,
+			targetOrg:     &restorePolTargetOrg,
+			targetProject: &restorePolTargetProject,
+			resourceGUID:  &restorePolResourceGUID,
+			names:         &restorePolSelected,
+			namesFlag:     "policies",
+			required:      []string{"source-org", "source-project"},
+		}); err != nil {
+			return err
+		}
 
 		cfg, err := mustLoadConfig()
 		if err != nil {
@@ -42,9 +55,16 @@ var createBranchPoliciesCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, restorePolSourceProject, "branch-policies")
+		bkp, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, restorePolSourceProject, internal.KindBranchPolicies)
+		if err != nil {
+			return err
+		}
+
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, restorePolSourceProject, targetOrg, targetProject); err != nil {
+			return err
+		}
 
-		return internal.RestoreBranchPoliciesFromBackup(
+		results, err := internal.RestoreBranchPoliciesFromBackup(
 			sourceOrgCfg.URL,
 			restorePolSourceProject,
 			targetOrgCfg.URL,
@@ -52,7 +72,9 @@ var createBranchPoliciesCmd = &cobra.Command{
 			bkp,
 			restorePolSelected,
 			restorePolResourceGUID,
+			newRestoreOptions(targetOrgCfg),
 		)
+		return finishRestore(results, err)
 	},
 }
 
@@ -63,10 +85,8 @@ func init() {
 	createBranchPoliciesCmd.Flags().StringVar(&restorePolSourceProject, "source-project", "", "Source project (where backup exists)")
 	createBranchPoliciesCmd.Flags().StringVar(&restorePolTargetOrg, "target-org", "", "Target organization (defaults to source-org if omitted)")
 	createBranchPoliciesCmd.Flags().StringVar(&restorePolTargetProject, "target-project", "", "Target project (defaults to source-project if omitted)")
-	createBranchPoliciesCmd.Flags().StringSliceVar(&restorePolSelected, "policies", []string{"all"}, "Policy filenames, policy ids, or 'all'")
-	createBranchPoliciesCmd.Flags().StringVar(&restorePolResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	createBranchPoliciesCmd.Flags().StringSliceVar(&restorePolSelected, "policies", []string{"all"}, "Policy filenames, policy ids, 'all', globs, re:REGEX, !exclusions or @file (see README)")
+	createBranchPoliciesCmd.Flags().StringVar(&restorePolResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
-	createBranchPoliciesCmd.MarkFlagRequired("source-org")
-	createBranchPoliciesCmd.MarkFlagRequired("source-project")
-	createBranchPoliciesCmd.MarkFlagRequired("ado-resource-guid")
+	addRestoreFlags(createBranchPoliciesCmd)
 }
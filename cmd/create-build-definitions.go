@@ -19,6 +19,19 @@ var createBuildDefsCmd = &cobra.Command{
 	Use:   "create-build-definitions",
 	Short: "Create classic build definitions in target org/project from local backup",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindBuildDefinitions,
+			sourceOrg:     &bldRestoreSourceOrg,
+			sourceProject: &bldRestoreSourceProject,
+			targetOrg:     &bldRestoreTargetOrg,
+			targetProject: &bldRestoreTargetProject,
+			resourceGUID:  &bldRestoreResourceGUID,
+			names:         &bldRestoreNames,
+			namesFlag:     "definitions",
+			required:      []string{"source-org", "source-project", "definitions"},
+		}); err != nil {
+			return err
+		}
 
 		cfg, err := mustLoadConfig()
 		if err != nil {
@@ -44,9 +57,16 @@ var createBuildDefsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, bldRestoreSourceProject, "build-definitions")
+		bkp, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, bldRestoreSourceProject, internal.KindBuildDefinitions)
+		if err != nil {
+			return err
+		}
+
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, bldRestoreSourceProject, targetOrg, targetProject); err != nil {
+			return err
+		}
 
-		return internal.RestoreBuildDefinitionsFromBackup(
+		results, err := internal.RestoreBuildDefinitionsFromBackup(
 			sourceOrgCfg.URL,
 			bldRestoreSourceProject,
 			targetOrgCfg.URL,
@@ -54,9 +74,11 @@ var createBuildDefsCmd = &cobra.Command{
 			bkp,
 			bldRestoreNames,
 			bldRestoreResourceGUID,
-			bldRestoreQueueMap,
-			bldRestoreDefaultQueue,
+			manifestQueueMap(bldRestoreQueueMap),
+			manifestDefaultQueue(bldRestoreDefaultQueue),
+			newRestoreOptions(targetOrgCfg),
 		)
+		return finishRestore(results, err)
 	},
 }
 
@@ -67,13 +89,11 @@ func init() {
 	createBuildDefsCmd.Flags().StringVar(&bldRestoreSourceProject, "source-project", "", "Source project (where backup exists)")
 	createBuildDefsCmd.Flags().StringVar(&bldRestoreTargetOrg, "target-org", "", "Target organization (defaults to source-org if omitted)")
 	createBuildDefsCmd.Flags().StringVar(&bldRestoreTargetProject, "target-project", "", "Target project (defaults to source-project if omitted)")
-	createBuildDefsCmd.Flags().StringSliceVar(&bldRestoreNames, "definitions", []string{}, "Build definition names or 'all'")
-	createBuildDefsCmd.Flags().StringVar(&bldRestoreResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	createBuildDefsCmd.Flags().StringSliceVar(&bldRestoreNames, "definitions", []string{}, "Build definition names, 'all', folders (\\Team\\**), globs, re:REGEX, !exclusions or @file (see README)")
+	createBuildDefsCmd.Flags().StringVar(&bldRestoreResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 	createBuildDefsCmd.Flags().StringSliceVar(&bldRestoreQueueMap, "queue-map", []string{}, "Queue mapping in form 'SourceQueue=TargetQueue' (repeatable)")
 	createBuildDefsCmd.Flags().StringVar(&bldRestoreDefaultQueue, "default-queue", "", "Fallback target queue name when no mapping/match exists")
 
-	createBuildDefsCmd.MarkFlagRequired("source-org")
-	createBuildDefsCmd.MarkFlagRequired("source-project")
-	createBuildDefsCmd.MarkFlagRequired("definitions")
-	createBuildDefsCmd.MarkFlagRequired("ado-resource-guid")
+	addRestoreFlags(createBuildDefsCmd)
+	addModeFlag(createBuildDefsCmd)
 }
@@ -19,6 +19,20 @@ var createReleaseDefinitionsCmd = &cobra.Command{
 	Use:   "create-release-definitions",
 	Short: "Create classic release definitions in target org/project from local backup",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindReleaseDefinitions,
+			sourceOrg:     &restoreRelSourceOrg,
+			sourceProject: &restoreRelSourceProject,
+			targetOrg:     &restoreRelTargetOrg,
+			targetProject: &restoreRelTargetProject,
+			resourceGUID:  &restoreRelAdoResourceGUID,
+			names:         &restoreRelDefinitions,
+			namesFlag:     "definitions",
+			required:      []string{"source-org", "source-project", "definitions"},
+		}); err != nil {
+			return err
+		}
+
 		cfg, err := mustLoadConfig()
 		if err != nil {
 			return err
@@ -43,9 +57,16 @@ var createReleaseDefinitionsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, restoreRelSourceProject, "release-definitions")
+		bkp, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, restoreRelSourceProject, internal.KindReleaseDefinitions)
+		if err != nil {
+			return err
+		}
+
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, restoreRelSourceProject, targetOrg, targetProject); err != nil {
+			return err
+		}
 
-		return internal.RestoreReleaseDefinitionsFromBackup(
+		results, err := internal.RestoreReleaseDefinitionsFromBackup(
 			sourceOrgCfg.URL,
 			restoreRelSourceProject,
 			targetOrgCfg.URL,
@@ -53,9 +74,11 @@ var createReleaseDefinitionsCmd = &cobra.Command{
 			bkp,
 			restoreRelDefinitions,
 			restoreRelAdoResourceGUID,
-			restoreRelQueueMap,
-			restoreRelDefaultQueue,
+			manifestQueueMap(restoreRelQueueMap),
+			manifestDefaultQueue(restoreRelDefaultQueue),
+			newRestoreOptions(targetOrgCfg),
 		)
+		return finishRestore(results, err)
 
 	},
 }
@@ -63,17 +86,15 @@ var createReleaseDefinitionsCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(createReleaseDefinitionsCmd)
 
-	createReleaseDefinitionsCmd.Flags().StringSliceVar(&restoreRelDefinitions, "definitions", []string{}, "Release definition names or 'all'")
+	createReleaseDefinitionsCmd.Flags().StringSliceVar(&restoreRelDefinitions, "definitions", []string{}, "Release definition names, 'all', folders (\\Team\\**), globs, re:REGEX, !exclusions or @file (see README)")
 	createReleaseDefinitionsCmd.Flags().StringVar(&restoreRelSourceOrg, "source-org", "", "Source organization (where backup exists)")
 	createReleaseDefinitionsCmd.Flags().StringVar(&restoreRelSourceProject, "source-project", "", "Source project (where backup exists)")
 	createReleaseDefinitionsCmd.Flags().StringVar(&restoreRelTargetOrg, "target-org", "", "Target organization (defaults to source-org if omitted)")
 	createReleaseDefinitionsCmd.Flags().StringVar(&restoreRelTargetProject, "target-project", "", "Target project (defaults to source-project if omitted)")
-	createReleaseDefinitionsCmd.Flags().StringVar(&restoreRelAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	createReleaseDefinitionsCmd.Flags().StringVar(&restoreRelAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 	createReleaseDefinitionsCmd.Flags().StringSliceVar(&restoreRelQueueMap, "queue-map", []string{}, "Queue mapping in form 'SourceQueue=TargetQueue' (repeatable)")
 	createReleaseDefinitionsCmd.Flags().StringVar(&restoreRelDefaultQueue, "default-queue", "", "Fallback target queue name when no mapping/match exists")
 
-	createReleaseDefinitionsCmd.MarkFlagRequired("definitions")
-	createReleaseDefinitionsCmd.MarkFlagRequired("source-org")
-	createReleaseDefinitionsCmd.MarkFlagRequired("source-project")
-	createReleaseDefinitionsCmd.MarkFlagRequired("ado-resource-guid")
+	addRestoreFlags(createReleaseDefinitionsCmd)
+	addModeFlag(createReleaseDefinitionsCmd)
 }
@@ -17,6 +17,20 @@ var createServiceConnectionsCmd = &cobra.Command{
 	Use:   "create-service-connections",
 	Short: "Create service connections in target org/project from local backup",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindServiceConnections,
+			sourceOrg:     &restoreSCSourceOrg,
+			sourceProject: &restoreSCSourceProject,
+			targetOrg:     &restoreSCTargetOrg,
+			targetProject: &restoreSCTargetProject,
+			resourceGUID:  &restoreSCAdoResourceGUID,
+			names:         &restoreSCNames,
+			namesFlag:     "connections",
+			required:      []string{"source-org", "source-project", "connections"},
+		}); err != nil {
+			return err
+		}
+
 		cfg, err := mustLoadConfig()
 		if err != nil {
 			return err
@@ -41,15 +55,24 @@ var createServiceConnectionsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, restoreSCSourceProject, "service-connections")
+		bkp, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, restoreSCSourceProject, internal.KindServiceConnections)
+		if err != nil {
+			return err
+		}
+
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, restoreSCSourceProject, targetOrg, targetProject); err != nil {
+			return err
+		}
 
-		return internal.RestoreServiceConnectionsFromBackup(
+		results, err := internal.RestoreServiceConnectionsFromBackup(
 			targetOrgCfg.URL,
 			targetProject,
 			bkp,
 			restoreSCNames,
 			restoreSCAdoResourceGUID,
+			newRestoreOptions(targetOrgCfg),
 		)
+		return finishRestore(results, err)
 	},
 }
 
@@ -60,11 +83,9 @@ func init() {
 	createServiceConnectionsCmd.Flags().StringVar(&restoreSCSourceProject, "source-project", "", "Source project (where backup exists)")
 	createServiceConnectionsCmd.Flags().StringVar(&restoreSCTargetOrg, "target-org", "", "Target organization (defaults to source-org if omitted)")
 	createServiceConnectionsCmd.Flags().StringVar(&restoreSCTargetProject, "target-project", "", "Target project (defaults to source-project if omitted)")
-	createServiceConnectionsCmd.Flags().StringSliceVar(&restoreSCNames, "connections", []string{}, "Service connection names or 'all'")
-	createServiceConnectionsCmd.Flags().StringVar(&restoreSCAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	createServiceConnectionsCmd.Flags().StringSliceVar(&restoreSCNames, "connections", []string{}, "Service connection names, 'all', globs, re:REGEX, !exclusions or @file (see README)")
+	createServiceConnectionsCmd.Flags().StringVar(&restoreSCAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
-	createServiceConnectionsCmd.MarkFlagRequired("source-org")
-	createServiceConnectionsCmd.MarkFlagRequired("source-project")
-	createServiceConnectionsCmd.MarkFlagRequired("connections")
-	createServiceConnectionsCmd.MarkFlagRequired("ado-resource-guid")
+	addRestoreFlags(createServiceConnectionsCmd)
+	addModeFlag(createServiceConnectionsCmd)
 }
@@ -17,6 +17,19 @@ var createTaskGroupsCmd = &cobra.Command{
 	Use:   "create-task-groups",
 	Short: "Create task groups in target org/project from local backup",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindTaskGroups,
+			sourceOrg:     &restoreTGSourceOrg,
+			sourceProject: &restoreTGSourceProject,
+			targetOrg:     &restoreTGTargetOrg,
+			targetProject: &restoreTGTargetProject,
+			resourceGUID:  &restoreAdoResourceGUID,
+			names:         &restoreTGroups,
+			namesFlag:     "groups",
+			required:      []string{"source-org", "source-project", "groups"},
+		}); err != nil {
+			return err
+		}
 
 		cfg, err := mustLoadConfig()
 		if err != nil {
@@ -51,10 +64,17 @@ var createTaskGroupsCmd = &cobra.Command{
 		// 	restoreTGSourceProject,
 		// 	"task-groups",
 		// )
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, restoreTGSourceProject, "task-groups")
+		bkp, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, restoreTGSourceProject, internal.KindTaskGroups)
+		if err != nil {
+			return err
+		}
 
 		// ✅ NEW: include source org URL + source project (for endpoint ID -> name -> target ID remap)
-		return internal.RestoreTaskGroupsFromBackup(
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, restoreTGSourceProject, resolvedTargetOrg, resolvedTargetProject); err != nil {
+			return err
+		}
+
+		results, err := internal.RestoreTaskGroupsFromBackup(
 			sourceOrgCfg.URL,
 			restoreTGSourceProject,
 			targetOrgCfg.URL,
@@ -62,22 +82,22 @@ var createTaskGroupsCmd = &cobra.Command{
 			bkp,
 			restoreTGroups,
 			restoreAdoResourceGUID,
+			newRestoreOptions(targetOrgCfg),
 		)
+		return finishRestore(results, err)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(createTaskGroupsCmd)
 
-	createTaskGroupsCmd.Flags().StringSliceVar(&restoreTGroups, "groups", []string{}, "Task group names or 'all'")
+	createTaskGroupsCmd.Flags().StringSliceVar(&restoreTGroups, "groups", []string{}, "Task group names, 'all', globs, re:REGEX, !exclusions or @file (see README)")
 	createTaskGroupsCmd.Flags().StringVar(&restoreTGSourceOrg, "source-org", "", "Source organization (where backup exists)")
 	createTaskGroupsCmd.Flags().StringVar(&restoreTGSourceProject, "source-project", "", "Source project (where backup exists)")
 	createTaskGroupsCmd.Flags().StringVar(&restoreTGTargetOrg, "target-org", "", "Target organization (defaults to source-org if omitted)")
 	createTaskGroupsCmd.Flags().StringVar(&restoreTGTargetProject, "target-project", "", "Target project (defaults to source-project if omitted)")
-	createTaskGroupsCmd.Flags().StringVar(&restoreAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	createTaskGroupsCmd.Flags().StringVar(&restoreAdoResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
-	createTaskGroupsCmd.MarkFlagRequired("groups")
-	createTaskGroupsCmd.MarkFlagRequired("source-org")
-	createTaskGroupsCmd.MarkFlagRequired("source-project")
-	createTaskGroupsCmd.MarkFlagRequired("ado-resource-guid")
+	addRestoreFlags(createTaskGroupsCmd)
+	addModeFlag(createTaskGroupsCmd)
 }
@@ -16,6 +16,18 @@ var createVariableGroupsCmd = &cobra.Command{
 	Use:   "create-variable-groups",
 	Short: "Restore variable groups from local backup",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindVariableGroups,
+			sourceOrg:     &restoreVarSourceOrg,
+			sourceProject: &restoreVarSourceProject,
+			targetOrg:     &restoreVarTargetOrg,
+			targetProject: &restoreVarTargetProject,
+			names:         &restoreVarGroups,
+			namesFlag:     "groups",
+			required:      []string{"source-org", "source-project", "groups"},
+		}); err != nil {
+			return err
+		}
 
 		cfg, err := mustLoadConfig()
 		if err != nil {
@@ -40,14 +52,23 @@ var createVariableGroupsCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, restoreVarSourceProject, "variable-groups")
+		bkp, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, restoreVarSourceProject, internal.KindVariableGroups)
+		if err != nil {
+			return err
+		}
+
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, restoreVarSourceProject, restoreVarTargetOrg, restoreVarTargetProject); err != nil {
+			return err
+		}
 
-		return internal.RestoreVariableGroupsFromBackup(
+		results, err := internal.RestoreVariableGroupsFromBackup(
 			targetOrgCfg.URL,
 			restoreVarTargetProject,
 			bkp,
 			restoreVarGroups,
+			newRestoreOptions(targetOrgCfg),
 		)
+		return finishRestore(results, err)
 	},
 }
 
@@ -59,7 +80,7 @@ func init() {
 		&restoreVarGroups,
 		"groups",
 		[]string{},
-		"Variable group names or 'all'",
+		"Variable group names, 'all', globs, re:REGEX, !exclusions or @file (see README)",
 	)
 
 	createVariableGroupsCmd.Flags().StringVar(
@@ -90,7 +111,5 @@ func init() {
 		"Target project",
 	)
 
-	createVariableGroupsCmd.MarkFlagRequired("groups")
-	createVariableGroupsCmd.MarkFlagRequired("source-org")
-	createVariableGroupsCmd.MarkFlagRequired("source-project")
+	addRestoreFlags(createVariableGroupsCmd)
 }
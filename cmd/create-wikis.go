@@ -17,6 +17,20 @@ var createWikisCmd = &cobra.Command{
 	Use:   "create-wikis",
 	Short: "Restore Azure DevOps wikis into target org/project from backup",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindWikis,
+			sourceOrg:     &restoreWikisSourceOrg,
+			sourceProject: &restoreWikisSourceProject,
+			targetOrg:     &restoreWikisTargetOrg,
+			targetProject: &restoreWikisTargetProject,
+			resourceGUID:  &restoreWikisResourceGUID,
+			names:         &restoreWikisSelected,
+			namesFlag:     "wikis",
+			required:      []string{"source-org", "source-project"},
+		}); err != nil {
+			return err
+		}
+
 		cfg, err := mustLoadConfig()
 		if err != nil {
 			return err
@@ -41,9 +55,16 @@ var createWikisCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, restoreWikisSourceProject, "wikis")
+		bkp, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, restoreWikisSourceProject, internal.KindWikis)
+		if err != nil {
+			return err
+		}
+
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, restoreWikisSourceProject, targetOrg, targetProject); err != nil {
+			return err
+		}
 
-		return internal.RestoreWikisFromBackup(
+		results, err := internal.RestoreWikisFromBackup(
 			sourceOrgCfg.URL,
 			restoreWikisSourceProject,
 			targetOrgCfg.URL,
@@ -51,7 +72,9 @@ var createWikisCmd = &cobra.Command{
 			bkp,
 			restoreWikisSelected,
 			restoreWikisResourceGUID,
+			newRestoreOptions(targetOrgCfg),
 		)
+		return finishRestore(results, err)
 	},
 }
 
@@ -62,10 +85,8 @@ func init() {
 	createWikisCmd.Flags().StringVar(&restoreWikisSourceProject, "source-project", "", "Source project (where backup exists)")
 	createWikisCmd.Flags().StringVar(&restoreWikisTargetOrg, "target-org", "", "Target org (defaults to source-org)")
 	createWikisCmd.Flags().StringVar(&restoreWikisTargetProject, "target-project", "", "Target project (defaults to source-project)")
-	createWikisCmd.Flags().StringSliceVar(&restoreWikisSelected, "wikis", []string{"all"}, "Wiki names/ids or 'all'")
-	createWikisCmd.Flags().StringVar(&restoreWikisResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	createWikisCmd.Flags().StringSliceVar(&restoreWikisSelected, "wikis", []string{"all"}, "Wiki names/ids, 'all', globs, re:REGEX, !exclusions or @file (see README)")
+	createWikisCmd.Flags().StringVar(&restoreWikisResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
-	createWikisCmd.MarkFlagRequired("source-org")
-	createWikisCmd.MarkFlagRequired("source-project")
-	createWikisCmd.MarkFlagRequired("ado-resource-guid")
+	addRestoreFlags(createWikisCmd)
 }
@@ -1,8 +1,6 @@
 package cmd
 
 import (
-	"fmt"
-
 	"azdo-vault/internal"
 
 	"github.com/spf13/cobra"
@@ -19,6 +17,20 @@ var createYamlPipelinesCmd = &cobra.Command{
 	Use:   "create-yaml-pipelines",
 	Short: "Create YAML pipelines in target org/project from local backup",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindYamlPipelines,
+			sourceOrg:     &restoreYamlSourceOrg,
+			sourceProject: &restoreYamlSourceProject,
+			targetOrg:     &restoreYamlTargetOrg,
+			targetProject: &restoreYamlTargetProject,
+			resourceGUID:  &restoreYamlAdoResourceGUID,
+			names:         &restoreYamlPipelines,
+			namesFlag:     "pipelines",
+			required:      []string{"source-org", "source-project", "pipelines"},
+		}); err != nil {
+			return err
+		}
+
 		cfg, err := mustLoadConfig()
 		if err != nil {
 			return err
@@ -44,15 +56,16 @@ var createYamlPipelinesCmd = &cobra.Command{
 			return err
 		}
 
-		bkp := backupPath(cfg, sourceOrgName, sourceOrgCfg, restoreYamlSourceProject, "yaml-pipelines")
-
-		// Need target repos to map repoName -> repoId
-		targetRepos, err := internal.ListRepos(targetOrgCfg.URL, targetProject)
+		bkp, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, restoreYamlSourceProject, internal.KindYamlPipelines)
 		if err != nil {
-			return fmt.Errorf("failed listing target repos: %w", err)
+			return err
+		}
+
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, restoreYamlSourceProject, targetOrg, targetProject); err != nil {
+			return err
 		}
 
-		return internal.RestoreYamlPipelinesFromBackup(
+		results, err := internal.RestoreYamlPipelinesFromBackup(
 			sourceOrgCfg.URL,
 			restoreYamlSourceProject,
 			targetOrgCfg.URL,
@@ -60,8 +73,9 @@ var createYamlPipelinesCmd = &cobra.Command{
 			bkp,
 			restoreYamlPipelines,
 			restoreYamlAdoResourceGUID,
-			targetRepos,
+			newRestoreOptions(targetOrgCfg),
 		)
+		return finishRestore(results, err)
 	},
 }
 
@@ -96,17 +110,15 @@ func init() {
 		&restoreYamlPipelines,
 		"pipelines",
 		[]string{},
-		"Pipeline names or 'all'",
+		"Pipeline names, 'all', folders (\\Team\\**), globs, re:REGEX, !exclusions or @file (see README)",
 	)
 	createYamlPipelinesCmd.Flags().StringVar(
 		&restoreYamlAdoResourceGUID,
 		"ado-resource-guid",
 		"",
-		"Azure DevOps AAD resource GUID (required for az rest)",
+		"Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)",
 	)
 
-	createYamlPipelinesCmd.MarkFlagRequired("source-org")
-	createYamlPipelinesCmd.MarkFlagRequired("source-project")
-	createYamlPipelinesCmd.MarkFlagRequired("pipelines")
-	createYamlPipelinesCmd.MarkFlagRequired("ado-resource-guid")
+	addRestoreFlags(createYamlPipelinesCmd)
+	addModeFlag(createYamlPipelinesCmd)
 }
@@ -2,9 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
 
 	"azdo-vault/internal"
 
@@ -21,6 +18,18 @@ var createReposCmd = &cobra.Command{
 	Use:   "create-repos",
 	Short: "Create repositories in Azure DevOps",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.KindRepos,
+			sourceOrg:     &sourceOrg,
+			sourceProject: &sourceProject,
+			targetOrg:     &targetOrg,
+			targetProject: &targetProject,
+			names:         &createRepos,
+			namesFlag:     "repos",
+			required:      []string{"source-project", "repos"},
+		}); err != nil {
+			return err
+		}
 
 		cfg, err := mustLoadConfig()
 		if err != nil {
@@ -44,32 +53,14 @@ var createReposCmd = &cobra.Command{
 			targetProject = sourceProject
 		}
 
-		var repoNames []string
-
-		// If "all" → read from backup directory
-		if len(createRepos) == 1 && createRepos[0] == "all" {
-
-			repoPath := filepath.Join(
-				sourceOrgCfg.BackupRoot,
-				sourceOrgName,
-				sourceProject,
-				"repos",
-			)
-
-			files, err := os.ReadDir(repoPath)
-			if err != nil {
-				return fmt.Errorf("failed reading backup repos: %w", err)
-			}
-
-			for _, f := range files {
-				if strings.HasSuffix(f.Name(), ".git") {
-					repoNames = append(repoNames,
-						strings.TrimSuffix(f.Name(), ".git"))
-				}
-			}
-
-		} else {
-			repoNames = createRepos
+		// patterns and "all" pick from the mirrors in the backup
+		repoPath, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, sourceProject, internal.KindRepos)
+		if err != nil {
+			return err
+		}
+		repoNames, err := internal.SelectBackupRepos(repoPath, createRepos)
+		if err != nil {
+			return err
 		}
 
 		if len(repoNames) == 0 {
@@ -81,22 +72,19 @@ var createReposCmd = &cobra.Command{
 			fmt.Println(" -", r)
 		}
 
-		for _, repo := range repoNames {
-
-			exists, _ := internal.RepoExists(targetOrgCfg.URL, targetProject, repo)
-			if exists {
-				fmt.Println("✔ Already exists:", repo)
-				continue
-			}
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, sourceProject, targetOrg, targetProject); err != nil {
+			return err
+		}
 
-			fmt.Println("Creating:", repo)
-			if err := internal.CreateRepo(targetOrgCfg.URL, targetProject, repo); err != nil {
-				return fmt.Errorf("Failed creating repo %s: %w \n\nBefore everyting, ensure you have permissions and the project exists.", repo, err)
-			}
+		results, err := internal.RestoreRepos(targetOrgCfg.URL, targetProject, repoNames, newRestoreOptions(targetOrgCfg))
+		if err != nil {
+			return finishRestore(results, fmt.Errorf("%w \n\nBefore everyting, ensure you have permissions and the project exists.", err))
 		}
 
-		fmt.Println("✔ Repository creation completed")
-		return nil
+		if !restoreDryRun {
+			fmt.Println("✔ Repository creation completed")
+		}
+		return finishRestore(results, nil)
 	},
 }
 
@@ -107,7 +95,7 @@ func init() {
 		&createRepos,
 		"repos",
 		[]string{},
-		"Repository names or 'all'",
+		"Repository names, 'all', globs, re:REGEX, !exclusions or @file (see README)",
 	)
 
 	createReposCmd.Flags().StringVar(
@@ -137,7 +125,6 @@ func init() {
 		"",
 		"Target organization name (where repos will be created)",
 	)
-	createReposCmd.MarkFlagRequired("repos")
-	createReposCmd.MarkFlagRequired("source-project")
 
+	addRestoreFlags(createReposCmd)
 }
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var diffSourceOrg string
+var diffSourceProject string
+var diffTargetOrg string
+var diffTargetProject string
+var diffKind string
+var diffItems []string
+var diffIgnore []string
+var diffOutput string
+var diffExitCode bool
+var diffResourceGUID string
+var diffAt string
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare a backup with a live project (config drift, migration check)",
+	Long: `Compares the backup of one kind with the live items of a project.
+Server-managed fields (ids, revisions, dates, links, ...) are stripped from
+both sides the same way restore strips them, and the rest is compared as JSON.
+
+By default the backup is compared with the project it was taken from. Use
+--target-org / --target-project to check a migrated project instead; IDs that
+restore remaps (repos, queues, ...) then differ and can be left out with --ignore.
+The newest snapshot holding the kind is compared unless --at picks an older one.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if diffOutput != "text" && diffOutput != "json" {
+			return fmt.Errorf("unknown output '%s' (use text or json)", diffOutput)
+		}
+
+		cfg, err := mustLoadConfig()
+		if err != nil {
+			return err
+		}
+
+		sourceOrgName, sourceOrgCfg, _, targetOrgCfg, targetProject, err := resolveSourceTarget(
+			cfg,
+			diffSourceOrg, diffSourceProject,
+			diffTargetOrg, diffTargetProject,
+		)
+		if err != nil {
+			return err
+		}
+
+		at, err := internal.ParseSnapshotTime(diffAt)
+		if err != nil {
+			return fmt.Errorf("--at: %w", err)
+		}
+		bkp, _, err := internal.BackupPath(projectRoot(sourceOrgName, sourceOrgCfg, diffSourceProject), diffKind, at)
+		if err != nil {
+			return err
+		}
+
+		diffs, err := internal.DiffBackup(
+			targetOrgCfg.URL,
+			targetProject,
+			bkp,
+			diffKind,
+			diffItems,
+			diffResourceGUID,
+			diffIgnore,
+		)
+		if err != nil {
+			return err
+		}
+
+		drift := 0
+		for _, d := range diffs {
+			if d.Status != internal.DiffSame {
+				drift++
+			}
+		}
+
+		if diffOutput == "json" {
+			data, err := json.MarshalIndent(diffs, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else {
+			printDiffs(diffs)
+		}
+
+		if diffExitCode && drift > 0 {
+			return fmt.Errorf("%d of %d items differ", drift, len(diffs))
+		}
+		return nil
+	},
+}
+
+func printDiffs(diffs []internal.ItemDiff) {
+	counts := map[string]int{}
+	for _, d := range diffs {
+		counts[d.Status]++
+		switch d.Status {
+		case internal.DiffOnlyBackup:
+			fmt.Printf("- %s (only in backup)\n", d.Item)
+		case internal.DiffOnlyLive:
+			fmt.Printf("+ %s (only in live project)\n", d.Item)
+		case internal.DiffChanged:
+			fmt.Printf("~ %s\n", d.Item)
+			for _, c := range d.Changes {
+				switch c.Op {
+				case internal.DiffAdd:
+					fmt.Printf("    + %s: %s\n", c.Path, diffValue(c.Live))
+				case internal.DiffRemove:
+					fmt.Printf("    - %s: %s\n", c.Path, diffValue(c.Backup))
+				default:
+					fmt.Printf("    ~ %s: %s -> %s\n", c.Path, diffValue(c.Backup), diffValue(c.Live))
+				}
+			}
+		}
+	}
+	fmt.Printf("\n%d same, %d changed, %d only in backup, %d only in live project\n",
+		counts[internal.DiffSame], counts[internal.DiffChanged], counts[internal.DiffOnlyBackup], counts[internal.DiffOnlyLive])
+}
+
+func diffValue(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVar(&diffSourceOrg, "source-org", "", "Organization of the backup")
+	diffCmd.Flags().StringVar(&diffSourceProject, "source-project", "", "Project of the backup")
+	diffCmd.Flags().StringVar(&diffTargetOrg, "target-org", "", "Live organization to compare with (defaults to source-org)")
+	diffCmd.Flags().StringVar(&diffTargetProject, "target-project", "", "Live project to compare with (defaults to source-project)")
+	diffCmd.Flags().StringVar(&diffKind, "kind", "", "Kind to compare: "+strings.Join(internal.DiffKinds, ","))
+	diffCmd.Flags().StringSliceVar(&diffItems, "items", []string{"all"}, "Item names (backup file names without .json), 'all', globs, re:REGEX, !exclusions or @file (see README)")
+	diffCmd.Flags().StringSliceVar(&diffIgnore, "ignore", []string{}, "Extra JSON paths to ignore, e.g. 'repository.id' or 'process.phases.*.target.queue' (repeatable)")
+	diffCmd.Flags().StringVar(&diffOutput, "output", "text", "Output format: text or json")
+	diffCmd.Flags().BoolVar(&diffExitCode, "exit-code", false, "Exit with an error if any item differs")
+	diffCmd.Flags().StringVar(&diffAt, "at", "", "Compare the newest snapshot taken at or before this time (see create-* --at; default: newest)")
+	diffCmd.Flags().StringVar(&diffResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
+
+	diffCmd.MarkFlagRequired("source-org")
+	diffCmd.MarkFlagRequired("source-project")
+	diffCmd.MarkFlagRequired("kind")
+}
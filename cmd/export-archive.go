@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var exportSourceOrg string
+var exportSourceProject string
+var exportSnapshot string
+var exportFile string
+var exportPublicKey string
+var exportRequireSignature bool
+
+var exportArchiveCmd = &cobra.Command{
+	Use:   "export-archive",
+	Short: "Pack the backup snapshots of a project into one tar.zst archive",
+	Long: `Packs the backup snapshots of a project into one zstd compressed tar
+archive, to move them to cold storage or into an air-gapped environment, where
+import-archive unpacks them.
+
+The archive starts with archive.json, which lists every file in it with its
+SHA-256. Each snapshot follows with its manifest, signature and snapshot.json
+first. Mirrored repos and wikis are packed as git bundles. The snapshots of an
+encrypted store stay encrypted, along with the project's wrapped data key.
+
+Every snapshot must be finished, be of the current backup schema (see
+upgrade-backup) and pass verify-backup: it must match its manifest, its
+mirrors must pass git fsck, and a signed manifest is checked with
+--public-key, else with the org's signing key.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := mustLoadConfig()
+		if err != nil {
+			return err
+		}
+		orgName, orgCfg, err := cfg.ResolveOrganizationWithName(exportSourceOrg)
+		if err != nil {
+			return err
+		}
+
+		pub, err := verifyKey(exportPublicKey, orgCfg, exportRequireSignature)
+		if err != nil {
+			return err
+		}
+
+		root := projectRoot(orgName, orgCfg, exportSourceProject)
+		fmt.Printf("Snapshots of %s:\n", exportSourceProject)
+		var snaps []*internal.Snapshot
+		if exportSnapshot == "" {
+			all, err := internal.ListSnapshots(root)
+			if err != nil {
+				return err
+			}
+			for _, s := range all {
+				if !s.Finished() {
+					fmt.Printf(" ⚠ %s: unfinished, skipped\n", s.ID)
+					continue
+				}
+				snaps = append(snaps, s)
+			}
+		} else {
+			id := exportSnapshot
+			if id == internal.LatestSnapshot {
+				id = ""
+			}
+			snap, err := internal.FindSnapshot(root, id)
+			if err != nil {
+				return err
+			}
+			snaps = append(snaps, snap)
+		}
+
+		file := exportFile
+		if file == "" {
+			file = fmt.Sprintf("%s-%s-%s%s", orgName, exportSourceProject, time.Now().UTC().Format("20060102T150405Z"), internal.ArchiveExt)
+		}
+		m, err := internal.ExportArchive(root, snaps, orgCfg, exportSourceProject, file, pub, exportRequireSignature)
+		if err != nil {
+			return fmt.Errorf("export failed: %w", err)
+		}
+
+		for _, id := range m.Snapshots {
+			fmt.Printf(" ✔ %s\n", id)
+		}
+		if m.Encrypted {
+			fmt.Println(" ⚠ encrypted: importing needs the org's encryption key or passphrase")
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✔ Wrote %s: %d snapshot(s), %d files, %d bytes\n", file, len(m.Snapshots), len(m.Entries), info.Size())
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportArchiveCmd)
+
+	exportArchiveCmd.Flags().StringVar(&exportSourceOrg, "source-org", "", "Organization the backup belongs to (default: the default organization)")
+	exportArchiveCmd.Flags().StringVar(&exportSourceProject, "source-project", "", "Project whose backup to export")
+	exportArchiveCmd.Flags().StringVar(&exportSnapshot, "snapshot", "", "Snapshot id to export, or 'latest' (default: every finished snapshot)")
+	exportArchiveCmd.Flags().StringVar(&exportFile, "file", "", "Archive to write (default: {org}-{project}-{time}.tar.zst)")
+	exportArchiveCmd.Flags().StringVar(&exportPublicKey, "public-key", "", "ed25519 public key (PEM) to check signatures with (default: the org's signing key)")
+	exportArchiveCmd.Flags().BoolVar(&exportRequireSignature, "require-signature", false, "Refuse snapshots whose manifest is not signed")
+
+	exportArchiveCmd.MarkFlagRequired("source-project")
+}
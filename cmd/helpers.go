@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"path/filepath"
 
@@ -63,18 +64,72 @@ func resolveSourceTarget(
 	return srcName, srcCfg, resTargetOrg, tgtCfg, resTargetProject, nil
 }
 
-func backupPath(
-	cfg *internal.Config,
-	orgName string,
+// projectRoot returns {BackupRoot}/{org}/{project}, the folder holding the
+// snapshots of a project and the restore state of its migrations.
+func projectRoot(orgName string, orgCfg *internal.OrganizationConfig, project string) string {
+	return filepath.Join(orgCfg.BackupRoot, orgName, project)
+}
+
+// verifyKey loads the public key snapshot signatures are checked with: the
+// file given (--public-key), else the org's signing key; nil if neither is
+// set, which requireSignature makes an error.
+func verifyKey(file string, orgCfg *internal.OrganizationConfig, requireSignature bool) (ed25519.PublicKey, error) {
+	switch {
+	case file != "":
+		return internal.LoadVerifyKey(file)
+	case orgCfg.SigningKeyFile != "":
+		return internal.LoadVerifyKey(orgCfg.SigningKeyFile)
+	case requireSignature:
+		return nil, fmt.Errorf("--require-signature needs --public-key or a signing key for the org")
+	}
+	return nil, nil
+}
+
+// beginSnapshot starts the snapshot a backup of kind writes into. A backup
+// of only some items (selected) carries the others over from the previous
+// backup, so the snapshot still holds every item of kind.
+func beginSnapshot(orgName string, orgCfg *internal.OrganizationConfig, project, kind string, selected []string) (*internal.Snapshot, error) {
+	sel, err := internal.ParseSelector(selected)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := internal.NewSnapshot(projectRoot(orgName, orgCfg, project), orgCfg, project)
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot failed: %w", err)
+	}
+	fmt.Println("Snapshot:", snap.Dir())
+	if !sel.All() {
+		if err := snap.CarryForward(kind); err != nil {
+			return nil, finishSnapshot(snap, kind, fmt.Errorf("carry over %s from the previous backup: %w", kind, err))
+		}
+	}
+	return snap, nil
+}
+
+// finishSnapshot records whether the backup of kind into snap succeeded
+// (err is nil) and returns err.
+func finishSnapshot(snap *internal.Snapshot, kind string, err error) error {
+	kinds, failed := []string{kind}, []string(nil)
+	if err != nil {
+		kinds, failed = nil, kinds
+	}
+	if ferr := snap.Finish(kinds, failed); ferr != nil && err == nil {
+		return fmt.Errorf("finish snapshot failed: %w", ferr)
+	}
+	return err
+}
+
+// writeRefIndex writes refs.json (source ID -> name index) into the
+// snapshot folder dir, so restores of this backup do not need the source
+// project.
+func writeRefIndex(
 	orgCfg *internal.OrganizationConfig,
 	project string,
-	kind string,
-) string {
-	_ = cfg // not used today; kept for future extensibility
-	return filepath.Join(
-		orgCfg.BackupRoot,
-		orgName,
-		project,
-		kind,
-	)
+	dir string,
+	resourceGUID string,
+) error {
+	if err := internal.WriteRefIndex(orgCfg.URL, project, dir, resourceGUID); err != nil {
+		return fmt.Errorf("write reference index failed: %w", err)
+	}
+	return nil
 }
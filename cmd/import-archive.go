@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var importFile string
+var importTargetOrg string
+var importTargetProject string
+var importDryRun bool
+var importPublicKey string
+var importRequireSignature bool
+
+var importArchiveCmd = &cobra.Command{
+	Use:   "import-archive",
+	Short: "Unpack an archive written by export-archive into the backup folder",
+	Long: `Unpacks an archive written by export-archive into the backup folder of a
+project, where restores, verify-backup and later backups find its snapshots.
+
+Every file is checked against archive.json, git bundles become mirrors again,
+and each snapshot must pass verify-backup before it is moved into place, so a
+damaged archive adds nothing: it must match its manifest, its mirrors must
+pass git fsck, and a signed manifest is checked with --public-key, else with
+the org's signing key. Snapshots the project folder already
+has are left as they are. latest moves to the archive's latest snapshot
+unless the folder has a newer one.
+
+An encrypted archive needs the target org to be configured with the key or
+passphrase it was written with. An unencrypted archive is refused by an org
+that encrypts its backups.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := mustLoadConfig()
+		if err != nil {
+			return err
+		}
+		orgName, orgCfg, err := cfg.ResolveOrganizationWithName(importTargetOrg)
+		if err != nil {
+			return err
+		}
+
+		pub, err := verifyKey(importPublicKey, orgCfg, importRequireSignature)
+		if err != nil {
+			return err
+		}
+
+		head, err := internal.ReadArchiveManifest(importFile)
+		if err != nil {
+			return err
+		}
+		project := importTargetProject
+		if project == "" {
+			project = head.Project
+		}
+		fmt.Printf("Archive of %s (%s), written %s by azdo-vault %s\n",
+			head.Project, head.OrganizationURL, head.CreatedAt.Format("2006-01-02 15:04:05Z"), head.ToolVersion)
+
+		res, err := internal.ImportArchive(importFile, projectRoot(orgName, orgCfg, project), pub, importRequireSignature, importDryRun)
+		if err != nil {
+			if res != nil {
+				printArchiveImport(res, project, importDryRun)
+			}
+			return fmt.Errorf("import failed: %w", err)
+		}
+		printArchiveImport(res, project, importDryRun)
+		return nil
+	},
+}
+
+func printArchiveImport(res *internal.ArchiveImport, project string, dryRun bool) {
+	verb := "imported"
+	if dryRun {
+		verb = "would import"
+	}
+	fmt.Printf("\n%s:\n", project)
+	for _, id := range res.Imported {
+		fmt.Printf(" ✔ %s: %s\n", id, verb)
+	}
+	for _, id := range res.Existing {
+		fmt.Printf(" ⚠ %s: already there, left as it is\n", id)
+	}
+	if res.Latest {
+		fmt.Printf(" ✔ latest -> %s\n", res.Manifest.Latest)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(importArchiveCmd)
+
+	importArchiveCmd.Flags().StringVar(&importFile, "file", "", "Archive to import")
+	importArchiveCmd.Flags().StringVar(&importTargetOrg, "target-org", "", "Organization whose backup folder to import into (default: the default organization)")
+	importArchiveCmd.Flags().StringVar(&importTargetProject, "target-project", "", "Project folder to import into (default: the archive's project)")
+	importArchiveCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "Only show what would be imported")
+	importArchiveCmd.Flags().StringVar(&importPublicKey, "public-key", "", "ed25519 public key (PEM) to check signatures with (default: the org's signing key)")
+	importArchiveCmd.Flags().BoolVar(&importRequireSignature, "require-signature", false, "Refuse snapshots whose manifest is not signed")
+
+	importArchiveCmd.MarkFlagRequired("file")
+}
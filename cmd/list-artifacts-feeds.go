@@ -44,9 +44,8 @@ func init() {
 
 	listArtifactsFeedsCmd.Flags().StringVar(&artifactsOrg, "org", "", "Organization name from config (e.g. dot)")
 	listArtifactsFeedsCmd.Flags().StringVar(&artifactsProject, "project", "", "Project name")
-	listArtifactsFeedsCmd.Flags().StringVar(&artifactsResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	listArtifactsFeedsCmd.Flags().StringVar(&artifactsResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
 	listArtifactsFeedsCmd.MarkFlagRequired("org")
 	listArtifactsFeedsCmd.MarkFlagRequired("project")
-	listArtifactsFeedsCmd.MarkFlagRequired("ado-resource-guid")
 }
@@ -47,10 +47,9 @@ func init() {
 	listArtifactsPackagesCmd.Flags().StringVar(&artifactsPackagesProject, "project", "", "Project name")
 	listArtifactsPackagesCmd.Flags().StringVar(&artifactsFeedID, "feed-id", "", "Feed ID (GUID)")
 	listArtifactsPackagesCmd.Flags().StringVar(&artifactsProtocol, "protocol", "", "Protocol filter: npm|maven|nuget|pypi (optional)")
-	listArtifactsPackagesCmd.Flags().StringVar(&artifactsPackagesResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	listArtifactsPackagesCmd.Flags().StringVar(&artifactsPackagesResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
 	listArtifactsPackagesCmd.MarkFlagRequired("org")
 	listArtifactsPackagesCmd.MarkFlagRequired("project")
 	listArtifactsPackagesCmd.MarkFlagRequired("feed-id")
-	listArtifactsFeedsCmd.MarkFlagRequired("ado-resource-guid")
 }
@@ -47,11 +47,10 @@ func init() {
 	listArtifactsVersionsCmd.Flags().StringVar(&artifactsVersionsProject, "project", "", "Project name")
 	listArtifactsVersionsCmd.Flags().StringVar(&artifactsVersionsFeedID, "feed-id", "", "Feed ID (GUID)")
 	listArtifactsVersionsCmd.Flags().StringVar(&artifactsPackageID, "package-id", "", "Package ID (GUID)")
-	listArtifactsVersionsCmd.Flags().StringVar(&artifactsVersionsResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	listArtifactsVersionsCmd.Flags().StringVar(&artifactsVersionsResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
 	listArtifactsVersionsCmd.MarkFlagRequired("org")
 	listArtifactsVersionsCmd.MarkFlagRequired("project")
 	listArtifactsVersionsCmd.MarkFlagRequired("feed-id")
 	listArtifactsVersionsCmd.MarkFlagRequired("package-id")
-	listArtifactsVersionsCmd.MarkFlagRequired("ado-resource-guid")
 }
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+// --manifest, shared by every create-* command and migrate-project.
+var restoreManifestPath string
+var restoreManifest *internal.Manifest
+var restoreKindModes map[string]string
+var restoreIdentities *internal.IdentityResolver
+var restoreNames *internal.Renamer
+var restoreKindCollisions map[string]string
+
+func addManifestFlag(c *cobra.Command) {
+	c.Flags().StringVar(&restoreManifestPath, "manifest", "", "Migration manifest (migration.yaml) with projects, kinds, selections, per-kind options and mappings; flags take precedence")
+}
+
+// restoreScope points at the flags of one restore command that a manifest
+// can fill in.
+type restoreScope struct {
+	kind          string // the command's kind; "" for migrate-project
+	sourceOrg     *string
+	sourceProject *string
+	targetOrg     *string
+	targetProject *string
+	resourceGUID  *string
+	names         *[]string // the selection flag, if the command has one
+	namesFlag     string
+	required      []string // flags that must be set, on the command line or in the manifest
+}
+
+// applyManifest loads --manifest and fills every flag of s that was not
+// given on the command line, and loads the identity mappings and rename
+// rules of the manifest and the flags. It then checks s.required, which replaces
+// MarkFlagRequired for flags a manifest can provide.
+func applyManifest(cmd *cobra.Command, s restoreScope) error {
+	if restoreManifestPath != "" {
+		m, err := internal.LoadManifest(restoreManifestPath)
+		if err != nil {
+			return err
+		}
+		restoreManifest = m
+
+		fill := func(flag string, dst *string, v string) {
+			if dst != nil && v != "" && !cmd.Flags().Changed(flag) {
+				*dst = v
+			}
+		}
+		fill("source-org", s.sourceOrg, m.Source.Org)
+		fill("source-project", s.sourceProject, m.Source.Project)
+		fill("target-org", s.targetOrg, m.Target.Org)
+		fill("target-project", s.targetProject, m.Target.Project)
+		fill("ado-resource-guid", s.resourceGUID, m.ResourceGUID)
+		if s.names != nil && !cmd.Flags().Changed(s.namesFlag) {
+			*s.names = m.Selection(s.kind)
+		}
+		if !cmd.Flags().Changed("mode") {
+			restoreKindModes = m.PerKindMode()
+		}
+		if !cmd.Flags().Changed("on-collision") {
+			restoreKindCollisions = m.PerKindCollision()
+		}
+		fmt.Println("Manifest:", m.Path())
+	}
+
+	ids, err := manifestMappings().IdentityMap()
+	if err != nil {
+		return err
+	}
+	if restoreIdentityMap != "" {
+		f, err := internal.LoadIdentityMap(restoreIdentityMap)
+		if err != nil {
+			return err
+		}
+		ids.Merge(f)
+	}
+	restoreIdentities = internal.NewIdentityResolver(ids)
+
+	if err := internal.ValidateCollision(s.kind, restoreCollision); err != nil {
+		return fmt.Errorf("--on-collision: %w", err)
+	}
+	extra := map[string][]internal.RenameRule{}
+	for _, v := range restoreRenames {
+		kind, rule, err := internal.ParseRenameTemplate(v)
+		if err != nil {
+			return err
+		}
+		extra[kind] = append(extra[kind], rule)
+	}
+	if restoreNames, err = internal.NewRenamer(manifestMappings(), extra); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, flag := range s.required {
+		empty := false
+		switch flag {
+		case "source-org":
+			empty = *s.sourceOrg == ""
+		case "source-project":
+			empty = *s.sourceProject == ""
+		case s.namesFlag:
+			empty = len(*s.names) == 0
+		}
+		if empty {
+			missing = append(missing, strconv.Quote(flag))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("required flag(s) %s not set (on the command line or in --manifest)", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// manifestMappings returns the name mappings of --manifest, if any.
+func manifestMappings() *internal.Mappings {
+	if restoreManifest == nil {
+		return nil
+	}
+	return restoreManifest.Mappings
+}
+
+// manifestQueueMap puts the queue mappings of --manifest before the
+// --queue-map pairs, so the flags win.
+func manifestQueueMap(pairs []string) []string {
+	return append(manifestMappings().QueuePairs(), pairs...)
+}
+
+// manifestDefaultQueue returns --default-queue, else the manifest's defaultQueue.
+func manifestDefaultQueue(flag string) string {
+	if flag == "" && manifestMappings() != nil {
+		return manifestMappings().DefaultQueue
+	}
+	return flag
+}
+
+// manifestSelection returns the item selection of every step for
+// MigrationPlan.Select; nil without --manifest.
+func manifestSelection(steps []string) map[string][]string {
+	if restoreManifest == nil {
+		return nil
+	}
+	sel := map[string][]string{}
+	for _, step := range steps {
+		sel[step] = restoreManifest.Selection(step)
+	}
+	return sel
+}
+
+// manifestParallelism returns the per-kind parallelism of --manifest as
+// "kind=N" values, to be read before (and overridden by) --parallelism.
+func manifestParallelism() []string {
+	var values []string
+	for kind, n := range restoreManifest.PerKindParallelism() {
+		values = append(values, fmt.Sprintf("%s=%d", kind, n))
+	}
+	return values
+}
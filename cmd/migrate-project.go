@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateSourceOrg string
+var migrateSourceProject string
+var migrateTargetOrg string
+var migrateTargetProject string
+var migrateInclude []string
+var migrateExclude []string
+var migrateResourceGUID string
+var migrateQueueMap []string
+var migrateDefaultQueue string
+var migrateStateFile string
+var migrateReset bool
+
+var migrateProjectCmd = &cobra.Command{
+	Use:   "migrate-project",
+	Short: "Restore a whole project backup into the target in dependency order (resumable)",
+	Long: `Runs the create-* steps in dependency order:
+  repos -> push -> service connections -> variable groups -> task groups ->
+  build definitions -> YAML pipelines -> release definitions -> branch policies ->
+  wikis -> artifacts feeds
+
+Progress is saved per item in a state file. Re-running the same command skips
+items that already finished and retries the ones that failed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			sourceOrg:     &migrateSourceOrg,
+			sourceProject: &migrateSourceProject,
+			targetOrg:     &migrateTargetOrg,
+			targetProject: &migrateTargetProject,
+			resourceGUID:  &migrateResourceGUID,
+			required:      []string{"source-org", "source-project"},
+		}); err != nil {
+			return err
+		}
+		if restoreManifest != nil && !cmd.Flags().Changed("include") {
+			migrateInclude = restoreManifest.Steps()
+		}
+
+		steps, err := internal.SelectMigrationSteps(migrateInclude, migrateExclude)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := mustLoadConfig()
+		if err != nil {
+			return err
+		}
+
+		sourceOrgName, sourceOrgCfg, targetOrgName, targetOrgCfg, targetProject, err := resolveSourceTarget(
+			cfg,
+			migrateSourceOrg, migrateSourceProject,
+			migrateTargetOrg, migrateTargetProject,
+		)
+		if err != nil {
+			return err
+		}
+
+		at, err := internal.ParseSnapshotTime(restoreAt)
+		if err != nil {
+			return fmt.Errorf("--at: %w", err)
+		}
+		root := projectRoot(sourceOrgName, sourceOrgCfg, migrateSourceProject)
+
+		statePath := migrateStateFile
+		if statePath == "" {
+			statePath = filepath.Join(root, fmt.Sprintf("migrate-state.%s.%s.json", targetOrgName, targetProject))
+		}
+		if migrateReset && !restoreDryRun {
+			if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		fmt.Printf("Migrating %s/%s -> %s/%s\n", sourceOrgCfg.URL, migrateSourceProject, targetOrgCfg.URL, targetProject)
+		fmt.Println("Steps:", strings.Join(steps, " -> "))
+		fmt.Println("State:", statePath)
+
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, migrateSourceProject, targetOrgName, targetProject); err != nil {
+			return err
+		}
+
+		report := &internal.Report{}
+		state, runErr := internal.MigrateProject(internal.MigrationPlan{
+			SourceOrgURL:  sourceOrgCfg.URL,
+			SourceProject: migrateSourceProject,
+			TargetOrgURL:  targetOrgCfg.URL,
+			TargetProject: targetProject,
+			BackupRoot:    root,
+			At:            at,
+			StatePath:     statePath,
+			Steps:         steps,
+			ResourceGUID:  migrateResourceGUID,
+			QueueMap:      manifestQueueMap(migrateQueueMap),
+			DefaultQueue:  manifestDefaultQueue(migrateDefaultQueue),
+			Select:        manifestSelection(steps),
+			Options:       newRestoreOptions(targetOrgCfg),
+			Report:        report,
+		})
+		if state == nil {
+			return runErr
+		}
+		if restoreDryRun {
+			return finishRestore(report.Results, runErr)
+		}
+
+		fmt.Println("\nSummary:")
+		for _, step := range steps {
+			st := state.Steps[step]
+			if st == nil {
+				continue
+			}
+			done, failed := 0, 0
+			for _, it := range st.Items {
+				switch it.Status {
+				case internal.MigrationDone:
+					done++
+				case internal.MigrationFailed:
+					failed++
+				}
+			}
+			mark := "✔"
+			if st.Status == internal.MigrationFailed {
+				mark = "✖"
+			}
+			fmt.Printf(" %s %-20s done=%d failed=%d\n", mark, step, done, failed)
+		}
+
+		if runErr != nil {
+			return finishRestore(report.Results, fmt.Errorf("migrate-project: %w", runErr))
+		}
+		fmt.Println("✔ Migration completed")
+		return finishRestore(report.Results, nil)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateProjectCmd)
+
+	migrateProjectCmd.Flags().StringVar(&migrateSourceOrg, "source-org", "", "Source organization (where backup exists)")
+	migrateProjectCmd.Flags().StringVar(&migrateSourceProject, "source-project", "", "Source project (where backup exists)")
+	migrateProjectCmd.Flags().StringVar(&migrateTargetOrg, "target-org", "", "Target organization (defaults to source-org if omitted)")
+	migrateProjectCmd.Flags().StringVar(&migrateTargetProject, "target-project", "", "Target project (defaults to source-project if omitted)")
+	migrateProjectCmd.Flags().StringSliceVar(&migrateInclude, "include", []string{"all"}, "Steps to run or 'all' (default: the kinds of --manifest, else all): "+strings.Join(internal.MigrationSteps, ","))
+	migrateProjectCmd.Flags().StringSliceVar(&migrateExclude, "exclude", []string{}, "Steps to skip (their resources must already exist in the target)")
+	migrateProjectCmd.Flags().StringVar(&migrateResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
+	migrateProjectCmd.Flags().StringSliceVar(&migrateQueueMap, "queue-map", []string{}, "Queue mapping in form 'SourceQueue=TargetQueue' (repeatable)")
+	migrateProjectCmd.Flags().StringVar(&migrateDefaultQueue, "default-queue", "", "Fallback target queue name when no mapping/match exists")
+	migrateProjectCmd.Flags().StringVar(&migrateStateFile, "state-file", "", "Progress file (default: migrate-state.{target-org}.{target-project}.json in the source backup folder)")
+	migrateProjectCmd.Flags().BoolVar(&migrateReset, "reset", false, "Discard saved progress and start over")
+
+	addRestoreFlags(migrateProjectCmd)
+	addModeFlag(migrateProjectCmd)
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"azdo-vault/internal"
@@ -36,22 +35,16 @@ var mirrorCloneCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		fmt.Printf("Found %d repos\n", len(allRepos))
 
+		sel, err := internal.ParseSelector(repos)
+		if err != nil {
+			return err
+		}
 		var selected []internal.Repo
-
-		if len(repos) == 1 && strings.ToLower(repos[0]) == "all" {
-			selected = allRepos
-		} else {
-
-			wanted := make(map[string]bool)
-			for _, name := range repos {
-				wanted[strings.ToLower(name)] = true
-			}
-
-			for _, r := range allRepos {
-				if wanted[strings.ToLower(r.Name)] {
-					selected = append(selected, r)
-				}
+		for _, r := range allRepos {
+			if sel.Match(r.Name) {
+				selected = append(selected, r)
 			}
 		}
 		if len(selected) == 0 {
@@ -83,15 +76,16 @@ var mirrorCloneCmd = &cobra.Command{
 			return nil
 		}
 
-		for _, r := range selected {
-			dest := filepath.Join(orgCfg.BackupRoot, orgNameOrDefault(cfg, orgName), project, "repos", r.Name+".git")
-			fmt.Println("Cloning:", r.Name)
-			if err := internal.MirrorClone(r.RemoteURL, dest); err != nil {
-				return err
-			}
+		snap, err := beginSnapshot(orgNameOrDefault(cfg, orgName), orgCfg, project, internal.KindRepos, repos)
+		if err != nil {
+			return err
+		}
+		err = internal.MirrorCloneRepos(selected, snap.Path(internal.KindRepos), newExecOptions(orgCfg))
+		if err := finishSnapshot(snap, internal.KindRepos, err); err != nil {
+			return err
 		}
 
-		fmt.Println("✔ Mirror clone completed\n\nThe path is:", orgCfg.BackupRoot)
+		fmt.Println("✔ Mirror clone completed\n\nThe path is:", snap.Path(internal.KindRepos))
 		return nil
 	},
 }
@@ -106,8 +100,9 @@ func orgNameOrDefault(cfg *internal.Config, name string) string {
 func init() {
 	rootCmd.AddCommand(mirrorCloneCmd)
 	mirrorCloneCmd.Flags().StringVar(&project, "project", "", "Azure DevOps project name")
-	mirrorCloneCmd.Flags().StringSliceVar(&repos, "repos", []string{}, "Repo names or 'all'")
+	mirrorCloneCmd.Flags().StringSliceVar(&repos, "repos", []string{}, "Repo names, 'all', globs, re:REGEX, !exclusions or @file (see README)")
 	mirrorCloneCmd.Flags().StringVar(&orgName, "org", "", "Organization name (optional)")
+	addParallelFlags(mirrorCloneCmd)
 	mirrorCloneCmd.MarkFlagRequired("project")
 	mirrorCloneCmd.MarkFlagRequired("repos")
 }
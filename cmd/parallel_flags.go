@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"strings"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+// Parallelism flags shared by the backup-*, create-*, migrate-project and
+// mirror-clone commands.
+var execParallelism parallelismValue
+var execOnError onErrorValue
+
+func addParallelFlags(c *cobra.Command) {
+	c.Flags().Var(&execParallelism, "parallelism",
+		"Items processed at once: N for every kind or kind=N for one kind, repeatable (default: the org's parallelism setting, else 1)")
+	c.Flags().Var(&execOnError, "on-error",
+		"When an item fails: fail-fast (start no new items) or collect (finish all items, report every failure). Default: restores fail fast, repo backups collect")
+}
+
+// newExecOptions combines the flags with the parallelism configured for org
+// and the per-kind parallelism of --manifest.
+func newExecOptions(orgCfg *internal.OrganizationConfig) *internal.ExecOptions {
+	base := 0
+	if orgCfg != nil {
+		base = orgCfg.Parallelism
+	}
+	// values were validated when the flags (or the manifest) were parsed
+	values := append(manifestParallelism(), execParallelism.values...)
+	opts, _ := internal.ParseParallelism(values, base, string(execOnError))
+	return opts
+}
+
+// parallelismValue is --parallelism: "N" or "kind=N", repeatable and comma separated.
+type parallelismValue struct {
+	values []string
+}
+
+func (p *parallelismValue) Set(v string) error {
+	parts := strings.Split(v, ",")
+	if _, err := internal.ParseParallelism(parts, 0, ""); err != nil {
+		return err
+	}
+	p.values = append(p.values, parts...)
+	return nil
+}
+
+func (p *parallelismValue) String() string { return strings.Join(p.values, ",") }
+func (p *parallelismValue) Type() string   { return "N|kind=N" }
+
+// onErrorValue is --on-error: one of internal.OnErrorPolicies.
+type onErrorValue string
+
+func (o *onErrorValue) Set(v string) error {
+	if _, err := internal.ParseParallelism(nil, 0, v); err != nil {
+		return err
+	}
+	*o = onErrorValue(v)
+	return nil
+}
+
+func (o *onErrorValue) String() string { return string(*o) }
+func (o *onErrorValue) Type() string   { return "policy" }
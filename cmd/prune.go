@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var pruneSourceOrg string
+var pruneSourceProject string
+var pruneKeep internal.RetentionConfig
+var pruneDryRun bool
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove the backup snapshots the retention policy does not keep",
+	Long: `Removes backup snapshots of one project, or of every project of an
+organization, that the retention policy does not keep. The policy is the org's
+retention setting; --keep-* flags override it.
+
+Of the snapshots holding a kind, prune keeps the newest, plus the newest of
+each of the last N days, weeks and months that have one. Each kind is counted
+on its own, so a kind backed up less often than the others keeps its
+snapshots. Snapshots of runs that never finished are removed after a day.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := mustLoadConfig()
+		if err != nil {
+			return err
+		}
+		orgName, orgCfg, err := cfg.ResolveOrganizationWithName(pruneSourceOrg)
+		if err != nil {
+			return err
+		}
+
+		keep := internal.RetentionConfig{}
+		if orgCfg.Retention != nil {
+			keep = *orgCfg.Retention
+		}
+		if cmd.Flags().Changed("keep-daily") {
+			keep.KeepDaily = pruneKeep.KeepDaily
+		}
+		if cmd.Flags().Changed("keep-weekly") {
+			keep.KeepWeekly = pruneKeep.KeepWeekly
+		}
+		if cmd.Flags().Changed("keep-monthly") {
+			keep.KeepMonthly = pruneKeep.KeepMonthly
+		}
+		if keep.Empty() {
+			return fmt.Errorf("no retention policy: give --keep-daily, --keep-weekly or --keep-monthly, or set retention for the org (configure add --keep-*)")
+		}
+
+		projects := []string{pruneSourceProject}
+		if pruneSourceProject == "" {
+			if projects, err = snapshotProjects(filepath.Join(orgCfg.BackupRoot, orgName)); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Retention: daily=%d weekly=%d monthly=%d\n", keep.KeepDaily, keep.KeepWeekly, keep.KeepMonthly)
+
+		verb := "Removed"
+		if pruneDryRun {
+			verb = "Would remove"
+		}
+		for _, project := range projects {
+			res, err := internal.PruneSnapshots(projectRoot(orgName, orgCfg, project), keep, pruneDryRun)
+			if err != nil {
+				return fmt.Errorf("%s: %w", project, err)
+			}
+			fmt.Printf("\n%s: keeping %d, removing %d\n", project, len(res.Kept), len(res.Removed))
+			for _, s := range res.Kept {
+				fmt.Printf(" ✔ %s (%s)\n", s.ID, strings.Join(res.Reasons[s.ID], ", "))
+			}
+			for _, s := range res.Removed {
+				fmt.Printf(" ✖ %s %s\n", verb, s.ID)
+			}
+		}
+		return nil
+	},
+}
+
+// snapshotProjects lists the projects below an org's backup folder that
+// have snapshots.
+func snapshotProjects(orgRoot string) ([]string, error) {
+	entries, err := os.ReadDir(orgRoot)
+	if err != nil {
+		return nil, err
+	}
+	var projects []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(orgRoot, e.Name(), internal.SnapshotsDir)); e.IsDir() && err == nil {
+			projects = append(projects, e.Name())
+		}
+	}
+	return projects, nil
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().StringVar(&pruneSourceOrg, "source-org", "", "Organization whose backups to prune (default: the default organization)")
+	pruneCmd.Flags().StringVar(&pruneSourceProject, "source-project", "", "Project whose backups to prune (default: every project of the org)")
+	pruneCmd.Flags().IntVar(&pruneKeep.KeepDaily, "keep-daily", 0, "Keep the newest snapshot of each of the last N days")
+	pruneCmd.Flags().IntVar(&pruneKeep.KeepWeekly, "keep-weekly", 0, "Keep the newest snapshot of each of the last N weeks")
+	pruneCmd.Flags().IntVar(&pruneKeep.KeepMonthly, "keep-monthly", 0, "Keep the newest snapshot of each of the last N months")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Only show which snapshots would be removed")
+}
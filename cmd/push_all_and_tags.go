@@ -2,9 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
 
 	"azdo-vault/internal"
 
@@ -24,6 +21,18 @@ var pushAllAndTagsCmd = &cobra.Command{
 	Use:   "push-all-and-tags",
 	Short: "Push all branches and tags to Azure DevOps repositories",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyManifest(cmd, restoreScope{
+			kind:          internal.StepPush,
+			sourceOrg:     &pushSourceOrg,
+			sourceProject: &pushSourceProject,
+			targetOrg:     &pushTargetOrg,
+			targetProject: &pushTargetProject,
+			names:         &pushRepos,
+			namesFlag:     "repos",
+			required:      []string{"source-project", "repos"},
+		}); err != nil {
+			return err
+		}
 
 		cfg, err := mustLoadConfig()
 		if err != nil {
@@ -47,59 +56,33 @@ var pushAllAndTagsCmd = &cobra.Command{
 			pushTargetProject = pushSourceProject
 		}
 
-		repoBasePath := filepath.Join(
-			sourceOrgCfg.BackupRoot,
-			sourceOrgName,
-			pushSourceProject,
-			"repos",
-		)
-
-		var repoNames []string
-
-		if len(pushRepos) == 1 && pushRepos[0] == "all" {
-
-			files, err := os.ReadDir(repoBasePath)
-			if err != nil {
-				return err
-			}
-
-			for _, f := range files {
-				if strings.HasSuffix(f.Name(), ".git") {
-					repoNames = append(repoNames,
-						strings.TrimSuffix(f.Name(), ".git"))
-				}
-			}
+		repoBasePath, err := restoreBackupPath(sourceOrgName, sourceOrgCfg, pushSourceProject, internal.KindRepos)
+		if err != nil {
+			return err
+		}
 
-		} else {
-			repoNames = pushRepos
+		repoNames, err := internal.SelectBackupRepos(repoBasePath, pushRepos)
+		if err != nil {
+			return err
 		}
 
 		if len(repoNames) == 0 {
 			return fmt.Errorf("no repositories found to push")
 		}
 
-		for _, repo := range repoNames {
-
-			localPath := filepath.Join(repoBasePath, repo+".git")
-
-			remoteURL, err := internal.GetRepoRemoteURL(
-				targetOrgCfg.URL,
-				pushTargetProject,
-				repo,
-			)
-			if err != nil {
-				return err
-			}
-
-			fmt.Println("Pushing:", repo)
+		if err := loadNamesLedger(sourceOrgCfg, sourceOrgName, pushSourceProject, pushTargetOrg, pushTargetProject); err != nil {
+			return err
+		}
 
-			if err := internal.PushAllAndTags(localPath, remoteURL); err != nil {
-				return err
-			}
+		results, err := internal.PushRepos(repoBasePath, targetOrgCfg.URL, pushTargetProject, repoNames, newRestoreOptions(targetOrgCfg))
+		if err != nil {
+			return finishRestore(results, err)
 		}
 
-		fmt.Println("✔ Mirror push completed")
-		return nil
+		if !restoreDryRun {
+			fmt.Println("✔ Mirror push completed")
+		}
+		return finishRestore(results, nil)
 	},
 }
 
@@ -110,7 +93,7 @@ func init() {
 		&pushRepos,
 		"repos",
 		[]string{},
-		"Repository names or 'all'",
+		"Repository names, 'all', globs, re:REGEX, !exclusions or @file (see README)",
 	)
 
 	pushAllAndTagsCmd.Flags().StringVar(
@@ -141,6 +124,5 @@ func init() {
 		"Target organization (where repos will be pushed)",
 	)
 
-	pushAllAndTagsCmd.MarkFlagRequired("repos")
-	pushAllAndTagsCmd.MarkFlagRequired("source-project")
+	addRestoreFlags(pushAllAndTagsCmd)
 }
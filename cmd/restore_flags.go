@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+// Restore flags shared by every create-* command and migrate-project.
+var restoreDryRun bool
+var restoreReport string
+var restorePlanOut string
+var restoreReportFormat string
+var restoreFailOnSkip bool
+var restoreMode string
+var restoreIdentityMap string
+var restoreRenames []string
+var restoreCollision string
+var restoreAt string
+
+func addRestoreFlags(c *cobra.Command) {
+	c.Flags().BoolVar(&restoreDryRun, "dry-run", false, "Plan only: show what would be created/skipped without writing to the target")
+	c.Flags().StringVar(&restoreReport, "report", "", "Write the per-item results (in a dry run: with sanitized, remapped payloads) to this file")
+	c.Flags().StringVar(&restorePlanOut, "plan-out", "", "Write the plan (in a dry run: with sanitized, remapped payloads) as JSON to this file; same as --report FILE --report-format json")
+	c.Flags().StringVar(&restoreReportFormat, "report-format", "", "Report format: json or junit (default: junit for *.xml, json otherwise)")
+	c.Flags().BoolVar(&restoreFailOnSkip, "fail-on-skip", false, "Exit with an error if any item failed or was skipped as unresolvable")
+	c.Flags().StringVar(&restoreIdentityMap, "identity-map", "", "Identity map file (CSV or YAML): source UPN/descriptor -> target UPN/descriptor, plus @domain rewrites; wins over the manifest's")
+	c.Flags().StringArrayVar(&restoreRenames, "rename", nil, "Rename rule KIND=TEMPLATE, e.g. repos=Payments-{name}; KIND is a kind, all or folders (repeatable, applied in order)")
+	c.Flags().StringVar(&restoreCollision, "on-collision", "", "When a target name is taken by an item this tool did not create: skip, suffix (create as name-2) or overwrite (update it); default: follow --mode")
+	c.Flags().StringVar(&restoreAt, "at", "", "Restore from the newest snapshot taken at or before this time: 2026-09-30 (end of day), 2026-09-30T18:00 (local time), RFC 3339 or a snapshot id (default: newest)")
+	addParallelFlags(c)
+	addManifestFlag(c)
+}
+
+// addModeFlag adds --mode to the commands whose kinds can be updated in place.
+func addModeFlag(c *cobra.Command) {
+	c.Flags().StringVar(&restoreMode, "mode", internal.ModeCreate,
+		"What to do with items that exist in the target: create (skip them), update (update them, skip missing ones) or sync (create and update)")
+	c.PreRunE = func(cmd *cobra.Command, args []string) error {
+		return internal.ValidateRestoreMode(restoreMode)
+	}
+}
+
+// newRestoreOptions returns the restore flags, completed by --manifest;
+// parallelism defaults to the setting of the target org, where the writes
+// go. The resource index is shared by every restore of the command.
+func newRestoreOptions(targetOrgCfg *internal.OrganizationConfig) *internal.RestoreOptions {
+	return &internal.RestoreOptions{
+		DryRun:      restoreDryRun,
+		Mode:        restoreMode,
+		PerKindMode: restoreKindModes,
+		Exec:        newExecOptions(targetOrgCfg),
+		Index:       internal.NewResourceIndex(),
+		Mappings:    manifestMappings(),
+		Identities:  restoreIdentities,
+		Names:       restoreNames,
+
+		Collision:        restoreCollision,
+		PerKindCollision: restoreKindCollisions,
+	}
+}
+
+// loadNamesLedger loads the names the restores of a source project created
+// in a target project, kept in names.{target-org}.{target-project}.json in
+// the source backup folder, so names picked by --on-collision suffix are
+// found again by later commands. A dry run reads it but never writes it.
+func loadNamesLedger(sourceOrgCfg *internal.OrganizationConfig, sourceOrgName, sourceProject, targetOrgName, targetProject string) error {
+	path := filepath.Join(projectRoot(sourceOrgName, sourceOrgCfg, sourceProject),
+		fmt.Sprintf("names.%s.%s.json", targetOrgName, targetProject))
+	return restoreNames.Load(path, !restoreDryRun)
+}
+
+// restoreBackupPath returns the backup folder of kind a restore reads: that
+// of the newest snapshot holding kind taken at or before --at.
+func restoreBackupPath(orgName string, orgCfg *internal.OrganizationConfig, project, kind string) (string, error) {
+	at, err := internal.ParseSnapshotTime(restoreAt)
+	if err != nil {
+		return "", fmt.Errorf("--at: %w", err)
+	}
+	path, snap, err := internal.BackupPath(projectRoot(orgName, orgCfg, project), kind, at)
+	if err != nil {
+		return "", err
+	}
+	if snap != nil {
+		fmt.Printf("Snapshot: %s (%s)\n", snap.ID, kind)
+	}
+	return path, nil
+}
+
+// finishRestore prints the results, writes --report and --plan-out and applies
+// --fail-on-skip.
+// runErr is returned unchanged so callers can `return finishRestore(results, err)`.
+func finishRestore(results []internal.RestoreResult, runErr error) error {
+	report := &internal.Report{Results: results}
+	c := report.Counts()
+
+	if restoreDryRun {
+		fmt.Println("\nPlan:")
+		if err := report.WriteTable(os.Stdout); err != nil {
+			return err
+		}
+		fmt.Printf("\n%d to create, %d to update, %d existing, %d missing, %d unresolvable\n",
+			c[internal.ActionCreate], c[internal.ActionUpdate], c[internal.ActionSkipExists], c[internal.ActionSkipMissing], c[internal.ActionSkipUnresolvable])
+	} else if len(results) > 0 {
+		fmt.Printf("\n%d created, %d updated, %d existing, %d missing, %d unresolvable, %d failed\n",
+			c[internal.ActionCreate], c[internal.ActionUpdate], c[internal.ActionSkipExists], c[internal.ActionSkipMissing], c[internal.ActionSkipUnresolvable], c["failed"])
+	}
+
+	if restoreReport != "" {
+		if err := writeReport(report, restoreReport, restoreReportFormat); err != nil {
+			return fmt.Errorf("write report: %w", err)
+		}
+		fmt.Println("✔ Report written to", restoreReport)
+	}
+	if restorePlanOut != "" {
+		if err := report.WriteJSON(restorePlanOut); err != nil {
+			return fmt.Errorf("write plan: %w", err)
+		}
+		fmt.Println("✔ Plan written to", restorePlanOut)
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+	if failed := report.Failed(); restoreFailOnSkip && len(failed) > 0 {
+		return fmt.Errorf("%d items failed or were skipped (--fail-on-skip)", len(failed))
+	}
+	return nil
+}
+
+func writeReport(report *internal.Report, path, format string) error {
+	if format == "" {
+		format = "json"
+		if strings.HasSuffix(strings.ToLower(path), ".xml") {
+			format = "junit"
+		}
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return report.WriteJSON(path)
+	case "junit":
+		return report.WriteJUnit(path)
+	}
+	return fmt.Errorf("unknown report format '%s' (use json or junit)", format)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 
+	"azdo-vault/internal"
+
 	"github.com/spf13/cobra"
 )
 
@@ -13,6 +15,8 @@ var rootCmd = &cobra.Command{
 	SilenceUsage: true,
 }
 
+var configProfile string
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -21,4 +25,9 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&configProfile, "profile", "", "Config profile to use (default: AZDO_VAULT_PROFILE, else the top-level organizations)")
+	cobra.OnInitialize(func() {
+		internal.SetProfile(configProfile)
+		internal.ToolVersion = Version
+	})
 }
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"strings"
 
 	"azdo-vault/internal"
 
@@ -39,18 +38,14 @@ var setDefaultBranchesCmd = &cobra.Command{
 			return nil
 		}
 
-		filter := map[string]bool{}
-		if len(setDefRepos) == 1 && strings.EqualFold(setDefRepos[0], "all") {
-			// no filter
-		} else {
-			for _, r := range setDefRepos {
-				filter[strings.ToLower(strings.TrimSpace(r))] = true
-			}
+		sel, err := internal.ParseSelector(setDefRepos)
+		if err != nil {
+			return err
 		}
 
 		changed := 0
 		for _, r := range repos {
-			if len(filter) > 0 && !filter[strings.ToLower(r.Name)] {
+			if !sel.Match(r.Name) {
 				continue
 			}
 
@@ -73,10 +68,9 @@ func init() {
 	setDefaultBranchesCmd.Flags().StringVar(&setDefOrg, "org", "", "Organization name from config")
 	setDefaultBranchesCmd.Flags().StringVar(&setDefProject, "project", "", "Project name")
 	setDefaultBranchesCmd.Flags().StringVar(&setDefBranch, "branch", "refs/heads/development", "Default branch (e.g. refs/heads/development or development)")
-	setDefaultBranchesCmd.Flags().StringSliceVar(&setDefRepos, "repos", []string{"all"}, "Repo names or 'all'")
-	setDefaultBranchesCmd.Flags().StringVar(&setDefResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (required for az rest)")
+	setDefaultBranchesCmd.Flags().StringSliceVar(&setDefRepos, "repos", []string{"all"}, "Repo names, 'all', globs, re:REGEX, !exclusions or @file (see README)")
+	setDefaultBranchesCmd.Flags().StringVar(&setDefResourceGUID, "ado-resource-guid", "", "Azure DevOps AAD resource GUID (used to request the access token; default: the org's resourceGuid)")
 
 	setDefaultBranchesCmd.MarkFlagRequired("org")
 	setDefaultBranchesCmd.MarkFlagRequired("project")
-	setDefaultBranchesCmd.MarkFlagRequired("ado-resource-guid")
 }
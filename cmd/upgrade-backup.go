@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var upgradeSourceOrg string
+var upgradeSourceProject string
+var upgradeDryRun bool
+var upgradeEncrypt bool
+
+var upgradeBackupCmd = &cobra.Command{
+	Use:   "upgrade-backup",
+	Short: "Rewrite backups written by older azdo-vault versions into the current layout",
+	Long: `Rewrites the backups of one project, or of every project of an
+organization, into the current backup schema:
+
+  - kind folders directly in the project folder, written before snapshots
+    existed, move into a snapshot named after the time of their newest file;
+  - older snapshots get the current schema version and a new manifest.json
+    (signed if the org has a signing key).
+
+If the org encrypts its backups, the ones written before encryption was
+turned on are refused by its readers until --encrypt encrypts them in place:
+each snapshot is checked against its manifest, its files are encrypted, its
+mirrors become encrypted bundles and it gets a new manifest.
+
+A snapshot whose manifest does not verify, or that is signed while the org
+has no signing key, is left as it is. Restores read old backups without
+upgrading them; upgrading makes them verifiable, prunable and exportable
+like new ones.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := mustLoadConfig()
+		if err != nil {
+			return err
+		}
+		orgName, orgCfg, err := cfg.ResolveOrganizationWithName(upgradeSourceOrg)
+		if err != nil {
+			return err
+		}
+
+		projects := []string{upgradeSourceProject}
+		if upgradeSourceProject == "" {
+			if projects, err = projectFolders(filepath.Join(orgCfg.BackupRoot, orgName)); err != nil {
+				return err
+			}
+		}
+
+		verb, move, encrypt := "Upgraded", "Moved", "encrypted"
+		if upgradeDryRun {
+			verb, move, encrypt = "Would upgrade", "Would move", "to encrypt"
+		}
+		skipped := 0
+		for _, project := range projects {
+			ups, err := internal.UpgradeBackup(projectRoot(orgName, orgCfg, project), orgCfg, project, upgradeEncrypt, upgradeDryRun)
+			fmt.Printf("\n%s:\n", project)
+			for _, u := range ups {
+				sealed := ""
+				if u.Encrypted {
+					sealed = ", " + encrypt
+				}
+				switch {
+				case u.Skipped == "unfinished":
+					fmt.Printf(" ⚠ %s: unfinished, skipped\n", u.Snapshot)
+				case u.Skipped != "":
+					skipped++
+					fmt.Printf(" ✖ %s: not upgraded: %s\n", u.Snapshot, u.Skipped)
+				case u.From == internal.BackupSchemaFlat:
+					fmt.Printf(" ✔ %s the backup in the project folder (%s) into snapshot %s%s\n", move, strings.Join(u.Kinds, ", "), u.Snapshot, sealed)
+				default:
+					fmt.Printf(" ✔ %s %s: schema %d -> %d%s\n", verb, u.Snapshot, u.From, internal.BackupSchemaVersion, sealed)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", project, err)
+			}
+			if len(ups) == 0 {
+				fmt.Println(" ✔ up to date")
+			}
+		}
+		if skipped > 0 {
+			return fmt.Errorf("%d snapshot(s) not upgraded", skipped)
+		}
+		return nil
+	},
+}
+
+// projectFolders lists the project folders below an org's backup folder.
+func projectFolders(orgRoot string) ([]string, error) {
+	entries, err := os.ReadDir(orgRoot)
+	if err != nil {
+		return nil, err
+	}
+	var projects []string
+	for _, e := range entries {
+		if e.IsDir() {
+			projects = append(projects, e.Name())
+		}
+	}
+	return projects, nil
+}
+
+func init() {
+	rootCmd.AddCommand(upgradeBackupCmd)
+
+	upgradeBackupCmd.Flags().StringVar(&upgradeSourceOrg, "source-org", "", "Organization whose backups to upgrade (default: the default organization)")
+	upgradeBackupCmd.Flags().StringVar(&upgradeSourceProject, "source-project", "", "Project whose backups to upgrade (default: every project of the org)")
+	upgradeBackupCmd.Flags().BoolVar(&upgradeDryRun, "dry-run", false, "Only show what would be upgraded")
+	upgradeBackupCmd.Flags().BoolVar(&upgradeEncrypt, "encrypt", false, "Encrypt the backups written before the org's encryption was turned on")
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+
+	"azdo-vault/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var verifySourceOrg string
+var verifySourceProject string
+var verifySnapshot string
+var verifyPublicKey string
+var verifyRequireSignature bool
+var verifyNoFsck bool
+
+var verifyBackupCmd = &cobra.Command{
+	Use:   "verify-backup",
+	Short: "Check a backup snapshot against its manifest",
+	Long: `Checks a backup snapshot against its manifest.json: every file it lists
+must be present and unchanged (SHA-256), no file may be added, and every
+mirrored repo must have the refs it had and pass git fsck.
+
+If the manifest is signed, the signature is checked with --public-key, else
+with the org's signing key; a signed snapshot fails if there is neither.
+--require-signature also fails unsigned snapshots.
+
+Exits non-zero if any snapshot has a problem.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := mustLoadConfig()
+		if err != nil {
+			return err
+		}
+		orgName, orgCfg, err := cfg.ResolveOrganizationWithName(verifySourceOrg)
+		if err != nil {
+			return err
+		}
+
+		pub, err := verifyKey(verifyPublicKey, orgCfg, verifyRequireSignature)
+		if err != nil {
+			return err
+		}
+
+		root := projectRoot(orgName, orgCfg, verifySourceProject)
+		var snaps []*internal.Snapshot
+		if verifySnapshot == "all" {
+			if snaps, err = internal.ListSnapshots(root); err == nil && len(snaps) == 0 {
+				err = fmt.Errorf("no snapshots in %s", root)
+			}
+		} else {
+			var snap *internal.Snapshot
+			snap, err = internal.FindSnapshot(root, verifySnapshot)
+			snaps = append(snaps, snap)
+		}
+		if err != nil {
+			return err
+		}
+
+		bad := 0
+		for _, snap := range snaps {
+			if !snap.Finished() && verifySnapshot == "all" {
+				fmt.Printf("\nSnapshot %s: unfinished, skipped\n", snap.ID)
+				continue
+			}
+			res, err := internal.VerifyBackup(snap, pub, verifyRequireSignature, !verifyNoFsck)
+			if err != nil {
+				return fmt.Errorf("%s: %w", snap.ID, err)
+			}
+			fmt.Printf("\nSnapshot %s: %d items\n", res.Snapshot, res.Items)
+			if !res.Signed {
+				fmt.Println(" ⚠ not signed")
+			}
+			for _, is := range res.Issues {
+				fmt.Printf(" ✖ %s: %s\n", is.Path, is.Problem)
+			}
+			if len(res.Issues) > 0 {
+				bad++
+				continue
+			}
+			if res.Signed {
+				fmt.Printf(" ✔ verified, signed by %s\n", res.KeyID)
+			} else {
+				fmt.Println(" ✔ verified")
+			}
+		}
+		if bad > 0 {
+			return fmt.Errorf("%d of %d snapshot(s) failed verification", bad, len(snaps))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyBackupCmd)
+
+	verifyBackupCmd.Flags().StringVar(&verifySourceOrg, "source-org", "", "Organization the backup belongs to (default: the default organization)")
+	verifyBackupCmd.Flags().StringVar(&verifySourceProject, "source-project", "", "Project the backup belongs to")
+	verifyBackupCmd.Flags().StringVar(&verifySnapshot, "snapshot", "", "Snapshot id to verify, or 'all' (default: latest)")
+	verifyBackupCmd.Flags().StringVar(&verifyPublicKey, "public-key", "", "ed25519 public key (PEM) to check signatures with (default: the org's signing key)")
+	verifyBackupCmd.Flags().BoolVar(&verifyRequireSignature, "require-signature", false, "Fail snapshots whose manifest is not signed")
+	verifyBackupCmd.Flags().BoolVar(&verifyNoFsck, "no-fsck", false, "Skip git fsck of bundled repos and of mirrors in snapshots before schema 3")
+
+	verifyBackupCmd.MarkFlagRequired("source-project")
+}
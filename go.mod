@@ -2,8 +2,13 @@ module azdo-vault
 
 go 1.24.5
 
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/spf13/cobra v1.10.2
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.10.2 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
 )
@@ -0,0 +1,82 @@
+package adoclient
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// APIProfiles maps a server generation to the newest REST API version it
+// supports. Requests are written against the cloud version; an older
+// profile lowers the api-version of every request (see WithAPIVersion).
+var APIProfiles = map[string]string{
+	"cloud":       "7.1",
+	"server-2022": "7.0",
+	"server-2020": "6.0",
+	"server-2019": "5.0",
+}
+
+// ResolveAPIVersion turns a profile name or an explicit "major.minor"
+// version into a version. Empty means the cloud default (no rewrite).
+func ResolveAPIVersion(profile string) (string, error) {
+	p := strings.ToLower(strings.TrimSpace(profile))
+	if p == "" {
+		return "", nil
+	}
+	if v, ok := APIProfiles[p]; ok {
+		if p == "cloud" {
+			return "", nil
+		}
+		return v, nil
+	}
+	major, minor, ok := strings.Cut(p, ".")
+	if ok && isDigits(major) && isDigits(minor) {
+		return p, nil
+	}
+
+	var names []string
+	for name := range APIProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("unknown api profile '%s' (use %s or a version like 6.0)", profile, strings.Join(names, ", "))
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithAPIVersion replaces the major.minor part of the api-version query
+// parameter of uri with version, keeping a "-preview.N" suffix:
+// 7.1-preview.1 becomes 6.0-preview.1 for version 6.0. An empty version,
+// or a uri without api-version, is returned unchanged.
+func WithAPIVersion(uri, version string) string {
+	if version == "" {
+		return uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	q := u.Query()
+	cur := q.Get("api-version")
+	if cur == "" {
+		return uri
+	}
+	suffix := ""
+	if i := strings.Index(cur, "-"); i >= 0 {
+		suffix = cur[i:]
+	}
+	q.Set("api-version", version+suffix)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
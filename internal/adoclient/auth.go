@@ -0,0 +1,127 @@
+package adoclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authorizer adds credentials to an outgoing request.
+type Authorizer interface {
+	Authorize(req *http.Request) error
+}
+
+// PAT authenticates with a personal access token (HTTP basic, empty user name).
+type PAT string
+
+func (p PAT) Authorize(req *http.Request) error {
+	if strings.TrimSpace(string(p)) == "" {
+		return fmt.Errorf("personal access token is empty")
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+string(p))))
+	return nil
+}
+
+// Bearer authenticates with a fixed OAuth access token.
+type Bearer string
+
+func (b Bearer) Authorize(req *http.Request) error {
+	if strings.TrimSpace(string(b)) == "" {
+		return fmt.Errorf("bearer token is empty")
+	}
+	req.Header.Set("Authorization", "Bearer "+string(b))
+	return nil
+}
+
+// AzureCLIToken asks `az account get-access-token` for a token of the signed-in
+// identity. The token is fetched once and reused until shortly before it expires,
+// so the az process is spawned once per run instead of once per request.
+type AzureCLIToken struct {
+	Resource string // AAD resource; DefaultResource when empty
+
+	cache tokenCache
+}
+
+func (a *AzureCLIToken) Authorize(req *http.Request) error {
+	return authorizeBearer(req, a.Token)
+}
+
+// Token returns a cached access token, refreshing it when it is about to expire.
+func (a *AzureCLIToken) Token() (string, error) {
+	return a.cache.get(func() (string, time.Time, error) {
+		cmd := exec.Command("az", "account", "get-access-token",
+			"--resource", resourceOrDefault(a.Resource),
+			"--output", "json",
+			"--only-show-errors",
+		)
+		out, err := cmd.Output()
+		if err != nil {
+			if ee, ok := err.(*exec.ExitError); ok {
+				return "", time.Time{}, fmt.Errorf("az account get-access-token failed (run: az login): %w\n%s", err, string(ee.Stderr))
+			}
+			return "", time.Time{}, fmt.Errorf("az account get-access-token failed (is the Azure CLI installed?): %w", err)
+		}
+
+		var resp struct {
+			AccessToken string `json:"accessToken"`
+			ExpiresOn   int64  `json:"expires_on"`
+		}
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return "", time.Time{}, fmt.Errorf("failed parsing az access token: %w", err)
+		}
+		if resp.AccessToken == "" {
+			return "", time.Time{}, fmt.Errorf("az account get-access-token returned no token")
+		}
+
+		expires := time.Now().Add(50 * time.Minute)
+		if resp.ExpiresOn > 0 {
+			expires = time.Unix(resp.ExpiresOn, 0)
+		}
+		return resp.AccessToken, expires, nil
+	})
+}
+
+// tokenCache holds one access token until shortly before it expires.
+type tokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (c *tokenCache) get(fetch func() (string, time.Time, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Until(c.expires) > 5*time.Minute {
+		return c.token, nil
+	}
+
+	tok, expires, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	c.token = tok
+	c.expires = expires
+	return tok, nil
+}
+
+func authorizeBearer(req *http.Request, token func() (string, error)) error {
+	tok, err := token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	return nil
+}
+
+func resourceOrDefault(resource string) string {
+	if strings.TrimSpace(resource) == "" {
+		return DefaultResource
+	}
+	return strings.TrimSpace(resource)
+}
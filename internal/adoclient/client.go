@@ -0,0 +1,302 @@
+// Package adoclient is a small typed HTTP client for the Azure DevOps REST API.
+//
+// It replaces shelling out to `az rest`: requests are sent directly over HTTPS,
+// request bodies never appear on a process command line, and failures are
+// reported as *Error values carrying the HTTP status code and response body.
+package adoclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultResource is the well-known AAD application ID of Azure DevOps.
+// It is used when no explicit resource GUID is configured.
+const DefaultResource = "499b84ac-1321-427f-aa17-267ca6975798"
+
+// Hosts holds the service base URLs of one organization.
+// Azure DevOps Services splits its API over several hosts; every URL here
+// already includes the organization segment, e.g. https://vsrm.dev.azure.com/{org}.
+// Azure DevOps Server serves all of them below the collection URL.
+type Hosts struct {
+	Core     string `json:"core,omitempty"`     // https://dev.azure.com/{org}
+	Identity string `json:"identity,omitempty"` // https://vssps.dev.azure.com/{org}
+	Release  string `json:"release,omitempty"`  // https://vsrm.dev.azure.com/{org}
+	Feeds    string `json:"feeds,omitempty"`    // https://feeds.dev.azure.com/{org}
+}
+
+// CloudHosts derives the service hosts from an Azure DevOps Services URL.
+// Both https://dev.azure.com/{org} and https://{org}.visualstudio.com are accepted.
+func CloudHosts(orgURL string) (Hosts, error) {
+	u := strings.TrimSpace(strings.TrimRight(orgURL, "/"))
+	low := strings.ToLower(u)
+
+	var org string
+	switch {
+	case strings.Contains(low, "dev.azure.com/"):
+		org = u[strings.Index(low, "dev.azure.com/")+len("dev.azure.com/"):]
+	case strings.Contains(low, ".visualstudio.com"):
+		host := strings.TrimPrefix(strings.TrimPrefix(u, "https://"), "http://")
+		org = host[:strings.Index(strings.ToLower(host), ".visualstudio.com")]
+		org = strings.TrimSuffix(org, ".vsrm")
+		org = strings.TrimSuffix(org, ".vssps")
+	}
+	if i := strings.Index(org, "/"); i >= 0 {
+		org = org[:i]
+	}
+	if org == "" {
+		return Hosts{}, fmt.Errorf("not an Azure DevOps Services organization url: %s", orgURL)
+	}
+
+	return Hosts{
+		Core:     "https://dev.azure.com/" + org,
+		Identity: "https://vssps.dev.azure.com/" + org,
+		Release:  "https://vsrm.dev.azure.com/" + org,
+		Feeds:    "https://feeds.dev.azure.com/" + org,
+	}, nil
+}
+
+// IsCloudURL reports whether orgURL is an Azure DevOps Services (cloud)
+// organization rather than an Azure DevOps Server collection.
+func IsCloudURL(orgURL string) bool {
+	low := strings.ToLower(orgURL)
+	return strings.Contains(low, "dev.azure.com/") || strings.Contains(low, ".visualstudio.com")
+}
+
+// ServerHosts returns the hosts of an Azure DevOps Server collection, e.g.
+// https://tfs.corp/tfs/DefaultCollection: every service is the collection URL.
+func ServerHosts(collectionURL string) (Hosts, error) {
+	u := strings.TrimSpace(strings.TrimRight(collectionURL, "/"))
+	if !strings.HasPrefix(strings.ToLower(u), "http://") && !strings.HasPrefix(strings.ToLower(u), "https://") {
+		return Hosts{}, fmt.Errorf("not an Azure DevOps Server collection url: %s", collectionURL)
+	}
+	return Hosts{Core: u, Identity: u, Release: u, Feeds: u}, nil
+}
+
+// DefaultHosts derives the hosts of orgURL: CloudHosts for Azure DevOps
+// Services, ServerHosts for anything else.
+func DefaultHosts(orgURL string) (Hosts, error) {
+	if IsCloudURL(orgURL) {
+		return CloudHosts(orgURL)
+	}
+	return ServerHosts(orgURL)
+}
+
+// Override returns h with every non-empty field of o replacing its own.
+func (h Hosts) Override(o Hosts) Hosts {
+	if o.Core != "" {
+		h.Core = strings.TrimRight(o.Core, "/")
+	}
+	if o.Identity != "" {
+		h.Identity = strings.TrimRight(o.Identity, "/")
+	}
+	if o.Release != "" {
+		h.Release = strings.TrimRight(o.Release, "/")
+	}
+	if o.Feeds != "" {
+		h.Feeds = strings.TrimRight(o.Feeds, "/")
+	}
+	return h
+}
+
+// Client sends authenticated requests to one Azure DevOps organization.
+type Client struct {
+	Hosts     Hosts
+	Auth      Authorizer
+	HTTP      *http.Client
+	UserAgent string
+	Retry     RetryPolicy
+	Logger    *slog.Logger // retry events; slog.Default() when nil
+
+	// APIVersion, if set, replaces the version of every api-version query
+	// parameter (see WithAPIVersion), for servers older than the cloud API.
+	APIVersion string
+}
+
+// New returns a client for the given hosts and authorizer.
+func New(hosts Hosts, auth Authorizer) *Client {
+	return &Client{
+		Hosts:     hosts,
+		Auth:      auth,
+		HTTP:      &http.Client{Timeout: 5 * time.Minute},
+		UserAgent: "azdo-vault",
+		Retry:     DefaultRetryPolicy(),
+	}
+}
+
+// Response is a successful (2xx) HTTP response with its body fully read.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Send performs a request, retrying it according to c.Retry. payload may be
+// nil, a []byte that is sent as-is, or any value that is encoded as JSON.
+// Non-2xx responses return *Error.
+func (c *Client) Send(method, uri string, payload any) (*Response, error) {
+	var body []byte
+	if payload != nil {
+		switch p := payload.(type) {
+		case []byte:
+			body = p
+		case string:
+			body = []byte(p)
+		default:
+			b, err := json.Marshal(p)
+			if err != nil {
+				return nil, fmt.Errorf("encode request body: %w", err)
+			}
+			body = b
+		}
+	}
+	method = strings.ToUpper(method)
+	uri = WithAPIVersion(uri, c.APIVersion)
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.sendOnce(method, uri, body, payload != nil)
+
+		var status int
+		var header http.Header
+		if err != nil {
+			if e, ok := err.(*Error); ok {
+				status, header = e.StatusCode, e.Header
+			} else if _, ok := err.(*permanentError); ok {
+				return nil, err
+			}
+		} else {
+			header = resp.Header
+		}
+
+		// Azure DevOps may also send Retry-After on a successful response when a
+		// client is close to being throttled; back off before the next call.
+		if err == nil {
+			if d, ok := serverDelay(header); ok && d > 0 {
+				d = c.Retry.capDelay(d)
+				c.logger().Warn("rate limit warning",
+					"method", method, "url", uri, "status", resp.StatusCode,
+					"delay", d, "remaining", header.Get("X-RateLimit-Remaining"))
+				time.Sleep(d)
+			}
+			return resp, nil
+		}
+
+		if attempt >= c.Retry.MaxAttempts || !c.Retry.retryable(method, status) {
+			return nil, err
+		}
+
+		d := c.Retry.delay(attempt, header)
+		c.logger().Warn("retrying request",
+			"method", method, "url", uri, "attempt", attempt, "max_attempts", c.Retry.MaxAttempts,
+			"status", status, "delay", d, "error", err.Error())
+		time.Sleep(d)
+	}
+}
+
+// permanentError marks failures that happen before anything is sent (bad URL,
+// no credentials); repeating the request cannot fix them.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func (c *Client) sendOnce(method, uri string, body []byte, hasBody bool) (*Response, error) {
+	var r io.Reader
+	if hasBody {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, uri, r)
+	if err != nil {
+		return nil, &permanentError{err: err}
+	}
+	req.Header.Set("Accept", "application/json")
+	if hasBody {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	if c.Auth != nil {
+		if err := c.Auth.Authorize(req); err != nil {
+			return nil, &permanentError{err: fmt.Errorf("authorize request: %w", err)}
+		}
+	}
+
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+
+	// Azure DevOps answers unauthenticated API calls with 203 and an HTML sign-in page.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || resp.StatusCode == http.StatusNonAuthoritativeInfo {
+		return nil, &Error{
+			Method:     req.Method,
+			URL:        uri,
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       data,
+		}
+	}
+
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}, nil
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
+// Get performs a GET request and returns the response body.
+func (c *Client) Get(uri string) ([]byte, error) {
+	resp, err := c.Send(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Post performs a POST request with a JSON payload and returns the response body.
+func (c *Client) Post(uri string, payload any) ([]byte, error) {
+	resp, err := c.Send(http.MethodPost, uri, payload)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put performs a PUT request with a JSON payload and returns the response body.
+func (c *Client) Put(uri string, payload any) ([]byte, error) {
+	resp, err := c.Send(http.MethodPut, uri, payload)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Patch performs a PATCH request with a JSON payload and returns the response body.
+func (c *Client) Patch(uri string, payload any) ([]byte, error) {
+	resp, err := c.Send(http.MethodPatch, uri, payload)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,60 @@
+package adoclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error is returned for every non-2xx response.
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (e *Error) Error() string {
+	msg := e.Message()
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s: HTTP %d: %s", e.Method, e.URL, e.StatusCode, msg)
+}
+
+// Message returns the server-provided error message, falling back to the raw body.
+// Azure DevOps errors look like {"$id":"1","message":"...","typeKey":"..."}.
+func (e *Error) Message() string {
+	var obj struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(e.Body, &obj) == nil && strings.TrimSpace(obj.Message) != "" {
+		return obj.Message
+	}
+
+	s := strings.TrimSpace(string(e.Body))
+	if e.StatusCode == http.StatusNonAuthoritativeInfo || strings.HasPrefix(s, "<") {
+		return "authentication required (received a sign-in page instead of JSON)"
+	}
+	if len(s) > 2000 {
+		s = s[:2000] + "..."
+	}
+	return s
+}
+
+// StatusCode returns the HTTP status of err, or 0 if err is not an *Error.
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether err is a 404 response.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
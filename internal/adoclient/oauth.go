@@ -0,0 +1,167 @@
+package adoclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultAuthority is the Microsoft Entra ID login host.
+const DefaultAuthority = "https://login.microsoftonline.com"
+
+// ServicePrincipal authenticates with the OAuth client-credentials flow
+// using a client secret.
+type ServicePrincipal struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	Resource     string // AAD resource; DefaultResource when empty
+	Authority    string // DefaultAuthority when empty
+
+	cache tokenCache
+}
+
+func (s *ServicePrincipal) Authorize(req *http.Request) error {
+	return authorizeBearer(req, s.Token)
+}
+
+func (s *ServicePrincipal) Token() (string, error) {
+	return s.cache.get(func() (string, time.Time, error) {
+		if s.ClientSecret == "" {
+			return "", time.Time{}, fmt.Errorf("service principal %s: client secret is empty", s.ClientID)
+		}
+		form := url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", s.ClientID)
+		form.Set("client_secret", s.ClientSecret)
+		form.Set("scope", resourceOrDefault(s.Resource)+"/.default")
+		return requestEntraToken(s.Authority, s.TenantID, form)
+	})
+}
+
+// WorkloadIdentity authenticates with a federated token (workload identity
+// federation). The token file is re-read on every refresh because the
+// platform rotates it, e.g. AZURE_FEDERATED_TOKEN_FILE on Kubernetes or a
+// file written by a CI OIDC step.
+type WorkloadIdentity struct {
+	TenantID  string
+	ClientID  string
+	TokenFile string
+	Resource  string // AAD resource; DefaultResource when empty
+	Authority string // DefaultAuthority when empty
+
+	cache tokenCache
+}
+
+func (w *WorkloadIdentity) Authorize(req *http.Request) error {
+	return authorizeBearer(req, w.Token)
+}
+
+func (w *WorkloadIdentity) Token() (string, error) {
+	return w.cache.get(func() (string, time.Time, error) {
+		assertion, err := os.ReadFile(w.TokenFile)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("read federated token file: %w", err)
+		}
+		form := url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", w.ClientID)
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+		form.Set("scope", resourceOrDefault(w.Resource)+"/.default")
+		return requestEntraToken(w.Authority, w.TenantID, form)
+	})
+}
+
+// ManagedIdentity requests a token from the Azure instance metadata service.
+// ClientID selects a user-assigned identity; leave it empty for the system-assigned one.
+type ManagedIdentity struct {
+	ClientID string
+	Resource string // AAD resource; DefaultResource when empty
+
+	cache tokenCache
+}
+
+func (m *ManagedIdentity) Authorize(req *http.Request) error {
+	return authorizeBearer(req, m.Token)
+}
+
+func (m *ManagedIdentity) Token() (string, error) {
+	return m.cache.get(func() (string, time.Time, error) {
+		q := url.Values{}
+		q.Set("api-version", "2018-02-01")
+		q.Set("resource", resourceOrDefault(m.Resource))
+		if m.ClientID != "" {
+			q.Set("client_id", m.ClientID)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "http://169.254.169.254/metadata/identity/oauth2/token?"+q.Encode(), nil)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Metadata", "true")
+
+		hc := &http.Client{Timeout: 30 * time.Second}
+		resp, err := hc.Do(req)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("managed identity endpoint not reachable: %w", err)
+		}
+		defer resp.Body.Close()
+
+		return parseTokenResponse(resp)
+	})
+}
+
+func requestEntraToken(authority, tenantID string, form url.Values) (string, time.Time, error) {
+	if strings.TrimSpace(tenantID) == "" {
+		return "", time.Time{}, fmt.Errorf("tenant id is required")
+	}
+	if strings.TrimSpace(form.Get("client_id")) == "" {
+		return "", time.Time{}, fmt.Errorf("client id is required")
+	}
+	if authority == "" {
+		authority = DefaultAuthority
+	}
+
+	uri := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimRight(authority, "/"), url.PathEscape(tenantID))
+
+	hc := &http.Client{Timeout: 30 * time.Second}
+	resp, err := hc.PostForm(uri, form)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return parseTokenResponse(resp)
+}
+
+func parseTokenResponse(resp *http.Response) (string, time.Time, error) {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var tok struct {
+		AccessToken      string      `json:"access_token"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed parsing token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tok.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token request failed (HTTP %d): %s %s", resp.StatusCode, tok.Error, tok.ErrorDescription)
+	}
+
+	expires := time.Now().Add(50 * time.Minute)
+	if secs, err := tok.ExpiresIn.Int64(); err == nil && secs > 0 {
+		expires = time.Now().Add(time.Duration(secs) * time.Second)
+	}
+	return tok.AccessToken, expires, nil
+}
@@ -0,0 +1,106 @@
+package adoclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ContinuationHeader is the response header Azure DevOps uses to signal that
+// a list result has more pages.
+const ContinuationHeader = "X-Ms-Continuationtoken"
+
+// maxPages guards against a server that keeps returning continuation tokens.
+const maxPages = 10000
+
+// Paging describes how a list endpoint is paged.
+type Paging struct {
+	// PageSize > 0 pages with $top/$skip until an empty page is returned.
+	// $skip advances by the items received, so a server that caps $top
+	// below PageSize still returns every item.
+	// PageSize == 0 follows x-ms-continuationtoken until the header is absent.
+	PageSize int
+}
+
+// TopSkip returns $top/$skip paging with the given page size.
+func TopSkip(pageSize int) Paging {
+	return Paging{PageSize: pageSize}
+}
+
+// page is the envelope of every Azure DevOps list response. Count is the
+// number of items the server reports for the page.
+type page[T any] struct {
+	Count *int `json:"count"`
+	Value []T  `json:"value"`
+}
+
+// ListAll fetches every page of a list endpoint and returns all items.
+// It never returns a partial result: if a page cannot be fetched or decoded,
+// holds fewer items than its count reports, or the server loops, the whole
+// call fails.
+func ListAll[T any](c *Client, uri string, paging Paging) ([]T, error) {
+	var all []T
+	seen := map[string]bool{}
+	token := ""
+
+	for n := 0; ; n++ {
+		if n >= maxPages {
+			return nil, fmt.Errorf("list %s: more than %d pages, giving up", uri, maxPages)
+		}
+
+		pageURI, err := pageURL(uri, paging, token, len(all))
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.Send(http.MethodGet, pageURI, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var p page[T]
+		if err := json.Unmarshal(resp.Body, &p); err != nil {
+			return nil, fmt.Errorf("failed parsing list page %d: %w\nRaw:\n%s", n+1, err, string(resp.Body))
+		}
+		if p.Count != nil && *p.Count != len(p.Value) {
+			return nil, fmt.Errorf("list %s: page %d reports %d items but holds %d", uri, n+1, *p.Count, len(p.Value))
+		}
+		all = append(all, p.Value...)
+
+		if paging.PageSize > 0 {
+			if len(p.Value) == 0 {
+				return all, nil
+			}
+			continue
+		}
+
+		token = resp.Header.Get(ContinuationHeader)
+		if token == "" {
+			return all, nil
+		}
+		if seen[token] {
+			return nil, fmt.Errorf("list %s: server repeated continuation token after %d pages", uri, n+1)
+		}
+		seen[token] = true
+	}
+}
+
+// pageURL returns uri for the page after the first skip items, or for the
+// page token points at.
+func pageURL(uri string, paging Paging, token string, skip int) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if paging.PageSize > 0 {
+		q.Set("$top", strconv.Itoa(paging.PageSize))
+		q.Set("$skip", strconv.Itoa(skip))
+	} else if token != "" {
+		q.Set("continuationToken", token)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,112 @@
+package adoclient
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried.
+//
+// Throttled requests (429) were rejected before they ran and are retried for
+// every method. Server errors and network failures are only retried for
+// idempotent methods (GET, HEAD, OPTIONS, PUT, DELETE) unless
+// RetryNonIdempotent is set, so a POST that may already have created
+// something is not sent twice.
+type RetryPolicy struct {
+	MaxAttempts        int           // total tries including the first; <= 1 disables retries
+	BaseDelay          time.Duration // first backoff step, doubled on every attempt
+	MaxDelay           time.Duration // upper bound for one wait, including Retry-After
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy is used by New.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   2 * time.Second,
+		MaxDelay:    2 * time.Minute,
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether a failed attempt may be repeated.
+// status is 0 for transport errors.
+func (p RetryPolicy) retryable(method string, status int) bool {
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	if !isIdempotent(method) && !p.RetryNonIdempotent {
+		return false
+	}
+	switch status {
+	case 0, http.StatusRequestTimeout, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// delay returns how long to wait before attempt+1 (attempt counts from 1).
+// Server hints win over the computed backoff.
+func (p RetryPolicy) delay(attempt int, h http.Header) time.Duration {
+	if d, ok := serverDelay(h); ok {
+		return p.capDelay(d)
+	}
+
+	base := p.BaseDelay
+	if base <= 0 {
+		base = time.Second
+	}
+	d := base << (attempt - 1)
+	if d <= 0 || d > p.maxDelay() {
+		d = p.maxDelay()
+	}
+	// Full jitter: spread concurrent clients over the whole window.
+	return time.Duration(rand.Int64N(int64(d)) + 1)
+}
+
+func (p RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay <= 0 {
+		return 2 * time.Minute
+	}
+	return p.MaxDelay
+}
+
+func (p RetryPolicy) capDelay(d time.Duration) time.Duration {
+	if d > p.maxDelay() {
+		return p.maxDelay()
+	}
+	return d
+}
+
+// serverDelay reads Retry-After (seconds or HTTP date), falling back to
+// X-RateLimit-Reset (unix seconds) when the rate limit is exhausted.
+func serverDelay(h http.Header) (time.Duration, bool) {
+	if h == nil {
+		return 0, false
+	}
+	if v := strings.TrimSpace(h.Get("Retry-After")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(time.Until(t), 0), true
+		}
+	}
+	if strings.TrimSpace(h.Get("X-RateLimit-Remaining")) == "0" {
+		if reset, err := strconv.ParseInt(strings.TrimSpace(h.Get("X-RateLimit-Reset")), 10, 64); err == nil {
+			return max(time.Until(time.Unix(reset, 0)), 0), true
+		}
+	}
+	return 0, false
+}
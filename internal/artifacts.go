@@ -5,15 +5,12 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+
+	"azdo-vault/internal/adoclient"
 )
 
 // -------------------- Models --------------------
 
-type FeedsListResponse struct {
-	Count int    `json:"count"`
-	Value []Feed `json:"value"`
-}
-
 type Feed struct {
 	ID                 string           `json:"id"`
 	Name               string           `json:"name"`
@@ -43,11 +40,6 @@ type UpstreamSource struct {
 }
 
 // Packages
-type PackagesListResponse struct {
-	Count int       `json:"count"`
-	Value []Package `json:"value"`
-}
-
 type Package struct {
 	ID           string `json:"id"`
 	Name         string `json:"name"`
@@ -55,42 +47,36 @@ type Package struct {
 	// Some responses include normalizedName, versions, etc. Add later if needed.
 }
 
-type PackageVersionsListResponse struct {
-	Count int              `json:"count"`
-	Value []PackageVersion `json:"value"`
-}
-
 type PackageVersion struct {
 	ID       string `json:"id"`
 	Version  string `json:"version"`
 	IsListed bool   `json:"isListed"`
 }
 
+// packagesPageSize is the $top asked for when paging feed packages; the
+// packages API does not return continuation tokens and may return fewer.
+const packagesPageSize = 500
+
 // -------------------- API --------------------
 func ListFeeds(orgURL, project, resourceGUID string) ([]Feed, error) {
-	orgName, err := ExtractOrgName(orgURL)
+	// IMPORTANT: feeds.dev.azure.com (not dev.azure.com)
+	feeds, err := feedsBase(orgURL)
 	if err != nil {
 		return nil, err
 	}
 
-	// IMPORTANT: feeds.dev.azure.com (not dev.azure.com)
-	uri := fmt.Sprintf("https://feeds.dev.azure.com/%s/%s/_apis/packaging/feeds?api-version=7.1-preview.1",
-		orgName, url.PathEscape(project))
+	uri := fmt.Sprintf("%s/%s/_apis/packaging/feeds?api-version=7.1-preview.1",
+		feeds, url.PathEscape(project))
 
-	out, err := azRest("get", uri, resourceGUID)
+	list, err := adoList[Feed](orgURL, resourceGUID, uri, adoclient.Paging{})
 	if err != nil {
 		return nil, err
 	}
-
-	var resp FeedsListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing feeds JSON: %w\nRaw:\n%s", err, string(out))
-	}
-	return resp.Value, nil
+	return list, nil
 }
 
 func ListPackages(orgURL, project, feedID, protocolType, resourceGUID string) ([]Package, error) {
-	orgName, err := ExtractOrgName(orgURL)
+	feeds, err := feedsBase(orgURL)
 	if err != nil {
 		return nil, err
 	}
@@ -100,53 +86,43 @@ func ListPackages(orgURL, project, feedID, protocolType, resourceGUID string) ([
 		q = "&protocolType=" + url.QueryEscape(protocolType)
 	}
 
-	uri := fmt.Sprintf("https://feeds.dev.azure.com/%s/%s/_apis/packaging/feeds/%s/packages?api-version=7.1-preview.1%s",
-		orgName, url.PathEscape(project), feedID, q)
+	uri := fmt.Sprintf("%s/%s/_apis/packaging/feeds/%s/packages?api-version=7.1-preview.1%s",
+		feeds, url.PathEscape(project), feedID, q)
 
-	out, err := azRest("get", uri, resourceGUID)
+	list, err := adoList[Package](orgURL, resourceGUID, uri, adoclient.TopSkip(packagesPageSize))
 	if err != nil {
 		return nil, err
 	}
-
-	var resp PackagesListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing packages JSON: %w\nRaw:\n%s", err, string(out))
-	}
-	return resp.Value, nil
+	return list, nil
 }
 
 func ListPackageVersions(orgURL, project, feedID, packageID, resourceGUID string) ([]PackageVersion, error) {
-	orgName, err := ExtractOrgName(orgURL)
+	feeds, err := feedsBase(orgURL)
 	if err != nil {
 		return nil, err
 	}
 
-	uri := fmt.Sprintf("https://feeds.dev.azure.com/%s/%s/_apis/packaging/feeds/%s/packages/%s/versions?api-version=7.1-preview.1",
-		orgName, url.PathEscape(project), feedID, packageID)
+	uri := fmt.Sprintf("%s/%s/_apis/packaging/feeds/%s/packages/%s/versions?api-version=7.1-preview.1",
+		feeds, url.PathEscape(project), feedID, packageID)
 
-	out, err := azRest("get", uri, resourceGUID)
+	list, err := adoList[PackageVersion](orgURL, resourceGUID, uri, adoclient.Paging{})
 	if err != nil {
 		return nil, err
 	}
-
-	var resp PackageVersionsListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing package versions JSON: %w\nRaw:\n%s", err, string(out))
-	}
-	return resp.Value, nil
+	return list, nil
 }
 
 func CreateFeed(orgURL, project, resourceGUID string, payload map[string]any) (*Feed, error) {
-	orgName, err := ExtractOrgName(orgURL)
+	feeds, err := feedsBase(orgURL)
 	if err != nil {
 		return nil, err
 	}
 
-	uri := fmt.Sprintf("https://feeds.dev.azure.com/%s/%s/_apis/packaging/feeds?api-version=7.1-preview.1",
-		orgName, url.PathEscape(project))
+	uri := fmt.Sprintf("%s/%s/_apis/packaging/feeds?api-version=7.1-preview.1",
+		feeds, url.PathEscape(project))
 
 	bodyBytes, _ := json.Marshal(payload)
-	out, err := azRestWithBody("post", uri, resourceGUID, string(bodyBytes))
+	out, err := adoSend(orgURL, resourceGUID, "post", uri, bodyBytes)
 	if err != nil {
 		return nil, err
 	}
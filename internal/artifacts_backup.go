@@ -3,28 +3,35 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 )
 
-func BackupArtifactsFeeds(orgURL, project, backupPath, resourceGUID string) error {
+func BackupArtifactsFeeds(orgURL, project, backupPath, resourceGUID string, exec *ExecOptions) error {
 	feeds, err := ListFeeds(orgURL, project, resourceGUID)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("Found %d feeds\n", len(feeds))
 	if err := os.MkdirAll(backupPath, 0755); err != nil {
 		return err
 	}
 
-	for _, f := range feeds {
+	err = forEach(len(feeds), exec.workers(KindArtifactsFeeds), exec.failFast(true), func(i int, out io.Writer) error {
+		f := feeds[i]
 		fp := filepath.Join(backupPath, safeFeedFile(f.Name))
 		b, _ := json.MarshalIndent(f, "", "  ")
-		if err := os.WriteFile(fp, b, 0644); err != nil {
+		if err := writeBackupFile(fp, b); err != nil {
 			return err
 		}
-		fmt.Println("✔ Backed up feed:", f.Name)
+		fmt.Fprintln(out, "✔ Backed up feed:", f.Name)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -2,34 +2,42 @@ package internal
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func RestoreArtifactsFeedsFromBackup(sourceOrgURL, sourceProject, targetOrgURL, targetProject, backupPath string, selected []string, resourceGUID string) error {
+func RestoreArtifactsFeedsFromBackup(sourceOrgURL, sourceProject, targetOrgURL, targetProject, backupPath string, selected []string, resourceGUID string, opts *RestoreOptions) ([]RestoreResult, error) {
 	files, err := os.ReadDir(backupPath)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	run := newRestoreRun(KindArtifactsFeeds, opts)
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return nil, err
 	}
-	restoreAll := len(selected) == 1 && strings.EqualFold(selected[0], "all")
 
-	targetFeeds, err := ListFeeds(targetOrgURL, targetProject, resourceGUID)
+	targetFeeds, err := run.idx.feeds(targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var items []os.DirEntry
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name(), ".json") {
 			continue
 		}
-		if !restoreAll && !contains(selected, f.Name()) && !contains(selected, strings.TrimSuffix(f.Name(), ".json")) {
+		if !sel.Match(f.Name(), strings.TrimSuffix(f.Name(), ".json")) {
 			continue
 		}
+		items = append(items, f)
+	}
 
+	err = run.each(len(items), func(i int, item *restoreRun) error {
+		f := items[i]
 		fp := filepath.Join(backupPath, f.Name())
-		b, err := os.ReadFile(fp)
+		b, err := readBackupFile(fp)
 		if err != nil {
 			return err
 		}
@@ -39,9 +47,23 @@ func RestoreArtifactsFeedsFromBackup(sourceOrgURL, sourceProject, targetOrgURL,
 			return err
 		}
 
+		sourceName := feed.Name
+		feed.Name = item.rename(sourceName)
+
 		if existing := FindFeedByName(targetFeeds, feed.Name); existing != nil {
-			fmt.Println("✔ Feed exists, skipping:", feed.Name)
-			continue
+			alt, ok, err := item.collide(sourceName, feed.Name, func(n string) (bool, error) {
+				current, err := item.idx.feeds(targetOrgURL, targetProject, resourceGUID)
+				return FindFeedByName(current, n) != nil, err
+			})
+			if err != nil {
+				return err
+			}
+			if !ok {
+				item.println("✔ Feed exists, skipping:", feed.Name)
+				item.exists(feed.Name, feed.ID, existing.ID)
+				return nil
+			}
+			feed.Name = alt
 		}
 
 		// Create payload (only fields ADO accepts for create)
@@ -54,15 +76,27 @@ func RestoreArtifactsFeedsFromBackup(sourceOrgURL, sourceProject, targetOrgURL,
 			payload["upstreamSources"] = feed.UpstreamSources
 		}
 
-		fmt.Println("Creating feed:", feed.Name)
+		if item.planned(feed.Name, feed.ID, ActionCreate, payload, nil) {
+			return nil
+		}
+
+		item.println("Creating feed:", feed.Name)
 		created, err := CreateFeed(targetOrgURL, targetProject, resourceGUID, payload)
 		if err != nil {
-			fmt.Printf("⚠ Failed creating feed '%s': %v\n", feed.Name, err)
-			continue
+			item.printf("⚠ Failed creating feed '%s': %v\n", feed.Name, err)
+			item.done(feed.Name, feed.ID, ActionCreate, "", nil, err)
+			return nil
 		}
-		fmt.Println("✔ Created feed:", created.Name)
+		item.idx.addFeed(targetOrgURL, targetProject, *created)
+		item.done(feed.Name, feed.ID, ActionCreate, created.ID, nil, nil)
+		item.created(sourceName, feed.Name)
+		item.println("✔ Created feed:", created.Name)
+		return nil
+	})
+	if err != nil {
+		return run.results, err
 	}
 
-	fmt.Println("✔ Artifacts feeds restore finished")
-	return nil
+	run.println("✔ Artifacts feeds restore finished")
+	return run.results, nil
 }
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"azdo-vault/internal/adoclient"
+)
+
+// newAuthorizer builds the credential provider configured for an organization.
+// resourceGUID is the AAD resource the token is requested for (DefaultResource when empty).
+func newAuthorizer(ac *AuthConfig, resourceGUID string) (adoclient.Authorizer, error) {
+	if ac == nil || strings.TrimSpace(ac.Method) == "" {
+		if pat := strings.TrimSpace(os.Getenv("AZURE_DEVOPS_EXT_PAT")); pat != "" {
+			return adoclient.PAT(pat), nil
+		}
+		return &adoclient.AzureCLIToken{Resource: resourceGUID}, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(ac.Method)) {
+	case AuthMethodPAT:
+		pat, err := readSecret("personal access token", orDefault(ac.PATEnv, "AZURE_DEVOPS_EXT_PAT"), ac.PATFile)
+		if err != nil {
+			return nil, err
+		}
+		return adoclient.PAT(pat), nil
+
+	case AuthMethodAzureCLI:
+		return &adoclient.AzureCLIToken{Resource: resourceGUID}, nil
+
+	case AuthMethodServicePrincipal:
+		secret, err := readSecret("client secret", orDefault(ac.ClientSecretEnv, "AZURE_CLIENT_SECRET"), ac.ClientSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		return &adoclient.ServicePrincipal{
+			TenantID:     orDefault(ac.TenantID, os.Getenv("AZURE_TENANT_ID")),
+			ClientID:     orDefault(ac.ClientID, os.Getenv("AZURE_CLIENT_ID")),
+			ClientSecret: secret,
+			Resource:     resourceGUID,
+		}, nil
+
+	case AuthMethodWorkloadIdentity:
+		tokenFile := orDefault(ac.TokenFile, os.Getenv("AZURE_FEDERATED_TOKEN_FILE"))
+		if tokenFile == "" {
+			return nil, fmt.Errorf("workload identity: federatedTokenFile is not set (or AZURE_FEDERATED_TOKEN_FILE)")
+		}
+		return &adoclient.WorkloadIdentity{
+			TenantID:  orDefault(ac.TenantID, os.Getenv("AZURE_TENANT_ID")),
+			ClientID:  orDefault(ac.ClientID, os.Getenv("AZURE_CLIENT_ID")),
+			TokenFile: tokenFile,
+			Resource:  resourceGUID,
+		}, nil
+
+	case AuthMethodManagedIdentity:
+		return &adoclient.ManagedIdentity{
+			ClientID: ac.ClientID,
+			Resource: resourceGUID,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown auth method '%s' (use: pat|azcli|service-principal|workload-identity|managed-identity)", ac.Method)
+	}
+}
+
+// readSecret reads a secret from a file if one is given, otherwise from an env var.
+func readSecret(what, envName, file string) (string, error) {
+	if strings.TrimSpace(file) != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("read %s file: %w", what, err)
+		}
+		if s := strings.TrimSpace(string(b)); s != "" {
+			return s, nil
+		}
+		return "", fmt.Errorf("%s file '%s' is empty", what, file)
+	}
+	if s := strings.TrimSpace(os.Getenv(envName)); s != "" {
+		return s, nil
+	}
+	return "", fmt.Errorf("%s not found: env var %s is empty", what, envName)
+}
+
+func orDefault(v, def string) string {
+	if strings.TrimSpace(v) != "" {
+		return strings.TrimSpace(v)
+	}
+	return def
+}
@@ -3,8 +3,10 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
-	"os/exec"
+	"net/url"
 	"strings"
+
+	"azdo-vault/internal/adoclient"
 )
 
 type Repo struct {
@@ -14,20 +16,17 @@ type Repo struct {
 	IsDisabled bool   `json:"isDisabled"`
 }
 
+// GET /{project}/_apis/git/repositories
 func ListRepos(orgURL, project string) ([]Repo, error) {
-	cmd := exec.Command("az", "repos", "list", "--organization", orgURL, "--project", project, "--output", "json")
-	out, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
+	uri := fmt.Sprintf("%s/%s/_apis/git/repositories?api-version=7.1", strings.TrimRight(orgURL, "/"), url.PathEscape(project))
 
-	var repos []Repo
-	if err := json.Unmarshal(out, &repos); err != nil {
-		return nil, err
+	list, err := adoList[Repo](orgURL, "", uri, adoclient.Paging{})
+	if err != nil {
+		return nil, fmt.Errorf("list repos failed: %w", err)
 	}
 
 	var enabled []Repo
-	for _, r := range repos {
+	for _, r := range list {
 		if !r.IsDisabled {
 			enabled = append(enabled, r)
 		}
@@ -36,138 +35,75 @@ func ListRepos(orgURL, project string) ([]Repo, error) {
 	return enabled, nil
 }
 
-type AzureRepo struct {
-	Name string `json:"name"`
-}
-
 func RepoExists(orgURL, project, name string) (bool, error) {
-	cmd := exec.Command("az", "repos", "show",
-		"--repository", name,
-		"--organization", orgURL,
-		"--project", project,
-		"--output", "json")
-
-	out, err := cmd.Output()
+	r, err := getRepo(orgURL, project, name, "")
 	if err != nil {
-		return false, nil // assume not exists
-	}
-
-	var repo AzureRepo
-	if err := json.Unmarshal(out, &repo); err != nil {
-		return false, nil
+		if adoclient.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
 	}
-
-	return repo.Name == name, nil
+	return strings.EqualFold(r.Name, name), nil
 }
 
+// POST /{project}/_apis/git/repositories
 func CreateRepo(orgURL, project, name string) error {
-	cmd := exec.Command("az", "repos", "create",
-		"--name", name,
-		"--project", project,
-		"--organization", orgURL,
-		"--output", "json")
-
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-
-	return cmd.Run()
-}
-
-func GetRepoRemoteURL(orgURL, project, repo string) (string, error) {
-
-	cmd := exec.Command("az", "repos", "show",
-		"--organization", orgURL,
-		"--project", project,
-		"--repository", repo,
-		"--query", "remoteUrl",
-		"-o", "tsv",
-	)
-
-	out, err := cmd.Output()
+	pinfo, err := GetProjectInfo(orgURL, project, "")
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return strings.TrimSpace(string(out)), nil
-}
-
-func GetRepoNameByID_AzRepos(orgURL, project, repoID string) (string, error) {
-	cmd := exec.Command("az", "repos", "show",
-		"--organization", orgURL,
-		"--project", project,
-		"--id", repoID,
-		"--query", "name",
-		"-o", "tsv",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("az repos show --id failed: %w\n%s", err, string(out))
+	uri := fmt.Sprintf("%s/%s/_apis/git/repositories?api-version=7.1", strings.TrimRight(orgURL, "/"), url.PathEscape(project))
+	payload := map[string]any{
+		"name":    name,
+		"project": map[string]any{"id": pinfo.Id},
 	}
 
-	name := strings.TrimSpace(string(out))
-	return name, nil
+	if _, err := adoSend(orgURL, "", "post", uri, payload); err != nil {
+		return fmt.Errorf("create repo failed: %w", err)
+	}
+	return nil
 }
 
-func GetRepoNameByID_Rest(orgURL, project, repoID, resourceGUID string) (string, error) {
-	uri := fmt.Sprintf("%s/%s/_apis/git/repositories/%s?api-version=7.1", orgURL, project, repoID)
-
-	cmd := exec.Command("az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+func GetRepoRemoteURL(orgURL, project, repo string) (string, error) {
+	r, err := getRepo(orgURL, project, repo, "")
 	if err != nil {
-		return "", fmt.Errorf("az rest git repo by id failed: %w\n%s", err, string(out))
-	}
-
-	var obj map[string]any
-	if err := json.Unmarshal(out, &obj); err != nil {
-		return "", fmt.Errorf("parse git repo JSON failed: %w\nRaw:\n%s", err, string(out))
+		return "", fmt.Errorf("get repo '%s' failed: %w", repo, err)
 	}
-
-	if n, ok := obj["name"].(string); ok {
-		return n, nil
-	}
-	return "", fmt.Errorf("git repo response has no name. Raw:\n%s", string(out))
+	return strings.TrimSpace(r.RemoteURL), nil
 }
 
 func ResolveSourceRepoNameByID(orgURL, project, repoID, resourceGUID string) (string, error) {
-	// try az repos show first
-	if name, err := GetRepoNameByID_AzRepos(orgURL, project, repoID); err == nil && name != "" {
-		return name, nil
+	r, err := GetRepoByID(orgURL, project, repoID, resourceGUID)
+	if err != nil {
+		return "", err
 	}
-	// fallback to REST
-	return GetRepoNameByID_Rest(orgURL, project, repoID, resourceGUID)
+	if r.Name == "" {
+		return "", fmt.Errorf("git repo response has no name (id=%s)", repoID)
+	}
+	return r.Name, nil
 }
 
-// Get repo name by repo ID (GUID) using az repos show --id
+// Get repo name by repo ID (GUID).
 // Works across org/project as long as you call it against the correct org/project.
 func GetRepoNameByID(orgURL, project, repoID string) (string, error) {
-	cmd := exec.Command("az", "repos", "show",
-		"--organization", orgURL,
-		"--project", project,
-		"--id", repoID,
-		"--query", "name",
-		"-o", "tsv",
-	)
-
-	out, err := cmd.CombinedOutput()
+	return ResolveSourceRepoNameByID(orgURL, project, repoID, "")
+}
+
+func GetRepoByID(orgURL, project, repoID, resourceGUID string) (*Repo, error) {
+	r, err := getRepo(orgURL, project, repoID, resourceGUID)
 	if err != nil {
-		return "", fmt.Errorf("az repos show --id failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("get git repo by id failed: %w", err)
 	}
-	return strings.TrimSpace(string(out)), nil
+	return r, nil
 }
 
-func GetRepoByID(orgURL, project, repoID, resourceGUID string) (*Repo, error) {
-	uri := fmt.Sprintf("%s/%s/_apis/git/repositories/%s?api-version=7.1", orgURL, project, repoID)
+// GET /{project}/_apis/git/repositories/{repositoryId or name}
+func getRepo(orgURL, project, repoIDOrName, resourceGUID string) (*Repo, error) {
+	uri := fmt.Sprintf("%s/%s/_apis/git/repositories/%s?api-version=7.1",
+		strings.TrimRight(orgURL, "/"), url.PathEscape(project), url.PathEscape(repoIDOrName))
 
-	out, err := azRest("get", uri, resourceGUID)
+	out, err := adoGet(orgURL, resourceGUID, uri)
 	if err != nil {
 		return nil, err
 	}
@@ -179,50 +115,20 @@ func GetRepoByID(orgURL, project, repoID, resourceGUID string) (*Repo, error) {
 	return &r, nil
 }
 
-func azRest(method, uri, resourceGUID string) ([]byte, error) {
-	args := []string{"rest", "--method", method, "--uri", uri, "--output", "json", "--only-show-errors"}
-	if strings.TrimSpace(resourceGUID) != "" {
-		args = append(args, "--resource", resourceGUID)
-	}
-	cmd := exec.Command("az", args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("az rest failed: %w\n%s", err, string(out))
-	}
-	return out, nil
-}
-
-func azRestWithBody(method, uri, resourceGUID string, body string) ([]byte, error) {
-	args := []string{
-		"rest",
-		"--method", method,
-		"--uri", uri,
-		"--headers", "Content-Type=application/json",
-		"--body", body,
-		"--output", "json",
-		"--only-show-errors",
-	}
-	if strings.TrimSpace(resourceGUID) != "" {
-		args = append(args, "--resource", resourceGUID)
-	}
-
-	cmd := exec.Command("az", args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("az rest failed: %w\n%s", err, string(out))
-	}
-	return out, nil
-}
-
+// ExtractOrgName returns the organization of a cloud URL, or the collection
+// name (last path segment) of an Azure DevOps Server URL.
 func ExtractOrgName(orgURL string) (string, error) {
 	s := strings.TrimSpace(strings.TrimRight(orgURL, "/"))
-	s = strings.TrimPrefix(s, "https://dev.azure.com/")
-	s = strings.TrimPrefix(s, "http://dev.azure.com/")
-	s = strings.TrimPrefix(s, "https://vssps.dev.azure.com/")
-	s = strings.TrimPrefix(s, "http://vssps.dev.azure.com/")
-
-	if i := strings.Index(s, "/"); i >= 0 {
-		s = s[:i]
+	if adoclient.IsCloudURL(s) {
+		h, err := adoclient.CloudHosts(s)
+		if err != nil {
+			return "", err
+		}
+		s = h.Core[strings.LastIndex(h.Core, "/")+1:]
+	} else if i := strings.LastIndex(s, "/"); i >= 0 && !strings.HasSuffix(s[:i], ":/") {
+		s = s[i+1:]
+	} else {
+		s = ""
 	}
 	if s == "" {
 		return "", fmt.Errorf("could not extract org name from url: %s", orgURL)
@@ -0,0 +1,585 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// A project archive is one zstd compressed tar file holding the snapshots of
+// a project folder, to move backups to cold storage or between air-gapped
+// environments:
+//
+//	archive.json                      the ArchiveManifest, always the first entry
+//	encryption.json                   the project's wrapped data key, if encrypted
+//	snapshots/{id}/manifest.json      per snapshot: its manifest, signature and
+//	snapshots/{id}/snapshot.json      snapshot.json first, then its other files
+//	snapshots/{id}/repos/api.git.bundle
+//
+// Mirrors ({name}.git, {name}.wiki.git) are packed as git bundles, which
+// import turns back into mirrors; the bundles of an encrypted store go in as
+// they are, like its other files, so such an archive is only readable with
+// the store's key. The restore state of the project folder (names ledgers,
+// migration state) belongs to the targets of the exporting environment and
+// stays behind.
+const (
+	ArchiveManifestFile = "archive.json"
+	ArchiveExt          = ".tar.zst"
+
+	archiveVersion = 1
+	// maxArchiveHead bounds what is read of archive.json before it is checked.
+	maxArchiveHead = 256 << 20
+)
+
+// ArchiveManifest describes a project archive and every entry after it.
+type ArchiveManifest struct {
+	Version         int            `json:"version"`
+	SchemaVersion   int            `json:"schemaVersion"` // of every snapshot in it, see BackupSchemaVersion
+	Tool            string         `json:"tool"`
+	ToolVersion     string         `json:"toolVersion"`
+	CreatedAt       time.Time      `json:"createdAt"`
+	OrganizationURL string         `json:"organizationUrl"`
+	Project         string         `json:"project"`
+	Encrypted       bool           `json:"encrypted"`        // holds encryption.json and files encrypted with its key
+	Latest          string         `json:"latest,omitempty"` // the snapshot latest pointed at, or the newest one
+	Snapshots       []string       `json:"snapshots"`
+	Entries         []ArchiveEntry `json:"entries"`
+}
+
+// ArchiveEntry is one file of a project archive.
+type ArchiveEntry struct {
+	Path   string `json:"path"` // relative to the project folder, "/" separated
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Bundle bool   `json:"bundle,omitempty"` // a git bundle of the mirror Path names without .bundle; empty for an empty repo
+	Origin string `json:"origin,omitempty"` // the remote of that mirror
+
+	src string // the file exported
+}
+
+// ExportArchive writes the snapshots snaps of projectRoot into the archive
+// dst. Every snapshot must be finished, of the current schema and pass
+// VerifyBackup with pub, requireSignature and git fsck. dst is written under
+// a temporary name and renamed when complete.
+func ExportArchive(projectRoot string, snaps []*Snapshot, org *OrganizationConfig, project, dst string, pub ed25519.PublicKey, requireSignature bool) (*ArchiveManifest, error) {
+	if kinds := flatKinds(projectRoot); len(kinds) > 0 {
+		return nil, fmt.Errorf("%s holds a backup written before snapshots (%s); run upgrade-backup first", projectRoot, strings.Join(kinds, ", "))
+	}
+	if len(snaps) == 0 {
+		return nil, fmt.Errorf("no snapshots to export in %s", projectRoot)
+	}
+
+	tmp, err := os.MkdirTemp("", "azdo-vault-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	m := &ArchiveManifest{
+		Version:       archiveVersion,
+		SchemaVersion: BackupSchemaVersion,
+		Tool:          "azdo-vault",
+		ToolVersion:   ToolVersion,
+		CreatedAt:     time.Now().UTC(),
+		Project:       project,
+	}
+	if org != nil {
+		m.OrganizationURL = org.URL
+	}
+	if key := filepath.Join(projectRoot, encryptionFile); fileExists(key) {
+		m.Encrypted = true
+		if err := m.add(ArchiveEntry{Path: encryptionFile, src: key}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range snaps {
+		switch {
+		case !s.Finished():
+			return nil, fmt.Errorf("snapshot %s is unfinished", s.ID)
+		case s.SchemaVersion != BackupSchemaVersion:
+			return nil, fmt.Errorf("snapshot %s has backup schema %d, not %d; run upgrade-backup first", s.ID, s.SchemaVersion, BackupSchemaVersion)
+		}
+		res, err := VerifyBackup(s, pub, requireSignature, true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.ID, err)
+		}
+		if len(res.Issues) > 0 {
+			return nil, fmt.Errorf("snapshot %s does not verify (%d problem(s)); run verify-backup", s.ID, len(res.Issues))
+		}
+		if err := m.addSnapshot(s, tmp); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.ID, err)
+		}
+		m.Snapshots = append(m.Snapshots, s.ID)
+	}
+
+	m.Latest = latestID(projectRoot)
+	if !contains(m.Snapshots, m.Latest) {
+		m.Latest = ""
+		for _, s := range snaps {
+			if len(s.Kinds) > 0 && s.ID > m.Latest {
+				m.Latest = s.ID
+			}
+		}
+	}
+
+	if err := writeArchive(m, dst); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// add hashes the file of e and appends e.
+func (m *ArchiveManifest) add(e ArchiveEntry) error {
+	sum, size, err := hashFile(e.src)
+	if err != nil {
+		return err
+	}
+	e.SHA256, e.Size = sum, size
+	m.Entries = append(m.Entries, e)
+	return nil
+}
+
+// addSnapshot adds the files of s, its mirrors bundled into tmp.
+func (m *ArchiveManifest) addSnapshot(s *Snapshot, tmp string) error {
+	prefix := SnapshotsDir + "/" + s.ID + "/"
+	head := []string{BackupManifestFile, BackupSignatureFile, snapshotFile}
+	for _, f := range head {
+		if fp := filepath.Join(s.dir, f); fileExists(fp) {
+			if err := m.add(ArchiveEntry{Path: prefix + f, src: fp}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return filepath.WalkDir(s.dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.dir, fp)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == "." || contains(head, rel):
+			return nil
+		case d.IsDir() && isMirror(fp):
+			bundle := filepath.Join(tmp, fmt.Sprintf("%d%s", len(m.Entries), bundleSuffix))
+			if err := sealMirror(fp, bundle); err != nil {
+				return err
+			}
+			origin, _ := exec.Command("git", "--git-dir", fp, "config", "--get", "remote.origin.url").Output()
+			if err := m.add(ArchiveEntry{
+				Path:   prefix + rel + bundleSuffix,
+				Bundle: true,
+				Origin: strings.TrimSpace(string(origin)),
+				src:    bundle,
+			}); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		case !d.Type().IsRegular():
+			return nil
+		}
+		return m.add(ArchiveEntry{Path: prefix + rel, src: fp})
+	})
+}
+
+// writeArchive writes archive.json and then every entry of m into dst.
+func writeArchive(m *ArchiveManifest, dst string) (err error) {
+	head, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	mode := int64(0644)
+	if m.Encrypted {
+		mode = 0600
+	}
+	header := func(name string, size int64) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: mode, ModTime: m.CreatedAt}
+	}
+	if err := tw.WriteHeader(header(ArchiveManifestFile, int64(len(head)))); err != nil {
+		return err
+	}
+	if _, err := tw.Write(head); err != nil {
+		return err
+	}
+	for _, e := range m.Entries {
+		if err := tw.WriteHeader(header(e.Path, e.Size)); err != nil {
+			return err
+		}
+		if err := copyArchiveEntry(tw, e); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+// copyArchiveEntry copies the file of e into w, failing if it changed since
+// it was hashed.
+func copyArchiveEntry(w io.Writer, e ArchiveEntry) error {
+	in, err := os.Open(e.src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(w, h), in, e.Size); err != nil {
+		return fmt.Errorf("%s: %w", e.Path, err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != e.SHA256 {
+		return fmt.Errorf("%s changed while it was archived", e.Path)
+	}
+	return nil
+}
+
+// ReadArchiveManifest reads the manifest at the head of the archive src.
+func ReadArchiveManifest(src string) (*ArchiveManifest, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return readArchiveHead(tar.NewReader(zr))
+}
+
+// readArchiveHead reads and checks archive.json, the first entry of tr.
+func readArchiveHead(tr *tar.Reader) (*ArchiveManifest, error) {
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != ArchiveManifestFile {
+		return nil, fmt.Errorf("not an azdo-vault archive: it does not start with %s", ArchiveManifestFile)
+	}
+	if hdr.Size > maxArchiveHead {
+		return nil, fmt.Errorf("%s is too large (%d bytes)", ArchiveManifestFile, hdr.Size)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, err
+	}
+	var m ArchiveManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", ArchiveManifestFile, err)
+	}
+
+	switch {
+	case m.Version > archiveVersion:
+		return nil, fmt.Errorf("archive version %d was written by a newer azdo-vault; this one reads up to version %d", m.Version, archiveVersion)
+	case m.SchemaVersion > BackupSchemaVersion:
+		return nil, fmt.Errorf("archive holds backup schema %d and was written by a newer azdo-vault; this one reads up to schema %d", m.SchemaVersion, BackupSchemaVersion)
+	}
+	for _, id := range m.Snapshots {
+		if _, err := time.Parse(snapshotIDLayout, id); err != nil {
+			return nil, fmt.Errorf("%s: invalid snapshot id '%s'", ArchiveManifestFile, id)
+		}
+	}
+	seen := map[string]bool{}
+	for _, e := range m.Entries {
+		if err := checkArchivePath(e, m.Snapshots); err != nil {
+			return nil, fmt.Errorf("%s: %w", ArchiveManifestFile, err)
+		}
+		if seen[e.Path] {
+			return nil, fmt.Errorf("%s: %s is listed twice", ArchiveManifestFile, e.Path)
+		}
+		seen[e.Path] = true
+	}
+	return &m, nil
+}
+
+// checkArchivePath fails for entries that would land outside the project
+// folder or outside the snapshots the archive lists.
+func checkArchivePath(e ArchiveEntry, snapshots []string) error {
+	if e.Path == encryptionFile && !e.Bundle {
+		return nil
+	}
+	parts := strings.Split(e.Path, "/")
+	switch {
+	case path.Clean(e.Path) != e.Path || !filepath.IsLocal(filepath.FromSlash(e.Path)) || strings.Contains(e.Path, `\`):
+		return fmt.Errorf("unsafe path %s", e.Path)
+	case len(parts) < 3 || parts[0] != SnapshotsDir || !contains(snapshots, parts[1]):
+		return fmt.Errorf("%s is not in a snapshot of the archive", e.Path)
+	case e.Bundle && !strings.HasSuffix(e.Path, ".git"+bundleSuffix):
+		return fmt.Errorf("bundle %s is not named {name}.git%s", e.Path, bundleSuffix)
+	case e.Size < 0:
+		return fmt.Errorf("%s has a negative size", e.Path)
+	}
+	return nil
+}
+
+// ArchiveImport is what ImportArchive added to a project folder.
+type ArchiveImport struct {
+	Manifest *ArchiveManifest
+	Imported []string // snapshots added
+	Existing []string // snapshots the folder already had, left as they are
+	Latest   bool     // whether latest now points at the archive's latest
+}
+
+// ImportArchive unpacks the archive src into projectRoot. Snapshots the
+// folder already has are left as they are; the others are unpacked into a
+// temporary folder in projectRoot, every entry checked against archive.json,
+// bundles turned back into mirrors and each snapshot checked with
+// VerifyBackup, with pub, requireSignature and git fsck, before it is moved
+// into place. latest moves to the archive's latest snapshot unless the folder
+// has a newer one.
+//
+// An encrypted archive needs projectRoot to be in an org with the
+// encryption key it was written with, and a project folder without a data
+// key or with the same one; an unencrypted one needs an org without
+// encryption. With dryRun only archive.json is read.
+func ImportArchive(src, projectRoot string, pub ed25519.PublicKey, requireSignature, dryRun bool) (_ *ArchiveImport, err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	m, err := readArchiveHead(tr)
+	if err != nil {
+		return nil, err
+	}
+	res := &ArchiveImport{Manifest: m}
+	for _, id := range m.Snapshots {
+		if fileExists(filepath.Join(projectRoot, SnapshotsDir, id)) {
+			res.Existing = append(res.Existing, id)
+		} else {
+			res.Imported = append(res.Imported, id)
+		}
+	}
+	if m.Encrypted && !encryptedStore(projectRoot) {
+		return nil, fmt.Errorf("the archive is encrypted, but the org of %s has no encryption configured; configure it with the key or passphrase the archive was written with", projectRoot)
+	}
+	if !m.Encrypted && encryptedStore(projectRoot) {
+		return nil, fmt.Errorf("the archive is not encrypted, but the org of %s encrypts its backups, which must not hold plain files; import it into an org without encryption", projectRoot)
+	}
+	if dryRun {
+		return res, nil
+	}
+
+	if err := os.MkdirAll(projectRoot, 0755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(projectRoot, ".import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	keyFile := filepath.Join(projectRoot, encryptionFile)
+	wroteKey := false
+	defer func() {
+		if err != nil && wroteKey {
+			os.Remove(keyFile)
+		}
+	}()
+
+	want := map[string]ArchiveEntry{}
+	for _, e := range m.Entries {
+		want[e.Path] = e
+	}
+	for {
+		hdr, nerr := tr.Next()
+		if nerr == io.EOF {
+			break
+		}
+		if nerr != nil {
+			return nil, fmt.Errorf("read %s: %w", src, nerr)
+		}
+		e, ok := want[hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is not in %s, or is in the archive twice", hdr.Name, ArchiveManifestFile)
+		}
+		delete(want, hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || hdr.Size != e.Size {
+			return nil, fmt.Errorf("%s does not match %s", hdr.Name, ArchiveManifestFile)
+		}
+
+		if e.Path == encryptionFile {
+			if wroteKey, err = importDataKey(tr, e, staging, keyFile); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if contains(res.Existing, strings.Split(e.Path, "/")[1]) {
+			continue
+		}
+		fp := filepath.Join(staging, filepath.FromSlash(e.Path))
+		if err := extractArchiveEntry(tr, e, fp, m.Encrypted); err != nil {
+			return nil, err
+		}
+		if e.Bundle {
+			if err := unbundleMirror(fp, e.Origin); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(want) > 0 {
+		return nil, fmt.Errorf("%s is truncated: %d file(s) of %s missing", src, len(want), ArchiveManifestFile)
+	}
+
+	for _, id := range res.Imported {
+		s := &Snapshot{ID: id, dir: filepath.Join(staging, SnapshotsDir, id)}
+		v, err := VerifyBackup(s, pub, requireSignature, true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		if len(v.Issues) > 0 {
+			return nil, fmt.Errorf("snapshot %s does not verify after unpacking: %s: %s", id, v.Issues[0].Path, v.Issues[0].Problem)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(projectRoot, SnapshotsDir), 0755); err != nil {
+		return nil, err
+	}
+	for i, id := range res.Imported {
+		if err := os.Rename(filepath.Join(staging, SnapshotsDir, id), filepath.Join(projectRoot, SnapshotsDir, id)); err != nil {
+			res.Imported = res.Imported[:i]
+			return res, fmt.Errorf("move snapshot %s into place: %w", id, err)
+		}
+	}
+
+	if cur := latestID(projectRoot); m.Latest != "" && (cur == "" || cur < m.Latest) {
+		if err := setLatest(projectRoot, m.Latest); err != nil {
+			return res, err
+		}
+		res.Latest = true
+	}
+	return res, nil
+}
+
+// extractArchiveEntry writes the entry e, the current one of r, to fp and
+// checks its SHA-256.
+func extractArchiveEntry(r io.Reader, e ArchiveEntry, fp string, encrypted bool) error {
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if encrypted {
+		mode = 0600
+	}
+	out, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.CopyN(io.MultiWriter(out, h), r, e.Size)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.Path, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != e.SHA256 {
+		return fmt.Errorf("%s is corrupted: sha256 %s, %s has %s", e.Path, short(sum), ArchiveManifestFile, short(e.SHA256))
+	}
+	return nil
+}
+
+// importDataKey installs the encryption.json of an archive as keyFile, the
+// data key of the project folder, and checks that the org's key opens it. It
+// reports whether it wrote keyFile, which an identical one makes needless.
+func importDataKey(r io.Reader, e ArchiveEntry, staging, keyFile string) (bool, error) {
+	fp := filepath.Join(staging, encryptionFile)
+	if err := extractArchiveEntry(r, e, fp, true); err != nil {
+		return false, err
+	}
+	wrote := false
+	if cur, err := os.ReadFile(keyFile); err == nil {
+		data, err := os.ReadFile(fp)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(cur, data) {
+			return false, fmt.Errorf("%s already has another data key; import the archive into another project", filepath.Dir(keyFile))
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	} else if err := os.Rename(fp, keyFile); err != nil {
+		return false, err
+	} else {
+		wrote = true
+	}
+
+	if _, err := backupKey(keyFile, false); err != nil {
+		if wrote {
+			os.Remove(keyFile)
+		}
+		return false, fmt.Errorf("the org's encryption key does not open the archive's data key: %w", err)
+	}
+	return wrote, nil
+}
+
+// unbundleMirror turns a bundle written by ExportArchive back into the
+// mirror it was made from, next to it, and removes the bundle.
+func unbundleMirror(bundle, origin string) error {
+	dir := strings.TrimSuffix(bundle, bundleSuffix)
+	info, err := os.Stat(bundle)
+	switch {
+	case err != nil:
+	case info.Size() == 0:
+		err = exec.Command("git", "init", "--quiet", "--bare", dir).Run()
+		if err == nil && origin != "" {
+			err = exec.Command("git", "--git-dir", dir, "remote", "add", "--mirror=fetch", "origin", origin).Run()
+		}
+	default:
+		err = MirrorClone(bundle, dir, io.Discard)
+		if err == nil && origin != "" {
+			err = setOrigin(dir, origin)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("unbundle %s: %w", filepath.Base(bundle), err)
+	}
+	return os.Remove(bundle)
+}
+
+func fileExists(fp string) bool {
+	_, err := os.Stat(fp)
+	return err == nil
+}
@@ -0,0 +1,624 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Backups of an org with an EncryptionConfig are encrypted at rest with
+// envelope encryption: every project folder has a random data key, stored
+// in {project}/encryption.json wrapped (AES-256-GCM) by the org's key file or
+// a key derived from its passphrase. Each backup file is encrypted with the
+// data key; the file names stay as they are, so selections, listings and
+// manifests work the same, but each file is sealed to its place in the
+// project folder (see sealedName). Readers refuse a file of an encrypted store
+// without the encryption header (see errNotEncrypted), so a plain file cannot
+// be slipped into the store in place of an encrypted one; upgrade-backup
+// --encrypt seals the backups written before encryption was turned on (see
+// sealBackupItems).
+const (
+	encryptionFile = "encryption.json"
+
+	pbkdf2Iterations = 600_000
+	encSegment       = 64 << 10 // plain text bytes per sealed segment
+)
+
+// encMagic starts every encrypted backup file.
+var encMagic = []byte("AZVENC1\n")
+
+// keyEnvelope is the content of encryption.json.
+type keyEnvelope struct {
+	Version    int    `json:"version"`
+	Algorithm  string `json:"algorithm"`
+	KDF        string `json:"kdf"` // "none" for a key file, "pbkdf2-sha256" for a passphrase
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	WrappedKey []byte `json:"wrappedKey"`
+}
+
+// The encryption settings of each org's backup folder ({BackupRoot}/{org}),
+// and the data keys of the project folders in them opened during this run.
+var (
+	storesMu     sync.Mutex
+	backupStores = map[string]*EncryptionConfig{}
+	storeKeys    = map[string]cipher.AEAD{}
+)
+
+// registerBackupStore makes the encryption settings of an org known to the
+// backup readers and writers. Like registerOrganization, it is called
+// whenever a command resolves an organization from the config.
+func registerBackupStore(name string, org *OrganizationConfig) {
+	root, err := filepath.Abs(filepath.Join(org.BackupRoot, name))
+	if err != nil {
+		return
+	}
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	backupStores[root] = org.Encryption
+}
+
+// ValidateEncryption checks that enc names exactly one usable key.
+func ValidateEncryption(enc *EncryptionConfig) error {
+	if enc == nil {
+		return nil
+	}
+	switch {
+	case enc.KeyFile != "" && enc.PassphraseEnv != "":
+		return fmt.Errorf("encryption: give a key file or a passphrase env var, not both")
+	case enc.KeyFile != "":
+		_, err := readKeyFile(enc.KeyFile)
+		return err
+	case enc.PassphraseEnv != "":
+		return nil // read when a backup is opened; CI may set it later
+	}
+	return fmt.Errorf("encryption: a key file or a passphrase env var is required")
+}
+
+// backupKey returns the data key of the project folder fp is in. With
+// create, a folder of an encrypted store without one gets a new one. It
+// returns nil (and no error) for files outside encrypted stores.
+func backupKey(fp string, create bool) (cipher.AEAD, error) {
+	abs, err := filepath.Abs(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	root, enc := findBackupStore(abs)
+	if enc == nil {
+		return nil, nil
+	}
+	rel, _ := filepath.Rel(root, abs)
+	project := filepath.Join(root, strings.Split(rel, string(filepath.Separator))[0])
+	if key, ok := storeKeys[project]; ok {
+		return key, nil
+	}
+
+	key, err := openDataKey(project, enc, create)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	storeKeys[project] = key
+	return key, nil
+}
+
+// findBackupStore returns the org backup folder the absolute path abs is in,
+// and its encryption settings. The caller holds storesMu.
+func findBackupStore(abs string) (string, *EncryptionConfig) {
+	var root string
+	var enc *EncryptionConfig
+	for r, e := range backupStores {
+		if strings.HasPrefix(abs, r+string(filepath.Separator)) && len(r) > len(root) {
+			root, enc = r, e
+		}
+	}
+	return root, enc
+}
+
+// sealedName is the name authenticated with every segment of the backup
+// file fp (see encryptStream): its path in the project folder, "/"
+// separated, so one encrypted file cannot stand in for another of a
+// different kind, item or snapshot. It starts at the snapshots folder, so a
+// snapshot unpacked in a temporary folder of the project (see ImportArchive)
+// has the names it gets once moved into place.
+func sealedName(fp string) string {
+	abs, err := filepath.Abs(fp)
+	if err != nil {
+		return filepath.Base(fp)
+	}
+	storesMu.Lock()
+	root, _ := findBackupStore(abs)
+	storesMu.Unlock()
+	if root == "" {
+		return filepath.Base(fp)
+	}
+	rel, _ := filepath.Rel(root, abs)
+	parts := strings.Split(filepath.ToSlash(rel), "/")[1:] // below the project folder
+	for i, p := range parts {
+		if p == SnapshotsDir {
+			parts = parts[i:]
+			break
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// encryptedStore reports whether fp is in the backup folder of an org that
+// encrypts its backups.
+func encryptedStore(fp string) bool {
+	abs, err := filepath.Abs(fp)
+	if err != nil {
+		return false
+	}
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	_, enc := findBackupStore(abs)
+	return enc != nil
+}
+
+// errNotEncrypted is the error for the plain file fp in an encrypted store.
+func errNotEncrypted(fp string) error {
+	return fmt.Errorf("%s is not encrypted, but its org encrypts its backups: it was written before encryption was turned on (run upgrade-backup --encrypt), or replaced", fp)
+}
+
+// keptPlain reports whether the file rel of a snapshot stays unencrypted in
+// an encrypted store: what describes the snapshot, not what it backs up.
+func keptPlain(rel string) bool {
+	switch rel {
+	case snapshotFile, BackupManifestFile, BackupSignatureFile, projectBackupReportFile:
+		return true
+	}
+	return false
+}
+
+// plainBackupItems lists the items of the snapshot folder dir that an
+// encrypted store must hold encrypted but that are not: mirror folders and
+// files without the encryption header.
+func plainBackupItems(dir string) ([]string, error) {
+	var plain []string
+	err := filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, fp)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == "." || keptPlain(rel):
+			return nil
+		case d.IsDir() && isMirror(fp):
+			plain = append(plain, rel)
+			return filepath.SkipDir
+		case d.Type().IsRegular() && !isEncryptedFile(fp):
+			plain = append(plain, rel)
+		}
+		return nil
+	})
+	return plain, err
+}
+
+// sealBackupItems encrypts the items of the snapshot folder dir listed by
+// plainBackupItems in place: a mirror becomes an encrypted bundle, a file is
+// encrypted under a temporary name and renamed over the plain one.
+func sealBackupItems(dir string, items []string) error {
+	for _, rel := range items {
+		fp := filepath.Join(dir, filepath.FromSlash(rel))
+		if isMirror(fp) {
+			if err := sealMirror(fp, fp+bundleSuffix); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(fp); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := sealFile(fp); err != nil {
+			return fmt.Errorf("encrypt %s: %w", fp, err)
+		}
+	}
+	return nil
+}
+
+// sealFile encrypts the plain backup file fp in place.
+func sealFile(fp string) (err error) {
+	key, err := backupKey(fp, true)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("%s is not in an encrypted store", fp)
+	}
+	in, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(fp), "."+filepath.Base(fp)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(out.Name())
+		}
+	}()
+	err = encryptStream(key, sealedName(fp), out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), fp)
+}
+
+// openDataKey unwraps the data key of a project folder, or makes one.
+func openDataKey(project string, enc *EncryptionConfig, create bool) (cipher.AEAD, error) {
+	path := filepath.Join(project, encryptionFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if !create {
+			return nil, nil
+		}
+		return newDataKey(path, enc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var env keyEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed parsing %s: %w", path, err)
+	}
+	kek, err := wrappingKey(enc, &env)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dek, err := kek.Open(nil, env.Nonce, env.WrappedKey, []byte(encryptionFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s: wrong key or passphrase for this backup", path)
+	}
+	return newGCM(dek)
+}
+
+func newDataKey(path string, enc *EncryptionConfig) (cipher.AEAD, error) {
+	env := keyEnvelope{Version: 1, Algorithm: "AES-256-GCM", KDF: "none"}
+	if enc.KeyFile == "" {
+		env.KDF, env.Iterations, env.Salt = "pbkdf2-sha256", pbkdf2Iterations, randomBytes(16)
+	}
+	kek, err := wrappingKey(enc, &env)
+	if err != nil {
+		return nil, err
+	}
+	dek := randomBytes(32)
+	env.Nonce = randomBytes(kek.NonceSize())
+	env.WrappedKey = kek.Seal(nil, env.Nonce, dek, []byte(encryptionFile))
+
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return newGCM(dek)
+}
+
+// wrappingKey returns the key that wraps the data key: the key file, or the
+// passphrase run through the KDF of env.
+func wrappingKey(enc *EncryptionConfig, env *keyEnvelope) (cipher.AEAD, error) {
+	switch {
+	case env.KDF == "none" && enc.KeyFile != "":
+		key, err := readKeyFile(enc.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return newGCM(key)
+	case env.KDF == "pbkdf2-sha256" && enc.PassphraseEnv != "":
+		pass := os.Getenv(enc.PassphraseEnv)
+		if pass == "" {
+			return nil, fmt.Errorf("passphrase env var %s is not set", enc.PassphraseEnv)
+		}
+		key, err := pbkdf2.Key(sha256.New, pass, env.Salt, env.Iterations, 32)
+		if err != nil {
+			return nil, err
+		}
+		return newGCM(key)
+	case env.KDF == "none":
+		return nil, fmt.Errorf("encrypted with a key file, but the org has none")
+	case env.KDF == "pbkdf2-sha256":
+		return nil, fmt.Errorf("encrypted with a passphrase, but the org has no passphrase env var")
+	}
+	return nil, fmt.Errorf("unknown key derivation '%s'", env.KDF)
+}
+
+// readKeyFile reads a 32 byte key, raw or base64.
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	return nil, fmt.Errorf("encryption key %s must hold 32 bytes, raw or base64 (openssl rand -base64 32)", path)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// writeBackupFile writes a backup file, encrypted if it is in an encrypted
+// store.
+func writeBackupFile(fp string, data []byte) error {
+	key, err := backupKey(fp, true)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return os.WriteFile(fp, data, 0644)
+	}
+	var buf bytes.Buffer
+	if err := encryptStream(key, sealedName(fp), &buf, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return os.WriteFile(fp, buf.Bytes(), 0600)
+}
+
+// readBackupFile reads a backup file, decrypting it if it is encrypted. A
+// plain file in an encrypted store is an error.
+func readBackupFile(fp string) ([]byte, error) {
+	data, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, encMagic) {
+		if encryptedStore(fp) {
+			return nil, errNotEncrypted(fp)
+		}
+		return data, nil
+	}
+	key, err := backupKey(fp, false)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%s is encrypted, but its org has no encryption key configured", fp)
+	}
+	var buf bytes.Buffer
+	if err := decryptStream(key, sealedName(fp), &buf, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("%s: %w", fp, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// isEncryptedFile reports whether fp starts with the encryption header.
+func isEncryptedFile(fp string) bool {
+	f, err := os.Open(fp)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(encMagic))
+	_, err = io.ReadFull(f, head)
+	return err == nil && bytes.Equal(head, encMagic)
+}
+
+// encryptStream writes src to dst as encMagic, a random nonce prefix and
+// segments of encSegment bytes, each sealed with the prefix and its number
+// as nonce. The name of the file and a last-segment flag are authenticated
+// with every segment, so files cannot be swapped, reordered or truncated
+// unnoticed.
+func encryptStream(key cipher.AEAD, name string, dst io.Writer, src io.Reader) error {
+	prefix := randomBytes(key.NonceSize() - 4)
+	if _, err := dst.Write(append(append([]byte{}, encMagic...), prefix...)); err != nil {
+		return err
+	}
+	in := bufio.NewReaderSize(src, encSegment+1)
+	buf := make([]byte, encSegment)
+	for n := uint32(0); ; n++ {
+		k, err := io.ReadFull(in, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last, err := atEOF(in)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(key.Seal(nil, segmentNonce(prefix, n), buf[:k], segmentAAD(name, last))); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// decryptStream reverses encryptStream; name must be the one src was
+// encrypted with.
+func decryptStream(key cipher.AEAD, name string, dst io.Writer, src io.Reader) error {
+	in := bufio.NewReaderSize(src, encSegment+key.Overhead()+1)
+	head := make([]byte, len(encMagic)+key.NonceSize()-4)
+	if _, err := io.ReadFull(in, head); err != nil || !bytes.HasPrefix(head, encMagic) {
+		return errors.New("not an encrypted backup file")
+	}
+	prefix := head[len(encMagic):]
+	buf := make([]byte, encSegment+key.Overhead())
+	for n := uint32(0); ; n++ {
+		k, err := io.ReadFull(in, buf)
+		switch {
+		case err == io.EOF:
+			return errors.New("truncated encrypted backup file")
+		case err != nil && err != io.ErrUnexpectedEOF:
+			return err
+		}
+		last, err := atEOF(in)
+		if err != nil {
+			return err
+		}
+		plain, err := key.Open(nil, segmentNonce(prefix, n), buf[:k], segmentAAD(name, last))
+		if err != nil {
+			return errors.New("decryption failed: wrong key, or the file was modified")
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// atEOF reports whether in has nothing left to read. Read errors are
+// returned, so a failing source does not end a stream early as if complete.
+func atEOF(in *bufio.Reader) (bool, error) {
+	_, err := in.Peek(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+func segmentNonce(prefix []byte, n uint32) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte{}, prefix...), n)
+}
+
+func segmentAAD(name string, last bool) []byte {
+	if last {
+		return []byte(name + "\x00last")
+	}
+	return []byte(name + "\x00")
+}
+
+// encryptFile encrypts the file src into dst with the key of dst's store;
+// without one it copies src.
+func encryptFile(src, dst string) error {
+	key, err := backupKey(dst, true)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		_, err = io.Copy(out, in)
+	} else {
+		err = encryptStream(key, sealedName(dst), out, in)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// decryptFile writes the plain content of the backup file src to dst. A
+// plain src in an encrypted store is an error.
+func decryptFile(src, dst string) error {
+	encrypted := isEncryptedFile(src)
+	if !encrypted && encryptedStore(src) {
+		return errNotEncrypted(src)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if !encrypted {
+		_, err = io.Copy(out, in)
+	} else {
+		var key cipher.AEAD
+		if key, err = backupKey(src, false); err == nil && key == nil {
+			err = fmt.Errorf("%s is encrypted, but its org has no encryption key configured", src)
+		}
+		if err == nil {
+			err = decryptStream(key, sealedName(src), out, in)
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	return nil
+}
+
+// resealFile copies the encrypted backup file src to dst, another place in
+// the same project folder, decrypting it and encrypting it again under the
+// name of dst (see sealedName).
+func resealFile(src, dst string) error {
+	key, err := backupKey(dst, true)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("%s is not in an encrypted store", dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(decryptStream(key, sealedName(src), pw, in))
+	}()
+	err = encryptStream(key, sealedName(dst), out, pr)
+	pr.CloseWithError(err)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	return nil
+}
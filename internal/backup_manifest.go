@@ -0,0 +1,503 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"azdo-vault/internal/adoclient"
+)
+
+// Every finished snapshot holds a manifest.json listing each file with its
+// SHA-256, and, if the org has a signing key, manifest.json.sig with an
+// ed25519 signature of the manifest.
+const (
+	BackupManifestFile  = "manifest.json"
+	BackupSignatureFile = "manifest.json.sig"
+
+	backupManifestVersion = 1
+)
+
+// ToolVersion is the azdo-vault version recorded in backup manifests.
+var ToolVersion = "dev"
+
+// BackupManifest describes what a snapshot holds and what produced it.
+type BackupManifest struct {
+	Version         int          `json:"version"`
+	SchemaVersion   int          `json:"schemaVersion"` // of the snapshot, see BackupSchemaVersion
+	Tool            string       `json:"tool"`
+	ToolVersion     string       `json:"toolVersion"`
+	Snapshot        string       `json:"snapshot"`
+	CreatedAt       time.Time    `json:"createdAt"`
+	OrganizationURL string       `json:"organizationUrl"`
+	OrganizationID  string       `json:"organizationId,omitempty"`
+	Project         string       `json:"project"`
+	ProjectID       string       `json:"projectId,omitempty"`
+	APIProfile      string       `json:"apiProfile"`
+	APIVersion      string       `json:"apiVersion,omitempty"` // empty: every call uses its own cloud version
+	Kinds           []string     `json:"kinds"`
+	Items           []BackupItem `json:"items"`
+}
+
+// BackupItem is one file of a snapshot, or one mirrored git repo.
+type BackupItem struct {
+	Kind     string `json:"kind,omitempty"` // empty for the snapshot's own files (refs.json, ...)
+	Path     string `json:"path"`           // relative to the snapshot, "/" separated
+	Name     string `json:"name,omitempty"`
+	SourceID string `json:"sourceId,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	Size     int64  `json:"size"` // of a mirror: of its files, which git may repack
+	SHA256   string `json:"sha256"`
+	Mirror   bool   `json:"mirror,omitempty"` // a bare git repo; SHA256 covers its refs (see hashMirror)
+}
+
+// backupSignature is the content of manifest.json.sig.
+type backupSignature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"keyId"`
+	Signature string `json:"signature"` // base64 ed25519 signature of manifest.json as written
+}
+
+// writeBackupManifest writes the manifest of s and, if org has a signing
+// key, its signature. Source IDs come from the snapshot's refs.json.
+func writeBackupManifest(s *Snapshot) error {
+	m := &BackupManifest{
+		Version:       backupManifestVersion,
+		SchemaVersion: s.SchemaVersion,
+		Tool:          "azdo-vault",
+		ToolVersion:   ToolVersion,
+		Snapshot:      s.ID,
+		CreatedAt:     time.Now().UTC(),
+		Project:       s.project,
+		APIProfile:    "cloud",
+		Kinds:         s.Kinds,
+	}
+	if s.org != nil {
+		m.OrganizationURL = s.org.URL
+		if s.org.APIProfile != "" {
+			m.APIProfile = s.org.APIProfile
+		}
+		m.APIVersion, _ = adoclient.ResolveAPIVersion(s.org.APIProfile)
+	}
+	if refs, err := LoadRefIndex(filepath.Join(s.dir, RefIndexFile)); err == nil {
+		m.OrganizationID, m.ProjectID = refs.OrgID, refs.ProjectID
+	}
+
+	items, err := scanBackupItems(s.dir)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	m.Items = items
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, BackupManifestFile), data, 0644); err != nil {
+		return err
+	}
+
+	if s.org == nil || s.org.SigningKeyFile == "" {
+		return nil
+	}
+	key, err := loadSigningKey(s.org.SigningKeyFile)
+	if err != nil {
+		return err
+	}
+	sig, err := json.MarshalIndent(backupSignature{
+		Algorithm: "ed25519",
+		KeyID:     keyID(key.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, BackupSignatureFile), sig, 0644)
+}
+
+// scanBackupItems lists and hashes every file below dir, except the manifest
+// and its signature; bare repos (*.git) are one item each.
+func scanBackupItems(dir string) ([]BackupItem, error) {
+	var items []BackupItem
+	err := filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, fp)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == "." || rel == BackupManifestFile || rel == BackupSignatureFile:
+			return nil
+		case d.IsDir() && isMirror(fp):
+			sum, size, err := hashMirror(fp)
+			if err != nil {
+				return err
+			}
+			items = append(items, BackupItem{
+				Kind:   backupItemKind(rel),
+				Path:   rel,
+				Name:   strings.TrimSuffix(strings.TrimSuffix(d.Name(), ".git"), ".wiki"),
+				Size:   size,
+				SHA256: sum,
+				Mirror: true,
+			})
+			return filepath.SkipDir
+		case !d.Type().IsRegular():
+			return nil
+		}
+
+		sum, size, err := hashFile(fp)
+		if err != nil {
+			return err
+		}
+		item := BackupItem{Kind: backupItemKind(rel), Path: rel, Size: size, SHA256: sum}
+		switch {
+		case item.Kind == "":
+		case strings.HasSuffix(rel, ".json"):
+			item.Name, item.SourceID, item.Revision = backupItemMeta(fp)
+		case strings.HasSuffix(rel, ".git"+bundleSuffix):
+			name, _ := mirrorName(d.Name())
+			item.Name = strings.TrimSuffix(name, ".wiki")
+		}
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// backupItemKind returns the kind whose folder holds rel; "" for the files
+// of the snapshot itself.
+func backupItemKind(rel string) string {
+	for _, kind := range AllKinds {
+		if strings.HasPrefix(rel, filepath.ToSlash(KindDir(kind))+"/") {
+			return kind
+		}
+	}
+	return ""
+}
+
+// backupItemMeta reads the name, id and revision of a backed up resource.
+func backupItemMeta(fp string) (name, id, revision string) {
+	data, err := readBackupFile(fp)
+	if err != nil {
+		return "", "", ""
+	}
+	var v map[string]any
+	if json.Unmarshal(data, &v) != nil {
+		return "", "", ""
+	}
+	scalar := func(x any) string {
+		switch t := x.(type) {
+		case string:
+			return t
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64)
+		}
+		return ""
+	}
+	name = scalar(v["name"])
+	if name == "" {
+		name = scalar(v["displayName"])
+	}
+	return name, scalar(v["id"]), scalar(v["revision"])
+}
+
+func hashFile(fp string) (string, int64, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// hashMirror identifies a bare repo by its refs: the SHA-256 of the
+// "objectname refname" lines of git for-each-ref, sorted by ref. Objects are
+// named by their content, so together with git fsck this covers the whole
+// repo, however it is packed; a clone of its bundle has the same refs. The
+// size is that of its files.
+func hashMirror(dir string) (string, int64, error) {
+	refs, err := exec.Command("git", "--git-dir", dir, "for-each-ref", "--format=%(objectname) %(refname)").Output()
+	if err != nil {
+		return "", 0, fmt.Errorf("git for-each-ref failed for %s: %w", dir, err)
+	}
+	var total int64
+	err = filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err == nil {
+			total += info.Size()
+		}
+		return err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	h := sha256.Sum256(refs)
+	return hex.EncodeToString(h[:]), total, nil
+}
+
+// hashMirrorFiles is how manifests before BackupSchemaMirrorRefs hash a
+// bare repo: the SHA-256 of the sorted "path sha256" lines of its files, so
+// a changed, added or removed file changes it.
+func hashMirrorFiles(dir string) (string, int64, error) {
+	var lines []string
+	var total int64
+	err := filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		sum, size, err := hashFile(fp)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, fp)
+		lines = append(lines, filepath.ToSlash(rel)+" "+sum)
+		total += size
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	sort.Strings(lines)
+	h := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(h[:]), total, nil
+}
+
+// loadSigningKey reads an ed25519 private key in PKCS #8 PEM form, as
+// written by `openssl genpkey -algorithm ed25519`.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", path, err)
+	}
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an ed25519 key", path)
+	}
+	return key, nil
+}
+
+// LoadVerifyKey reads an ed25519 public key in PKIX PEM form (`openssl pkey
+// -pubout`), or takes the public half of a private key file.
+func LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "PRIVATE KEY" {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("public key %s: %w", path, err)
+	}
+	key, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ed25519 key", path)
+	}
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+// keyID is the SHA-256 fingerprint of a public key, as ssh-keygen -l prints it.
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// VerifyIssue is one problem verify-backup found.
+type VerifyIssue struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+}
+
+// VerifyResult is the outcome of verifying one snapshot.
+type VerifyResult struct {
+	Snapshot string        `json:"snapshot"`
+	Items    int           `json:"items"`
+	Signed   bool          `json:"signed"`
+	KeyID    string        `json:"keyId,omitempty"`
+	Issues   []VerifyIssue `json:"issues,omitempty"`
+}
+
+// VerifyBackup checks a snapshot against its manifest: every listed file
+// must exist with its SHA-256 and no other file may be present. A signature
+// must be by pub and match the manifest; a signed manifest fails without pub,
+// since its signature cannot be checked. requireSignature also fails an
+// unsigned manifest. With fsck, every mirrored repo, bundled or
+// not, must pass `git fsck --full`. A mirror whose manifest entry covers only
+// its refs (see hashMirror) is always checked with git fsck, since the refs
+// say nothing about the objects on disk. In an encrypted store every backed
+// up file must be encrypted (see keptPlain).
+func VerifyBackup(s *Snapshot, pub ed25519.PublicKey, requireSignature, fsck bool) (*VerifyResult, error) {
+	return verifyBackup(s, pub, requireSignature, fsck, false)
+}
+
+// verifyBackup is VerifyBackup; with allowPlain, plain files and mirrors of
+// an encrypted store are checked like encrypted ones, for upgrade-backup
+// --encrypt to check a snapshot before it seals it.
+func verifyBackup(s *Snapshot, pub ed25519.PublicKey, requireSignature, fsck, allowPlain bool) (*VerifyResult, error) {
+	res := &VerifyResult{Snapshot: s.ID}
+	issue := func(path, format string, args ...any) {
+		res.Issues = append(res.Issues, VerifyIssue{Path: path, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, BackupManifestFile))
+	if os.IsNotExist(err) {
+		issue(BackupManifestFile, "missing (the snapshot is unfinished or older than manifests)")
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m BackupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		issue(BackupManifestFile, "corrupted: %v", err)
+		return res, nil
+	}
+	res.Items = len(m.Items)
+
+	sigData, err := os.ReadFile(filepath.Join(s.dir, BackupSignatureFile))
+	switch {
+	case err == nil:
+		var sig backupSignature
+		if err := json.Unmarshal(sigData, &sig); err != nil {
+			issue(BackupSignatureFile, "corrupted: %v", err)
+			break
+		}
+		res.Signed, res.KeyID = true, sig.KeyID
+		raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+		switch {
+		case pub == nil:
+			issue(BackupSignatureFile, "signed by key %s, but no public key was given to check the signature", sig.KeyID)
+		case err != nil || sig.Algorithm != "ed25519":
+			issue(BackupSignatureFile, "unreadable %s signature", sig.Algorithm)
+		case sig.KeyID != keyID(pub):
+			issue(BackupSignatureFile, "signed by key %s, not by %s", sig.KeyID, keyID(pub))
+		case !ed25519.Verify(pub, data, raw):
+			issue(BackupManifestFile, "signature does not match: the manifest was modified")
+		}
+	case os.IsNotExist(err):
+		if requireSignature {
+			issue(BackupSignatureFile, "missing: the manifest is not signed")
+		}
+	default:
+		return nil, err
+	}
+
+	encrypted := encryptedStore(s.dir)
+	listed := map[string]bool{}
+	for _, it := range m.Items {
+		listed[it.Path] = true
+		fp := filepath.Join(s.dir, filepath.FromSlash(it.Path))
+		if _, err := os.Stat(fp); os.IsNotExist(err) {
+			issue(it.Path, "missing")
+			continue
+		}
+		if encrypted && !allowPlain && !keptPlain(it.Path) && (it.Mirror || !isEncryptedFile(fp)) {
+			issue(it.Path, "not encrypted, but the org encrypts its backups (run upgrade-backup --encrypt)")
+		}
+		hash, sized, refsOnly := hashFile, true, false
+		switch {
+		case it.Mirror && m.SchemaVersion < BackupSchemaMirrorRefs:
+			hash = hashMirrorFiles
+		case it.Mirror:
+			hash, sized, refsOnly = hashMirror, false, true
+		}
+		sum, size, err := hash(fp)
+		if err != nil {
+			issue(it.Path, "unreadable: %v", err)
+			continue
+		}
+		if sum != it.SHA256 || (sized && size != it.Size) {
+			issue(it.Path, "modified: sha256 %s, manifest has %s", short(sum), short(it.SHA256))
+		}
+		if refsOnly || (fsck && (it.Mirror || strings.HasSuffix(it.Path, ".git"+bundleSuffix))) {
+			if err := fsckMirror(strings.TrimSuffix(fp, bundleSuffix)); err != nil {
+				issue(it.Path, "git fsck failed: %v", err)
+			}
+		}
+	}
+
+	present, err := scanBackupPaths(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range present {
+		if !listed[p] {
+			issue(p, "not in the manifest")
+		}
+	}
+	return res, nil
+}
+
+// scanBackupPaths lists the paths scanBackupItems would list, without hashing.
+func scanBackupPaths(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, fp)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == "." || rel == BackupManifestFile || rel == BackupSignatureFile:
+			return nil
+		case d.IsDir() && isMirror(fp):
+			paths = append(paths, rel)
+			return filepath.SkipDir
+		case d.Type().IsRegular():
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+func short(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
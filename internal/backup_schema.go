@@ -0,0 +1,286 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Backup schema versions. The files of one item (a JSON file per resource:
+// {name}.json for most kinds, {id}_{type}.json with _backupHints for branch
+// policies, {id}_{name}.json for wikis, bare git mirrors for repos and wiki
+// content) have kept their shape; versions differ in where they live and
+// what describes them:
+//
+//	0  kind folders, refs.json and backup-report.json directly in {project}/
+//	1  snapshots/{id}/ with snapshot.json, which has no schemaVersion;
+//	   manifest.json only in snapshots taken since manifests exist
+//	2  schemaVersion in snapshot.json and manifest.json; every finished
+//	   snapshot has a manifest
+//	3  the manifest identifies a mirror by its refs rather than by its
+//	   files, so a mirror cloned from a bundle (see ImportArchive) or
+//	   repacked still verifies
+//
+// Restores read every version: BackupPath falls back to version 0 folders
+// and ListSnapshots reads version 1 snapshot.json files as version 1.
+// UpgradeBackup rewrites all of them into the current version. A snapshot written
+// by a newer azdo-vault is refused rather than misread.
+const (
+	BackupSchemaFlat       = 0
+	BackupSchemaSnapshots  = 1
+	BackupSchemaMirrorRefs = 3
+	BackupSchemaVersion    = 3
+)
+
+// checkSchema fails for snapshots of a schema this build does not know.
+func (s *Snapshot) checkSchema() error {
+	if s.SchemaVersion > BackupSchemaVersion {
+		return fmt.Errorf("snapshot %s has backup schema %d and was written by a newer azdo-vault; this one reads up to schema %d", s.ID, s.SchemaVersion, BackupSchemaVersion)
+	}
+	return nil
+}
+
+// flatKinds returns the kinds with a version 0 backup in projectRoot.
+func flatKinds(projectRoot string) []string {
+	var kinds []string
+	for _, kind := range AllKinds {
+		entries, err := os.ReadDir(filepath.Join(projectRoot, KindDir(kind)))
+		if err == nil && len(entries) > 0 {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// flatFiles are the files of a version 0 backup besides its kind folders.
+var flatFiles = []string{RefIndexFile, projectBackupReportFile}
+
+// BackupUpgrade is one backup UpgradeBackup rewrote, or would rewrite.
+type BackupUpgrade struct {
+	Snapshot  string   // the snapshot written or rewritten
+	From      int      // the schema version it had
+	Kinds     []string // kinds it holds
+	Encrypted bool     // it was written before its org's backups were encrypted
+	Skipped   string   // why it was left as it is; "" if upgraded
+}
+
+// notEncrypted is why UpgradeBackup leaves a plain backup of an encrypted
+// store alone without encrypt.
+const notEncrypted = "not encrypted, but the org encrypts its backups; run with --encrypt"
+
+// UpgradeBackup rewrites the backups of a project into the current schema:
+//
+//   - a version 0 backup moves into a new snapshot named after the newest
+//     file in it, which becomes latest unless a newer snapshot exists;
+//   - an older snapshot gets the current schema version and a new manifest.
+//
+// With encrypt, the backups an encrypted store holds from before encryption
+// was turned on are sealed in place (see sealBackupItems) and get a new
+// manifest; without it they are left alone, since the store's readers
+// refuse them.
+//
+// A snapshot whose manifest does not verify (see VerifyBackup; signatures
+// are checked with org's signing key, if it has one) keeps its manifest and
+// stays as it is, as does a signed one the org has no key to sign again.
+// Unfinished snapshots are skipped. With dryRun nothing is written.
+func UpgradeBackup(projectRoot string, org *OrganizationConfig, project string, encrypt, dryRun bool) ([]BackupUpgrade, error) {
+	sealed := encryptedStore(projectRoot)
+	if encrypt && !sealed {
+		return nil, fmt.Errorf("the org of %s does not encrypt its backups", projectRoot)
+	}
+	snaps, err := ListSnapshots(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile := ""
+	if org != nil {
+		keyFile = org.SigningKeyFile
+	}
+
+	var out []BackupUpgrade
+	for _, s := range snaps {
+		var plain []string
+		if sealed && s.Finished() {
+			if plain, err = plainBackupItems(s.dir); err != nil {
+				return out, fmt.Errorf("%s: %w", s.ID, err)
+			}
+		}
+		if s.Finished() && s.SchemaVersion >= BackupSchemaVersion && len(plain) == 0 {
+			continue
+		}
+		u := BackupUpgrade{Snapshot: s.ID, From: s.SchemaVersion, Kinds: s.Kinds, Encrypted: len(plain) > 0}
+		switch {
+		case !s.Finished():
+			u.Skipped = "unfinished"
+		case len(plain) > 0 && !encrypt:
+			u.Skipped = notEncrypted
+		default:
+			if u.Skipped, err = upgradeBlocker(s, keyFile, len(plain) > 0); err != nil {
+				return out, fmt.Errorf("%s: %w", s.ID, err)
+			}
+		}
+		if u.Skipped == "" && !dryRun {
+			if err := sealBackupItems(s.dir, plain); err != nil {
+				return out, fmt.Errorf("%s: %w", s.ID, err)
+			}
+			s.SchemaVersion, s.org, s.project = BackupSchemaVersion, org, project
+			if err := s.write(); err != nil {
+				return out, fmt.Errorf("%s: %w", s.ID, err)
+			}
+		}
+		out = append(out, u)
+	}
+
+	kinds := flatKinds(projectRoot)
+	if len(kinds) == 0 {
+		return out, nil
+	}
+	t, err := flatBackupTime(projectRoot, kinds)
+	if err != nil {
+		return out, err
+	}
+	u := BackupUpgrade{Snapshot: t.UTC().Format(snapshotIDLayout), From: BackupSchemaFlat, Kinds: kinds, Encrypted: sealed}
+	if sealed && !encrypt {
+		u.Skipped = notEncrypted
+	}
+	if u.Skipped == "" && !dryRun {
+		s, err := upgradeFlat(projectRoot, t, kinds, org, project)
+		if err != nil {
+			return out, err
+		}
+		u.Snapshot = s.ID
+		if sealed {
+			plain, err := plainBackupItems(s.dir)
+			if err == nil {
+				err = sealBackupItems(s.dir, plain)
+			}
+			if err == nil {
+				err = s.write()
+			}
+			if err != nil {
+				return append(out, u), fmt.Errorf("%s: %w", s.ID, err)
+			}
+		}
+	}
+	return append(out, u), nil
+}
+
+// upgradeBlocker returns why the manifest of an older snapshot must not be
+// rewritten; "" if it may. With plain, the snapshot is checked as it was
+// before its org's backups were encrypted.
+func upgradeBlocker(s *Snapshot, keyFile string, plain bool) (string, error) {
+	if _, err := os.Stat(filepath.Join(s.dir, BackupManifestFile)); os.IsNotExist(err) {
+		return "", nil
+	}
+	var pub ed25519.PublicKey
+	if keyFile != "" {
+		var err error
+		if pub, err = LoadVerifyKey(keyFile); err != nil {
+			return "", err
+		}
+	}
+	res, err := verifyBackup(s, pub, false, false, plain)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case res.Signed && keyFile == "":
+		return "signed by " + res.KeyID + ", but the org has no signing key to sign it again", nil
+	case len(res.Issues) > 0:
+		return fmt.Sprintf("%d problem(s), run verify-backup", len(res.Issues)), nil
+	}
+	return "", nil
+}
+
+// flatBackupTime returns when the newest file of a version 0 backup was
+// written, which stands in for the time of the backup.
+func flatBackupTime(projectRoot string, kinds []string) (time.Time, error) {
+	var newest time.Time
+	visit := func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	}
+	for _, kind := range kinds {
+		if err := filepath.WalkDir(filepath.Join(projectRoot, KindDir(kind)), visit); err != nil {
+			return time.Time{}, err
+		}
+	}
+	for _, f := range flatFiles {
+		if info, err := os.Stat(filepath.Join(projectRoot, f)); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// upgradeFlat moves a version 0 backup into a new snapshot. If a move fails,
+// the ones done are undone and the snapshot removed, so restores still find
+// the backup where it was.
+func upgradeFlat(projectRoot string, t time.Time, kinds []string, org *OrganizationConfig, project string) (*Snapshot, error) {
+	s, err := newSnapshotAt(projectRoot, t, org, project)
+	if err != nil {
+		return nil, err
+	}
+
+	type move struct{ from, to string }
+	var moves []move
+	for _, kind := range kinds {
+		moves = append(moves, move{filepath.Join(projectRoot, KindDir(kind)), s.Path(kind)})
+	}
+	for _, f := range flatFiles {
+		if _, err := os.Stat(filepath.Join(projectRoot, f)); err == nil {
+			moves = append(moves, move{filepath.Join(projectRoot, f), filepath.Join(s.dir, f)})
+		}
+	}
+
+	undo := func(done []move) {
+		for i := len(done) - 1; i >= 0; i-- {
+			os.Rename(done[i].to, done[i].from)
+		}
+		os.RemoveAll(s.dir)
+	}
+	for i, m := range moves {
+		err := os.MkdirAll(filepath.Dir(m.to), 0755)
+		if err == nil {
+			err = os.Rename(m.from, m.to)
+		}
+		if err != nil {
+			undo(moves[:i])
+			return nil, fmt.Errorf("move %s into snapshot %s: %w", m.from, s.ID, err)
+		}
+	}
+	s.Kinds, s.FinishedAt = kinds, t.UTC()
+	if err := s.write(); err != nil {
+		undo(moves)
+		return nil, err
+	}
+
+	// artifacts/feeds leaves an empty artifacts folder behind
+	for _, kind := range kinds {
+		if parent := filepath.Dir(KindDir(kind)); parent != "." {
+			os.Remove(filepath.Join(projectRoot, parent))
+		}
+	}
+	if latest := latestID(projectRoot); latest == "" || latest < s.ID {
+		if err := setLatest(projectRoot, s.ID); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
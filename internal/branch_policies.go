@@ -3,18 +3,15 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
-	"net/url"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
-)
 
-type PolicyConfigListResponse struct {
-	Count int            `json:"count"`
-	Value []PolicyConfig `json:"value"`
-}
+	"azdo-vault/internal/adoclient"
+)
 
 type PolicyConfig struct {
 	Id         int            `json:"id,omitempty"`
@@ -59,29 +56,11 @@ func (p PolicyConfig) MarshalJSON() ([]byte, error) {
 func ListPolicyConfigurations(orgURL, project, resourceGUID string) ([]PolicyConfig, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/policy/configurations?api-version=7.1", strings.TrimRight(orgURL, "/"), project)
 
-	args := []string{
-		"rest",
-		"--method", "get",
-		"--uri", uri,
-		"--output", "json",
-		"--only-show-errors",
-	}
-
-	if strings.TrimSpace(resourceGUID) != "" {
-		args = append(args, "--resource", resourceGUID)
-	}
-
-	cmd := exec.Command("az", args...)
-	out, err := cmd.CombinedOutput()
+	list, err := adoList[PolicyConfig](orgURL, resourceGUID, uri, adoclient.Paging{})
 	if err != nil {
-		return nil, fmt.Errorf("az rest list policy configurations failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("list policy configurations failed: %w", err)
 	}
-
-	var resp PolicyConfigListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing policy configurations JSON: %w\nRaw:\n%s", err, string(out))
-	}
-	return resp.Value, nil
+	return list, nil
 }
 
 func CreatePolicyConfiguration(orgURL, project, resourceGUID string, payload map[string]any) (int, error) {
@@ -92,23 +71,9 @@ func CreatePolicyConfiguration(orgURL, project, resourceGUID string, payload map
 
 	uri := fmt.Sprintf("%s/%s/_apis/policy/configurations?api-version=7.1", strings.TrimRight(orgURL, "/"), project)
 
-	args := []string{
-		"rest",
-		"--method", "post",
-		"--uri", uri,
-		"--headers", "Content-Type=application/json",
-		"--body", string(bodyBytes),
-		"--output", "json",
-		"--only-show-errors",
-	}
-	if strings.TrimSpace(resourceGUID) != "" {
-		args = append(args, "--resource", resourceGUID)
-	}
-
-	cmd := exec.Command("az", args...)
-	out, err := cmd.CombinedOutput()
+	out, err := adoSend(orgURL, resourceGUID, "post", uri, bodyBytes)
 	if err != nil {
-		return 0, fmt.Errorf("az rest create policy configuration failed: %w\n%s", err, string(out))
+		return 0, fmt.Errorf("create policy configuration failed: %w", err)
 	}
 
 	var created map[string]any
@@ -139,6 +104,7 @@ func BackupBranchPolicies(
 	orgURL, project, backupPath string,
 	selectedRepos []string, // repo names or ["all"]
 	resourceGUID string,
+	exec *ExecOptions,
 ) error {
 
 	repoIDs, err := resolveRepoIDsForFilter(orgURL, project, selectedRepos)
@@ -154,11 +120,13 @@ func BackupBranchPolicies(
 		fmt.Println("No policy configurations found")
 		return nil
 	}
+	fmt.Printf("Found %d policy configurations\n", len(all))
 
 	if err := os.MkdirAll(backupPath, 0755); err != nil {
 		return err
 	}
 
+	var items []PolicyConfig
 	for _, pc := range all {
 		if pc.Raw == nil {
 			continue
@@ -169,7 +137,11 @@ func BackupBranchPolicies(
 				continue
 			}
 		}
+		items = append(items, pc)
+	}
 
+	err = forEach(len(items), exec.workers(KindBranchPolicies), exec.failFast(true), func(i int, out io.Writer) error {
+		pc := items[i]
 		hints := PolicyBackupHints{
 			Identities:       map[string]IdentityHint{},
 			BuildDefinitions: map[string]string{},
@@ -180,7 +152,7 @@ func BackupBranchPolicies(
 			if err == nil && ih != nil {
 				hints.Identities[id] = *ih
 			} else {
-				fmt.Printf("⚠ backup: could not resolve identity id=%s policy=%s err=%v\n", id, PolicyShortLabel(pc.Raw), err)
+				fmt.Fprintf(out, "⚠ backup: could not resolve identity id=%s policy=%s err=%v\n", id, PolicyShortLabel(pc.Raw), err)
 			}
 		}
 		pc.Raw["_backupHints"] = hints
@@ -198,11 +170,15 @@ func BackupBranchPolicies(
 		if err != nil {
 			return err
 		}
-		if err := os.WriteFile(fp, data, 0644); err != nil {
+		if err := writeBackupFile(fp, data); err != nil {
 			return err
 		}
 
-		fmt.Println("✔ Backed up policy:", PolicyShortLabel(pc.Raw))
+		fmt.Fprintln(out, "✔ Backed up policy:", PolicyShortLabel(pc.Raw))
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -213,18 +189,23 @@ func RestoreBranchPoliciesFromBackup(
 	targetOrgURL, targetProject, backupPath string,
 	selected []string, // filenames or "all"
 	resourceGUID string,
-) error {
+	opts *RestoreOptions,
+) ([]RestoreResult, error) {
 
 	files, err := os.ReadDir(backupPath)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	run := newRestoreRun(KindBranchPolicies, opts)
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return run.results, err
 	}
-	restoreAll := len(selected) == 1 && strings.EqualFold(selected[0], "all")
 
 	// Build target repo name -> id map
-	targetRepos, err := ListRepos(targetOrgURL, targetProject)
+	targetRepos, err := run.idx.repos(targetOrgURL, targetProject)
 	if err != nil {
-		return fmt.Errorf("failed to list target repos: %w", err)
+		return nil, fmt.Errorf("failed to list target repos: %w", err)
 	}
 	targetRepoIDByName := map[string]string{}
 	for _, r := range targetRepos {
@@ -232,33 +213,39 @@ func RestoreBranchPoliciesFromBackup(
 		targetRepoIDByName[strings.ToLower(r.Name)] = r.Id
 	}
 
-	// Build SOURCE repo id -> name map (once)
-	sourceRepos, err := ListRepos(sourceOrgURL, sourceProject)
+	// SOURCE repo id -> name map, from the reference index captured at backup time
+	refs, err := sourceRefs(backupPath, sourceOrgURL, sourceProject, resourceGUID)
 	if err != nil {
-		return fmt.Errorf("failed to list source repos: %w", err)
+		return nil, err
 	}
+	// ... with renamed repos already under their target name
 	sourceRepoNameByID := map[string]string{}
-	for _, r := range sourceRepos {
-		sourceRepoNameByID[strings.ToLower(r.Id)] = r.Name
+	for id, name := range refs.Repos {
+		sourceRepoNameByID[id] = run.names.Name(KindRepos, name)
 	}
 
 	// Load target existing policies once for "exists" check
-	targetExisting, err := ListPolicyConfigurations(targetOrgURL, targetProject, resourceGUID)
+	targetExisting, err := run.idx.policies(targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return fmt.Errorf("failed listing target policies: %w", err)
+		return nil, fmt.Errorf("failed listing target policies: %w", err)
 	}
 
+	var items []os.DirEntry
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name(), ".json") {
 			continue
 		}
 
-		if !restoreAll && !contains(selected, f.Name()) && !contains(selected, strings.TrimSuffix(f.Name(), ".json")) {
+		if !sel.Match(f.Name(), strings.TrimSuffix(f.Name(), ".json")) {
 			continue
 		}
+		items = append(items, f)
+	}
 
+	err = run.each(len(items), func(i int, item *restoreRun) error {
+		f := items[i]
 		fp := filepath.Join(backupPath, f.Name())
-		b, err := os.ReadFile(fp)
+		b, err := readBackupFile(fp)
 		if err != nil {
 			return err
 		}
@@ -274,28 +261,35 @@ func RestoreBranchPoliciesFromBackup(
 
 		// Build "signature" for dedupe (type + scopes + key settings)
 		sig := PolicySignature(pc.Raw)
+		label := PolicyShortLabel(pc.Raw)
+		sourceID := strconv.Itoa(pc.Id)
 		if existing := FindPolicyConfigBySignature(targetExisting, sig); existing != nil {
-			fmt.Println("✔ Policy exists, skipping:", PolicyShortLabel(pc.Raw))
-			continue
+			item.println("✔ Policy exists, skipping:", PolicyShortLabel(pc.Raw))
+			item.exists(label, sourceID, strconv.Itoa(existing.Id))
+			return nil
 		}
 
 		payload := SanitizePolicyForCreate(pc.Raw)
+		refs.withPolicyHints(payload)
 
 		// identity mapping
-		if err := RemapPolicyIdentityIDs(payload, targetOrgURL, resourceGUID); err != nil {
-			fmt.Printf("⚠ Identity mapping failed for '%s': %s\n", PolicyShortLabel(payload), err.Error())
-			continue
+		if err := RemapPolicyIdentityIDs(payload, item.ids, targetOrgURL, resourceGUID); err != nil {
+			item.printf("⚠ Identity mapping failed for '%s': %s\n", PolicyShortLabel(payload), err.Error())
+			item.unresolvable(label, sourceID, "identity mapping failed: "+err.Error())
+			return nil
 		}
 
 		//DEBUG
 		if s, ok := payload["settings"].(map[string]any); ok {
-			fmt.Printf("DEBUG mapped requiredReviewerIds=%v\n", s["requiredReviewerIds"])
+			item.printf("DEBUG mapped requiredReviewerIds=%v\n", s["requiredReviewerIds"])
 		}
 
 		// build validation mapping
-		if err := RemapBuildValidationDefinition(payload, targetOrgURL, targetProject, resourceGUID); err != nil {
-			fmt.Printf("⚠ Build validation mapping warning for '%s': %s\n", PolicyShortLabel(payload), err.Error())
+		var warnings []string
+		if err := RemapBuildValidationDefinition(payload, item.names, targetOrgURL, targetProject, resourceGUID); err != nil {
+			item.printf("⚠ Build validation mapping warning for '%s': %s\n", PolicyShortLabel(payload), err.Error())
 			// do NOT skip; try creating anyway
+			warnings = append(warnings, "build validation mapping: "+err.Error())
 		}
 
 		// repoId mapping inside settings.scope[]
@@ -306,21 +300,34 @@ func RestoreBranchPoliciesFromBackup(
 			targetRepoIDByName,
 			resourceGUID,
 		); err != nil {
-			fmt.Printf("⚠ Skipping policy '%s': repo mapping failed: %s\n", PolicyShortLabel(pc.Raw), err.Error())
-			continue
+			item.printf("⚠ Skipping policy '%s': repo mapping failed: %s\n", PolicyShortLabel(pc.Raw), err.Error())
+			item.unresolvable(label, sourceID, "repo mapping failed: "+err.Error())
+			return nil
 		}
 
 		delete(payload, "_backupHints")
 
-		fmt.Println("Creating policy:", PolicyShortLabel(payload))
-		if _, err := CreatePolicyConfiguration(targetOrgURL, targetProject, resourceGUID, payload); err != nil {
-			fmt.Printf("⚠ Failed creating policy '%s'.\n%s\n", PolicyShortLabel(payload), err.Error())
-			continue
+		if item.planned(label, sourceID, ActionCreate, payload, warnings) {
+			return nil
+		}
+
+		item.println("Creating policy:", PolicyShortLabel(payload))
+		id, err := CreatePolicyConfiguration(targetOrgURL, targetProject, resourceGUID, payload)
+		if err != nil {
+			item.printf("⚠ Failed creating policy '%s'.\n%s\n", PolicyShortLabel(payload), err.Error())
+			item.done(label, sourceID, ActionCreate, "", warnings, err)
+			return nil
 		}
+		item.idx.addPolicy(targetOrgURL, targetProject, PolicyConfig{Id: id, Raw: payload})
+		item.done(label, sourceID, ActionCreate, strconv.Itoa(id), warnings, nil)
+		return nil
+	})
+	if err != nil {
+		return run.results, err
 	}
 
-	fmt.Println("✔ Branch policies restore finished")
-	return nil
+	run.println("✔ Branch policies restore finished")
+	return run.results, nil
 }
 
 // ---------- helpers ----------
@@ -381,11 +388,13 @@ func intFromAny(v any) int {
 }
 
 // If user passed --repos all => no filter (empty map)
+// Otherwise --repos is a selector (see Selector) over the project's repos.
 func resolveRepoIDsForFilter(orgURL, project string, selectedRepos []string) (map[string]bool, error) {
-	if len(selectedRepos) == 0 {
-		return map[string]bool{}, nil
+	sel, err := ParseSelector(selectedRepos)
+	if err != nil {
+		return nil, err
 	}
-	if len(selectedRepos) == 1 && strings.EqualFold(selectedRepos[0], "all") {
+	if sel.All() {
 		return map[string]bool{}, nil
 	}
 
@@ -394,14 +403,9 @@ func resolveRepoIDsForFilter(orgURL, project string, selectedRepos []string) (ma
 		return nil, err
 	}
 
-	want := map[string]bool{}
-	for _, r := range selectedRepos {
-		want[strings.ToLower(r)] = true
-	}
-
 	out := map[string]bool{}
 	for _, r := range all {
-		if want[strings.ToLower(r.Name)] {
+		if sel.Match(r.Name) {
 			out[strings.ToLower(r.Id)] = true
 		}
 	}
@@ -413,13 +417,14 @@ func resolveRepoIDsForFilter(orgURL, project string, selectedRepos []string) (ma
 }
 
 func GetIdentityById(orgURL, identityId, resourceGUID string) (*IdentityHint, error) {
-	org := strings.TrimRight(orgURL, "/")
-	org = strings.TrimPrefix(org, "https://dev.azure.com/")
-	org = strings.TrimPrefix(org, "http://dev.azure.com/")
+	vssps, err := identityBase(orgURL)
+	if err != nil {
+		return nil, err
+	}
 
-	uri := fmt.Sprintf("https://vssps.dev.azure.com/%s/_apis/identities?identityIds=%s&api-version=7.1-preview.1", org, identityId)
+	uri := fmt.Sprintf("%s/_apis/identities?identityIds=%s&api-version=7.1-preview.1", vssps, identityId)
 
-	out, err := azRest("get", uri, resourceGUID)
+	out, err := adoGet(orgURL, resourceGUID, uri)
 	if err != nil {
 		return nil, err
 	}
@@ -438,149 +443,19 @@ func GetIdentityById(orgURL, identityId, resourceGUID string) (*IdentityHint, er
 	if obj == nil {
 		return nil, fmt.Errorf("invalid identity payload")
 	}
-
-	h := &IdentityHint{}
-
-	// displayName fallbacks
-	if s, ok := obj["displayName"].(string); ok && strings.TrimSpace(s) != "" {
-		h.DisplayName = s
-	}
-	if h.DisplayName == "" {
-		if s, ok := obj["providerDisplayName"].(string); ok && strings.TrimSpace(s) != "" {
-			h.DisplayName = s
-		}
-	}
-	if h.DisplayName == "" {
-		if s, ok := obj["customDisplayName"].(string); ok && strings.TrimSpace(s) != "" {
-			h.DisplayName = s
-		}
-	}
-
-	// uniqueName fallbacks
-	pick := func(x any) string {
-		s, _ := x.(string)
-		return strings.TrimSpace(s)
-	}
-
-	if s := pick(obj["uniqueName"]); s != "" {
-		h.UniqueName = s
-	}
-	if h.UniqueName == "" {
-		if s := pick(obj["signInAddress"]); s != "" {
-			h.UniqueName = s
-		}
-	}
-	if h.UniqueName == "" {
-		if s := pick(obj["mailAddress"]); s != "" {
-			h.UniqueName = s
-		}
-	}
-
-	// IMPORTANT: properties fallback (this is usually where Account/UPN lives)
-	if h.UniqueName == "" {
-		if props, ok := obj["properties"].(map[string]any); ok && props != nil {
-			// many identity APIs encode values as { "$value": "..." }
-			getProp := func(key string) string {
-				v := props[key]
-				if m, ok := v.(map[string]any); ok && m != nil {
-					if s := pick(m["$value"]); s != "" {
-						return s
-					}
-				}
-				return pick(v)
-			}
-
-			// try common keys
-			for _, k := range []string{"Account", "Mail", "SignInAddress", "Email"} {
-				if s := getProp(k); s != "" {
-					h.UniqueName = s
-					break
-				}
-			}
-		}
-	}
+	h := identityHintFrom(obj)
 
 	// If both are empty, we can't map later — treat as error for backup hints.
 	if h.UniqueName == "" && h.DisplayName == "" {
 		return nil, fmt.Errorf("identity %s has no uniqueName/displayName in response", identityId)
 	}
 
-	return h, nil
-}
-
-func FindTargetIdentityIdByHint(targetOrgURL string, hint IdentityHint, resourceGUID string) (string, error) {
-	// Best effort search by uniqueName first, fallback to displayName.
-	// Identity search:
-	// GET https://vssps.dev.azure.com/{org}/_apis/identities?searchFilter=General&filterValue=<value>&api-version=7.1-preview.1
-
-	org := strings.TrimRight(targetOrgURL, "/")
-	org = strings.TrimPrefix(org, "https://dev.azure.com/")
-	org = strings.TrimPrefix(org, "http://dev.azure.com/")
-
-	query := hint.UniqueName
-	if strings.TrimSpace(query) == "" {
-		query = hint.DisplayName
-	}
-	if strings.TrimSpace(query) == "" {
-		return "", fmt.Errorf("empty identity hint")
-	}
-
-	uri := fmt.Sprintf("https://vssps.dev.azure.com/%s/_apis/identities?searchFilter=General&filterValue=%s&api-version=7.1-preview.1",
-		org, url.QueryEscape(query))
-
-	out, err := azRest("get", uri, resourceGUID)
-	if err != nil {
-		return "", err
-	}
-
-	var resp map[string]any
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return "", err
-	}
-
-	val, _ := resp["value"].([]any)
-	if len(val) == 0 {
-		return "", fmt.Errorf("no target identity match for '%s'", query)
-	}
-
-	// pick best match: exact uniqueName if possible
-	bestId := ""
-	for _, v := range val {
-		m, _ := v.(map[string]any)
-		if m == nil {
-			continue
-		}
-		id, _ := m["id"].(string)
-		uniq, _ := m["uniqueName"].(string)
-		disp, _ := m["displayName"].(string)
-
-		if hint.UniqueName != "" && strings.EqualFold(uniq, hint.UniqueName) {
-			return id, nil
-		}
-		// fallback to exact displayName
-		if bestId == "" && hint.DisplayName != "" && strings.EqualFold(disp, hint.DisplayName) {
-			bestId = id
-		}
-	}
-
-	if bestId != "" {
-		return bestId, nil
-	}
-
-	// otherwise first result
-	first, _ := val[0].(map[string]any)
-	if first != nil {
-		if id, ok := first["id"].(string); ok && id != "" {
-			return id, nil
-		}
-	}
-
-	return "", fmt.Errorf("could not extract target identity id from search results")
+	return &h, nil
 }
 
 func GetBuildDefinitionName(orgURL, project string, id int, resourceGUID string) (string, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/build/definitions/%d?api-version=7.1", strings.TrimRight(orgURL, "/"), project, id)
-	out, err := azRest("get", uri, resourceGUID)
+	out, err := adoGet(orgURL, resourceGUID, uri)
 	if err != nil {
 		return "", err
 	}
@@ -601,45 +476,3 @@ func BuildTargetReposByName(repos []Repo) map[string]string {
 	}
 	return m
 }
-
-func ResolveIdentityIDByUPN(targetOrgURL, upn string) (string, error) {
-	// targetOrgURL is https://dev.azure.com/{orgName}
-	// vssps base: https://vssps.dev.azure.com/{orgName}
-	orgName, err := ExtractOrgName(targetOrgURL)
-	if err != nil {
-		return "", err
-	}
-
-	uri := fmt.Sprintf("https://vssps.dev.azure.com/%s/_apis/identities?searchFilter=General&filterValue=%s&queryMembership=None&api-version=7.1-preview.1",
-		orgName,
-		url.QueryEscape(upn),
-	)
-
-	out, err := azRest("get", uri, "")
-	if err != nil {
-		return "", err
-	}
-
-	var resp struct {
-		Value []struct {
-			ID         string `json:"id"`
-			UniqueName string `json:"uniqueName"`
-		} `json:"value"`
-	}
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return "", err
-	}
-
-	// pick exact match on uniqueName, else first
-	low := strings.ToLower(upn)
-	for _, v := range resp.Value {
-		if strings.ToLower(v.UniqueName) == low && v.ID != "" {
-			return v.ID, nil
-		}
-	}
-	if len(resp.Value) > 0 && resp.Value[0].ID != "" {
-		return resp.Value[0].ID, nil
-	}
-
-	return "", fmt.Errorf("identity not found for '%s'", upn)
-}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 )
 
@@ -219,7 +220,11 @@ func ExtractBuildDefinitionId(raw map[string]any) (int, bool) {
 	return 0, false
 }
 
-func RemapPolicyIdentityIDs(payload map[string]any, targetOrgURL, resourceGUID string) error {
+// RemapPolicyIdentityIDs replaces the source reviewer ids in a policy with
+// their target ids, resolved through ids. Every reviewer must resolve to
+// exactly one target identity; otherwise the policy is not restored rather
+// than restored with a reviewer missing or wrong.
+func RemapPolicyIdentityIDs(payload map[string]any, ids *IdentityResolver, targetOrgURL, resourceGUID string) error {
 
 	settings, _ := payload["settings"].(map[string]any)
 	if settings == nil {
@@ -235,10 +240,6 @@ func RemapPolicyIdentityIDs(payload map[string]any, targetOrgURL, resourceGUID s
 	}
 
 	hints := readPolicyHints(payload)
-	if len(hints.Identities) == 0 {
-		return nil
-	}
-
 	if needs && len(hints.Identities) == 0 {
 		return fmt.Errorf("policy has required reviewers but backup has no identity hints; skipping to avoid TF402457")
 	}
@@ -247,15 +248,25 @@ func RemapPolicyIdentityIDs(payload map[string]any, targetOrgURL, resourceGUID s
 	}
 
 	idMap := map[string]string{}
+	var failed []string
 	for srcId, hint := range hints.Identities {
-		tid, err := FindTargetIdentityIdByHint(targetOrgURL, hint, resourceGUID)
-		if err != nil || strings.TrimSpace(tid) == "" {
+		tid, err := ids.Resolve(targetOrgURL, resourceGUID, hint)
+		if err != nil {
+			failed = append(failed, err.Error())
 			continue
 		}
 		idMap[srcId] = tid
 	}
-	if len(idMap) == 0 {
-		return fmt.Errorf("no identities could be mapped (check that users/groups exist in target org)")
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+
+	mapID := func(src string) (string, error) {
+		if mapped := idMap[src]; mapped != "" {
+			return mapped, nil
+		}
+		return "", fmt.Errorf("reviewer id=%s has no identity hint in the backup", src)
 	}
 
 	if arr, ok := settings["requiredReviewerIds"].([]any); ok {
@@ -266,17 +277,12 @@ func RemapPolicyIdentityIDs(payload map[string]any, targetOrgURL, resourceGUID s
 			if src == "" {
 				continue
 			}
-			mapped := idMap[src]
-			if mapped == "" {
-				continue
+			mapped, err := mapID(src)
+			if err != nil {
+				return err
 			}
 			newArr = append(newArr, mapped)
 		}
-
-		if len(arr) > 0 && len(newArr) == 0 {
-			return fmt.Errorf("all requiredReviewerIds could not be mapped; skipping policy")
-		}
-
 		settings["requiredReviewerIds"] = newArr
 	}
 
@@ -292,25 +298,20 @@ func RemapPolicyIdentityIDs(payload map[string]any, targetOrgURL, resourceGUID s
 			if src == "" {
 				continue
 			}
-			mapped := idMap[src]
-			if mapped == "" {
-				continue
+			mapped, err := mapID(src)
+			if err != nil {
+				return err
 			}
 			m["id"] = mapped
 			newArr = append(newArr, m)
 		}
-
-		if len(arr) > 0 && len(newArr) == 0 {
-			delete(settings, "requiredReviewers")
-		} else {
-			settings["requiredReviewers"] = newArr
-		}
+		settings["requiredReviewers"] = newArr
 	}
 
 	return nil
 }
 
-func RemapBuildValidationDefinition(payload map[string]any, targetOrgURL, targetProject, resourceGUID string) error {
+func RemapBuildValidationDefinition(payload map[string]any, names *Renamer, targetOrgURL, targetProject, resourceGUID string) error {
 	hints := readPolicyHints(payload)
 	if len(hints.BuildDefinitions) == 0 {
 		return nil
@@ -336,7 +337,14 @@ func RemapBuildValidationDefinition(payload map[string]any, targetOrgURL, target
 		return fmt.Errorf("no build definition hint for id=%s", srcIdStr)
 	}
 
-	targetId, err := FindBuildDefinitionIdByName(targetOrgURL, targetProject, srcName, resourceGUID)
+	// the definition may have been renamed on restore
+	var targetId int
+	var err error
+	for _, name := range names.Definitions(srcName) {
+		if targetId, err = FindBuildDefinitionIdByName(targetOrgURL, targetProject, name, resourceGUID); err == nil {
+			break
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -350,7 +358,7 @@ func FindBuildDefinitionIdByName(orgURL, project, name, resourceGUID string) (in
 	uri := fmt.Sprintf("%s/%s/_apis/build/definitions?name=%s&api-version=7.1",
 		strings.TrimRight(orgURL, "/"), project, url.QueryEscape(name))
 
-	out, err := azRest("get", uri, resourceGUID)
+	out, err := adoGet(orgURL, resourceGUID, uri)
 	if err != nil {
 		return 0, err
 	}
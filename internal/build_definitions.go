@@ -3,16 +3,14 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
-)
 
-type BuildDefinitionListResponse struct {
-	Count int               `json:"count"`
-	Value []BuildDefinition `json:"value"`
-}
+	"azdo-vault/internal/adoclient"
+)
 
 type BuildDefinition struct {
 	Id       int            `json:"id,omitempty"`
@@ -27,11 +25,6 @@ type TaskAgentQueue struct {
 	Name string `json:"name"`
 }
 
-type TaskAgentQueueListResponse struct {
-	Count int              `json:"count"`
-	Value []TaskAgentQueue `json:"value"`
-}
-
 func (b *BuildDefinition) UnmarshalJSON(data []byte) error {
 	var m map[string]any
 	if err := json.Unmarshal(data, &m); err != nil {
@@ -68,40 +61,19 @@ func (b BuildDefinition) MarshalJSON() ([]byte, error) {
 func ListBuildDefinitions(orgURL, project, resourceGUID string) ([]BuildDefinition, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/build/definitions?api-version=7.1", orgURL, project)
 
-	cmd := exec.Command("az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	list, err := adoList[BuildDefinition](orgURL, resourceGUID, uri, adoclient.Paging{})
 	if err != nil {
-		return nil, fmt.Errorf("az rest list build definitions failed: %w\n%s", err, string(out))
-	}
-
-	var resp BuildDefinitionListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing JSON: %w\nRaw:\n%s", err, string(out))
+		return nil, fmt.Errorf("list build definitions failed: %w", err)
 	}
-	return resp.Value, nil
+	return list, nil
 }
 
 func GetBuildDefinition(orgURL, project, resourceGUID string, id int) (*BuildDefinition, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/build/definitions/%d?api-version=7.1", orgURL, project, id)
 
-	cmd := exec.Command("az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoGet(orgURL, resourceGUID, uri)
 	if err != nil {
-		return nil, fmt.Errorf("az rest get build definition failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("get build definition failed: %w", err)
 	}
 
 	var def BuildDefinition
@@ -154,19 +126,9 @@ func CreateBuildDefinition(orgURL, project, resourceGUID string, def BuildDefini
 	// 	fmt.Println("DEBUG: ADO_DUMP_BUILDDEF_BODY is enabled, writing file for:", def.Name)
 	// }
 
-	cmd := exec.Command("az", "rest",
-		"--method", "post",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--headers", "Content-Type=application/json",
-		"--body", string(bodyBytes),
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoSend(orgURL, resourceGUID, "post", uri, bodyBytes)
 	if err != nil {
-		return 0, fmt.Errorf("az rest create build definition failed: %w\n%s", err, string(out))
+		return 0, fmt.Errorf("create build definition failed: %w", err)
 	}
 
 	var created map[string]any
@@ -179,6 +141,21 @@ func CreateBuildDefinition(orgURL, project, resourceGUID string, def BuildDefini
 	return 0, fmt.Errorf("created build definition but no id returned. Raw:\n%s", string(out))
 }
 
+// UpdateBuildDefinition replaces definition id with payload (already
+// sanitized and remapped). revision must be the definition's current
+// revision in the target, otherwise the server rejects the update.
+func UpdateBuildDefinition(orgURL, project, resourceGUID string, id, revision int, payload map[string]any) error {
+	body := deepCopyMap(payload)
+	body["id"] = id
+	body["revision"] = revision
+
+	uri := fmt.Sprintf("%s/%s/_apis/build/definitions/%d?api-version=7.1", orgURL, project, id)
+	if _, err := adoSend(orgURL, resourceGUID, "put", uri, body); err != nil {
+		return fmt.Errorf("update build definition failed: %w", err)
+	}
+	return nil
+}
+
 func FindBuildDefinitionByName(orgURL, project, resourceGUID, name string) (*BuildDefinition, error) {
 	list, err := ListBuildDefinitions(orgURL, project, resourceGUID)
 	if err != nil {
@@ -228,7 +205,8 @@ func extractBuildRepoNameAndID(def map[string]any) (repoName, repoID string) {
 
 func remapBuildDefinitionRepo(
 	def map[string]any,
-	sourceOrgURL, sourceProject string,
+	refs *RefIndex,
+	names *Renamer,
 	targetRepoIDByName map[string]string,
 ) (string, string, error) {
 
@@ -236,16 +214,16 @@ func remapBuildDefinitionRepo(
 
 	// If the exported def doesn't contain repo name, resolve from source repo id
 	if repoName == "" && repoID != "" {
-		n, err := GetRepoNameByID(sourceOrgURL, sourceProject, repoID)
-		if err != nil {
-			return "", "", fmt.Errorf("repo name not found (id='%s'): %w", repoID, err)
+		repoName = refs.repoName(repoID)
+		if repoName == "" {
+			return "", "", fmt.Errorf("repo name not found (id='%s') in source reference index", repoID)
 		}
-		repoName = n
 	}
 
 	if repoName == "" {
 		return "", "", fmt.Errorf("repo name is empty (repoID='%s')", repoID)
 	}
+	repoName = names.Name(KindRepos, repoName)
 
 	//targetID := targetRepoIDByName[repoName]
 	targetID := targetRepoIDByName[strings.ToLower(repoName)]
@@ -268,24 +246,11 @@ func remapBuildDefinitionRepo(
 func ListTaskAgentQueues(orgURL, project, resourceGUID string) ([]TaskAgentQueue, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/distributedtask/queues?api-version=7.1", orgURL, project)
 
-	cmd := exec.Command("az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	list, err := adoList[TaskAgentQueue](orgURL, resourceGUID, uri, adoclient.Paging{})
 	if err != nil {
-		return nil, fmt.Errorf("az rest list queues failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("list queues failed: %w", err)
 	}
-
-	var resp TaskAgentQueueListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing queues JSON: %w\nRaw:\n%s", err, string(out))
-	}
-	return resp.Value, nil
+	return list, nil
 }
 
 func parseKeyValuePairs(pairs []string) (map[string]string, error) {
@@ -333,27 +298,36 @@ func setBuildQueueID(def map[string]any, id int) {
 // Backup / Restore
 // -----------------------------
 
-func BackupBuildDefinitions(orgURL, project, backupPath string, selected []string, resourceGUID string) error {
+func BackupBuildDefinitions(orgURL, project, backupPath string, selected []string, resourceGUID string, exec *ExecOptions) error {
 	list, err := ListBuildDefinitions(orgURL, project, resourceGUID)
 	if err != nil {
 		return err
 	}
 
-	backupAll := len(selected) == 1 && selected[0] == "all"
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return err
+	}
 	if len(list) == 0 {
 		fmt.Println("No build definitions found")
 		return nil
 	}
+	fmt.Printf("Found %d build definitions\n", len(list))
 
 	if err := os.MkdirAll(backupPath, 0755); err != nil {
 		return err
 	}
 
+	var items []BuildDefinition
 	for _, d := range list {
-		if !backupAll && !contains(selected, d.Name) {
+		if !sel.MatchIn(d.Path, d.Name) {
 			continue
 		}
+		items = append(items, d)
+	}
 
+	err = forEach(len(items), exec.workers(KindBuildDefinitions), exec.failFast(true), func(i int, out io.Writer) error {
+		d := items[i]
 		full, err := GetBuildDefinition(orgURL, project, resourceGUID, d.Id)
 		if err != nil {
 			return err
@@ -364,11 +338,15 @@ func BackupBuildDefinitions(orgURL, project, backupPath string, selected []strin
 		if err != nil {
 			return err
 		}
-		if err := os.WriteFile(fp, data, 0644); err != nil {
+		if err := writeBackupFile(fp, data); err != nil {
 			return err
 		}
 
-		fmt.Println("✔ Backed up build definition:", full.Name)
+		fmt.Fprintln(out, "✔ Backed up build definition:", full.Name)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	return nil
 }
@@ -380,17 +358,30 @@ func RestoreBuildDefinitionsFromBackup(
 	resourceGUID string,
 	queueMapPairs []string,
 	defaultQueue string,
-) error {
+	opts *RestoreOptions,
+) ([]RestoreResult, error) {
+	run := newRestoreRun(KindBuildDefinitions, opts)
 
 	files, err := os.ReadDir(backupPath)
 	if err != nil {
-		return err
+		return run.results, err
+	}
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return run.results, err
+	}
+
+	refs, err := sourceRefs(backupPath, sourceOrgURL, sourceProject, resourceGUID)
+	if err != nil {
+		return run.results, err
+	}
+	if err := run.rewriteFrom(refs, targetOrgURL, targetProject, resourceGUID); err != nil {
+		return run.results, err
 	}
-	restoreAll := len(selected) == 1 && selected[0] == "all"
 
-	targetRepos, err := ListRepos(targetOrgURL, targetProject)
+	targetRepos, err := run.idx.repos(targetOrgURL, targetProject)
 	if err != nil {
-		return fmt.Errorf("failed to list target repos: %w", err)
+		return run.results, fmt.Errorf("failed to list target repos: %w", err)
 	}
 	targetRepoIDByName := map[string]string{}
 	for _, r := range targetRepos {
@@ -398,9 +389,9 @@ func RestoreBuildDefinitionsFromBackup(
 		targetRepoIDByName[strings.ToLower(r.Name)] = r.Id
 	}
 
-	targetQueues, err := ListTaskAgentQueues(targetOrgURL, targetProject, resourceGUID)
+	targetQueues, err := run.idx.queues(targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 	targetQueueIDByName := map[string]int{}
 	for _, q := range targetQueues {
@@ -409,38 +400,43 @@ func RestoreBuildDefinitionsFromBackup(
 
 	queueMap, err := parseKeyValuePairs(queueMapPairs)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 	if defaultQueue != "" {
 		if _, ok := targetQueueIDByName[defaultQueue]; !ok {
-			return fmt.Errorf("default-queue '%s' not found in target project queues", defaultQueue)
+			return run.results, fmt.Errorf("default-queue '%s' not found in target project queues", defaultQueue)
 		}
 	}
 
-	srcEPIDToName, tgtEPNameToID, err := BuildEndpointMaps(sourceOrgURL, sourceProject, targetOrgURL, targetProject, resourceGUID)
+	srcEPIDToName, tgtEPNameToID, err := BuildEndpointMaps(run.idx, run.names, refs, targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return fmt.Errorf("failed to build service connection maps: %w", err)
+		return run.results, fmt.Errorf("failed to build service connection maps: %w", err)
 	}
-	srcVGIDToName, tgtVGNameToID, err := BuildVarGroupMaps(sourceOrgURL, sourceProject, targetOrgURL, targetProject)
+	srcVGIDToName, tgtVGNameToID, err := BuildVarGroupMaps(run.idx, run.names, refs, targetOrgURL, targetProject)
 	if err != nil {
-		return fmt.Errorf("failed to build variable group maps: %w", err)
+		return run.results, fmt.Errorf("failed to build variable group maps: %w", err)
 	}
-	srcTGIDToName, tgtTGNameToID, err := BuildTaskGroupMaps(sourceOrgURL, sourceProject, targetOrgURL, targetProject, resourceGUID)
+	srcTGIDToName, tgtTGNameToID, err := BuildTaskGroupMaps(run.idx, run.names, refs, targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return fmt.Errorf("failed to build task group maps: %w", err)
+		return run.results, fmt.Errorf("failed to build task group maps: %w", err)
 	}
 
+	var items []os.DirEntry
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name(), ".json") {
 			continue
 		}
 		name := strings.TrimSuffix(f.Name(), ".json")
-		if !restoreAll && !contains(selected, name) {
+		if !sel.matchFile(filepath.Join(backupPath, f.Name()), name) {
 			continue
 		}
+		items = append(items, f)
+	}
 
+	err = run.each(len(items), func(i int, item *restoreRun) error {
+		f := items[i]
 		fp := filepath.Join(backupPath, f.Name())
-		b, err := os.ReadFile(fp)
+		b, err := readBackupFile(fp)
 		if err != nil {
 			return err
 		}
@@ -450,24 +446,56 @@ func RestoreBuildDefinitionsFromBackup(
 			return err
 		}
 
-		existing, err := FindBuildDefinitionByName(targetOrgURL, targetProject, resourceGUID, def.Name)
+		sourceID := strconv.Itoa(def.Id)
+		if def.Raw == nil {
+			tmp, _ := json.Marshal(def)
+			_ = json.Unmarshal(tmp, &def.Raw)
+		}
+		sourceName := def.Name
+		setName := func(name string) {
+			def.Name = name
+			def.Raw["name"] = name
+		}
+		setName(item.rename(sourceName))
+		if path := item.names.Folder(def.Path); path != def.Path {
+			item.printf("Moving build definition '%s' from folder '%s' to '%s'\n", def.Name, def.Path, path)
+			def.Path = path
+			def.Raw["path"] = path
+		}
+
+		existing, err := item.idx.buildDefinition(targetOrgURL, targetProject, resourceGUID, def.Name)
 		if err != nil {
 			return err
 		}
 		if existing != nil {
-			fmt.Println("✔ Build definition exists, skipping:", def.Name)
-			continue
+			alt, ok, err := item.collide(sourceName, def.Name, func(n string) (bool, error) {
+				d, err := item.idx.buildDefinition(targetOrgURL, targetProject, resourceGUID, n)
+				return d != nil, err
+			})
+			if err != nil {
+				return err
+			}
+			if ok {
+				setName(alt)
+				existing = nil
+			}
 		}
-
-		if def.Raw == nil {
-			tmp, _ := json.Marshal(def)
-			_ = json.Unmarshal(tmp, &def.Raw)
+		if existing != nil && !opts.updates(KindBuildDefinitions) {
+			item.println("✔ Build definition exists, skipping:", def.Name)
+			item.exists(def.Name, sourceID, strconv.Itoa(existing.Id))
+			return nil
+		}
+		if existing == nil && !opts.creates(KindBuildDefinitions) {
+			item.println("Build definition not in target, skipping:", def.Name)
+			item.missing(def.Name, sourceID)
+			return nil
 		}
 
-		repoName, _, err := remapBuildDefinitionRepo(def.Raw, sourceOrgURL, sourceProject, targetRepoIDByName)
+		repoName, _, err := remapBuildDefinitionRepo(def.Raw, refs, item.names, targetRepoIDByName)
 		if err != nil {
-			fmt.Printf("⚠ Skipping build definition '%s': repo remap failed: %s\n", def.Name, err.Error())
-			continue
+			item.printf("⚠ Skipping build definition '%s': repo remap failed: %s\n", def.Name, err.Error())
+			item.unresolvable(def.Name, sourceID, "repo remap failed: "+err.Error())
+			return nil
 		}
 
 		srcQueueName := extractBuildQueueName(def.Raw)
@@ -492,9 +520,10 @@ func RestoreBuildDefinitionsFromBackup(
 			targetQueueName = defaultQueue
 		}
 		if targetQueueID == 0 {
-			fmt.Printf("⚠ Skipping '%s': queue not resolved (source='%s'). Provide --queue-map or --default-queue.\n",
+			item.printf("⚠ Skipping '%s': queue not resolved (source='%s'). Provide --queue-map or --default-queue.\n",
 				def.Name, srcQueueName)
-			continue
+			item.unresolvable(def.Name, sourceID, fmt.Sprintf("queue not resolved (source='%s')", srcQueueName))
+			return nil
 		}
 		setBuildQueueID(def.Raw, targetQueueID)
 
@@ -508,15 +537,46 @@ func RestoreBuildDefinitionsFromBackup(
 			tgtTGNameToID,
 		)
 
-		fmt.Printf("Creating build definition: %s (repo='%s', queue: '%s' -> '%s')\n",
+		sanitizeBuildDefinitionForCreate(def.Raw)
+		item.rewrite(def.Raw)
+		planned := deepCopyMap(def.Raw)
+
+		if existing != nil {
+			if item.planned(def.Name, sourceID, ActionUpdate, planned, nil) {
+				return nil
+			}
+			item.printf("Updating build definition: %s (revision %d, repo='%s', queue: '%s' -> '%s')\n",
+				def.Name, existing.Revision, repoName, srcQueueName, targetQueueName)
+			err := UpdateBuildDefinition(targetOrgURL, targetProject, resourceGUID, existing.Id, existing.Revision, planned)
+			if err != nil {
+				item.printf("⚠ Failed updating '%s'.\n%s\n", def.Name, err.Error())
+			}
+			item.done(def.Name, sourceID, ActionUpdate, strconv.Itoa(existing.Id), nil, err)
+			return nil
+		}
+
+		if item.planned(def.Name, sourceID, ActionCreate, planned, nil) {
+			return nil
+		}
+
+		item.printf("Creating build definition: %s (repo='%s', queue: '%s' -> '%s')\n",
 			def.Name, repoName, srcQueueName, targetQueueName)
 
-		if _, err := CreateBuildDefinition(targetOrgURL, targetProject, resourceGUID, def); err != nil {
-			fmt.Printf("⚠ Failed creating '%s'.\n%s\n", def.Name, err.Error())
-			continue
+		id, err := CreateBuildDefinition(targetOrgURL, targetProject, resourceGUID, def)
+		if err != nil {
+			item.printf("⚠ Failed creating '%s'.\n%s\n", def.Name, err.Error())
+			item.done(def.Name, sourceID, ActionCreate, "", nil, err)
+			return nil
 		}
+		item.idx.addBuildDefinition(targetOrgURL, targetProject, BuildDefinition{Id: id, Name: def.Name, Path: def.Path})
+		item.done(def.Name, sourceID, ActionCreate, strconv.Itoa(id), nil, nil)
+		item.created(sourceName, def.Name)
+		return nil
+	})
+	if err != nil {
+		return run.results, err
 	}
 
-	fmt.Println("✔ Build definitions restore finished (check warnings above)")
-	return nil
+	run.println("✔ Build definitions restore finished (check warnings above)")
+	return run.results, nil
 }
@@ -5,26 +5,25 @@ import (
 	"strings"
 )
 
-// BuildEndpointMaps uses your existing service_connections.go implementation.
+// BuildEndpointMaps returns source endpoint id -> name (from the backup's
+// reference index, under their target names) and target endpoint name -> id (from idx).
 func BuildEndpointMaps(
-	sourceOrgURL, sourceProject,
+	idx *ResourceIndex,
+	names *Renamer,
+	refs *RefIndex,
 	targetOrgURL, targetProject,
 	resourceGUID string,
 ) (map[string]string, map[string]string, error) {
 
-	src, err := ListServiceConnections(sourceOrgURL, sourceProject, resourceGUID)
-	if err != nil {
-		return nil, nil, fmt.Errorf("list source service connections failed: %w", err)
-	}
-	tgt, err := ListServiceConnections(targetOrgURL, targetProject, resourceGUID)
+	tgt, err := idx.serviceConnections(targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
 		return nil, nil, fmt.Errorf("list target service connections failed: %w", err)
 	}
 
 	srcIDToName := map[string]string{}
-	for _, e := range src {
-		if e.Id != "" && e.Name != "" {
-			srcIDToName[strings.ToLower(e.Id)] = e.Name
+	for id, name := range refs.ServiceEndpoints {
+		if id != "" && name != "" {
+			srcIDToName[strings.ToLower(id)] = names.Name(KindServiceConnections, name)
 		}
 	}
 
@@ -39,24 +38,20 @@ func BuildEndpointMaps(
 }
 
 func BuildVarGroupMaps(
-	sourceOrgURL, sourceProject,
+	idx *ResourceIndex,
+	names *Renamer,
+	refs *RefIndex,
 	targetOrgURL, targetProject string,
 ) (map[int]string, map[string]int, error) {
 
-	src, err := ListVariableGroups(sourceOrgURL, sourceProject)
-	if err != nil {
-		return nil, nil, err
-	}
-	tgt, err := ListVariableGroups(targetOrgURL, targetProject)
+	tgt, err := idx.variableGroups(targetOrgURL, targetProject)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	srcIDToName := map[int]string{}
-	for _, g := range src {
-		if g.Id != 0 && g.Name != "" {
-			srcIDToName[g.Id] = g.Name
-		}
+	srcIDToName := refs.variableGroupNames()
+	for id, name := range srcIDToName {
+		srcIDToName[id] = names.Name(KindVariableGroups, name)
 	}
 
 	tgtNameToID := map[string]int{}
@@ -70,24 +65,22 @@ func BuildVarGroupMaps(
 }
 
 func BuildTaskGroupMaps(
-	sourceOrgURL, sourceProject,
+	idx *ResourceIndex,
+	names *Renamer,
+	refs *RefIndex,
 	targetOrgURL, targetProject,
 	resourceGUID string,
 ) (map[string]string, map[string]string, error) {
 
-	src, err := ListTaskGroups(sourceOrgURL, sourceProject, resourceGUID)
-	if err != nil {
-		return nil, nil, err
-	}
-	tgt, err := ListTaskGroups(targetOrgURL, targetProject, resourceGUID)
+	tgt, err := idx.taskGroups(targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	srcIDToName := map[string]string{}
-	for _, tg := range src {
-		if tg.Id != "" && tg.Name != "" {
-			srcIDToName[strings.ToLower(tg.Id)] = tg.Name
+	for id, name := range refs.TaskGroups {
+		if id != "" && name != "" {
+			srcIDToName[strings.ToLower(id)] = names.Name(KindTaskGroups, name)
 		}
 	}
 
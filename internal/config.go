@@ -5,83 +5,289 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+
+	"azdo-vault/internal/adoclient"
 )
 
 type OrganizationConfig struct {
-	URL        string `json:"url"`
-	BackupRoot string `json:"backupRoot"`
+	URL        string       `json:"url"`
+	BackupRoot string       `json:"backupRoot"`
+	Auth       *AuthConfig  `json:"auth,omitempty"`
+	Retry      *RetryConfig `json:"retry,omitempty"`
+
+	// Parallelism is how many items (repos, definitions, ...) commands on
+	// this org process at once unless --parallelism is given; 0 or 1 means one at a time.
+	Parallelism int `json:"parallelism,omitempty"`
+
+	// Hosts overrides the service base URLs derived from URL. Azure DevOps
+	// Server collections (https://tfs.corp/tfs/DefaultCollection) serve every
+	// service below URL and rarely need this.
+	Hosts *adoclient.Hosts `json:"hosts,omitempty"`
+
+	// APIProfile is the REST API level of the server: a name from
+	// adoclient.APIProfiles (cloud, server-2022, ...) or a version like "6.0".
+	// Empty means cloud.
+	APIProfile string `json:"apiProfile,omitempty"`
+
+	// ResourceGUID is the AAD resource tokens are requested for when a
+	// command has no --ado-resource-guid; empty means Azure DevOps itself.
+	ResourceGUID string `json:"resourceGuid,omitempty"`
+
+	// Proxy is the HTTP(S) proxy URL for REST calls to this org; empty uses
+	// HTTPS_PROXY / HTTP_PROXY from the environment.
+	Proxy string `json:"proxy,omitempty"`
+
+	// Retention is the snapshot retention policy prune applies to the
+	// backups of this org unless its --keep-* flags are given.
+	Retention *RetentionConfig `json:"retention,omitempty"`
+
+	// SigningKeyFile is an ed25519 private key (PKCS #8 PEM) that signs the
+	// manifest of every backup snapshot of this org; empty leaves them unsigned.
+	SigningKeyFile string `json:"signingKeyFile,omitempty"`
+
+	// Encryption encrypts the backups of this org at rest; nil writes them
+	// in plain text.
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
+}
+
+// EncryptionConfig holds the key that backups are encrypted with: a key file
+// or a passphrase. Like AuthConfig, it names where the secret is, never the
+// secret itself.
+type EncryptionConfig struct {
+	KeyFile       string `json:"keyFile,omitempty"`       // 32 random bytes, raw or base64 (openssl rand -base64 32)
+	PassphraseEnv string `json:"passphraseEnv,omitempty"` // env var holding a passphrase
+}
+
+// Auth methods supported in AuthConfig.Method.
+const (
+	AuthMethodPAT              = "pat"
+	AuthMethodAzureCLI         = "azcli"
+	AuthMethodServicePrincipal = "service-principal"
+	AuthMethodWorkloadIdentity = "workload-identity"
+	AuthMethodManagedIdentity  = "managed-identity"
+)
+
+// AuthConfig selects how requests to one organization are authenticated.
+// Secrets are never stored here; only the env var or file that holds them.
+// An org without auth config uses AZURE_DEVOPS_EXT_PAT if set, otherwise az login.
+type AuthConfig struct {
+	Method string `json:"method"`
+
+	// pat
+	PATEnv  string `json:"patEnv,omitempty"`
+	PATFile string `json:"patFile,omitempty"`
+
+	// service-principal / workload-identity / managed-identity
+	TenantID         string `json:"tenantId,omitempty"`
+	ClientID         string `json:"clientId,omitempty"`
+	ClientSecretEnv  string `json:"clientSecretEnv,omitempty"`
+	ClientSecretFile string `json:"clientSecretFile,omitempty"`
+	TokenFile        string `json:"federatedTokenFile,omitempty"`
 }
 
+// RetryConfig tunes how throttled or failed REST calls to one organization
+// are retried. Empty fields keep the defaults (5 attempts, 2s base delay, 2m cap).
+// Delays use Go duration syntax, e.g. "500ms" or "30s".
+type RetryConfig struct {
+	MaxAttempts        int    `json:"maxAttempts,omitempty"`
+	BaseDelay          string `json:"baseDelay,omitempty"`
+	MaxDelay           string `json:"maxDelay,omitempty"`
+	RetryNonIdempotent bool   `json:"retryNonIdempotent,omitempty"`
+}
+
+// CurrentConfigVersion is the schema version written by SaveConfig.
+// Version 1 (no "version" field) had no profiles; it loads unchanged.
+const CurrentConfigVersion = 2
+
+// Config is the content of ~/.azdo-vault/config.json. The top-level
+// organizations form the default profile; Profiles holds further named sets
+// (e.g. "ci", "staging"), selected with --profile or AZDO_VAULT_PROFILE.
+// Once a profile is selected, DefaultOrganization and Organizations are the
+// ones of that profile.
 type Config struct {
+	Version             int                           `json:"version"`
+	DefaultOrganization string                        `json:"defaultOrganization"`
+	Organizations       map[string]OrganizationConfig `json:"organizations"`
+	Profiles            map[string]*Profile           `json:"profiles,omitempty"`
+
+	profile string   // selected profile; "" is the default one
+	base    *Profile // top-level organizations while a profile is selected
+}
+
+// Profile is a named set of organizations.
+type Profile struct {
 	DefaultOrganization string                        `json:"defaultOrganization"`
 	Organizations       map[string]OrganizationConfig `json:"organizations"`
 }
 
+// activeProfile is the profile LoadConfig selects (see SetProfile).
+var activeProfile string
+
+// SetProfile selects the profile used by later LoadConfig calls.
+// An empty name falls back to AZDO_VAULT_PROFILE, then to the default profile.
+func SetProfile(name string) {
+	activeProfile = name
+}
+
+// configPath is ~/.azdo-vault/config.json unless AZDO_VAULT_CONFIG is set.
 func configPath() string {
+	if p := os.Getenv(EnvPrefix + "CONFIG"); p != "" {
+		return p
+	}
 	home, _ := os.UserHomeDir()
 	return filepath.Join(home, ".azdo-vault", "config.json")
 }
 
+// LoadConfig reads the config file and selects the active profile. Without a
+// config file it returns an empty config if the environment defines an
+// organization (see ApplyEnv), so CI runs need no file at all.
 func LoadConfig() (*Config, error) {
+	var cfg Config
+
 	data, err := os.ReadFile(configPath())
 	if err != nil {
+		if !os.IsNotExist(err) || !envDefinesOrganization() {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
 
+	if cfg.Version > CurrentConfigVersion {
+		return nil, fmt.Errorf("config version %d is newer than this azdo-vault supports (%d)", cfg.Version, CurrentConfigVersion)
+	}
+	cfg.init()
+	return &cfg, nil
+}
+
+// NewConfig returns an empty config with the active profile selected,
+// for the first `configure add`.
+func NewConfig() *Config {
 	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+	cfg.init()
+	return &cfg
+}
+
+func (c *Config) init() {
+	if c.Organizations == nil {
+		c.Organizations = make(map[string]OrganizationConfig)
 	}
 
-	return &cfg, nil
+	profile := activeProfile
+	if profile == "" {
+		profile = os.Getenv(EnvPrefix + "PROFILE")
+	}
+	c.useProfile(profile)
+}
+
+// useProfile swaps the organizations of a named profile into the top-level
+// fields. A profile that does not exist yet starts empty; `configure add`
+// creates it on save.
+func (c *Config) useProfile(name string) {
+	if name == "" || name == DefaultProfile {
+		return
+	}
+	p := c.Profiles[name]
+	if p == nil {
+		p = &Profile{}
+	}
+	c.base = &Profile{DefaultOrganization: c.DefaultOrganization, Organizations: c.Organizations}
+	c.profile = name
+	c.DefaultOrganization = p.DefaultOrganization
+	c.Organizations = p.Organizations
+	if c.Organizations == nil {
+		c.Organizations = make(map[string]OrganizationConfig)
+	}
+}
+
+// DefaultProfile names the top-level organizations of the config.
+const DefaultProfile = "default"
+
+// ProfileName returns the selected profile.
+func (c *Config) ProfileName() string {
+	if c.profile == "" {
+		return DefaultProfile
+	}
+	return c.profile
 }
 
+// SaveConfig writes cfg with the current schema version. Changes made while
+// a profile is selected are written to that profile.
 func SaveConfig(cfg *Config) error {
+	out := *cfg
+	out.Version = CurrentConfigVersion
+	if cfg.profile != "" {
+		out.Profiles = make(map[string]*Profile, len(cfg.Profiles)+1)
+		for name, p := range cfg.Profiles {
+			out.Profiles[name] = p
+		}
+		out.Profiles[cfg.profile] = &Profile{DefaultOrganization: cfg.DefaultOrganization, Organizations: cfg.Organizations}
+		out.DefaultOrganization = cfg.base.DefaultOrganization
+		out.Organizations = cfg.base.Organizations
+	}
+
 	path := configPath()
 	os.MkdirAll(filepath.Dir(path), 0700)
-	data, _ := json.MarshalIndent(cfg, "", "  ")
+	data, _ := json.MarshalIndent(&out, "", "  ")
 	return os.WriteFile(path, data, 0600)
 }
 
+// ResolveOrganization returns the named organization, or the default one
+// when name is empty, with AZDO_VAULT_* overrides applied.
 func (c *Config) ResolveOrganization(name string) (*OrganizationConfig, error) {
-	if name != "" {
-		org, ok := c.Organizations[name]
-		if !ok {
-			return nil, fmt.Errorf("organization '%s' not found", name)
+	_, org, err := c.ResolveOrganizationWithName(name)
+	return org, err
+}
+
+func (c *Config) ResolveOrganizationWithName(name string) (string, *OrganizationConfig, error) {
+	if name == "" {
+		name = c.DefaultOrganizationName()
+		if name == "" {
+			return "", nil, fmt.Errorf("no default organization set%s", c.inProfile())
 		}
-		return &org, nil
 	}
 
-	if c.DefaultOrganization == "" {
-		return nil, fmt.Errorf("no default organization set")
+	org, ok := c.Organizations[name]
+	if !ok && os.Getenv(envOrgKey(name, "URL")) == "" {
+		return "", nil, fmt.Errorf("organization '%s' not found%s", name, c.inProfile())
 	}
-
-	org, ok := c.Organizations[c.DefaultOrganization]
-	if !ok {
-		return nil, fmt.Errorf("default organization '%s' not found", c.DefaultOrganization)
+	if err := ApplyEnv(name, &org); err != nil {
+		return "", nil, err
 	}
 
-	return &org, nil
+	registerOrganization(&org)
+	registerBackupStore(name, &org)
+	return name, &org, nil
 }
 
-func (c *Config) ResolveOrganizationWithName(name string) (string, *OrganizationConfig, error) {
-
-	if name != "" {
-		org, ok := c.Organizations[name]
-		if !ok {
-			return "", nil, fmt.Errorf("organization '%s' not found", name)
-		}
-		return name, &org, nil
+// DefaultOrganizationName returns AZDO_VAULT_ORG if set, else the default
+// organization of the selected profile.
+func (c *Config) DefaultOrganizationName() string {
+	if name := os.Getenv(EnvPrefix + "ORG"); name != "" {
+		return name
 	}
+	return c.DefaultOrganization
+}
 
-	if c.DefaultOrganization == "" {
-		return "", nil, fmt.Errorf("no default organization set")
+func (c *Config) inProfile() string {
+	if c.profile == "" {
+		return ""
 	}
+	return fmt.Sprintf(" in profile '%s'", c.profile)
+}
 
-	org, ok := c.Organizations[c.DefaultOrganization]
-	if !ok {
-		return "", nil, fmt.Errorf("default organization '%s' not found", c.DefaultOrganization)
+// ProfileNames returns the default profile followed by the named ones, sorted.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles)+1)
+	for name := range c.Profiles {
+		if name != DefaultProfile {
+			names = append(names, name)
+		}
 	}
-
-	return c.DefaultOrganization, &org, nil
+	if c.profile != "" && c.Profiles[c.profile] == nil {
+		names = append(names, c.profile)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...)
 }
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts every environment variable that overrides the config.
+//
+// Global variables apply to every organization:
+//
+//	AZDO_VAULT_CONFIG         config file path (default ~/.azdo-vault/config.json)
+//	AZDO_VAULT_PROFILE        profile to use
+//	AZDO_VAULT_ORG            default organization alias
+//	AZDO_VAULT_BACKUP_ROOT, AZDO_VAULT_AUTH, AZDO_VAULT_RESOURCE_GUID,
+//	AZDO_VAULT_API_PROFILE, AZDO_VAULT_PROXY, AZDO_VAULT_PARALLELISM,
+//	AZDO_VAULT_SIGNING_KEY_FILE, AZDO_VAULT_ENCRYPTION_KEY_FILE,
+//	AZDO_VAULT_ENCRYPTION_PASSPHRASE_ENV
+//
+// Per-organization variables take precedence over them and are named after
+// the alias in upper case, other characters replaced with "_", e.g. for "my-org":
+//
+//	AZDO_VAULT_MY_ORG_URL, AZDO_VAULT_MY_ORG_BACKUP_ROOT, AZDO_VAULT_MY_ORG_AUTH, ...
+//
+// An organization whose _URL variable is set does not need to be in the config.
+const EnvPrefix = "AZDO_VAULT_"
+
+// envOrgKey returns the per-organization variable for a setting.
+func envOrgKey(alias, setting string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(alias) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return EnvPrefix + b.String() + "_" + setting
+}
+
+// envLookup returns the per-organization value of a setting, else the global one.
+func envLookup(alias, setting string) (string, bool) {
+	if v := os.Getenv(envOrgKey(alias, setting)); v != "" {
+		return v, true
+	}
+	if v := os.Getenv(EnvPrefix + setting); v != "" {
+		return v, true
+	}
+	return "", false
+}
+
+// ApplyEnv overrides the settings of org with the AZDO_VAULT_* variables for
+// alias. An org that only exists in the environment gets the default backup root.
+func ApplyEnv(alias string, org *OrganizationConfig) error {
+	if v := os.Getenv(envOrgKey(alias, "URL")); v != "" {
+		org.URL = strings.TrimRight(v, "/")
+	}
+	if v, ok := envLookup(alias, "BACKUP_ROOT"); ok {
+		org.BackupRoot = v
+	}
+	if org.BackupRoot == "" {
+		org.BackupRoot = DefaultBackupRoot()
+	}
+	if v, ok := envLookup(alias, "AUTH"); ok {
+		auth := AuthConfig{}
+		if org.Auth != nil {
+			auth = *org.Auth
+		}
+		auth.Method = v
+		org.Auth = &auth
+	}
+	if v, ok := envLookup(alias, "RESOURCE_GUID"); ok {
+		org.ResourceGUID = v
+	}
+	if v, ok := envLookup(alias, "API_PROFILE"); ok {
+		org.APIProfile = v
+	}
+	if v, ok := envLookup(alias, "PROXY"); ok {
+		org.Proxy = v
+	}
+	if v, ok := envLookup(alias, "SIGNING_KEY_FILE"); ok {
+		org.SigningKeyFile = v
+	}
+	if v, ok := envLookup(alias, "ENCRYPTION_KEY_FILE"); ok {
+		org.Encryption = &EncryptionConfig{KeyFile: v}
+	}
+	if v, ok := envLookup(alias, "ENCRYPTION_PASSPHRASE_ENV"); ok {
+		org.Encryption = &EncryptionConfig{PassphraseEnv: v}
+	}
+	if v, ok := envLookup(alias, "PARALLELISM"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid parallelism '%s' in environment for '%s'", v, alias)
+		}
+		org.Parallelism = n
+	}
+	return nil
+}
+
+// envDefinesOrganization reports whether any AZDO_VAULT_*_URL variable is set.
+func envDefinesOrganization() bool {
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, EnvPrefix) && strings.HasSuffix(key, "_URL") {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultBackupRoot is where backups go unless an org sets backupRoot.
+func DefaultBackupRoot() string {
+	home, _ := os.UserHomeDir()
+	return home + "/azdo-vaults"
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"azdo-vault/internal/adoclient"
+)
+
+// CheckResult is the outcome of one step of CheckOrganization.
+type CheckResult struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// CheckOrganization tests an organization: every service host is reachable,
+// the credentials are accepted and the identity may list projects. With a
+// project it also checks read access to its repos, pipelines, releases,
+// service connections and feeds. Checks after a failed sign-in are skipped.
+func CheckOrganization(org *OrganizationConfig, project, resourceGUID string) []CheckResult {
+	var results []CheckResult
+	add := func(name string, err error, detail string) bool {
+		if err != nil {
+			results = append(results, CheckResult{Name: name, Detail: describeCheckError(err)})
+			return false
+		}
+		results = append(results, CheckResult{Name: name, OK: true, Detail: detail})
+		return true
+	}
+
+	hosts, err := hostsFor(org.URL)
+	if err == nil {
+		err = ValidateOrganization(org)
+	}
+	if !add("config", err, org.URL) {
+		return results
+	}
+
+	// connectionData answers on every service host and names the signed-in identity.
+	seen := map[string]bool{}
+	signedIn := false
+	for _, h := range []struct{ name, base string }{
+		{"core", hosts.Core}, {"identity", hosts.Identity}, {"release", hosts.Release}, {"feeds", hosts.Feeds},
+	} {
+		if seen[h.base] {
+			continue
+		}
+		seen[h.base] = true
+
+		user, err := connectionUser(org.URL, resourceGUID, h.base)
+		if add("reach "+h.name+" ("+h.base+")", err, "signed in as "+user) {
+			signedIn = true
+		}
+	}
+	if !signedIn {
+		return results
+	}
+
+	_, err = adoGet(org.URL, resourceGUID, fmt.Sprintf("%s/_apis/projects?$top=1&api-version=7.1", hosts.Core))
+	add("list projects", err, "")
+
+	if project == "" {
+		return results
+	}
+	p := url.PathEscape(project)
+	for _, c := range []struct{ name, uri string }{
+		{"read project", fmt.Sprintf("%s/_apis/projects/%s?api-version=7.1", hosts.Core, p)},
+		{"list repos", fmt.Sprintf("%s/%s/_apis/git/repositories?api-version=7.1", hosts.Core, p)},
+		{"list build definitions", fmt.Sprintf("%s/%s/_apis/build/definitions?$top=1&api-version=7.1", hosts.Core, p)},
+		{"list release definitions", fmt.Sprintf("%s/%s/_apis/release/definitions?$top=1&api-version=7.1", hosts.Release, p)},
+		{"list service connections", fmt.Sprintf("%s/%s/_apis/serviceendpoint/endpoints?api-version=7.1", hosts.Core, p)},
+		{"list feeds", fmt.Sprintf("%s/%s/_apis/packaging/feeds?api-version=7.1-preview.1", hosts.Feeds, p)},
+	} {
+		_, err := adoGet(org.URL, resourceGUID, c.uri)
+		add(c.name, err, "")
+	}
+	return results
+}
+
+// connectionUser calls {base}/_apis/connectionData and returns the display
+// name of the authenticated identity. It is tried once: an unreachable host
+// should fail the check quickly rather than wait out the retry backoff.
+func connectionUser(orgURL, resourceGUID, base string) (string, error) {
+	c, err := clientFor(orgURL, resourceGUID)
+	if err != nil {
+		return "", err
+	}
+	once := *c
+	once.Retry.MaxAttempts = 1
+
+	out, err := once.Get(base + "/_apis/connectionData")
+	if err != nil {
+		return "", err
+	}
+
+	var data struct {
+		AuthenticatedUser struct {
+			ProviderDisplayName string `json:"providerDisplayName"`
+			CustomDisplayName   string `json:"customDisplayName"`
+			Descriptor          string `json:"descriptor"`
+		} `json:"authenticatedUser"`
+	}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return "", fmt.Errorf("parse connection data failed: %w", err)
+	}
+	u := data.AuthenticatedUser
+	switch {
+	case u.CustomDisplayName != "":
+		return u.CustomDisplayName, nil
+	case u.ProviderDisplayName != "":
+		return u.ProviderDisplayName, nil
+	case u.Descriptor != "":
+		return u.Descriptor, nil
+	}
+	return "", errors.New("not signed in (anonymous identity)")
+}
+
+// describeCheckError tells unreachable hosts, rejected credentials and
+// missing permissions apart.
+func describeCheckError(err error) string {
+	switch adoclient.StatusCode(err) {
+	case 0:
+		return err.Error()
+	case http.StatusUnauthorized, http.StatusNonAuthoritativeInfo:
+		return "authentication failed: " + err.Error()
+	case http.StatusForbidden:
+		return "permission denied: " + err.Error()
+	case http.StatusNotFound:
+		return "not found: " + err.Error()
+	}
+	return err.Error()
+}
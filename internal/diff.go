@@ -0,0 +1,410 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Item statuses in a drift report.
+const (
+	DiffSame       = "same"
+	DiffChanged    = "changed"
+	DiffOnlyBackup = "only-in-backup"
+	DiffOnlyLive   = "only-in-live"
+)
+
+// Operations of a JSONChange, seen from the backup towards the live item.
+const (
+	DiffAdd     = "add"
+	DiffRemove  = "remove"
+	DiffReplace = "replace"
+)
+
+// DiffKinds lists the kinds `diff` can compare. Repos are git data and are
+// not compared.
+var DiffKinds = []string{
+	KindBranchPolicies,
+	KindBuildDefinitions,
+	KindReleaseDefinitions,
+	KindYamlPipelines,
+	KindTaskGroups,
+	KindServiceConnections,
+	KindVariableGroups,
+	KindArtifactsFeeds,
+	KindWikis,
+}
+
+// JSONChange is one difference between the normalized backup and live item.
+// Path is dotted, with array indexes: "process.phases[0].steps[2].inputs.script".
+type JSONChange struct {
+	Path   string `json:"path"`
+	Op     string `json:"op"`
+	Backup any    `json:"backup,omitempty"`
+	Live   any    `json:"live,omitempty"`
+}
+
+// ItemDiff is the drift of one item between a backup and a live project.
+// Item is the backup file name without ".json".
+type ItemDiff struct {
+	Item    string       `json:"item"`
+	Status  string       `json:"status"`
+	Changes []JSONChange `json:"changes,omitempty"`
+}
+
+// DiffBackup compares the backup of one kind in backupPath with the live
+// project. Both sides are normalized the way restore sanitizes payloads, so
+// server-managed fields (ids, revisions, dates, links, ...) do not show up.
+// ignore lists extra paths to drop before comparing; "*" matches any key or
+// array index, e.g. "process.phases.*.target.queue".
+func DiffBackup(orgURL, project, backupPath, kind string, selected []string, resourceGUID string, ignore []string) ([]ItemDiff, error) {
+	if !contains(DiffKinds, kind) {
+		return nil, fmt.Errorf("diff: unsupported kind '%s' (use %s)", kind, strings.Join(DiffKinds, ", "))
+	}
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := map[string]map[string]any{}
+	files, err := os.ReadDir(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		item := strings.TrimSuffix(f.Name(), ".json")
+		if !sel.matchFile(filepath.Join(backupPath, f.Name()), item) {
+			continue
+		}
+		b, err := readBackupFile(filepath.Join(backupPath, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]any
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("failed parsing %s: %w", f.Name(), err)
+		}
+		backup[item] = m
+	}
+
+	live, err := liveItems(orgURL, project, kind, resourceGUID, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]bool{}
+	for k := range backup {
+		keys[k] = true
+	}
+	for k := range live {
+		keys[k] = true
+	}
+	items := make([]string, 0, len(keys))
+	for k := range keys {
+		items = append(items, k)
+	}
+	sort.Strings(items)
+
+	var out []ItemDiff
+	for _, item := range items {
+		b, inBackup := backup[item]
+		l, inLive := live[item]
+		switch {
+		case !inLive:
+			out = append(out, ItemDiff{Item: item, Status: DiffOnlyBackup})
+		case !inBackup:
+			out = append(out, ItemDiff{Item: item, Status: DiffOnlyLive})
+		default:
+			nb, nl := normalizeForDiff(kind, b), normalizeForDiff(kind, l)
+			for _, p := range ignore {
+				removePath(nb, strings.Split(p, "."))
+				removePath(nl, strings.Split(p, "."))
+			}
+			var changes []JSONChange
+			diffJSON("", nb, nl, &changes)
+			d := ItemDiff{Item: item, Status: DiffSame}
+			if len(changes) > 0 {
+				d.Status = DiffChanged
+				d.Changes = changes
+			}
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// liveItems fetches the live items of kind, keyed the way the backup names
+// its files, in the same JSON shape the backup stores.
+func liveItems(orgURL, project, kind, resourceGUID string, sel *Selector) (map[string]map[string]any, error) {
+	out := map[string]map[string]any{}
+
+	switch kind {
+	case KindBuildDefinitions:
+		list, err := ListBuildDefinitions(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range list {
+			if !sel.MatchIn(d.Path, d.Name) {
+				continue
+			}
+			full, err := GetBuildDefinition(orgURL, project, resourceGUID, d.Id)
+			if err != nil {
+				return nil, err
+			}
+			out[d.Name] = toMap(full)
+		}
+
+	case KindReleaseDefinitions:
+		list, err := ListReleaseDefinitions(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range list {
+			if !sel.MatchIn(pickString(d.Raw["path"]), d.Name) {
+				continue
+			}
+			full, err := GetReleaseDefinition(orgURL, project, resourceGUID, d.Id)
+			if err != nil {
+				return nil, err
+			}
+			out[d.Name] = full
+		}
+
+	case KindYamlPipelines:
+		list, err := ListPipelines(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range list {
+			if !sel.MatchIn(pickString(p.Raw["folder"]), p.Name) {
+				continue
+			}
+			full, err := GetPipeline(orgURL, project, resourceGUID, p.Id)
+			if err != nil {
+				return nil, err
+			}
+			if isYamlPipeline(full) {
+				out[p.Name] = full
+			}
+		}
+
+	case KindTaskGroups:
+		list, err := ListTaskGroups(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, tg := range list {
+			if sel.Match(tg.Name) {
+				out[tg.Name] = toMap(tg)
+			}
+		}
+
+	case KindServiceConnections:
+		list, err := ListServiceConnections(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range list {
+			if !sel.Match(e.Name) {
+				continue
+			}
+			full, err := GetServiceConnection(orgURL, project, resourceGUID, e.Id)
+			if err != nil {
+				return nil, err
+			}
+			out[e.Name] = toMap(full)
+		}
+
+	case KindVariableGroups:
+		list, err := ListVariableGroups(orgURL, project)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range list {
+			if sel.Match(g.Name) {
+				out[g.Name] = toMap(g)
+			}
+		}
+
+	case KindBranchPolicies:
+		list, err := ListPolicyConfigurations(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, pc := range list {
+			if pc.Raw == nil {
+				continue
+			}
+			item := strings.TrimSuffix(policyFilename(pc.Raw), ".json")
+			if sel.Match(item) {
+				out[item] = toMap(pc)
+			}
+		}
+
+	case KindArtifactsFeeds:
+		list, err := ListFeeds(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range list {
+			item := strings.TrimSuffix(safeFeedFile(f.Name), ".json")
+			if sel.Match(item) {
+				out[item] = toMap(f)
+			}
+		}
+
+	case KindWikis:
+		list, err := ListWikis(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range list {
+			item := fmt.Sprintf("%s_%s", safeFilePart(w.ID), safeFilePart(w.Name))
+			if sel.Match(item) {
+				out[item] = toMap(w)
+			}
+		}
+	}
+	return out, nil
+}
+
+// normalizeForDiff strips what restore strips before sending an item, so only
+// configuration remains.
+func normalizeForDiff(kind string, m map[string]any) map[string]any {
+	c := deepCopyMap(m)
+
+	switch kind {
+	case KindBuildDefinitions:
+		sanitizeBuildDefinitionForCreate(c)
+	case KindReleaseDefinitions:
+		c = sanitizeReleaseDefinitionForCreate(c)
+	case KindYamlPipelines:
+		c = sanitizeYamlPipelineForCreate(c)
+	case KindTaskGroups:
+		sanitizeTaskGroupForCreate(c)
+	case KindServiceConnections:
+		sanitizeServiceConnectionForCreate(c)
+	case KindVariableGroups:
+		delete(c, "id")
+	case KindBranchPolicies:
+		c = SanitizePolicyForCreate(c)
+		delete(c, "_backupHints")
+	case KindArtifactsFeeds:
+		delete(c, "id")
+		delete(c, "defaultViewId")
+		delete(c, "project")
+	case KindWikis:
+		for _, k := range []string{"id", "projectId", "repositoryId", "url", "remoteUrl"} {
+			delete(c, k)
+		}
+	}
+	return c
+}
+
+func toMap(v any) map[string]any {
+	b, _ := json.Marshal(v)
+	var m map[string]any
+	_ = json.Unmarshal(b, &m)
+	return m
+}
+
+// diffJSON appends the differences between a (backup) and b (live) to out.
+// Objects are compared key by key, arrays index by index.
+func diffJSON(path string, a, b any, out *[]JSONChange) {
+	am, aIsMap := a.(map[string]any)
+	bm, bIsMap := b.(map[string]any)
+	if aIsMap && bIsMap {
+		keys := map[string]bool{}
+		for k := range am {
+			keys[k] = true
+		}
+		for k := range bm {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			av, inA := am[k]
+			bv, inB := bm[k]
+			switch {
+			case !inB:
+				*out = append(*out, JSONChange{Path: p, Op: DiffRemove, Backup: av})
+			case !inA:
+				*out = append(*out, JSONChange{Path: p, Op: DiffAdd, Live: bv})
+			default:
+				diffJSON(p, av, bv, out)
+			}
+		}
+		return
+	}
+
+	aa, aIsArr := a.([]any)
+	ba, bIsArr := b.([]any)
+	if aIsArr && bIsArr {
+		for i := 0; i < len(aa) || i < len(ba); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(ba):
+				*out = append(*out, JSONChange{Path: p, Op: DiffRemove, Backup: aa[i]})
+			case i >= len(aa):
+				*out = append(*out, JSONChange{Path: p, Op: DiffAdd, Live: ba[i]})
+			default:
+				diffJSON(p, aa[i], ba[i], out)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, JSONChange{Path: path, Op: DiffReplace, Backup: a, Live: b})
+	}
+}
+
+// removePath deletes the value at a dotted path; "*" matches any object key
+// or array index, a number matches an array index.
+func removePath(v any, parts []string) {
+	if len(parts) == 0 {
+		return
+	}
+	head, rest := parts[0], parts[1:]
+
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if head != "*" && head != k {
+				continue
+			}
+			if len(rest) == 0 {
+				delete(t, k)
+			} else {
+				removePath(child, rest)
+			}
+		}
+	case []any:
+		if len(rest) == 0 {
+			return // array elements are not removed, only fields inside them
+		}
+		for i, child := range t {
+			if head == "*" || head == strconv.Itoa(i) {
+				removePath(child, rest)
+			}
+		}
+	}
+}
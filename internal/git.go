@@ -1,19 +1,23 @@
 package internal
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
-func MirrorClone(url, dest string) error {
+// MirrorClone clones url as a bare mirror into dest; git output goes to out.
+func MirrorClone(url, dest string, out io.Writer) error {
 	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
 		return err
 	}
 	cmd := exec.Command("git", "clone", "--mirror", url, dest)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = out
+	cmd.Stderr = out
 	return cmd.Run()
 }
 
@@ -32,7 +36,7 @@ func MirrorClone(url, dest string) error {
 // 	return cmd.Run()
 // }
 
-func PushAllAndTags(localPath, remoteURL string) error {
+func PushAllAndTags(localPath, remoteURL string, out io.Writer) error {
 
 	pushAll := exec.Command(
 		"git",
@@ -42,8 +46,8 @@ func PushAllAndTags(localPath, remoteURL string) error {
 		remoteURL,
 	)
 
-	pushAll.Stdout = os.Stdout
-	pushAll.Stderr = os.Stderr
+	pushAll.Stdout = out
+	pushAll.Stderr = out
 
 	if err := pushAll.Run(); err != nil {
 		return err
@@ -57,8 +61,8 @@ func PushAllAndTags(localPath, remoteURL string) error {
 		remoteURL,
 	)
 
-	pushTags.Stdout = os.Stdout
-	pushTags.Stderr = os.Stderr
+	pushTags.Stdout = out
+	pushTags.Stderr = out
 
 	return pushTags.Run()
 }
@@ -81,3 +85,209 @@ func MirrorPush(mirrorDir, targetRemoteURL string) error {
 	}
 	return nil
 }
+
+// isMirror reports whether dir is a bare git repository.
+func isMirror(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "HEAD"))
+	return err == nil && strings.HasSuffix(dir, ".git")
+}
+
+// copyMirror clones the mirror src into dst, keeping the remote src was
+// cloned from.
+func copyMirror(src, dst string) error {
+	url, err := exec.Command("git", "--git-dir", src, "config", "--get", "remote.origin.url").Output()
+	if err != nil {
+		return fmt.Errorf("read origin of %s: %w", src, err)
+	}
+	if err := MirrorClone(src, dst, io.Discard); err != nil {
+		return fmt.Errorf("clone %s: %w", src, err)
+	}
+	return setOrigin(dst, strings.TrimSpace(string(url)))
+}
+
+func setOrigin(dir, url string) error {
+	cmd := exec.Command("git", "--git-dir", dir, "remote", "set-url", "origin", url)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git remote set-url failed for %s: %w\n%s", dir, err, string(out))
+	}
+	return nil
+}
+
+// MirrorCloneOrUpdate clones url as a bare mirror into dest, or fetches into
+// an existing mirror. A new mirror starts from seed, an earlier mirror of the
+// same repo, if given: its objects are hard linked (copied across file
+// systems) and only new ones are fetched from url.
+func MirrorCloneOrUpdate(url, dest, seed string, out io.Writer) error {
+	if _, err := os.Stat(filepath.Join(dest, "HEAD")); err != nil {
+		if seed == "" {
+			return MirrorClone(url, dest, out)
+		}
+		if err := MirrorClone(seed, dest, out); err != nil {
+			fmt.Fprintf(out, "⚠ Could not start from %s, cloning in full: %v\n", seed, err)
+			if err := os.RemoveAll(dest); err != nil {
+				return err
+			}
+			return MirrorClone(url, dest, out)
+		}
+		if err := setOrigin(dest, url); err != nil {
+			return err
+		}
+	}
+	cmd := exec.Command("git", "--git-dir", dest, "remote", "update", "--prune")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git remote update failed for %s: %w", dest, err)
+	}
+	return nil
+}
+
+// A mirror in an encrypted store is kept as a git bundle, {name}.git.bundle,
+// encrypted like every other backup file.
+const bundleSuffix = ".bundle"
+
+// mirrorName returns the repo name of a mirror in a backup folder:
+// {name}.git, or {name}.git.bundle.
+func mirrorName(entry string) (string, bool) {
+	return strings.CutSuffix(strings.TrimSuffix(entry, bundleSuffix), ".git")
+}
+
+// backupMirror mirrors url into the mirror dest ({name}.git) of a backup,
+// starting from seed (see seedMirror). In an encrypted store the mirror is
+// made in a temporary folder and kept as the encrypted bundle dest.bundle,
+// so no plain copy of it reaches the store; a plain seed there is not used.
+func backupMirror(url, dest, seed string, out io.Writer) error {
+	key, err := backupKey(dest, true)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dest + bundleSuffix); err == nil && key != nil {
+		seed = dest + bundleSuffix // carried over from the previous backup
+	} else if key != nil && isMirror(seed) {
+		fmt.Fprintf(out, "⚠ Not starting from %s: %v\n", seed, errNotEncrypted(seed))
+		seed = ""
+	}
+
+	tmp := ""
+	if key != nil || strings.HasSuffix(seed, bundleSuffix) {
+		if tmp, err = os.MkdirTemp("", "azdo-vault-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+	}
+	if strings.HasSuffix(seed, bundleSuffix) {
+		plain := filepath.Join(tmp, "seed.bundle")
+		if err := decryptFile(seed, plain); err != nil {
+			fmt.Fprintf(out, "⚠ Could not start from %s: %v\n", seed, err)
+			seed = ""
+		} else if info, err := os.Stat(plain); err != nil || info.Size() == 0 {
+			seed = "" // an empty repo
+		} else {
+			seed = plain
+		}
+	}
+
+	if key == nil {
+		if err := MirrorCloneOrUpdate(url, dest, seed, out); err != nil {
+			return err
+		}
+		return removeIfExists(dest + bundleSuffix)
+	}
+	work := filepath.Join(tmp, filepath.Base(dest))
+	if err := MirrorCloneOrUpdate(url, work, seed, out); err != nil {
+		return err
+	}
+	if err := sealMirror(work, dest+bundleSuffix); err != nil {
+		return err
+	}
+	return os.RemoveAll(dest)
+}
+
+// sealMirror writes the bare repo dir as the bundle dst, encrypted if dst is
+// in an encrypted store. An empty repo, which git cannot bundle, gives an
+// empty bundle.
+func sealMirror(dir, dst string) error {
+	tmp, err := os.MkdirTemp("", "azdo-vault-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	bundle := filepath.Join(tmp, "mirror.bundle")
+	refs, err := exec.Command("git", "--git-dir", dir, "for-each-ref").Output()
+	if err != nil {
+		return fmt.Errorf("git for-each-ref failed for %s: %w", dir, err)
+	}
+	if len(bytes.TrimSpace(refs)) == 0 {
+		err = os.WriteFile(bundle, nil, 0600)
+	} else if out, berr := exec.Command("git", "--git-dir", dir, "bundle", "create", bundle, "--all").CombinedOutput(); berr != nil {
+		err = fmt.Errorf("git bundle create failed for %s: %w\n%s", dir, berr, string(out))
+	}
+	if err != nil {
+		return err
+	}
+	return encryptFile(bundle, dst)
+}
+
+// openMirror returns a bare repo for the mirror dir ({name}.git) of a
+// backup: dir itself, or a temporary clone of its bundle (dir.bundle), which
+// done removes. A plain mirror in an encrypted store is an error.
+func openMirror(dir string) (repo string, done func(), err error) {
+	if isMirror(dir) {
+		if encryptedStore(dir) {
+			return "", nil, errNotEncrypted(dir)
+		}
+		return dir, func() {}, nil
+	}
+	bundle := dir + bundleSuffix
+	if _, err := os.Stat(bundle); err != nil {
+		return "", nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "azdo-vault-")
+	if err != nil {
+		return "", nil, err
+	}
+	done = func() { os.RemoveAll(tmp) }
+	plain := filepath.Join(tmp, "mirror.bundle")
+	repo = filepath.Join(tmp, filepath.Base(dir))
+	if err = decryptFile(bundle, plain); err == nil {
+		if info, serr := os.Stat(plain); serr == nil && info.Size() == 0 {
+			err = exec.Command("git", "init", "--quiet", "--bare", repo).Run()
+		} else {
+			err = MirrorClone(plain, repo, io.Discard)
+		}
+	}
+	if err != nil {
+		done()
+		return "", nil, fmt.Errorf("open %s: %w", bundle, err)
+	}
+	return repo, done, nil
+}
+
+// fsckMirror runs git fsck --full on a mirror of a backup, bundled or not.
+// A mirror folder is checked where it is, even in an encrypted store, where
+// openMirror refuses it: upgrade-backup --encrypt checks it before sealing it.
+func fsckMirror(dir string) error {
+	repo := dir
+	if !isMirror(dir) {
+		r, done, err := openMirror(dir)
+		if err != nil {
+			return err
+		}
+		defer done()
+		repo = r
+	}
+	out, err := exec.Command("git", "--git-dir", repo, "fsck", "--full", "--no-progress").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IdentityMap translates source identities to target identities. Keys of
+// Identities are a source uniqueName (UPN), subject descriptor or display
+// name, values a target uniqueName or descriptor; keys match case
+// insensitively. Domains rewrites the domain of unmapped uniqueNames,
+// e.g. "@old.com" -> "@new.com".
+//
+// As a file it is YAML:
+//
+//	identities:
+//	  alice@old.com: alice.smith@new.com
+//	  aad.ZjU5...: aad.NDE3...
+//	domains:
+//	  "@old.com": "@new.com"
+//
+// or CSV with one "source,target" pair per line, where a pair of domains
+// (both starting with "@") is a domain rule:
+//
+//	source,target
+//	alice@old.com,alice.smith@new.com
+//	@old.com,@new.com
+type IdentityMap struct {
+	Identities map[string]string `yaml:"identities,omitempty"`
+	Domains    map[string]string `yaml:"domains,omitempty"`
+}
+
+// LoadIdentityMap reads an identity map file: CSV for *.csv, YAML otherwise.
+func LoadIdentityMap(path string) (*IdentityMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &IdentityMap{}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = m.readCSV(data)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(m); err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %s: %w", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *IdentityMap) readCSV(data []byte) error {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	for first := true; ; first = false {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		src, tgt := strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])
+		if first && strings.EqualFold(src, "source") && strings.EqualFold(tgt, "target") {
+			continue
+		}
+		if strings.HasPrefix(src, "@") && strings.HasPrefix(tgt, "@") {
+			m.add(&m.Domains, src, tgt)
+		} else {
+			m.add(&m.Identities, src, tgt)
+		}
+	}
+}
+
+func (m *IdentityMap) add(table *map[string]string, src, tgt string) {
+	if *table == nil {
+		*table = map[string]string{}
+	}
+	(*table)[src] = tgt
+}
+
+func (m *IdentityMap) validate() error {
+	for src, tgt := range m.Identities {
+		if strings.TrimSpace(src) == "" || strings.TrimSpace(tgt) == "" {
+			return fmt.Errorf("identity mapping '%s' -> '%s' has an empty side", src, tgt)
+		}
+	}
+	for src, tgt := range m.Domains {
+		if !strings.HasPrefix(src, "@") || !strings.HasPrefix(tgt, "@") {
+			return fmt.Errorf("domain rule '%s' -> '%s' must map @domain to @domain", src, tgt)
+		}
+	}
+	return nil
+}
+
+// Merge adds the entries of o to m; entries of o win.
+func (m *IdentityMap) Merge(o *IdentityMap) {
+	if o == nil {
+		return
+	}
+	for src, tgt := range o.Identities {
+		m.add(&m.Identities, src, tgt)
+	}
+	for src, tgt := range o.Domains {
+		m.add(&m.Domains, src, tgt)
+	}
+}
+
+// target returns what to look a source identity up by in the target: an
+// explicit mapping of its descriptor, uniqueName or display name, else its
+// uniqueName with the domain rewritten, else h itself.
+func (m *IdentityMap) target(h IdentityHint) IdentityHint {
+	if m == nil {
+		return h
+	}
+	for _, key := range []string{h.Descriptor, h.UniqueName, h.DisplayName} {
+		if key == "" {
+			continue
+		}
+		if tgt := mapName(m.Identities, key); tgt != key {
+			if isDescriptor(tgt) {
+				return IdentityHint{Descriptor: tgt}
+			}
+			return IdentityHint{UniqueName: tgt}
+		}
+	}
+
+	if h.UniqueName != "" && len(m.Domains) > 0 {
+		// longest domain first, so "@eu.old.com" beats "@old.com"
+		domains := make([]string, 0, len(m.Domains))
+		for d := range m.Domains {
+			domains = append(domains, d)
+		}
+		sort.Slice(domains, func(i, j int) bool { return len(domains[i]) > len(domains[j]) })
+
+		for _, d := range domains {
+			if len(h.UniqueName) > len(d) && strings.EqualFold(h.UniqueName[len(h.UniqueName)-len(d):], d) {
+				return IdentityHint{UniqueName: h.UniqueName[:len(h.UniqueName)-len(d)] + m.Domains[d]}
+			}
+		}
+	}
+
+	// the source descriptor means nothing in another org
+	return IdentityHint{UniqueName: h.UniqueName, DisplayName: h.DisplayName}
+}
+
+// Subject descriptor types (the part before the first dot) of users and groups.
+var descriptorTypes = []string{"aad", "aadgp", "msa", "vss", "vssgp", "svc", "s2s", "bnd", "imp", "win", "wingp", "ad", "adgp", "ghb", "unauth"}
+
+// isDescriptor tells a subject descriptor ("aad.ZjU5...") or a legacy identity
+// descriptor ("Microsoft.TeamFoundation.Identity;S-1-9-...") from a uniqueName.
+func isDescriptor(s string) bool {
+	if strings.ContainsAny(s, "@\\ ") {
+		return false
+	}
+	if strings.Contains(s, ";") {
+		return true
+	}
+	typ, rest, ok := strings.Cut(s, ".")
+	return ok && rest != "" && contains(descriptorTypes, strings.ToLower(typ))
+}
+
+// IdentityResolver finds the target identity of a source identity, the one
+// place every restorer maps users and groups through. It applies an
+// IdentityMap first and then looks the result up in the target org by
+// descriptor, uniqueName or (only when nothing else is known) display name.
+// A lookup must match exactly one identity: no match and several matches
+// are both errors, never a guess. Results are cached per target org for the
+// run; the resolver is safe for concurrent use. A nil *IdentityResolver
+// resolves without a map and without a cache.
+type IdentityResolver struct {
+	maps  *IdentityMap
+	mu    sync.Mutex
+	cache map[string]identityLookup
+}
+
+type identityLookup struct {
+	id  string
+	err error
+}
+
+func NewIdentityResolver(m *IdentityMap) *IdentityResolver {
+	return &IdentityResolver{maps: m, cache: map[string]identityLookup{}}
+}
+
+// Resolve returns the id of the target identity of src in targetOrgURL.
+func (r *IdentityResolver) Resolve(targetOrgURL, resourceGUID string, src IdentityHint) (string, error) {
+	var maps *IdentityMap
+	if r != nil {
+		maps = r.maps
+	}
+	h := maps.target(src)
+
+	key := strings.ToLower(strings.TrimRight(targetOrgURL, "/") + "|" + h.Descriptor + "|" + h.UniqueName + "|" + h.DisplayName)
+	if r != nil {
+		r.mu.Lock()
+		l, ok := r.cache[key]
+		r.mu.Unlock()
+		if ok {
+			return l.id, l.err
+		}
+	}
+
+	id, err := findTargetIdentity(targetOrgURL, resourceGUID, h)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", src.label(), err)
+	}
+	if r != nil {
+		r.mu.Lock()
+		r.cache[key] = identityLookup{id: id, err: err}
+		r.mu.Unlock()
+	}
+	return id, err
+}
+
+// label names an identity in messages.
+func (h IdentityHint) label() string {
+	switch {
+	case h.UniqueName != "":
+		return h.UniqueName
+	case h.DisplayName != "":
+		return h.DisplayName
+	case h.Descriptor != "":
+		return h.Descriptor
+	}
+	return "(unknown identity)"
+}
+
+// findTargetIdentity looks h up in the target org and returns the id of the
+// only identity that matches it exactly.
+func findTargetIdentity(targetOrgURL, resourceGUID string, h IdentityHint) (string, error) {
+	vssps, err := identityBase(targetOrgURL)
+	if err != nil {
+		return "", err
+	}
+
+	var uri, want string
+	var names func(map[string]any) []string
+	switch {
+	case h.Descriptor != "":
+		param := "subjectDescriptors"
+		if strings.Contains(h.Descriptor, ";") {
+			param = "descriptors"
+		}
+		uri = fmt.Sprintf("%s/_apis/identities?%s=%s&api-version=7.1-preview.1", vssps, param, url.QueryEscape(h.Descriptor))
+		want, names = h.Descriptor, identityDescriptors
+	case h.UniqueName != "":
+		uri = fmt.Sprintf("%s/_apis/identities?searchFilter=General&filterValue=%s&api-version=7.1-preview.1", vssps, url.QueryEscape(h.UniqueName))
+		want, names = h.UniqueName, identityUniqueNames
+	case h.DisplayName != "":
+		uri = fmt.Sprintf("%s/_apis/identities?searchFilter=General&filterValue=%s&api-version=7.1-preview.1", vssps, url.QueryEscape(h.DisplayName))
+		want, names = h.DisplayName, identityDisplayNames
+	default:
+		return "", fmt.Errorf("no uniqueName, descriptor or display name to search for")
+	}
+
+	out, err := adoGet(targetOrgURL, resourceGUID, uri)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Value []map[string]any `json:"value"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return "", err
+	}
+
+	// search results are fuzzy: keep the identities that match exactly
+	var ids, labels []string
+	for _, obj := range resp.Value {
+		id, _ := obj["id"].(string)
+		if obj == nil || id == "" || contains(ids, id) {
+			continue
+		}
+		for _, n := range names(obj) {
+			if strings.EqualFold(n, want) {
+				ids = append(ids, id)
+				labels = append(labels, identityHintFrom(obj).label())
+				break
+			}
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("not found in target as '%s'", want)
+	case 1:
+		return ids[0], nil
+	}
+	return "", fmt.Errorf("'%s' is ambiguous in target (%d matches: %s); map it explicitly in the identity map",
+		want, len(ids), strings.Join(labels, ", "))
+}
+
+// identityHintFrom reads the names of an identity from an identities API
+// object; the UPN of a user usually only appears in properties.Account.
+func identityHintFrom(obj map[string]any) IdentityHint {
+	var h IdentityHint
+	if n := identityDisplayNames(obj); len(n) > 0 {
+		h.DisplayName = n[0]
+	}
+	if n := identityUniqueNames(obj); len(n) > 0 {
+		h.UniqueName = n[0]
+	}
+	h.Descriptor = pickString(obj["subjectDescriptor"])
+	return h
+}
+
+func identityDisplayNames(obj map[string]any) []string {
+	return nonEmpty(pickString(obj["displayName"]), pickString(obj["providerDisplayName"]), pickString(obj["customDisplayName"]))
+}
+
+func identityUniqueNames(obj map[string]any) []string {
+	names := nonEmpty(pickString(obj["uniqueName"]), pickString(obj["signInAddress"]), pickString(obj["mailAddress"]))
+
+	// many identity APIs encode values as { "$value": "..." }
+	if props, ok := obj["properties"].(map[string]any); ok {
+		for _, k := range []string{"Account", "Mail", "SignInAddress", "Email"} {
+			v := props[k]
+			if m, ok := v.(map[string]any); ok {
+				v = m["$value"]
+			}
+			names = append(names, nonEmpty(pickString(v))...)
+		}
+	}
+	return names
+}
+
+func identityDescriptors(obj map[string]any) []string {
+	return nonEmpty(pickString(obj["subjectDescriptor"]), pickString(obj["descriptor"]))
+}
+
+func pickString(v any) string {
+	s, _ := v.(string)
+	return strings.TrimSpace(s)
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
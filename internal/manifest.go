@@ -0,0 +1,281 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestVersion is the migration.yaml schema version this build reads.
+const ManifestVersion = 1
+
+// Manifest is a migration recipe (migration.yaml) that can be checked into
+// git and reused across projects. Command line flags take precedence over it.
+//
+//	version: 1
+//	source: {org: src, project: Web}
+//	target: {org: dst, project: Web}
+//	kinds:
+//	  repos: {}
+//	  build-definitions: {select: [CI, Nightly], mode: sync, parallelism: 4, onCollision: suffix}
+//	  release-definitions: {select: ['\Team A\**', '!*-old']}
+//	mappings:
+//	  queues: {Hosted Ubuntu 1604: Azure Pipelines}
+//	  repos: {OldName: NewName}
+//	  renames:
+//	    all: [{template: "Payments-{name}"}]
+//	    folders: [{match: '^\\Team', replace: '\Payments'}]
+type Manifest struct {
+	Version      int                      `yaml:"version"`
+	Source       ManifestProject          `yaml:"source"`
+	Target       ManifestProject          `yaml:"target"`
+	ResourceGUID string                   `yaml:"resourceGuid,omitempty"`
+	Kinds        map[string]*KindManifest `yaml:"kinds,omitempty"` // step name -> selection and options; empty means every step
+	Mappings     *Mappings                `yaml:"mappings,omitempty"`
+
+	path string
+}
+
+// ManifestProject is one side of a migration: an org alias from the config
+// and a project.
+type ManifestProject struct {
+	Org     string `yaml:"org,omitempty"`
+	Project string `yaml:"project,omitempty"`
+}
+
+// KindManifest selects the items of one kind and tunes how they are restored.
+type KindManifest struct {
+	Select      []string `yaml:"select,omitempty"`      // selectors (see Selector), @files relative to the manifest; empty means all
+	Mode        string   `yaml:"mode,omitempty"`        // see RestoreModes
+	Parallelism int      `yaml:"parallelism,omitempty"` // overrides the run's parallelism for this kind
+	OnCollision string   `yaml:"onCollision,omitempty"` // see CollisionStrategies
+}
+
+// Mappings translate source names to target names. Keys are matched case
+// insensitively. A renamed repo, service connection, variable group or task
+// group is created under its target name, and references to it are remapped
+// to that name (see Renamer). Identities go through an IdentityResolver
+// (see IdentityMap).
+type Mappings struct {
+	Queues             map[string]string `yaml:"queues,omitempty"`
+	DefaultQueue       string            `yaml:"defaultQueue,omitempty"`
+	Identities         map[string]string `yaml:"identities,omitempty"`      // source uniqueName / descriptor / display name -> target uniqueName / descriptor
+	IdentityDomains    map[string]string `yaml:"identityDomains,omitempty"` // "@old.com" -> "@new.com"
+	IdentityFile       string            `yaml:"identityFile,omitempty"`    // identity map file (see IdentityMap), relative to the manifest
+	Repos              map[string]string `yaml:"repos,omitempty"`
+	ServiceConnections map[string]string `yaml:"serviceConnections,omitempty"`
+	VariableGroups     map[string]string `yaml:"variableGroups,omitempty"`
+	TaskGroups         map[string]string `yaml:"taskGroups,omitempty"`
+
+	// Renames holds ordered rename rules per kind (plus "all" and "folders"),
+	// for items without an explicit mapping above (see Renamer).
+	Renames map[string][]RenameRule `yaml:"renames,omitempty"`
+}
+
+// LoadManifest reads and validates a migration.yaml. Unknown fields are
+// errors, so a typo does not silently drop a mapping.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed parsing %s: %w", path, err)
+	}
+	m.path = path
+	if f := m.Mappings.identityFile(); f != "" && !filepath.IsAbs(f) {
+		m.Mappings.IdentityFile = filepath.Join(filepath.Dir(path), f)
+	}
+	for _, k := range m.Kinds {
+		if k != nil {
+			k.Select = ResolveSelectorFiles(k.Select, filepath.Dir(path))
+		}
+	}
+
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func (m *Manifest) validate() error {
+	if m.Version == 0 {
+		m.Version = ManifestVersion
+	}
+	if m.Version > ManifestVersion {
+		return fmt.Errorf("manifest version %d is newer than this azdo-vault supports (%d)", m.Version, ManifestVersion)
+	}
+	for kind, k := range m.Kinds {
+		if !contains(MigrationSteps, kind) {
+			return fmt.Errorf("unknown kind '%s' (use %s)", kind, strings.Join(MigrationSteps, ", "))
+		}
+		if k == nil {
+			continue
+		}
+		if err := ValidateRestoreMode(k.Mode); err != nil {
+			return fmt.Errorf("kind %s: %w", kind, err)
+		}
+		if k.Parallelism < 0 {
+			return fmt.Errorf("kind %s: parallelism must not be negative", kind)
+		}
+		if err := ValidateCollision(kind, k.OnCollision); err != nil {
+			return fmt.Errorf("kind %s: %w", kind, err)
+		}
+		if _, err := ParseSelector(k.Select); err != nil {
+			return fmt.Errorf("kind %s: select: %w", kind, err)
+		}
+	}
+	if m.Mappings != nil {
+		inline := &IdentityMap{Identities: m.Mappings.Identities, Domains: m.Mappings.IdentityDomains}
+		if err := inline.validate(); err != nil {
+			return fmt.Errorf("mappings: %w", err)
+		}
+		if err := compileRenameRules(m.Mappings.Renames); err != nil {
+			return fmt.Errorf("mappings: %w", err)
+		}
+	}
+	return nil
+}
+
+// Path returns the file the manifest was loaded from.
+func (m *Manifest) Path() string {
+	return m.path
+}
+
+// Steps returns the steps listed under kinds, or every step if none are.
+func (m *Manifest) Steps() []string {
+	if m == nil || len(m.Kinds) == 0 {
+		return []string{"all"}
+	}
+	steps := make([]string, 0, len(m.Kinds))
+	for kind := range m.Kinds {
+		steps = append(steps, kind)
+	}
+	sort.Strings(steps)
+	return steps
+}
+
+// Kind returns the settings of a kind; never nil.
+func (m *Manifest) Kind(kind string) *KindManifest {
+	if m == nil || m.Kinds[kind] == nil {
+		return &KindManifest{}
+	}
+	return m.Kinds[kind]
+}
+
+// Selection returns the selectors of a kind, ["all"] if none are.
+// The push step follows the repos selection unless it has its own.
+func (m *Manifest) Selection(kind string) []string {
+	sel := m.Kind(kind).Select
+	if len(sel) == 0 && kind == StepPush {
+		sel = m.Kind(StepRepos).Select
+	}
+	if len(sel) == 0 {
+		return []string{"all"}
+	}
+	return sel
+}
+
+// PerKindParallelism returns the parallelism overrides of the kinds.
+func (m *Manifest) PerKindParallelism() map[string]int {
+	out := map[string]int{}
+	if m == nil {
+		return out
+	}
+	for kind, k := range m.Kinds {
+		if k != nil && k.Parallelism > 0 {
+			out[kind] = k.Parallelism
+		}
+	}
+	return out
+}
+
+// PerKindMode returns the restore mode overrides of the kinds.
+func (m *Manifest) PerKindMode() map[string]string {
+	out := map[string]string{}
+	if m == nil {
+		return out
+	}
+	for kind, k := range m.Kinds {
+		if k != nil && k.Mode != "" {
+			out[kind] = k.Mode
+		}
+	}
+	return out
+}
+
+// PerKindCollision returns the collision strategy overrides of the kinds.
+func (m *Manifest) PerKindCollision() map[string]string {
+	out := map[string]string{}
+	if m == nil {
+		return out
+	}
+	for kind, k := range m.Kinds {
+		if k != nil && k.OnCollision != "" {
+			out[kind] = k.OnCollision
+		}
+	}
+	return out
+}
+
+// QueuePairs returns the queue mappings as "Source=Target" pairs, the form
+// of --queue-map, sorted for a stable order.
+func (m *Mappings) QueuePairs() []string {
+	if m == nil {
+		return nil
+	}
+	pairs := make([]string, 0, len(m.Queues))
+	for src, tgt := range m.Queues {
+		pairs = append(pairs, src+"="+tgt)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// mapName looks name up in table (case insensitively) and returns the target
+// name, or name itself when it is not mapped.
+func mapName(table map[string]string, name string) string {
+	if tgt, ok := table[name]; ok && tgt != "" {
+		return tgt
+	}
+	for src, tgt := range table {
+		if strings.EqualFold(src, name) && tgt != "" {
+			return tgt
+		}
+	}
+	return name
+}
+
+func (m *Mappings) identityFile() string {
+	if m == nil {
+		return ""
+	}
+	return m.IdentityFile
+}
+
+// IdentityMap returns the identity mappings: those of identityFile, then
+// the identities and identityDomains written in the manifest, which win.
+func (m *Mappings) IdentityMap() (*IdentityMap, error) {
+	out := &IdentityMap{}
+	if m == nil {
+		return out, nil
+	}
+	if m.IdentityFile != "" {
+		f, err := LoadIdentityMap(m.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		out.Merge(f)
+	}
+	out.Merge(&IdentityMap{Identities: m.Identities, Domains: m.IdentityDomains})
+	return out, nil
+}
@@ -0,0 +1,379 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Migration steps. Most restore a backup kind of the same name; "push" pushes
+// the mirrored repos into the repos created by the "repos" step.
+const (
+	StepRepos              = "repos"
+	StepPush               = "push"
+	StepServiceConnections = "service-connections"
+	StepVariableGroups     = "variable-groups"
+	StepTaskGroups         = "task-groups"
+	StepBuildDefinitions   = "build-definitions"
+	StepYamlPipelines      = "yaml-pipelines"
+	StepReleaseDefinitions = "release-definitions"
+	StepBranchPolicies     = "branch-policies"
+	StepWikis              = "wikis"
+	StepArtifactsFeeds     = "artifacts-feeds"
+)
+
+// MigrationSteps lists every step migrate-project knows about.
+var MigrationSteps = []string{
+	StepRepos,
+	StepPush,
+	StepServiceConnections,
+	StepVariableGroups,
+	StepTaskGroups,
+	StepBuildDefinitions,
+	StepYamlPipelines,
+	StepReleaseDefinitions,
+	StepBranchPolicies,
+	StepWikis,
+	StepArtifactsFeeds,
+}
+
+// migrationDeps: a step runs only after the steps it references by ID.
+//   - builds remap repos, queues, service connections, variable and task groups
+//   - releases remap build artifacts (RemapReleaseArtifacts) and the same refs
+//   - build validation policies point at builds (RemapBuildValidationDefinition)
+var migrationDeps = map[string][]string{
+	StepPush:               {StepRepos},
+	StepTaskGroups:         {StepServiceConnections},
+	StepBuildDefinitions:   {StepPush, StepServiceConnections, StepVariableGroups, StepTaskGroups},
+	StepYamlPipelines:      {StepPush, StepServiceConnections, StepVariableGroups},
+	StepReleaseDefinitions: {StepBuildDefinitions, StepServiceConnections, StepVariableGroups, StepTaskGroups},
+	StepBranchPolicies:     {StepPush, StepBuildDefinitions},
+	StepWikis:              {StepPush},
+}
+
+// SelectMigrationSteps resolves --include / --exclude and returns the steps
+// in dependency order.
+func SelectMigrationSteps(include, exclude []string) ([]string, error) {
+	steps, err := selectNames("step", MigrationSteps, include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	return orderSteps(steps)
+}
+
+// orderSteps sorts steps topologically (Kahn), keeping MigrationSteps order
+// among steps that are ready at the same time. Dependencies that are not
+// selected are assumed to exist in the target already.
+func orderSteps(steps []string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, s := range steps {
+		selected[s] = true
+	}
+
+	done := map[string]bool{}
+	var out []string
+	for len(out) < len(steps) {
+		progressed := false
+		for _, s := range MigrationSteps {
+			if !selected[s] || done[s] {
+				continue
+			}
+			ready := true
+			for _, d := range migrationDeps[s] {
+				if selected[d] && !done[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[s] = true
+				out = append(out, s)
+				progressed = true
+			}
+		}
+		if !progressed {
+			return nil, fmt.Errorf("migration steps have a dependency cycle")
+		}
+	}
+	return out, nil
+}
+
+// MigrationPlan describes one migrate-project run.
+type MigrationPlan struct {
+	SourceOrgURL  string
+	SourceProject string
+	TargetOrgURL  string
+	TargetProject string
+	BackupRoot    string    // {BackupRoot}/{org}/{project} of the source backup
+	At            time.Time // restore from the newest snapshots taken at or before; zero: the newest
+	StatePath     string
+	Steps         []string // as returned by SelectMigrationSteps
+	ResourceGUID  string
+	QueueMap      []string // "SourceQueue=TargetQueue" for build/release definitions
+	DefaultQueue  string
+	Select        map[string][]string // step -> selectors (see Selector); a missing step or ["all"] selects every item
+	Options       *RestoreOptions
+	Report        *Report // if set, receives the results of every item
+
+	dirs map[string]string // step -> backup folder it restores from
+}
+
+// MigrateProject restores every step of the plan from the local backup.
+// Steps run in order; the items of a step run with the step's parallelism
+// (see ExecOptions). Each item's outcome is saved to the state file; items
+// that finished in an earlier run are skipped (unless the mode updates
+// existing items), failed ones are retried.
+// A failing item does not stop the run; an error is returned at the end.
+// In a dry run the saved state is read but never written.
+func MigrateProject(plan MigrationPlan) (*MigrationState, error) {
+	state, err := LoadMigrationState(plan.StatePath,
+		plan.SourceOrgURL, plan.SourceProject, plan.TargetOrgURL, plan.TargetProject)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Options.dryRun() {
+		state.path = ""
+	}
+
+	plan.dirs = map[string]string{}
+	failed := 0
+	for _, step := range plan.Steps {
+		fmt.Printf("\n=== %s ===\n", step)
+
+		dir, snap, err := BackupPath(plan.BackupRoot, step, plan.At)
+		if err != nil {
+			failed++
+			fmt.Printf("⚠ %s: %v\n", step, err)
+			if err := state.SetStep(step, MigrationFailed); err != nil {
+				return state, err
+			}
+			continue
+		}
+		if snap != nil {
+			fmt.Println("Snapshot:", snap.ID)
+		}
+		plan.dirs[step] = dir
+
+		for _, d := range migrationDeps[step] {
+			if ds := state.Steps[d]; ds != nil && ds.Status == MigrationFailed {
+				fmt.Printf("⚠ %s depends on %s, which had failures; affected items may be skipped\n", step, d)
+			}
+		}
+
+		items, err := migrationItems(plan, step)
+		if err != nil {
+			failed++
+			fmt.Printf("⚠ %s: %v\n", step, err)
+			if err := state.SetStep(step, MigrationFailed); err != nil {
+				return state, err
+			}
+			continue
+		}
+		if len(items) == 0 {
+			fmt.Println("Nothing to migrate")
+		}
+
+		// Items run level by level: a task group nesting another is only
+		// restored once the nested group exists in the target, so its id can
+		// be mapped. Every other step is one level.
+		levels, err := migrationLevels(plan, step, items)
+		if err != nil {
+			failed++
+			fmt.Printf("⚠ %s: %v\n", step, err)
+			if err := state.SetStep(step, MigrationFailed); err != nil {
+				return state, err
+			}
+			continue
+		}
+
+		var mu sync.Mutex
+		stepFailed := false
+		migrate := func(item string, out io.Writer) error {
+			// update / sync re-apply every item so the target converges
+			mu.Lock()
+			done := !plan.Options.updates(step) && state.ItemDone(step, item)
+			mu.Unlock()
+			if done {
+				fmt.Fprintf(out, "✔ Already migrated, skipping: %s\n", item)
+				return nil
+			}
+
+			results, err := runMigrationItem(plan, step, item, out)
+			runErr := err
+			if !plan.Options.dryRun() {
+				runErr = itemError(results, err)
+			}
+			if runErr != nil {
+				fmt.Fprintf(out, "⚠ %s '%s' failed: %v\n", step, item, runErr)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if plan.Report != nil {
+				plan.Report.Add(results...)
+			}
+			if runErr != nil {
+				stepFailed = true
+				failed++
+			}
+			// a state that cannot be saved stops the run
+			return state.SetItem(step, item, runErr)
+		}
+		workers := plan.Options.exec().workers(step)
+		for _, level := range levels {
+			err := forEach(len(level), workers, false, func(i int, out io.Writer) error {
+				return migrate(items[level[i]], out)
+			})
+			if err != nil {
+				return state, err
+			}
+		}
+
+		status := MigrationDone
+		if stepFailed {
+			status = MigrationFailed
+		}
+		if err := state.SetStep(step, status); err != nil {
+			return state, err
+		}
+	}
+
+	if failed > 0 {
+		return state, fmt.Errorf("%d items failed; re-run the same command to retry them", failed)
+	}
+	return state, nil
+}
+
+// migrationItems lists the backup entries a step works through:
+// repo mirrors (see mirrorName) for repos/push, backup files (*.json)
+// otherwise, limited to the step's selection.
+// Item names are what the restore functions accept in their selection.
+func migrationItems(plan MigrationPlan, step string) ([]string, error) {
+	dir := plan.dirs[step]
+	itemName := func(entry string) (string, bool) {
+		return strings.CutSuffix(entry, ".json")
+	}
+	if step == StepRepos || step == StepPush {
+		itemName = mirrorName
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		fmt.Println("No backup found at", dir)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sel, err := ParseSelector(plan.Select[step])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", step, err)
+	}
+
+	var items []string
+	for _, e := range entries {
+		name, ok := itemName(e.Name())
+		if !ok {
+			continue
+		}
+		if sel.matchFile(filepath.Join(dir, e.Name()), name) {
+			items = append(items, name)
+		}
+	}
+	return items, nil
+}
+
+// migrationLevels returns the indexes of items in the order they must run:
+// levels one after another, the items of a level in parallel. Task groups
+// are levelled by nesting (see taskGroupLevels); the items of every other
+// step are one level.
+func migrationLevels(plan MigrationPlan, step string, items []string) ([][]int, error) {
+	if step != StepTaskGroups {
+		all := make([]int, len(items))
+		for i := range items {
+			all[i] = i
+		}
+		return [][]int{all}, nil
+	}
+	groups := make([]TaskGroup, len(items))
+	for i, item := range items {
+		b, err := readBackupFile(filepath.Join(plan.dirs[step], item+".json"))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &groups[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", item, err)
+		}
+	}
+	return taskGroupLevels(groups), nil
+}
+
+// runMigrationItem restores one item; its output goes to out.
+func runMigrationItem(plan MigrationPlan, step, item string, out io.Writer) ([]RestoreResult, error) {
+	src, srcProject := plan.SourceOrgURL, plan.SourceProject
+	tgt, tgtProject := plan.TargetOrgURL, plan.TargetProject
+	path := plan.dirs[step]
+	guid := plan.ResourceGUID
+	sel := []string{"=" + item} // exactly this item, see Selector
+	opts := plan.Options.withOutput(out)
+
+	switch step {
+	case StepRepos:
+		return RestoreRepos(tgt, tgtProject, []string{item}, opts)
+
+	case StepPush:
+		return PushRepos(path, tgt, tgtProject, []string{item}, opts)
+
+	case StepServiceConnections:
+		return RestoreServiceConnectionsFromBackup(tgt, tgtProject, path, sel, guid, opts)
+
+	case StepVariableGroups:
+		return RestoreVariableGroupsFromBackup(tgt, tgtProject, path, sel, opts)
+
+	case StepTaskGroups:
+		return RestoreTaskGroupsFromBackup(src, srcProject, tgt, tgtProject, path, sel, guid, opts)
+
+	case StepBuildDefinitions:
+		return RestoreBuildDefinitionsFromBackup(src, srcProject, tgt, tgtProject, path, sel, guid, plan.QueueMap, plan.DefaultQueue, opts)
+
+	case StepYamlPipelines:
+		return RestoreYamlPipelinesFromBackup(src, srcProject, tgt, tgtProject, path, sel, guid, opts)
+
+	case StepReleaseDefinitions:
+		return RestoreReleaseDefinitionsFromBackup(src, srcProject, tgt, tgtProject, path, sel, guid, plan.QueueMap, plan.DefaultQueue, opts)
+
+	case StepBranchPolicies:
+		return RestoreBranchPoliciesFromBackup(src, srcProject, tgt, tgtProject, path, sel, guid, opts)
+
+	case StepWikis:
+		return RestoreWikisFromBackup(src, srcProject, tgt, tgtProject, path, sel, guid, opts)
+
+	case StepArtifactsFeeds:
+		return RestoreArtifactsFeedsFromBackup(src, srcProject, tgt, tgtProject, path, sel, guid, opts)
+	}
+	return nil, fmt.Errorf("unknown step '%s'", step)
+}
+
+// itemError turns the results of one item into the error saved in the
+// migration state: the run error, or the first failed result. A skipped
+// (unresolvable) item is not done and is retried on the next run.
+func itemError(results []RestoreResult, runErr error) error {
+	if runErr != nil {
+		return runErr
+	}
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			return fmt.Errorf("%s", r.Error)
+		case r.Action == ActionSkipUnresolvable:
+			return fmt.Errorf("skipped: %s", r.Reason)
+		}
+	}
+	return nil
+}
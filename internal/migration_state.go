@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Item / step statuses recorded in a MigrationState.
+const (
+	MigrationPending = "pending"
+	MigrationDone    = "done"
+	MigrationFailed  = "failed"
+)
+
+// MigrationState is the resumable progress of one migrate-project run.
+// It is rewritten after every item so an interrupted run can pick up
+// exactly where it stopped.
+type MigrationState struct {
+	SourceOrgURL  string                `json:"sourceOrgUrl"`
+	SourceProject string                `json:"sourceProject"`
+	TargetOrgURL  string                `json:"targetOrgUrl"`
+	TargetProject string                `json:"targetProject"`
+	StartedAt     time.Time             `json:"startedAt"`
+	UpdatedAt     time.Time             `json:"updatedAt"`
+	Steps         map[string]*StepState `json:"steps"`
+	path          string
+}
+
+type StepState struct {
+	Status string                `json:"status"`
+	Items  map[string]*ItemState `json:"items"`
+}
+
+type ItemState struct {
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// LoadMigrationState reads the state file at path, or starts a new state if
+// it does not exist. A state written for another source/target is rejected.
+func LoadMigrationState(path, sourceOrgURL, sourceProject, targetOrgURL, targetProject string) (*MigrationState, error) {
+	st := &MigrationState{
+		SourceOrgURL:  sourceOrgURL,
+		SourceProject: sourceProject,
+		TargetOrgURL:  targetOrgURL,
+		TargetProject: targetProject,
+		StartedAt:     time.Now().UTC(),
+		Steps:         map[string]*StepState{},
+		path:          path,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved MigrationState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed parsing migration state %s: %w", path, err)
+	}
+	if normalizeOrgURL(saved.SourceOrgURL) != normalizeOrgURL(sourceOrgURL) || saved.SourceProject != sourceProject ||
+		normalizeOrgURL(saved.TargetOrgURL) != normalizeOrgURL(targetOrgURL) || saved.TargetProject != targetProject {
+		return nil, fmt.Errorf("migration state %s belongs to %s/%s -> %s/%s; use --reset or another --state-file",
+			path, saved.SourceOrgURL, saved.SourceProject, saved.TargetOrgURL, saved.TargetProject)
+	}
+	if saved.Steps == nil {
+		saved.Steps = map[string]*StepState{}
+	}
+	saved.path = path
+	return &saved, nil
+}
+
+func (s *MigrationState) step(name string) *StepState {
+	st, ok := s.Steps[name]
+	if !ok {
+		st = &StepState{Status: MigrationPending, Items: map[string]*ItemState{}}
+		s.Steps[name] = st
+	}
+	if st.Items == nil {
+		st.Items = map[string]*ItemState{}
+	}
+	return st
+}
+
+// ItemDone reports whether an item finished successfully in an earlier run.
+func (s *MigrationState) ItemDone(step, item string) bool {
+	it := s.step(step).Items[item]
+	return it != nil && it.Status == MigrationDone
+}
+
+// SetItem records the outcome of one item and saves the state.
+func (s *MigrationState) SetItem(step, item string, err error) error {
+	it := &ItemState{Status: MigrationDone, At: time.Now().UTC()}
+	if err != nil {
+		it.Status = MigrationFailed
+		it.Error = err.Error()
+	}
+	s.step(step).Items[item] = it
+	return s.Save()
+}
+
+// SetStep records the overall status of a step and saves the state.
+func (s *MigrationState) SetStep(step, status string) error {
+	s.step(step).Status = status
+	return s.Save()
+}
+
+// Save writes the state atomically (temp file + rename).
+// A state without a path (dry run) is kept in memory only.
+func (s *MigrationState) Save() error {
+	s.UpdatedAt = time.Now().UTC()
+	if s.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
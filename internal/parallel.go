@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Failure policies of ExecOptions.OnError.
+const (
+	OnErrorFailFast = "fail-fast" // start no new item after a failure, return the first error
+	OnErrorCollect  = "collect"   // process every item, return all failures together
+)
+
+// OnErrorPolicies lists the accepted values of ExecOptions.OnError.
+var OnErrorPolicies = []string{OnErrorFailFast, OnErrorCollect}
+
+// ExecOptions controls how the items of one kind (repos, definitions, ...)
+// are processed. A nil *ExecOptions processes one item at a time with the
+// kind's default failure policy, as before parallelism existed.
+type ExecOptions struct {
+	// Parallelism is the number of items processed at once; <= 1 means one at a time.
+	Parallelism int
+
+	// PerKind overrides Parallelism for some kinds (keys are Kind* names).
+	PerKind map[string]int
+
+	// OnError is OnErrorFailFast or OnErrorCollect; empty keeps the kind's
+	// default (restores stop at the first failed write, repo backups go on).
+	OnError string
+}
+
+func (o *ExecOptions) workers(kind string) int {
+	if o == nil {
+		return 1
+	}
+	if n, ok := o.PerKind[kind]; ok {
+		return n
+	}
+	return o.Parallelism
+}
+
+func (o *ExecOptions) failFast(def bool) bool {
+	if o == nil || o.OnError == "" {
+		return def
+	}
+	return o.OnError == OnErrorFailFast
+}
+
+// ParseParallelism reads --parallelism values: "N" sets every kind, "kind=N"
+// one kind. Later values win. base is the org default (0 if not configured).
+func ParseParallelism(values []string, base int, onError string) (*ExecOptions, error) {
+	if onError != "" && !contains(OnErrorPolicies, onError) {
+		return nil, fmt.Errorf("unknown on-error policy '%s' (use %s)", onError, strings.Join(OnErrorPolicies, ", "))
+	}
+	o := &ExecOptions{Parallelism: base, PerKind: map[string]int{}, OnError: onError}
+
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		kind, num, hasKind := strings.Cut(v, "=")
+		if !hasKind {
+			num, kind = kind, ""
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(num))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid parallelism '%s' (use N or kind=N, N >= 1)", v)
+		}
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if kind == "" {
+			o.Parallelism = n
+			continue
+		}
+		if !contains(AllKinds, kind) && kind != StepPush {
+			return nil, fmt.Errorf("unknown kind '%s' in parallelism '%s' (valid: %s)", kind, v, strings.Join(AllKinds, ", "))
+		}
+		o.PerKind[kind] = n
+	}
+	return o, nil
+}
+
+// forEach calls fn for the items 0..n-1 on up to workers goroutines.
+//
+// Every call writes to its own buffer; buffers are copied to stdout in item
+// order as soon as all earlier items are done, so the output reads like a
+// sequential run. With one worker fn writes to stdout directly.
+//
+// With failFast no item is started after a failure and the error of the
+// lowest failed item is returned; otherwise every item runs and all errors
+// are joined.
+func forEach(n, workers int, failFast bool, fn func(i int, out io.Writer) error) error {
+	errs := make([]error, n)
+
+	if workers <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			errs[i] = fn(i, os.Stdout)
+			if errs[i] != nil && failFast {
+				return errs[i]
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	var (
+		mu      sync.Mutex
+		bufs    = make([]*bytes.Buffer, n)
+		done    = make([]bool, n)
+		next    int
+		stopped bool
+	)
+
+	flush := func() {
+		for next < n && done[next] {
+			if bufs[next] != nil {
+				os.Stdout.Write(bufs[next].Bytes())
+				bufs[next] = nil
+			}
+			next++
+		}
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				mu.Lock()
+				skip := stopped
+				mu.Unlock()
+
+				var buf bytes.Buffer
+				var err error
+				if !skip {
+					err = fn(i, &buf)
+				}
+
+				mu.Lock()
+				bufs[i], errs[i], done[i] = &buf, err, true
+				if err != nil && failFast {
+					stopped = true
+				}
+				flush()
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failFast {
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
@@ -9,6 +9,7 @@ type PolicyBackupHints struct {
 type IdentityHint struct {
 	UniqueName  string `json:"uniqueName,omitempty"` // usually email/UPN
 	DisplayName string `json:"displayName,omitempty"`
+	Descriptor  string `json:"descriptor,omitempty"` // subject descriptor in the source org, e.g. aad.ZjU5...
 }
 
 type PolicyBackupFile struct {
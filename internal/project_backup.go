@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backup kinds, in the order backup-project runs them.
+// The names match the suffix of the single-kind backup-* commands.
+const (
+	KindRepos              = "repos"
+	KindBranchPolicies     = "branch-policies"
+	KindBuildDefinitions   = "build-definitions"
+	KindReleaseDefinitions = "release-definitions"
+	KindYamlPipelines      = "yaml-pipelines"
+	KindTaskGroups         = "task-groups"
+	KindServiceConnections = "service-connections"
+	KindVariableGroups     = "variable-groups"
+	KindArtifactsFeeds     = "artifacts-feeds"
+	KindWikis              = "wikis"
+)
+
+// AllKinds lists every kind backup-project knows about, in run order.
+var AllKinds = []string{
+	KindRepos,
+	KindBranchPolicies,
+	KindBuildDefinitions,
+	KindReleaseDefinitions,
+	KindYamlPipelines,
+	KindTaskGroups,
+	KindServiceConnections,
+	KindVariableGroups,
+	KindArtifactsFeeds,
+	KindWikis,
+}
+
+// KindDir returns the folder of a kind in a backup (see Snapshot).
+func KindDir(kind string) string {
+	if kind == KindArtifactsFeeds {
+		return filepath.Join("artifacts", "feeds")
+	}
+	return kind
+}
+
+// SelectKinds resolves --include / --exclude into an ordered list of kinds.
+// An empty include (or "all") means every kind.
+func SelectKinds(include, exclude []string) ([]string, error) {
+	return selectNames("kind", AllKinds, include, exclude)
+}
+
+// selectNames filters the ordered list all by include / exclude names.
+// Unknown names are rejected so a typo cannot silently drop a step.
+func selectNames(what string, all, include, exclude []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, k := range all {
+		known[k] = true
+	}
+
+	norm := func(list []string) (map[string]bool, error) {
+		m := map[string]bool{}
+		for _, k := range list {
+			k = strings.ToLower(strings.TrimSpace(k))
+			if k == "" {
+				continue
+			}
+			if k != "all" && !known[k] {
+				return nil, fmt.Errorf("unknown %s '%s' (valid: %s)", what, k, strings.Join(all, ", "))
+			}
+			m[k] = true
+		}
+		return m, nil
+	}
+
+	inc, err := norm(include)
+	if err != nil {
+		return nil, err
+	}
+	exc, err := norm(exclude)
+	if err != nil {
+		return nil, err
+	}
+	includeAll := len(inc) == 0 || inc["all"]
+
+	var out []string
+	for _, k := range all {
+		if (includeAll || inc[k]) && !exc[k] && !exc["all"] {
+			out = append(out, k)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no %ss left after include/exclude", what)
+	}
+	return out, nil
+}
+
+// Kind result statuses in a ProjectBackupReport.
+const (
+	KindStatusOK     = "ok"
+	KindStatusFailed = "failed"
+)
+
+type KindResult struct {
+	Kind     string `json:"kind"`
+	Status   string `json:"status"`
+	Path     string `json:"path"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ProjectBackupReport is written as backup-report.json next to the kind folders.
+type ProjectBackupReport struct {
+	OrganizationURL string       `json:"organizationUrl"`
+	Project         string       `json:"project"`
+	StartedAt       time.Time    `json:"startedAt"`
+	FinishedAt      time.Time    `json:"finishedAt"`
+	Kinds           []KindResult `json:"kinds"`
+	Failed          int          `json:"failed"`
+}
+
+const projectBackupReportFile = "backup-report.json"
+
+// BackupProject runs the backup of every selected kind into projectRoot, the
+// folder of a new snapshot (see Snapshot). A failing kind is recorded and the
+// run moves on; the report is always written and an error is returned at the
+// end if any kind failed.
+func BackupProject(orgURL, project, projectRoot string, kinds []string, resourceGUID string, exec *ExecOptions) (*ProjectBackupReport, error) {
+	all := []string{"all"}
+
+	run := map[string]func(path string) error{
+		KindRepos: func(path string) error {
+			return BackupRepos(orgURL, project, path, all, exec)
+		},
+		KindBranchPolicies: func(path string) error {
+			return BackupBranchPolicies(orgURL, project, path, all, resourceGUID, exec)
+		},
+		KindBuildDefinitions: func(path string) error {
+			return BackupBuildDefinitions(orgURL, project, path, all, resourceGUID, exec)
+		},
+		KindReleaseDefinitions: func(path string) error {
+			return BackupReleaseDefinitions(orgURL, project, path, all, resourceGUID, exec)
+		},
+		KindYamlPipelines: func(path string) error {
+			return BackupYamlPipelines(orgURL, project, path, all, resourceGUID, exec)
+		},
+		KindTaskGroups: func(path string) error {
+			return BackupTaskGroups(orgURL, project, path, all, resourceGUID, exec)
+		},
+		KindServiceConnections: func(path string) error {
+			return BackupServiceConnections(orgURL, project, path, all, resourceGUID, exec)
+		},
+		KindVariableGroups: func(path string) error {
+			return BackupVariableGroups(orgURL, project, path, all, exec)
+		},
+		KindArtifactsFeeds: func(path string) error {
+			return BackupArtifactsFeeds(orgURL, project, path, resourceGUID, exec)
+		},
+		KindWikis: func(path string) error {
+			return BackupWikis(orgURL, project, path, all, resourceGUID, exec)
+		},
+	}
+
+	report := &ProjectBackupReport{
+		OrganizationURL: orgURL,
+		Project:         project,
+		StartedAt:       time.Now().UTC(),
+	}
+
+	for _, kind := range kinds {
+		fn, ok := run[kind]
+		if !ok {
+			return nil, fmt.Errorf("unknown kind '%s'", kind)
+		}
+
+		path := filepath.Join(projectRoot, KindDir(kind))
+		fmt.Printf("\n=== %s ===\n", kind)
+
+		start := time.Now()
+		err := fn(path)
+		res := KindResult{
+			Kind:     kind,
+			Status:   KindStatusOK,
+			Path:     path,
+			Duration: time.Since(start).Round(time.Millisecond).String(),
+		}
+		if err != nil {
+			res.Status = KindStatusFailed
+			res.Error = err.Error()
+			report.Failed++
+			fmt.Printf("⚠ %s backup failed: %v\n", kind, err)
+		}
+		report.Kinds = append(report.Kinds, res)
+	}
+
+	// Reference index for source-independent restores; recorded like a kind.
+	fmt.Printf("\n=== refs ===\n")
+	start := time.Now()
+	res := KindResult{
+		Kind:   "refs",
+		Status: KindStatusOK,
+		Path:   filepath.Join(projectRoot, RefIndexFile),
+	}
+	if err := WriteRefIndex(orgURL, project, projectRoot, resourceGUID); err != nil {
+		res.Status = KindStatusFailed
+		res.Error = err.Error()
+		report.Failed++
+		fmt.Printf("⚠ reference index failed: %v\n", err)
+	}
+	res.Duration = time.Since(start).Round(time.Millisecond).String()
+	report.Kinds = append(report.Kinds, res)
+
+	report.FinishedAt = time.Now().UTC()
+
+	if err := os.MkdirAll(projectRoot, 0755); err != nil {
+		return report, err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return report, err
+	}
+	if err := os.WriteFile(filepath.Join(projectRoot, projectBackupReportFile), data, 0644); err != nil {
+		return report, err
+	}
+
+	if report.Failed > 0 {
+		return report, fmt.Errorf("%d of %d kinds failed", report.Failed, len(report.Kinds))
+	}
+	return report, nil
+}
+
+// BackupRepos mirror-clones the selected repositories into backupPath,
+// starting from the mirrors of the previous snapshot and updating mirrors
+// that already exist. Every repo is attempted unless exec asks to fail fast;
+// failures are returned together.
+func BackupRepos(orgURL, project, backupPath string, selected []string, exec *ExecOptions) error {
+	repos, err := ListRepos(orgURL, project)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Found %d repos\n", len(repos))
+
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return err
+	}
+
+	var items []Repo
+	for _, r := range repos {
+		if !sel.Match(r.Name) {
+			continue
+		}
+		items = append(items, r)
+	}
+
+	seeds := mirrorSeeds(backupPath, KindRepos)
+	return forEach(len(items), exec.workers(KindRepos), exec.failFast(false), func(i int, out io.Writer) error {
+		r := items[i]
+		dest := filepath.Join(backupPath, r.Name+".git")
+		if err := backupMirror(r.RemoteURL, dest, seedMirror(seeds, r.Name+".git"), out); err != nil {
+			fmt.Fprintf(out, "⚠ Failed to back up repo %s: %v\n", r.Name, err)
+			return fmt.Errorf("repo %s: %w", r.Name, err)
+		}
+		fmt.Fprintln(out, "✔ Backed up repo:", r.Name)
+		return nil
+	})
+}
+
+// MirrorCloneRepos mirror-clones repos into reposPath ({name}.git), as
+// mirror-clone does, starting from the mirrors of the previous snapshot.
+// It stops at the first failure unless exec collects.
+func MirrorCloneRepos(repos []Repo, reposPath string, exec *ExecOptions) error {
+	seeds := mirrorSeeds(reposPath, KindRepos)
+	return forEach(len(repos), exec.workers(KindRepos), exec.failFast(true), func(i int, out io.Writer) error {
+		r := repos[i]
+		fmt.Fprintln(out, "Cloning:", r.Name)
+		if err := backupMirror(r.RemoteURL, filepath.Join(reposPath, r.Name+".git"), seedMirror(seeds, r.Name+".git"), out); err != nil {
+			return fmt.Errorf("repo %s: %w", r.Name, err)
+		}
+		return nil
+	})
+}
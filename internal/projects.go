@@ -3,7 +3,6 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
-	"os/exec"
 )
 
 type ProjectInfo struct {
@@ -14,18 +13,9 @@ type ProjectInfo struct {
 func GetProjectInfo(orgURL, projectName, resourceGUID string) (*ProjectInfo, error) {
 	uri := fmt.Sprintf("%s/_apis/projects/%s?api-version=7.1", orgURL, projectName)
 
-	cmd := exec.Command(
-		"az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoGet(orgURL, resourceGUID, uri)
 	if err != nil {
-		return nil, fmt.Errorf("az rest get project failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("get project failed: %w", err)
 	}
 
 	var p ProjectInfo
@@ -0,0 +1,278 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RefIndexFile is written to the folder of every backup snapshot (see Snapshot).
+const RefIndexFile = "refs.json"
+
+// RefIndex maps the IDs of a source project to names, captured at backup time.
+// Restores remap every reference by name through it, so they work after the
+// source org is gone. It generalizes PolicyBackupHints to every kind.
+// GUID keys are lower case; numeric IDs are stored as decimal strings.
+type RefIndex struct {
+	OrgURL           string                  `json:"orgUrl"`
+	OrgID            string                  `json:"orgId,omitempty"`
+	Project          string                  `json:"project"`
+	ProjectID        string                  `json:"projectId"`
+	CreatedAt        time.Time               `json:"createdAt"`
+	Repos            map[string]string       `json:"repos"`            // repo id -> name
+	Queues           map[string]string       `json:"queues"`           // agent queue id -> name
+	ServiceEndpoints map[string]string       `json:"serviceEndpoints"` // endpoint id -> name
+	VariableGroups   map[string]string       `json:"variableGroups"`   // group id -> name
+	TaskGroups       map[string]string       `json:"taskGroups"`       // task group id -> name
+	BuildDefinitions map[string]string       `json:"buildDefinitions"` // definition id -> name
+	Feeds            map[string]string       `json:"feeds,omitempty"`  // feed id -> name
+	Identities       map[string]IdentityHint `json:"identities"`       // identity id -> hint (policy reviewers)
+}
+
+// BuildRefIndex lists the project's resources and builds its reference index.
+// Identities are the reviewers of branch policies, resolved like
+// BackupBranchPolicies does; withIdentities=false skips them.
+func BuildRefIndex(orgURL, project, resourceGUID string, withIdentities bool) (*RefIndex, error) {
+	idx := &RefIndex{
+		OrgURL:           orgURL,
+		Project:          project,
+		CreatedAt:        time.Now().UTC(),
+		Repos:            map[string]string{},
+		Queues:           map[string]string{},
+		ServiceEndpoints: map[string]string{},
+		VariableGroups:   map[string]string{},
+		TaskGroups:       map[string]string{},
+		BuildDefinitions: map[string]string{},
+		Feeds:            map[string]string{},
+		Identities:       map[string]IdentityHint{},
+	}
+
+	pinfo, err := GetProjectInfo(orgURL, project, resourceGUID)
+	if err != nil {
+		return nil, fmt.Errorf("refs: %w", err)
+	}
+	idx.ProjectID = pinfo.Id
+
+	// the org id only goes into backup manifests; a host that hides it
+	// does not fail the backup
+	if idx.OrgID, err = organizationID(orgURL, resourceGUID); err != nil {
+		fmt.Printf("⚠ refs: could not read the organization id: %v\n", err)
+	}
+
+	repos, err := ListRepos(orgURL, project)
+	if err != nil {
+		return nil, fmt.Errorf("refs: %w", err)
+	}
+	for _, r := range repos {
+		idx.Repos[strings.ToLower(r.Id)] = r.Name
+	}
+
+	queues, err := ListTaskAgentQueues(orgURL, project, resourceGUID)
+	if err != nil {
+		return nil, fmt.Errorf("refs: %w", err)
+	}
+	for _, q := range queues {
+		idx.Queues[strconv.Itoa(q.ID)] = q.Name
+	}
+
+	endpoints, err := ListServiceConnections(orgURL, project, resourceGUID)
+	if err != nil {
+		return nil, fmt.Errorf("refs: %w", err)
+	}
+	for _, e := range endpoints {
+		idx.ServiceEndpoints[strings.ToLower(e.Id)] = e.Name
+	}
+
+	groups, err := ListVariableGroups(orgURL, project)
+	if err != nil {
+		return nil, fmt.Errorf("refs: %w", err)
+	}
+	for _, g := range groups {
+		idx.VariableGroups[strconv.Itoa(g.Id)] = g.Name
+	}
+
+	taskGroups, err := ListTaskGroups(orgURL, project, resourceGUID)
+	if err != nil {
+		return nil, fmt.Errorf("refs: %w", err)
+	}
+	for _, tg := range taskGroups {
+		idx.TaskGroups[strings.ToLower(tg.Id)] = tg.Name
+	}
+
+	defs, err := ListBuildDefinitions(orgURL, project, resourceGUID)
+	if err != nil {
+		return nil, fmt.Errorf("refs: %w", err)
+	}
+	for _, d := range defs {
+		idx.BuildDefinitions[strconv.Itoa(d.Id)] = d.Name
+	}
+
+	// not every collection has Azure Artifacts; feeds are only needed to
+	// rewrite feed ids in restored payloads
+	feeds, err := ListFeeds(orgURL, project, resourceGUID)
+	if err != nil {
+		fmt.Printf("⚠ refs: could not list feeds: %v\n", err)
+	}
+	for _, f := range feeds {
+		idx.Feeds[strings.ToLower(f.ID)] = f.Name
+	}
+
+	if withIdentities {
+		policies, err := ListPolicyConfigurations(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, fmt.Errorf("refs: %w", err)
+		}
+		for _, pc := range policies {
+			for _, id := range ExtractIdentityIDs(pc.Raw) {
+				if _, ok := idx.Identities[id]; ok {
+					continue
+				}
+				ih, err := GetIdentityById(orgURL, id, resourceGUID)
+				if err != nil || ih == nil {
+					fmt.Printf("⚠ refs: could not resolve identity id=%s: %v\n", id, err)
+					continue
+				}
+				idx.Identities[id] = *ih
+			}
+		}
+	}
+
+	return idx, nil
+}
+
+// WriteRefIndex builds the reference index of a project and writes it to
+// {projectRoot}/refs.json.
+func WriteRefIndex(orgURL, project, projectRoot, resourceGUID string) error {
+	idx, err := BuildRefIndex(orgURL, project, resourceGUID, true)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(projectRoot, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	fp := filepath.Join(projectRoot, RefIndexFile)
+	if err := writeBackupFile(fp, data); err != nil {
+		return err
+	}
+	fmt.Println("✔ Reference index written:", fp)
+	return nil
+}
+
+func LoadRefIndex(path string) (*RefIndex, error) {
+	data, err := readBackupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var idx RefIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed parsing %s: %w", path, err)
+	}
+	return &idx, nil
+}
+
+// sourceRefs returns the reference index for a restore from backupPath (a kind
+// folder, e.g. {snapshot}/build-definitions or {snapshot}/artifacts/feeds).
+// Backups taken before refs.json existed fall back to querying the live source.
+func sourceRefs(backupPath, sourceOrgURL, sourceProject, resourceGUID string) (*RefIndex, error) {
+	if refs, err := backupRefs(backupPath); refs != nil || err != nil {
+		return refs, err
+	}
+
+	fmt.Printf("⚠ No %s in backup; resolving source references from %s/%s\n", RefIndexFile, sourceOrgURL, sourceProject)
+	return BuildRefIndex(sourceOrgURL, sourceProject, resourceGUID, false)
+}
+
+// backupRefs loads the refs.json of the backup backupPath belongs to; nil
+// if it has none.
+func backupRefs(backupPath string) (*RefIndex, error) {
+	dir := filepath.Clean(backupPath)
+	for i := 0; i < 3; i++ {
+		fp := filepath.Join(dir, RefIndexFile)
+		if _, err := os.Stat(fp); err == nil {
+			return LoadRefIndex(fp)
+		}
+		dir = filepath.Dir(dir)
+	}
+	return nil, nil
+}
+
+// repoName returns the source repo name for id ("" if unknown).
+func (r *RefIndex) repoName(id string) string {
+	return r.Repos[strings.ToLower(strings.TrimSpace(id))]
+}
+
+// queueNames returns queue id -> name.
+func (r *RefIndex) queueNames() map[int]string {
+	out := map[int]string{}
+	for k, v := range r.Queues {
+		if id, err := strconv.Atoi(k); err == nil {
+			out[id] = v
+		}
+	}
+	return out
+}
+
+// variableGroupNames returns group id -> name.
+func (r *RefIndex) variableGroupNames() map[int]string {
+	out := map[int]string{}
+	for k, v := range r.VariableGroups {
+		if id, err := strconv.Atoi(k); err == nil {
+			out[id] = v
+		}
+	}
+	return out
+}
+
+// withPolicyHints fills the identity and build definition hints of a policy
+// payload from the index, for policies backed up without them.
+func (r *RefIndex) withPolicyHints(payload map[string]any) {
+	hints := readPolicyHints(payload)
+	if hints.Identities == nil {
+		hints.Identities = map[string]IdentityHint{}
+	}
+	if hints.BuildDefinitions == nil {
+		hints.BuildDefinitions = map[string]string{}
+	}
+	for _, id := range ExtractIdentityIDs(payload) {
+		if _, ok := hints.Identities[id]; !ok {
+			if h, ok := r.Identities[id]; ok {
+				hints.Identities[id] = h
+			}
+		}
+	}
+	if id, ok := ExtractBuildDefinitionId(payload); ok {
+		key := strconv.Itoa(id)
+		if _, ok := hints.BuildDefinitions[key]; !ok && r.BuildDefinitions[key] != "" {
+			hints.BuildDefinitions[key] = r.BuildDefinitions[key]
+		}
+	}
+	payload["_backupHints"] = hints
+}
+
+// organizationID returns the instance id of the organization (collection)
+// from {core}/_apis/connectionData.
+func organizationID(orgURL, resourceGUID string) (string, error) {
+	hosts, err := hostsFor(orgURL)
+	if err != nil {
+		return "", err
+	}
+	out, err := adoGet(orgURL, resourceGUID, hosts.Core+"/_apis/connectionData")
+	if err != nil {
+		return "", err
+	}
+	var data struct {
+		InstanceID string `json:"instanceId"`
+	}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return "", fmt.Errorf("parse connection data failed: %w", err)
+	}
+	return data.InstanceID, nil
+}
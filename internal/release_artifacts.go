@@ -7,7 +7,12 @@ import (
 	"strings"
 )
 
+// RemapReleaseArtifacts points the git / build artifacts of a release
+// definition at the target project, looking repos and build definitions up
+// by their target names (see Renamer) in idx.
 func RemapReleaseArtifacts(
+	idx *ResourceIndex,
+	names *Renamer,
 	payload map[string]any,
 	targetOrgURL, targetProject, resourceGUID string,
 ) error {
@@ -17,36 +22,13 @@ func RemapReleaseArtifacts(
 		return nil
 	}
 
-	tgtProj, err := GetProjectInfo(targetOrgURL, targetProject, resourceGUID)
+	tgtProj, err := idx.project(targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
 		return fmt.Errorf("get target project info failed: %w", err)
 	}
 	tgtProjectID := tgtProj.Id
 	tgtProjectName := tgtProj.Name
 
-	repos, err := ListRepos(targetOrgURL, targetProject)
-	if err != nil {
-		return fmt.Errorf("list target repos failed: %w", err)
-	}
-	repoIDByName := map[string]string{}
-	for _, r := range repos {
-		if r.Id != "" && r.Name != "" {
-			repoIDByName[strings.ToLower(r.Name)] = r.Id
-		}
-	}
-
-	// target build defs map (name -> id)
-	bdefs, err := ListBuildDefinitions(targetOrgURL, targetProject, resourceGUID)
-	if err != nil {
-		return fmt.Errorf("list target build definitions failed: %w", err)
-	}
-	buildIDByName := map[string]int{}
-	for _, d := range bdefs {
-		if d.Id != 0 && d.Name != "" {
-			buildIDByName[d.Name] = d.Id
-		}
-	}
-
 	for _, aAny := range arts {
 		a, _ := aAny.(map[string]any)
 		if a == nil {
@@ -77,13 +59,22 @@ func RemapReleaseArtifacts(
 			if strings.TrimSpace(repoName) == "" {
 				return fmt.Errorf("git artifact has empty repo name")
 			}
+			repoName = names.Name(KindRepos, repoName)
 
-			repoID := repoIDByName[strings.ToLower(repoName)]
+			repo, err := idx.repo(targetOrgURL, targetProject, repoName)
+			if err != nil {
+				return fmt.Errorf("list target repos failed: %w", err)
+			}
+			repoID := ""
+			if repo != nil {
+				repoID = repo.Id
+			}
 			if repoID == "" {
 				return fmt.Errorf("target repo not found for git artifact repo '%s'", repoName)
 			}
 
 			defObj["id"] = repoID
+			defObj["name"] = repoName
 
 			// sourceId: "{projectId}:{repoId}"
 			a["sourceId"] = fmt.Sprintf("%s:%s", tgtProjectID, repoID)
@@ -100,12 +91,23 @@ func RemapReleaseArtifacts(
 				return fmt.Errorf("build artifact has empty build definition name")
 			}
 
-			newBuildID := buildIDByName[buildName]
+			newBuildID := 0
+			for _, name := range names.Definitions(buildName) {
+				bdef, err := idx.buildDefinition(targetOrgURL, targetProject, resourceGUID, name)
+				if err != nil {
+					return fmt.Errorf("list target build definitions failed: %w", err)
+				}
+				if bdef != nil {
+					newBuildID, buildName = bdef.Id, name
+					break
+				}
+			}
 			if newBuildID == 0 {
 				return fmt.Errorf("target build definition not found for '%s'", buildName)
 			}
 
 			defObj["id"] = strconv.Itoa(newBuildID)
+			defObj["name"] = buildName
 
 			// sourceId: "{projectId}:{buildDefId}"
 			a["sourceId"] = fmt.Sprintf("%s:%d", tgtProjectID, newBuildID)
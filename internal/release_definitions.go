@@ -3,16 +3,15 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-)
 
-type ReleaseDefinitionListResponse struct {
-	Count int              `json:"count"`
-	Value []ReleaseSummary `json:"value"`
-}
+	"azdo-vault/internal/adoclient"
+)
 
 type ReleaseSummary struct {
 	Id   int            `json:"id,omitempty"`
@@ -36,44 +35,29 @@ func (r *ReleaseSummary) UnmarshalJSON(data []byte) error {
 }
 
 func ListReleaseDefinitions(orgURL, project, resourceGUID string) ([]ReleaseSummary, error) {
-	vsrmOrg := toVSRMBase(orgURL) // https://vsrm.dev.azure.com/{org}
-	uri := fmt.Sprintf("%s/%s/_apis/release/definitions?api-version=7.1", vsrmOrg, project)
-
-	cmd := exec.Command("az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	vsrmOrg, err := releaseBase(orgURL) // https://vsrm.dev.azure.com/{org}
 	if err != nil {
-		return nil, fmt.Errorf("az rest list release definitions failed: %w\n%s", err, string(out))
+		return nil, err
 	}
+	uri := fmt.Sprintf("%s/%s/_apis/release/definitions?api-version=7.1", vsrmOrg, project)
 
-	var resp ReleaseDefinitionListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing JSON: %w\nRaw:\n%s", err, string(out))
+	list, err := adoList[ReleaseSummary](orgURL, resourceGUID, uri, adoclient.Paging{})
+	if err != nil {
+		return nil, fmt.Errorf("list release definitions failed: %w", err)
 	}
-	return resp.Value, nil
+	return list, nil
 }
 
 func GetReleaseDefinition(orgURL, project, resourceGUID string, id int) (map[string]any, error) {
-	vsrmOrg := toVSRMBase(orgURL)
+	vsrmOrg, err := releaseBase(orgURL)
+	if err != nil {
+		return nil, err
+	}
 	uri := fmt.Sprintf("%s/%s/_apis/release/definitions/%d?api-version=7.1", vsrmOrg, project, id)
 
-	cmd := exec.Command("az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoGet(orgURL, resourceGUID, uri)
 	if err != nil {
-		return nil, fmt.Errorf("az rest get release definition failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("get release definition failed: %w", err)
 	}
 
 	var obj map[string]any
@@ -97,7 +81,10 @@ func FindReleaseDefinitionByName(orgURL, project, resourceGUID, name string) (ma
 }
 
 func CreateReleaseDefinition(orgURL, project, resourceGUID string, payload map[string]any) (int, error) {
-	vsrmOrg := toVSRMBase(orgURL)
+	vsrmOrg, err := releaseBase(orgURL)
+	if err != nil {
+		return 0, err
+	}
 	uri := fmt.Sprintf("%s/%s/_apis/release/definitions?api-version=7.1", vsrmOrg, project)
 
 	bodyBytes, err := json.Marshal(payload)
@@ -105,19 +92,9 @@ func CreateReleaseDefinition(orgURL, project, resourceGUID string, payload map[s
 		return 0, err
 	}
 
-	cmd := exec.Command("az", "rest",
-		"--method", "post",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--headers", "Content-Type=application/json",
-		"--body", string(bodyBytes),
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoSend(orgURL, resourceGUID, "post", uri, bodyBytes)
 	if err != nil {
-		return 0, fmt.Errorf("az rest create release definition failed: %w\n%s", err, string(out))
+		return 0, fmt.Errorf("create release definition failed: %w", err)
 	}
 
 	var created map[string]any
@@ -131,27 +108,80 @@ func CreateReleaseDefinition(orgURL, project, resourceGUID string, payload map[s
 	return 0, fmt.Errorf("created release definition but no id returned. Raw:\n%s", string(out))
 }
 
-func BackupReleaseDefinitions(orgURL, project, backupPath string, selected []string, resourceGUID string) error {
+// UpdateReleaseDefinition replaces current (the target definition as returned
+// by GetReleaseDefinition) with payload, using current's id and revision.
+// Stages are matched by name so existing ones keep their IDs; stages missing
+// from payload are removed, new ones are added.
+func UpdateReleaseDefinition(orgURL, project, resourceGUID string, current, payload map[string]any) error {
+	vsrmOrg, err := releaseBase(orgURL)
+	if err != nil {
+		return err
+	}
+
+	body := deepCopyMap(payload)
+	body["id"] = current["id"]
+	body["revision"] = current["revision"]
+
+	envIDByName := map[string]any{}
+	curEnvs, _ := current["environments"].([]any)
+	for _, e := range curEnvs {
+		if m, ok := e.(map[string]any); ok {
+			if n, ok := m["name"].(string); ok {
+				envIDByName[n] = m["id"]
+			}
+		}
+	}
+	envs, _ := body["environments"].([]any)
+	for _, e := range envs {
+		m, ok := e.(map[string]any)
+		if !ok {
+			continue
+		}
+		n, _ := m["name"].(string)
+		if id, ok := envIDByName[n]; ok {
+			m["id"] = id
+		} else {
+			m["id"] = 0
+		}
+	}
+
+	uri := fmt.Sprintf("%s/%s/_apis/release/definitions?api-version=7.1", vsrmOrg, project)
+	if _, err := adoSend(orgURL, resourceGUID, "put", uri, body); err != nil {
+		return fmt.Errorf("update release definition failed: %w", err)
+	}
+	return nil
+}
+
+func BackupReleaseDefinitions(orgURL, project, backupPath string, selected []string, resourceGUID string, exec *ExecOptions) error {
 	list, err := ListReleaseDefinitions(orgURL, project, resourceGUID)
 	if err != nil {
 		return err
 	}
 
-	backupAll := len(selected) == 1 && selected[0] == "all"
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return err
+	}
 	if len(list) == 0 {
 		fmt.Println("No release definitions found")
 		return nil
 	}
+	fmt.Printf("Found %d release definitions\n", len(list))
 
 	if err := os.MkdirAll(backupPath, 0755); err != nil {
 		return err
 	}
 
+	var items []ReleaseSummary
 	for _, d := range list {
-		if !backupAll && !contains(selected, d.Name) {
+		if !sel.MatchIn(pickString(d.Raw["path"]), d.Name) {
 			continue
 		}
+		items = append(items, d)
+	}
 
+	err = forEach(len(items), exec.workers(KindReleaseDefinitions), exec.failFast(true), func(i int, out io.Writer) error {
+		d := items[i]
 		full, err := GetReleaseDefinition(orgURL, project, resourceGUID, d.Id)
 		if err != nil {
 			return err
@@ -162,11 +192,15 @@ func BackupReleaseDefinitions(orgURL, project, backupPath string, selected []str
 		if err != nil {
 			return err
 		}
-		if err := os.WriteFile(fp, data, 0644); err != nil {
+		if err := writeBackupFile(fp, data); err != nil {
 			return err
 		}
 
-		fmt.Println("✔ Backed up release definition:", d.Name)
+		fmt.Fprintln(out, "✔ Backed up release definition:", d.Name)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -179,65 +213,81 @@ func RestoreReleaseDefinitionsFromBackup(
 	resourceGUID string,
 	queueMapPairs []string,
 	defaultQueue string,
-) error {
+	opts *RestoreOptions,
+) ([]RestoreResult, error) {
+	run := newRestoreRun(KindReleaseDefinitions, opts)
 	files, err := os.ReadDir(backupPath)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 
-	restoreAll := len(selected) == 1 && selected[0] == "all"
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return run.results, err
+	}
 
-	targetQueues, err := ListTaskAgentQueues(targetOrgURL, targetProject, resourceGUID)
+	targetQueues, err := run.idx.queues(targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 	targetQueueIDByName := map[string]int{}
 	for _, q := range targetQueues {
 		targetQueueIDByName[q.Name] = q.ID
 	}
 
-	sourceQueueIDToName, err := buildQueueIDToName(sourceOrgURL, sourceProject, resourceGUID)
+	refs, err := sourceRefs(backupPath, sourceOrgURL, sourceProject, resourceGUID)
 	if err != nil {
-		return fmt.Errorf("failed to list source queues: %w", err)
+		return run.results, err
+	}
+	if err := run.rewriteFrom(refs, targetOrgURL, targetProject, resourceGUID); err != nil {
+		return run.results, err
 	}
+	sourceQueueIDToName := refs.queueNames()
 
 	queueMap, err := parseKeyValuePairs(queueMapPairs)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 
 	if defaultQueue != "" {
 		if _, ok := targetQueueIDByName[defaultQueue]; !ok {
-			return fmt.Errorf("default-queue '%s' not found in target project queues", defaultQueue)
+			return run.results, fmt.Errorf("default-queue '%s' not found in target project queues", defaultQueue)
 		}
 	}
 
-	srcEPIDToName, tgtEPNameToID, err := BuildEndpointMaps(sourceOrgURL, sourceProject, targetOrgURL, targetProject, resourceGUID)
+	srcEPIDToName, tgtEPNameToID, err := BuildEndpointMaps(run.idx, run.names, refs, targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return fmt.Errorf("failed to build service connection maps: %w", err)
+		return run.results, fmt.Errorf("failed to build service connection maps: %w", err)
 	}
 
-	srcVGIDToName, tgtVGNameToID, err := BuildVarGroupMaps(sourceOrgURL, sourceProject, targetOrgURL, targetProject)
+	srcVGIDToName, tgtVGNameToID, err := BuildVarGroupMaps(run.idx, run.names, refs, targetOrgURL, targetProject)
 	if err != nil {
-		return fmt.Errorf("failed to build variable group maps: %w", err)
+		return run.results, fmt.Errorf("failed to build variable group maps: %w", err)
 	}
 
-	srcTGIDToName, tgtTGNameToID, err := BuildTaskGroupMaps(sourceOrgURL, sourceProject, targetOrgURL, targetProject, resourceGUID)
+	srcTGIDToName, tgtTGNameToID, err := BuildTaskGroupMaps(run.idx, run.names, refs, targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return fmt.Errorf("failed to build task group maps: %w", err)
+		return run.results, fmt.Errorf("failed to build task group maps: %w", err)
 	}
 
+	var items []os.DirEntry
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name(), ".json") {
 			continue
 		}
 		name := strings.TrimSuffix(f.Name(), ".json")
-		if !restoreAll && !contains(selected, name) {
+		if !sel.matchFile(filepath.Join(backupPath, f.Name()), name) {
 			continue
 		}
+		items = append(items, f)
+	}
+
+	err = run.each(len(items), func(i int, item *restoreRun) error {
+		f := items[i]
+		name := strings.TrimSuffix(f.Name(), ".json")
 
 		fp := filepath.Join(backupPath, f.Name())
-		b, err := os.ReadFile(fp)
+		b, err := readBackupFile(fp)
 		if err != nil {
 			return err
 		}
@@ -247,21 +297,59 @@ func RestoreReleaseDefinitionsFromBackup(
 			return err
 		}
 
-		existing, err := FindReleaseDefinitionByName(targetOrgURL, targetProject, resourceGUID, name)
+		sourceID := strconv.Itoa(intFromAny(full["id"]))
+
+		sourceName := name
+		name = item.rename(sourceName)
+		full["name"] = name
+		if path, _ := full["path"].(string); path != "" {
+			if moved := item.names.Folder(path); moved != path {
+				item.printf("Moving release definition '%s' from folder '%s' to '%s'\n", name, path, moved)
+				full["path"] = moved
+			}
+		}
+
+		existing, err := item.idx.releaseDefinition(targetOrgURL, targetProject, resourceGUID, name)
 		if err != nil {
 			return err
 		}
 		if existing != nil {
-			fmt.Println("✔ Release definition exists, skipping:", name)
-			continue
+			alt, ok, err := item.collide(sourceName, name, func(n string) (bool, error) {
+				d, err := item.idx.releaseDefinition(targetOrgURL, targetProject, resourceGUID, n)
+				return d != nil, err
+			})
+			if err != nil {
+				return err
+			}
+			if ok {
+				name, existing = alt, nil
+				full["name"] = alt
+			}
+		}
+		if existing != nil && !opts.updates(KindReleaseDefinitions) {
+			item.println("✔ Release definition exists, skipping:", name)
+			item.exists(name, sourceID, strconv.Itoa(intFromAny(existing["id"])))
+			return nil
+		}
+		if existing == nil && !opts.creates(KindReleaseDefinitions) {
+			item.println("Release definition not in target, skipping:", name)
+			item.missing(name, sourceID)
+			return nil
 		}
 
 		payload := sanitizeReleaseDefinitionForCreate(full)
 
 		// ✅ Remap artifact project/repo/build ids FIRST (before post)
-		if err := RemapReleaseArtifacts(payload, targetOrgURL, targetProject, resourceGUID); err != nil {
-			fmt.Printf("⚠ Skipping release '%s': artifact remap failed: %s\n", name, err.Error())
-			continue
+		if err := RemapReleaseArtifacts(item.idx, item.names, payload, targetOrgURL, targetProject, resourceGUID); err != nil {
+			item.printf("⚠ Skipping release '%s': artifact remap failed: %s\n", name, err.Error())
+			item.unresolvable(name, sourceID, "artifact remap failed: "+err.Error())
+			return nil
+		}
+
+		if err := RemapReleaseIdentities(item.ids, payload, targetOrgURL, resourceGUID); err != nil {
+			item.printf("⚠ Skipping release '%s': identity mapping failed: %s\n", name, err.Error())
+			item.unresolvable(name, sourceID, "identity mapping failed: "+err.Error())
+			return nil
 		}
 
 		targetQName, targetQID, qerr := remapReleaseQueues(
@@ -273,12 +361,13 @@ func RestoreReleaseDefinitionsFromBackup(
 		)
 
 		if qerr != nil {
-			fmt.Printf("⚠ Skipping release '%s': queue not resolved (%s). Provide --default-queue or fix --queue-map.\n", name, qerr.Error())
-			continue
+			item.printf("⚠ Skipping release '%s': queue not resolved (%s). Provide --default-queue or fix --queue-map.\n", name, qerr.Error())
+			item.unresolvable(name, sourceID, "queue not resolved: "+qerr.Error())
+			return nil
 		}
-		fmt.Printf("Queue mapped for release '%s': %s (id=%d)\n", name, targetQName, targetQID)
+		item.printf("Queue mapped for release '%s': %s (id=%d)\n", name, targetQName, targetQID)
 
-		RemapReleaseDefinitionRefsByName(
+		warnings := RemapReleaseDefinitionRefsByName(
 			payload,
 			srcEPIDToName,
 			tgtEPNameToID,
@@ -287,17 +376,51 @@ func RestoreReleaseDefinitionsFromBackup(
 			srcTGIDToName,
 			tgtTGNameToID,
 		)
+		for _, w := range warnings {
+			item.printf("⚠ %s\n", w)
+		}
 
-		fmt.Println("Creating release definition:", name)
-		if _, err := CreateReleaseDefinition(targetOrgURL, targetProject, resourceGUID, payload); err != nil {
-			fmt.Printf("⚠ Failed creating release definition '%s'.\n%s\n", name, err.Error())
-			continue
+		item.rewrite(payload)
+
+		if existing != nil {
+			targetID := intFromAny(existing["id"])
+			if item.planned(name, sourceID, ActionUpdate, payload, warnings) {
+				return nil
+			}
+			item.println("Updating release definition:", name)
+			current, err := GetReleaseDefinition(targetOrgURL, targetProject, resourceGUID, targetID)
+			if err == nil {
+				err = UpdateReleaseDefinition(targetOrgURL, targetProject, resourceGUID, current, payload)
+			}
+			if err != nil {
+				item.printf("⚠ Failed updating release definition '%s'.\n%s\n", name, err.Error())
+			}
+			item.done(name, sourceID, ActionUpdate, strconv.Itoa(targetID), warnings, err)
+			return nil
 		}
 
+		if item.planned(name, sourceID, ActionCreate, payload, warnings) {
+			return nil
+		}
+
+		item.println("Creating release definition:", name)
+		id, err := CreateReleaseDefinition(targetOrgURL, targetProject, resourceGUID, payload)
+		if err != nil {
+			item.printf("⚠ Failed creating release definition '%s'.\n%s\n", name, err.Error())
+			item.done(name, sourceID, ActionCreate, "", warnings, err)
+			return nil
+		}
+		item.idx.addReleaseDefinition(targetOrgURL, targetProject, id, name)
+		item.done(name, sourceID, ActionCreate, strconv.Itoa(id), warnings, nil)
+		item.created(sourceName, name)
+		return nil
+	})
+	if err != nil {
+		return run.results, err
 	}
 
-	fmt.Println("✔ Release definitions restore finished")
-	return nil
+	run.println("✔ Release definitions restore finished")
+	return run.results, nil
 }
 
 // ---- helpers ----
@@ -325,6 +448,54 @@ func sanitizeReleaseDefinitionForCreate(full map[string]any) map[string]any {
 	return payload
 }
 
+// RemapReleaseIdentities replaces the owner and the pre- and post-deployment
+// approvers of every stage with their target identities. An identity that
+// does not resolve to exactly one target user or group fails the whole
+// definition; automated approvals carry no approver and are left alone.
+func RemapReleaseIdentities(ids *IdentityResolver, payload map[string]any, targetOrgURL, resourceGUID string) error {
+	var failed []string
+	remap := func(ref map[string]any) map[string]any {
+		hint := IdentityHint{
+			UniqueName:  pickString(ref["uniqueName"]),
+			DisplayName: pickString(ref["displayName"]),
+			Descriptor:  pickString(ref["descriptor"]),
+		}
+		tid, err := ids.Resolve(targetOrgURL, resourceGUID, hint)
+		if err != nil {
+			failed = append(failed, err.Error())
+			return ref
+		}
+		return map[string]any{"id": tid}
+	}
+
+	envs, _ := payload["environments"].([]any)
+	for _, e := range envs {
+		env, _ := e.(map[string]any)
+		if env == nil {
+			continue
+		}
+		if owner, ok := env["owner"].(map[string]any); ok {
+			env["owner"] = remap(owner)
+		}
+		for _, key := range []string{"preDeployApprovals", "postDeployApprovals"} {
+			block, _ := env[key].(map[string]any)
+			approvals, _ := block["approvals"].([]any)
+			for _, a := range approvals {
+				approval, _ := a.(map[string]any)
+				if approver, ok := approval["approver"].(map[string]any); ok {
+					approval["approver"] = remap(approver)
+				}
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		return fmt.Errorf("%s", strings.Join(slices.Compact(failed), "; "))
+	}
+	return nil
+}
+
 func deepCopyMap(in map[string]any) map[string]any {
 	b, _ := json.Marshal(in)
 	var out map[string]any
@@ -332,20 +503,6 @@ func deepCopyMap(in map[string]any) map[string]any {
 	return out
 }
 
-// orgURL: https://dev.azure.com/{org}  -> https://vsrm.dev.azure.com/{org}
-func toVSRMBase(orgURL string) string {
-	u := strings.TrimRight(orgURL, "/")
-	// supports both dev.azure.com/{org} and https://{org}.visualstudio.com
-	if strings.Contains(u, "dev.azure.com/") {
-		parts := strings.Split(u, "dev.azure.com/")
-		if len(parts) == 2 && parts[1] != "" {
-			return "https://vsrm.dev.azure.com/" + parts[1]
-		}
-	}
-	// fallback: if user already gave vsrm or something else
-	return strings.Replace(u, "https://dev.azure.com", "https://vsrm.dev.azure.com", 1)
-}
-
 // Azure DevOps release definition POST wants variableGroups as objects: [{ "id": 15 }]
 func setReleaseVariableGroups(payload map[string]any, ids []int) {
 	// out := make([]any, 0, len(ids))
@@ -373,6 +530,7 @@ func readVarGroupIDAny(v any) int {
 }
 
 // Remap variableGroups + service endpoints + taskgroups in a release definition payload.
+// Variable groups missing in the target are dropped; it returns a warning for each.
 func RemapReleaseDefinitionRefsByName(
 	payload map[string]any,
 	srcEndpointIDToName map[string]string,
@@ -381,7 +539,8 @@ func RemapReleaseDefinitionRefsByName(
 	tgtVarGroupNameToID map[string]int,
 	srcTaskGroupIDToName map[string]string,
 	tgtTaskGroupNameToID map[string]string,
-) {
+) []string {
+	var warnings []string
 	// ReleaseDefinition.variableGroups is int[] (or may come expanded). We remap by name.
 	if vgs, ok := payload["variableGroups"].([]any); ok {
 		outIDs := make([]int, 0, len(vgs))
@@ -402,8 +561,8 @@ func RemapReleaseDefinitionRefsByName(
 			} else {
 				// IMPORTANT: do NOT keep source IDs in target payload
 				// (those IDs don't exist in target and can break create)
-				fmt.Printf("⚠ %s: pipeline variable group '%s' (src id=%d) not found in target; dropping\n",
-					fmt.Sprint(payload["name"]), name, srcID)
+				warnings = append(warnings, fmt.Sprintf("%s: pipeline variable group '%s' (src id=%d) not found in target; dropping",
+					fmt.Sprint(payload["name"]), name, srcID))
 			}
 		}
 
@@ -432,8 +591,8 @@ func RemapReleaseDefinitionRefsByName(
 					if newID, ok := tgtVarGroupNameToID[strings.ToLower(name)]; ok && newID != 0 {
 						outIDs = append(outIDs, newID)
 					} else {
-						fmt.Printf("⚠ %s: stage variable group '%s' (src id=%d) not found in target; dropping\n",
-							fmt.Sprint(payload["name"]), name, srcID)
+						warnings = append(warnings, fmt.Sprintf("%s: stage variable group '%s' (src id=%d) not found in target; dropping",
+							fmt.Sprint(payload["name"]), name, srcID))
 					}
 				}
 				env["variableGroups"] = outIDs
@@ -479,6 +638,7 @@ func RemapReleaseDefinitionRefsByName(
 			}
 		}
 	}
+	return warnings
 }
 
 func setReleaseQueueID(payload map[string]any, queueID int) {
@@ -500,20 +660,6 @@ func setReleaseQueueID(payload map[string]any, queueID int) {
 
 // ---- Release queue mapping helpers ----
 
-func buildQueueIDToName(orgURL, project, resourceGUID string) (map[int]string, error) {
-	qs, err := ListTaskAgentQueues(orgURL, project, resourceGUID)
-	if err != nil {
-		return nil, err
-	}
-	m := map[int]string{}
-	for _, q := range qs {
-		if q.ID != 0 && q.Name != "" {
-			m[q.ID] = q.Name
-		}
-	}
-	return m, nil
-}
-
 func extractReleaseQueueIDs(payload map[string]any) []int {
 	ids := []int{}
 
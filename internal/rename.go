@@ -0,0 +1,309 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RenameFolders is the rename rules key for the folder path of build,
+// release and YAML pipeline definitions (e.g. "\Team\CI").
+const RenameFolders = "folders"
+
+// RenameRule rewrites the target name of an item. With Match and Replace
+// it is a regular expression replacement ($1 expands a group); with
+// Template the name becomes the template, where {name} is the current name
+// (e.g. "Payments-{name}"). A template rule with Match only applies to names
+// that match.
+type RenameRule struct {
+	Match    string `yaml:"match,omitempty"`
+	Replace  string `yaml:"replace,omitempty"`
+	Template string `yaml:"template,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (r *RenameRule) compile() error {
+	switch {
+	case r.Template != "" && r.Replace != "":
+		return fmt.Errorf("rule has both replace and template")
+	case r.Template == "" && r.Match == "":
+		return fmt.Errorf("rule needs match and replace, or a template")
+	case r.Template != "" && !strings.Contains(r.Template, "{name}") && r.Match == "":
+		return fmt.Errorf("template '%s' without {name} gives every item the same name", r.Template)
+	}
+	if r.Match == "" {
+		return nil
+	}
+	re, err := regexp.Compile(r.Match)
+	if err != nil {
+		return fmt.Errorf("bad match '%s': %w", r.Match, err)
+	}
+	r.re = re
+	return nil
+}
+
+func (r *RenameRule) apply(name string) string {
+	if r.re != nil && !r.re.MatchString(name) {
+		return name
+	}
+	if r.Template != "" {
+		return strings.ReplaceAll(r.Template, "{name}", name)
+	}
+	return r.re.ReplaceAllString(name, r.Replace)
+}
+
+// ParseRenameTemplate parses a --rename value, "KIND=TEMPLATE" (e.g.
+// "repos=Payments-{name}"), where KIND may be "all" for every kind.
+func ParseRenameTemplate(value string) (string, RenameRule, error) {
+	kind, tmpl, ok := strings.Cut(value, "=")
+	kind = strings.TrimSpace(kind)
+	if !ok || kind == "" || tmpl == "" {
+		return "", RenameRule{}, fmt.Errorf("invalid --rename '%s' (use KIND=TEMPLATE, e.g. repos=Payments-{name})", value)
+	}
+	if err := validateRenameKind(kind); err != nil {
+		return "", RenameRule{}, err
+	}
+	r := RenameRule{Template: tmpl}
+	if err := r.compile(); err != nil {
+		return "", RenameRule{}, fmt.Errorf("invalid --rename '%s': %w", value, err)
+	}
+	return kind, r, nil
+}
+
+func validateRenameKind(kind string) error {
+	if kind == "all" || kind == RenameFolders || contains(AllKinds, kind) {
+		return nil
+	}
+	return fmt.Errorf("unknown rename kind '%s' (use all, %s or %s)", kind, RenameFolders, strings.Join(AllKinds, ", "))
+}
+
+// compileRenameRules checks and compiles rules keyed by kind.
+func compileRenameRules(rules map[string][]RenameRule) error {
+	for kind, list := range rules {
+		if err := validateRenameKind(kind); err != nil {
+			return err
+		}
+		for i := range list {
+			if err := list[i].compile(); err != nil {
+				return fmt.Errorf("rename %s rule %d: %w", kind, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Renamer gives every restored item its target name, so that the item and
+// every reference to it agree: an explicit mapping (Mappings.Repos, ...)
+// wins, else the rename rules of the item's kind run in order, each on the
+// result of the previous one; rules under "all" run after the kind's own.
+//
+// It also keeps a ledger of the names the restores created and the names
+// picked by the suffix collision strategy, so that a re-run updates or skips
+// its own items instead of suffixing them again. With a ledger file (see
+// Load) this holds across commands and runs.
+//
+// One Renamer is meant to live for one command run and be shared by every
+// restorer in it. It is safe for concurrent use. A nil *Renamer keeps every
+// name.
+type Renamer struct {
+	maps  *Mappings
+	rules map[string][]RenameRule
+
+	mu     sync.Mutex
+	ledger namesLedger
+	path   string
+}
+
+// namesLedger is the content of a names file. Keys are lower case.
+type namesLedger struct {
+	Picked  map[string]map[string]string `json:"picked"`  // kind -> source name -> name picked by suffix
+	Created map[string]map[string]string `json:"created"` // kind -> target name -> source name
+}
+
+// NewRenamer returns a renamer for the mappings and rename rules of maps
+// plus extra rules (from --rename), which run after them.
+func NewRenamer(maps *Mappings, extra map[string][]RenameRule) (*Renamer, error) {
+	rules := map[string][]RenameRule{}
+	if maps != nil {
+		for kind, list := range maps.Renames {
+			rules[kind] = append(rules[kind], list...)
+		}
+	}
+	for kind, list := range extra {
+		rules[kind] = append(rules[kind], list...)
+	}
+	if err := compileRenameRules(rules); err != nil {
+		return nil, err
+	}
+	return &Renamer{maps: maps, rules: rules}, nil
+}
+
+// Load reads the ledger of earlier runs from path. With save, this run's
+// additions are written back to it.
+func (r *Renamer) Load(path string, save bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		if err := json.Unmarshal(data, &r.ledger); err != nil {
+			return fmt.Errorf("failed parsing %s: %w", path, err)
+		}
+	}
+	if save {
+		r.path = path
+	}
+	return nil
+}
+
+// Name returns the target name of a source item of kind.
+func (r *Renamer) Name(kind, name string) string {
+	if r == nil {
+		return name
+	}
+	if kind == StepPush {
+		kind = KindRepos // push follows the repos it pushes into
+	}
+	r.mu.Lock()
+	tgt, ok := r.ledger.Picked[kind][strings.ToLower(name)]
+	r.mu.Unlock()
+	if ok {
+		return tgt
+	}
+	return r.ruleName(kind, name)
+}
+
+// ruleName is Name without the names picked by suffix.
+func (r *Renamer) ruleName(kind, name string) string {
+	if r.maps != nil {
+		var table map[string]string
+		switch kind {
+		case KindRepos:
+			table = r.maps.Repos
+		case KindServiceConnections:
+			table = r.maps.ServiceConnections
+		case KindVariableGroups:
+			table = r.maps.VariableGroups
+		case KindTaskGroups:
+			table = r.maps.TaskGroups
+		}
+		if tgt := mapName(table, name); tgt != name {
+			return tgt
+		}
+	}
+
+	out := name
+	for _, rule := range r.rules[kind] {
+		out = rule.apply(out)
+	}
+	if kind != RenameFolders {
+		for _, rule := range r.rules["all"] {
+			out = rule.apply(out)
+		}
+	}
+	return out
+}
+
+// Definitions returns the target names a build definition or YAML pipeline
+// called name may have; references to one (release build artifacts, build
+// validation policies) do not tell the two kinds apart.
+func (r *Renamer) Definitions(name string) []string {
+	names := []string{r.Name(KindBuildDefinitions, name)}
+	if y := r.Name(KindYamlPipelines, name); !strings.EqualFold(y, names[0]) {
+		names = append(names, y)
+	}
+	return names
+}
+
+// Folder returns the target folder of a definition folder path.
+func (r *Renamer) Folder(path string) string {
+	if r == nil || path == "" {
+		return path
+	}
+	return r.ruleName(RenameFolders, path)
+}
+
+// owns reports whether a restore created the target item name of kind.
+func (r *Renamer) owns(kind, name string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.ledger.Created[kind][strings.ToLower(name)]
+	return ok
+}
+
+// created records that the item of source was created as name.
+func (r *Renamer) created(kind, source, name string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	setLedger(&r.ledger.Created, kind, name, source)
+	return r.save()
+}
+
+// claimSuffixed picks the first name "{name}-2", "{name}-3", ... that is not
+// taken in the target nor picked for another item, and remembers it as the
+// target name of source.
+func (r *Renamer) claimSuffixed(kind, source, name string, taken func(name string) (bool, error)) (string, error) {
+	if r == nil {
+		return "", fmt.Errorf("no renamer to record '%s' under", source)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	picked := map[string]bool{}
+	for _, tgt := range r.ledger.Picked[kind] {
+		picked[strings.ToLower(tgt)] = true
+	}
+	for n := 2; ; n++ {
+		alt := fmt.Sprintf("%s-%d", name, n)
+		if picked[strings.ToLower(alt)] {
+			continue
+		}
+		t, err := taken(alt)
+		if err != nil {
+			return "", err
+		}
+		if t {
+			continue
+		}
+		setLedger(&r.ledger.Picked, kind, source, alt)
+		return alt, r.save()
+	}
+}
+
+func setLedger(table *map[string]map[string]string, kind, key, value string) {
+	if *table == nil {
+		*table = map[string]map[string]string{}
+	}
+	if (*table)[kind] == nil {
+		(*table)[kind] = map[string]string{}
+	}
+	(*table)[kind][strings.ToLower(key)] = value
+}
+
+// save writes the ledger to the names file, if any; the caller holds r.mu.
+func (r *Renamer) save() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(&r.ledger, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, data, 0644)
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SelectBackupRepos returns the repos of the mirrors in reposDir (see mirrorName)
+// that selected picks (see Selector). A list of plain names is returned as
+// is, mirrored or not.
+func SelectBackupRepos(reposDir string, selected []string) ([]string, error) {
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return nil, err
+	}
+	if names, ok := sel.Literal(); ok {
+		return names, nil
+	}
+
+	entries, err := os.ReadDir(reposDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading backup repos: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		name, ok := mirrorName(e.Name())
+		if ok && sel.Match(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// RestoreRepos creates the named (empty) repositories in the target project
+// under their target names, skipping the ones that already exist (or, with
+// the suffix collision strategy, creating them under a free name). Content
+// is pushed by PushRepos.
+func RestoreRepos(targetOrgURL, targetProject string, names []string, opts *RestoreOptions) ([]RestoreResult, error) {
+	run := newRestoreRun(KindRepos, opts)
+
+	err := run.each(len(names), func(i int, item *restoreRun) error {
+		repo := item.rename(names[i])
+		exists, err := RepoExists(targetOrgURL, targetProject, repo)
+		if err != nil {
+			return err
+		}
+		if exists {
+			alt, ok, err := item.collide(names[i], repo, func(n string) (bool, error) {
+				return RepoExists(targetOrgURL, targetProject, n)
+			})
+			if err != nil {
+				return err
+			}
+			if !ok {
+				item.println("✔ Already exists:", repo)
+				item.exists(repo, "", "")
+				return nil
+			}
+			repo = alt
+		}
+		if item.planned(repo, "", ActionCreate, map[string]any{"name": repo}, nil) {
+			return nil
+		}
+
+		item.println("Creating:", repo)
+		err = CreateRepo(targetOrgURL, targetProject, repo)
+		item.idx.forget(targetOrgURL, targetProject, KindRepos)
+		item.done(repo, "", ActionCreate, "", nil, err)
+		if err != nil {
+			return fmt.Errorf("failed creating repo %s: %w", repo, err)
+		}
+		item.created(names[i], repo)
+		return nil
+	})
+	return run.results, err
+}
+
+// PushRepos pushes all branches and tags of the mirrors in reposPath
+// ({name}.git, or its bundle) into the repositories of their target names in the target
+// project.
+func PushRepos(reposPath, targetOrgURL, targetProject string, names []string, opts *RestoreOptions) ([]RestoreResult, error) {
+	run := newRestoreRun(StepPush, opts)
+
+	err := run.each(len(names), func(i int, item *restoreRun) error {
+		repo := item.names.Name(StepPush, names[i])
+		if item.planned(repo, "", ActionUpdate, "push all branches and tags", nil) {
+			return nil
+		}
+
+		remoteURL, err := GetRepoRemoteURL(targetOrgURL, targetProject, repo)
+		if err != nil {
+			item.done(repo, "", ActionUpdate, "", nil, err)
+			return err
+		}
+
+		mirror, done, err := openMirror(filepath.Join(reposPath, names[i]+".git"))
+		if err != nil {
+			item.done(repo, "", ActionUpdate, "", nil, err)
+			return err
+		}
+		defer done()
+
+		item.println("Pushing:", repo)
+		err = PushAllAndTags(mirror, remoteURL, item.out)
+		item.done(repo, "", ActionUpdate, "", nil, err)
+		return err
+	})
+	return run.results, err
+}
@@ -1,9 +1,7 @@
 package internal
 
 import (
-	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strings"
 )
 
@@ -29,25 +27,8 @@ func UpdateRepoDefaultBranch(orgURL, project, repoID, defaultBranch, resourceGUI
 	body := map[string]any{
 		"defaultBranch": defaultBranch,
 	}
-	bodyBytes, _ := json.Marshal(body)
-
-	args := []string{
-		"rest",
-		"--method", "patch",
-		"--uri", uri,
-		"--headers", "Content-Type=application/json",
-		"--body", string(bodyBytes),
-		"--only-show-errors",
-		"--output", "none",
-	}
-	if strings.TrimSpace(resourceGUID) != "" {
-		args = append(args, "--resource", resourceGUID)
-	}
-
-	cmd := exec.Command("az", args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("az rest update repo default branch failed: %w\n%s", err, string(out))
+	if _, err := adoSend(orgURL, resourceGUID, "patch", uri, body); err != nil {
+		return fmt.Errorf("update repo default branch failed: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,319 @@
+package internal
+
+import (
+	"strings"
+	"sync"
+)
+
+// ResourceIndex caches the target listings a restore looks things up in
+// (definitions, groups, connections, repos, queues, project info), so each
+// restored item does not re-list the whole collection.
+//
+// Entries are keyed by org URL, project and kind, loaded on first use and
+// kept up to date with what the run itself creates. One index is meant to
+// live for one command run and be shared by every restorer in it (see
+// RestoreOptions.Index). It is safe for concurrent use. A nil *ResourceIndex
+// caches nothing and lists on every lookup.
+type ResourceIndex struct {
+	mu      sync.Mutex
+	entries map[indexKey]*indexEntry
+}
+
+func NewResourceIndex() *ResourceIndex {
+	return &ResourceIndex{entries: map[indexKey]*indexEntry{}}
+}
+
+// Index kinds that are not backup kinds.
+const (
+	indexProject = "project"
+	indexQueues  = "queues"
+)
+
+type indexKey struct {
+	orgURL, project, kind string
+}
+
+type indexEntry struct {
+	mu     sync.Mutex
+	loaded bool
+	list   any            // []T as loaded, plus what the run created
+	byName map[string]int // lookup key -> position in list
+}
+
+func (x *ResourceIndex) entry(orgURL, project, kind string) *indexEntry {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	k := indexKey{strings.ToLower(strings.TrimRight(orgURL, "/")), strings.ToLower(project), kind}
+	e := x.entries[k]
+	if e == nil {
+		e = &indexEntry{}
+		x.entries[k] = e
+	}
+	return e
+}
+
+// forget drops a kind, so the next lookup lists it again. Used after writes
+// that do not return the created object.
+func (x *ResourceIndex) forget(orgURL, project, kind string) {
+	if x == nil {
+		return
+	}
+	e := x.entry(orgURL, project, kind)
+	e.mu.Lock()
+	e.loaded, e.list, e.byName = false, nil, nil
+	e.mu.Unlock()
+}
+
+// indexLoad makes sure the entry is loaded; the caller holds e.mu.
+func indexLoad[T any](e *indexEntry, load func() ([]T, error), key func(T) string) error {
+	if e.loaded {
+		return nil
+	}
+	list, err := load()
+	if err != nil {
+		return err
+	}
+	e.byName = make(map[string]int, len(list))
+	for i, v := range list {
+		if k := key(v); k != "" {
+			if _, dup := e.byName[k]; !dup {
+				e.byName[k] = i
+			}
+		}
+	}
+	e.list, e.loaded = list, true
+	return nil
+}
+
+// indexAll returns a copy of the listing of one kind.
+func indexAll[T any](x *ResourceIndex, orgURL, project, kind string, load func() ([]T, error), key func(T) string) ([]T, error) {
+	if x == nil {
+		return load()
+	}
+	e := x.entry(orgURL, project, kind)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := indexLoad(e, load, key); err != nil {
+		return nil, err
+	}
+	return append([]T(nil), e.list.([]T)...), nil
+}
+
+// indexFind returns the item whose key is name, or nil.
+func indexFind[T any](x *ResourceIndex, orgURL, project, kind string, load func() ([]T, error), key func(T) string, name string) (*T, error) {
+	if x == nil {
+		list, err := load()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range list {
+			if key(v) == name {
+				return &v, nil
+			}
+		}
+		return nil, nil
+	}
+	e := x.entry(orgURL, project, kind)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := indexLoad(e, load, key); err != nil {
+		return nil, err
+	}
+	i, ok := e.byName[name]
+	if !ok {
+		return nil, nil
+	}
+	v := e.list.([]T)[i]
+	return &v, nil
+}
+
+// indexAdd records an item the run created. An entry that was never loaded
+// is left alone; it will include the item when it is listed.
+func indexAdd[T any](x *ResourceIndex, orgURL, project, kind string, key func(T) string, v T) {
+	if x == nil {
+		return
+	}
+	e := x.entry(orgURL, project, kind)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.loaded {
+		return
+	}
+	list := append(e.list.([]T), v)
+	e.list = list
+	if k := key(v); k != "" {
+		e.byName[k] = len(list) - 1
+	}
+}
+
+// ---- typed lookups ----
+
+func repoKey(r Repo) string                         { return strings.ToLower(r.Name) }
+func buildDefinitionKey(d BuildDefinition) string   { return d.Name }
+func releaseDefinitionKey(d ReleaseSummary) string  { return d.Name }
+func pipelineKey(p Pipeline) string                 { return p.Name }
+func serviceConnectionKey(e ServiceEndpoint) string { return e.Name }
+func taskGroupKey(g TaskGroup) string               { return g.Name }
+func variableGroupKey(g VariableGroup) string       { return g.Name }
+func queueKey(q TaskAgentQueue) string              { return q.Name }
+func wikiKey(w Wiki) string                         { return strings.ToLower(w.Name) }
+func feedKey(f Feed) string                         { return strings.ToLower(strings.TrimSpace(f.Name)) }
+func policyKey(PolicyConfig) string                 { return "" }
+func projectKey(p ProjectInfo) string               { return p.Name }
+
+func (x *ResourceIndex) repos(orgURL, project string) ([]Repo, error) {
+	return indexAll(x, orgURL, project, KindRepos, func() ([]Repo, error) {
+		return ListRepos(orgURL, project)
+	}, repoKey)
+}
+
+// repo looks a repo up by name, ignoring case.
+func (x *ResourceIndex) repo(orgURL, project, name string) (*Repo, error) {
+	return indexFind(x, orgURL, project, KindRepos, func() ([]Repo, error) {
+		return ListRepos(orgURL, project)
+	}, repoKey, strings.ToLower(name))
+}
+
+// project returns the id and name of a project.
+func (x *ResourceIndex) project(orgURL, project, resourceGUID string) (*ProjectInfo, error) {
+	list, err := indexAll(x, orgURL, project, indexProject, func() ([]ProjectInfo, error) {
+		p, err := GetProjectInfo(orgURL, project, resourceGUID)
+		if err != nil {
+			return nil, err
+		}
+		return []ProjectInfo{*p}, nil
+	}, projectKey)
+	if err != nil {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+func (x *ResourceIndex) queues(orgURL, project, resourceGUID string) ([]TaskAgentQueue, error) {
+	return indexAll(x, orgURL, project, indexQueues, func() ([]TaskAgentQueue, error) {
+		return ListTaskAgentQueues(orgURL, project, resourceGUID)
+	}, queueKey)
+}
+
+func (x *ResourceIndex) buildDefinitions(orgURL, project, resourceGUID string) ([]BuildDefinition, error) {
+	return indexAll(x, orgURL, project, KindBuildDefinitions, func() ([]BuildDefinition, error) {
+		return ListBuildDefinitions(orgURL, project, resourceGUID)
+	}, buildDefinitionKey)
+}
+
+func (x *ResourceIndex) buildDefinition(orgURL, project, resourceGUID, name string) (*BuildDefinition, error) {
+	return indexFind(x, orgURL, project, KindBuildDefinitions, func() ([]BuildDefinition, error) {
+		return ListBuildDefinitions(orgURL, project, resourceGUID)
+	}, buildDefinitionKey, name)
+}
+
+func (x *ResourceIndex) addBuildDefinition(orgURL, project string, d BuildDefinition) {
+	indexAdd(x, orgURL, project, KindBuildDefinitions, buildDefinitionKey, d)
+}
+
+// releaseDefinition returns the listed (summary) JSON of a release definition.
+func (x *ResourceIndex) releaseDefinition(orgURL, project, resourceGUID, name string) (map[string]any, error) {
+	d, err := indexFind(x, orgURL, project, KindReleaseDefinitions, func() ([]ReleaseSummary, error) {
+		return ListReleaseDefinitions(orgURL, project, resourceGUID)
+	}, releaseDefinitionKey, name)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	return d.Raw, nil
+}
+
+func (x *ResourceIndex) addReleaseDefinition(orgURL, project string, id int, name string) {
+	indexAdd(x, orgURL, project, KindReleaseDefinitions, releaseDefinitionKey,
+		ReleaseSummary{Id: id, Name: name, Raw: map[string]any{"id": float64(id), "name": name}})
+}
+
+func (x *ResourceIndex) pipeline(orgURL, project, resourceGUID, name string) (*Pipeline, error) {
+	return indexFind(x, orgURL, project, KindYamlPipelines, func() ([]Pipeline, error) {
+		return ListPipelines(orgURL, project, resourceGUID)
+	}, pipelineKey, name)
+}
+
+// addPipeline records a created YAML pipeline, which is a build definition too.
+func (x *ResourceIndex) addPipeline(orgURL, project string, id int, name string) {
+	indexAdd(x, orgURL, project, KindYamlPipelines, pipelineKey, Pipeline{Id: id, Name: name})
+	x.addBuildDefinition(orgURL, project, BuildDefinition{Id: id, Name: name})
+}
+
+func (x *ResourceIndex) serviceConnections(orgURL, project, resourceGUID string) ([]ServiceEndpoint, error) {
+	return indexAll(x, orgURL, project, KindServiceConnections, func() ([]ServiceEndpoint, error) {
+		return ListServiceConnections(orgURL, project, resourceGUID)
+	}, serviceConnectionKey)
+}
+
+func (x *ResourceIndex) serviceConnection(orgURL, project, resourceGUID, name string) (*ServiceEndpoint, error) {
+	return indexFind(x, orgURL, project, KindServiceConnections, func() ([]ServiceEndpoint, error) {
+		return ListServiceConnections(orgURL, project, resourceGUID)
+	}, serviceConnectionKey, name)
+}
+
+func (x *ResourceIndex) addServiceConnection(orgURL, project string, e ServiceEndpoint) {
+	indexAdd(x, orgURL, project, KindServiceConnections, serviceConnectionKey, e)
+}
+
+func (x *ResourceIndex) taskGroups(orgURL, project, resourceGUID string) ([]TaskGroup, error) {
+	return indexAll(x, orgURL, project, KindTaskGroups, func() ([]TaskGroup, error) {
+		return ListTaskGroups(orgURL, project, resourceGUID)
+	}, taskGroupKey)
+}
+
+func (x *ResourceIndex) taskGroup(orgURL, project, resourceGUID, name string) (*TaskGroup, error) {
+	return indexFind(x, orgURL, project, KindTaskGroups, func() ([]TaskGroup, error) {
+		return ListTaskGroups(orgURL, project, resourceGUID)
+	}, taskGroupKey, name)
+}
+
+func (x *ResourceIndex) addTaskGroup(orgURL, project string, g TaskGroup) {
+	indexAdd(x, orgURL, project, KindTaskGroups, taskGroupKey, g)
+}
+
+func (x *ResourceIndex) variableGroups(orgURL, project string) ([]VariableGroup, error) {
+	return indexAll(x, orgURL, project, KindVariableGroups, func() ([]VariableGroup, error) {
+		return ListVariableGroups(orgURL, project)
+	}, variableGroupKey)
+}
+
+func (x *ResourceIndex) variableGroup(orgURL, project, name string) (*VariableGroup, error) {
+	return indexFind(x, orgURL, project, KindVariableGroups, func() ([]VariableGroup, error) {
+		return ListVariableGroups(orgURL, project)
+	}, variableGroupKey, name)
+}
+
+func (x *ResourceIndex) addVariableGroup(orgURL, project string, g VariableGroup) {
+	indexAdd(x, orgURL, project, KindVariableGroups, variableGroupKey, g)
+}
+
+func (x *ResourceIndex) wikis(orgURL, project, resourceGUID string) ([]Wiki, error) {
+	return indexAll(x, orgURL, project, KindWikis, func() ([]Wiki, error) {
+		return ListWikis(orgURL, project, resourceGUID)
+	}, wikiKey)
+}
+
+func (x *ResourceIndex) addWiki(orgURL, project string, w Wiki) {
+	indexAdd(x, orgURL, project, KindWikis, wikiKey, w)
+}
+
+func (x *ResourceIndex) feeds(orgURL, project, resourceGUID string) ([]Feed, error) {
+	return indexAll(x, orgURL, project, KindArtifactsFeeds, func() ([]Feed, error) {
+		return ListFeeds(orgURL, project, resourceGUID)
+	}, feedKey)
+}
+
+func (x *ResourceIndex) addFeed(orgURL, project string, f Feed) {
+	indexAdd(x, orgURL, project, KindArtifactsFeeds, feedKey, f)
+}
+
+// policies are matched by signature, not name, so they have no lookup key.
+func (x *ResourceIndex) policies(orgURL, project, resourceGUID string) ([]PolicyConfig, error) {
+	return indexAll(x, orgURL, project, KindBranchPolicies, func() ([]PolicyConfig, error) {
+		return ListPolicyConfigurations(orgURL, project, resourceGUID)
+	}, policyKey)
+}
+
+func (x *ResourceIndex) addPolicy(orgURL, project string, p PolicyConfig) {
+	indexAdd(x, orgURL, project, KindBranchPolicies, policyKey, p)
+}
@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"azdo-vault/internal/adoclient"
+)
+
+// One REST client per organization URL + resource, shared by every call in a run.
+var (
+	clientsMu sync.Mutex
+	clients   = map[string]*adoclient.Client{}
+	orgs      = map[string]*OrganizationConfig{} // normalized org URL -> config
+)
+
+// restLogger receives structured retry / throttling events on stderr,
+// keeping them apart from the command's normal stdout output.
+var restLogger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+func normalizeOrgURL(orgURL string) string {
+	return strings.ToLower(strings.TrimRight(strings.TrimSpace(orgURL), "/"))
+}
+
+func clientKey(orgURL, resourceGUID string) string {
+	return normalizeOrgURL(orgURL) + "|" + strings.ToLower(strings.TrimSpace(resourceGUID))
+}
+
+// registerOrganization makes an org's settings (auth etc.) known to clientFor.
+// It is called whenever a command resolves an organization from the config.
+func registerOrganization(org *OrganizationConfig) {
+	if org == nil || strings.TrimSpace(org.URL) == "" {
+		return
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	orgs[normalizeOrgURL(org.URL)] = org
+}
+
+// clientFor returns the REST client for an organization, authenticated with
+// the provider from its config (see newAuthorizer).
+func clientFor(orgURL, resourceGUID string) (*adoclient.Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	org := orgs[normalizeOrgURL(orgURL)]
+	if resourceGUID == "" && org != nil {
+		resourceGUID = org.ResourceGUID
+	}
+
+	key := clientKey(orgURL, resourceGUID)
+	if c, ok := clients[key]; ok {
+		return c, nil
+	}
+
+	hosts, apiVersion, err := orgHosts(orgURL, org)
+	if err != nil {
+		return nil, err
+	}
+
+	var ac *AuthConfig
+	var rc *RetryConfig
+	if org != nil {
+		ac = org.Auth
+		rc = org.Retry
+	}
+	auth, err := newAuthorizer(ac, resourceGUID)
+	if err != nil {
+		return nil, fmt.Errorf("auth for %s: %w", orgURL, err)
+	}
+	retry, err := retryPolicy(rc)
+	if err != nil {
+		return nil, fmt.Errorf("retry config for %s: %w", orgURL, err)
+	}
+
+	c := adoclient.New(hosts, auth)
+	if org != nil && org.Proxy != "" {
+		proxy, err := url.Parse(org.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy for %s: %w", orgURL, err)
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = http.ProxyURL(proxy)
+		c.HTTP.Transport = t
+	}
+	c.APIVersion = apiVersion
+	c.Retry = retry
+	c.Logger = restLogger
+	clients[key] = c
+	return c, nil
+}
+
+// orgHosts returns the service hosts and API version of an organization:
+// derived from its URL (cloud or Azure DevOps Server), then overridden by
+// the hosts and apiProfile of its config, if any.
+func orgHosts(orgURL string, org *OrganizationConfig) (adoclient.Hosts, string, error) {
+	hosts, err := adoclient.DefaultHosts(orgURL)
+	if err != nil {
+		return hosts, "", err
+	}
+	if org == nil {
+		return hosts, "", nil
+	}
+	if org.Hosts != nil {
+		hosts = hosts.Override(*org.Hosts)
+	}
+	version, err := adoclient.ResolveAPIVersion(org.APIProfile)
+	if err != nil {
+		return hosts, "", fmt.Errorf("config for %s: %w", orgURL, err)
+	}
+	return hosts, version, nil
+}
+
+// ValidateOrganization checks the URL, hosts, API profile, proxy, retention,
+// signing key and encryption key of an org config.
+func ValidateOrganization(org *OrganizationConfig) error {
+	if _, _, err := orgHosts(org.URL, org); err != nil {
+		return err
+	}
+	if org.Proxy != "" {
+		if u, err := url.Parse(org.Proxy); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy url: %s", org.Proxy)
+		}
+	}
+	if r := org.Retention; r != nil && (r.KeepDaily < 0 || r.KeepWeekly < 0 || r.KeepMonthly < 0) {
+		return fmt.Errorf("retention counts must not be negative")
+	}
+	if org.SigningKeyFile != "" {
+		if _, err := loadSigningKey(org.SigningKeyFile); err != nil {
+			return err
+		}
+	}
+	return ValidateEncryption(org.Encryption)
+}
+
+// retryPolicy applies an org's retry overrides to the default policy.
+func retryPolicy(rc *RetryConfig) (adoclient.RetryPolicy, error) {
+	p := adoclient.DefaultRetryPolicy()
+	if rc == nil {
+		return p, nil
+	}
+	if rc.MaxAttempts > 0 {
+		p.MaxAttempts = rc.MaxAttempts
+	}
+	if rc.BaseDelay != "" {
+		d, err := time.ParseDuration(rc.BaseDelay)
+		if err != nil {
+			return p, fmt.Errorf("invalid baseDelay %q: %w", rc.BaseDelay, err)
+		}
+		p.BaseDelay = d
+	}
+	if rc.MaxDelay != "" {
+		d, err := time.ParseDuration(rc.MaxDelay)
+		if err != nil {
+			return p, fmt.Errorf("invalid maxDelay %q: %w", rc.MaxDelay, err)
+		}
+		p.MaxDelay = d
+	}
+	p.RetryNonIdempotent = rc.RetryNonIdempotent
+	return p, nil
+}
+
+// adoGet performs a GET against an organization and returns the body.
+func adoGet(orgURL, resourceGUID, uri string) ([]byte, error) {
+	c, err := clientFor(orgURL, resourceGUID)
+	if err != nil {
+		return nil, err
+	}
+	return c.Get(uri)
+}
+
+// adoSend performs a request with a JSON payload and returns the body.
+func adoSend(orgURL, resourceGUID, method, uri string, payload any) ([]byte, error) {
+	c, err := clientFor(orgURL, resourceGUID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Send(method, uri, payload)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func hostsFor(orgURL string) (adoclient.Hosts, error) {
+	c, err := clientFor(orgURL, "")
+	if err != nil {
+		return adoclient.Hosts{}, err
+	}
+	return c.Hosts, nil
+}
+
+// releaseBase: https://dev.azure.com/{org} -> https://vsrm.dev.azure.com/{org}
+// (the collection URL on Azure DevOps Server)
+func releaseBase(orgURL string) (string, error) {
+	h, err := hostsFor(orgURL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(h.Release, "/"), nil
+}
+
+// identityBase: https://dev.azure.com/{org} -> https://vssps.dev.azure.com/{org}
+// (the collection URL on Azure DevOps Server)
+func identityBase(orgURL string) (string, error) {
+	h, err := hostsFor(orgURL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(h.Identity, "/"), nil
+}
+
+// feedsBase: https://dev.azure.com/{org} -> https://feeds.dev.azure.com/{org}
+// (the collection URL on Azure DevOps Server)
+func feedsBase(orgURL string) (string, error) {
+	h, err := hostsFor(orgURL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(h.Feeds, "/"), nil
+}
+
+// adoList fetches every page of a list endpoint, so callers never see a
+// truncated collection (see adoclient.ListAll).
+func adoList[T any](orgURL, resourceGUID, uri string, paging adoclient.Paging) ([]T, error) {
+	c, err := clientFor(orgURL, resourceGUID)
+	if err != nil {
+		return nil, err
+	}
+	return adoclient.ListAll[T](c, uri, paging)
+}
@@ -0,0 +1,559 @@
+package internal
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Actions in a RestoreResult: what a restore did (or, in a dry run, would do)
+// with one backup item.
+const (
+	ActionCreate           = "create"
+	ActionUpdate           = "update"
+	ActionSkipExists       = "skip-exists"
+	ActionSkipUnresolvable = "skip-unresolvable"
+	ActionSkipMissing      = "skip-missing" // --mode=update: not in the target, left alone
+)
+
+// Restore modes: what to do with items that already exist in the target.
+// Variable groups are always updated; feeds, wikis, branch policies and repos
+// are always create-only.
+const (
+	ModeCreate = "create" // create missing items, skip existing ones
+	ModeUpdate = "update" // update existing items over their current revision, skip missing ones
+	ModeSync   = "sync"   // create missing items and update existing ones
+)
+
+// RestoreModes lists the accepted values of RestoreOptions.Mode.
+var RestoreModes = []string{ModeCreate, ModeUpdate, ModeSync}
+
+// Collision strategies: what to do when the target name of an item is taken
+// by an item the restore did not create. Empty follows the mode.
+const (
+	CollisionSkip      = "skip"      // leave the existing item alone
+	CollisionSuffix    = "suffix"    // create the item as "{name}-2", "{name}-3", ...
+	CollisionOverwrite = "overwrite" // update the existing item (kinds in overwritableKinds only)
+)
+
+// CollisionStrategies lists the accepted values of RestoreOptions.Collision.
+var CollisionStrategies = []string{CollisionSkip, CollisionSuffix, CollisionOverwrite}
+
+// overwritableKinds are the kinds whose existing items can be updated in place.
+var overwritableKinds = []string{
+	KindServiceConnections, KindVariableGroups, KindTaskGroups,
+	KindBuildDefinitions, KindYamlPipelines, KindReleaseDefinitions,
+}
+
+// RestoreResult is the outcome of one backup item in a Restore* run.
+// Error is set when the create / update call itself failed.
+type RestoreResult struct {
+	Kind     string    `json:"kind"`
+	Item     string    `json:"item"`
+	Action   string    `json:"action"`
+	SourceID string    `json:"sourceId,omitempty"`
+	TargetID string    `json:"targetId,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Warnings []string  `json:"warnings,omitempty"`
+	Rewrites []Rewrite `json:"rewrites,omitempty"` // source org / project references replaced in the payload
+	Error    string    `json:"error,omitempty"`
+	DryRun   bool      `json:"dryRun,omitempty"`
+	Payload  any       `json:"payload,omitempty"` // dry run only: the body that would be sent
+}
+
+// Failed reports whether the item did not end up in the target as intended.
+func (r RestoreResult) Failed() bool {
+	return r.Error != "" || r.Action == ActionSkipUnresolvable
+}
+
+// RestoreOptions holds run-wide settings shared by every Restore*FromBackup
+// function. A nil *RestoreOptions is a normal run.
+type RestoreOptions struct {
+	// DryRun computes the sanitized, remapped payload of every item and
+	// returns it in the results, but sends nothing that writes to the target.
+	DryRun bool
+
+	// Mode is one of RestoreModes; empty means ModeCreate.
+	Mode string
+
+	// PerKindMode overrides Mode for some kinds (keys are Kind* names).
+	PerKindMode map[string]string
+
+	// Exec sets how many items are restored at once and the failure policy.
+	Exec *ExecOptions
+
+	// Index caches target listings across Restore* calls of one run; nil
+	// gives every call its own index.
+	Index *ResourceIndex
+
+	// Mappings translate source names to target names (see Manifest).
+	Mappings *Mappings
+
+	// Identities maps source users and groups to target ones; nil resolves
+	// them by uniqueName without a mapping file.
+	Identities *IdentityResolver
+
+	// Names gives items their target names; nil builds one from Mappings
+	// for each Restore* call.
+	Names *Renamer
+
+	// Collision is one of CollisionStrategies; empty follows the mode.
+	// PerKindCollision overrides it for some kinds.
+	Collision        string
+	PerKindCollision map[string]string
+
+	out io.Writer // set by withOutput; nil means stdout
+}
+
+func (o *RestoreOptions) dryRun() bool {
+	return o != nil && o.DryRun
+}
+
+func (o *RestoreOptions) mode(kind string) string {
+	if o == nil {
+		return ModeCreate
+	}
+	if m := o.PerKindMode[kind]; m != "" {
+		return m
+	}
+	if o.Mode == "" {
+		return ModeCreate
+	}
+	return o.Mode
+}
+
+// creates reports whether missing items of kind are created.
+func (o *RestoreOptions) creates(kind string) bool {
+	return o.mode(kind) != ModeUpdate
+}
+
+// updates reports whether existing items of kind are updated.
+func (o *RestoreOptions) updates(kind string) bool {
+	switch o.collision(kind) {
+	case CollisionOverwrite:
+		return true
+	case CollisionSkip:
+		return false
+	}
+	return o.mode(kind) != ModeCreate
+}
+
+// collision returns the collision strategy of kind; overwrite falls back to
+// the mode for kinds that cannot be updated.
+func (o *RestoreOptions) collision(kind string) string {
+	if o == nil {
+		return ""
+	}
+	c := o.Collision
+	if k := o.PerKindCollision[kind]; k != "" {
+		c = k
+	}
+	if c == CollisionOverwrite && !contains(overwritableKinds, kind) {
+		return ""
+	}
+	return c
+}
+
+func (o *RestoreOptions) mappings() *Mappings {
+	if o == nil {
+		return nil
+	}
+	return o.Mappings
+}
+
+func (o *RestoreOptions) names() *Renamer {
+	if o != nil && o.Names != nil {
+		return o.Names
+	}
+	// the rules of Mappings were checked by LoadManifest
+	r, _ := NewRenamer(o.mappings(), nil)
+	return r
+}
+
+func (o *RestoreOptions) identities() *IdentityResolver {
+	if o == nil || o.Identities == nil {
+		return NewIdentityResolver(nil)
+	}
+	return o.Identities
+}
+
+// ValidateRestoreMode checks a --mode value.
+func ValidateRestoreMode(mode string) error {
+	if mode == "" || contains(RestoreModes, mode) {
+		return nil
+	}
+	return fmt.Errorf("unknown mode '%s' (use %s)", mode, strings.Join(RestoreModes, ", "))
+}
+
+// ValidateCollision checks a collision strategy for kind; kind "" (a run
+// over several kinds) accepts overwrite, which then applies to the kinds
+// that support it.
+func ValidateCollision(kind, strategy string) error {
+	if strategy == "" {
+		return nil
+	}
+	if !contains(CollisionStrategies, strategy) {
+		return fmt.Errorf("unknown collision strategy '%s' (use %s)", strategy, strings.Join(CollisionStrategies, ", "))
+	}
+	if strategy == CollisionOverwrite && kind != "" && !contains(overwritableKinds, kind) {
+		return fmt.Errorf("%s cannot be overwritten (only %s)", kind, strings.Join(overwritableKinds, ", "))
+	}
+	return nil
+}
+
+// withOutput returns a copy of o whose restore output goes to out.
+func (o *RestoreOptions) withOutput(out io.Writer) *RestoreOptions {
+	c := RestoreOptions{}
+	if o != nil {
+		c = *o
+	}
+	c.out = out
+	return &c
+}
+
+func (o *RestoreOptions) exec() *ExecOptions {
+	if o == nil {
+		return nil
+	}
+	return o.Exec
+}
+
+// restoreRun collects the results of one Restore* call for one kind.
+type restoreRun struct {
+	kind    string
+	dryRun  bool
+	exec    *ExecOptions
+	idx     *ResourceIndex
+	names   *Renamer
+	suffix  bool // the collision strategy is suffix
+	ids     *IdentityResolver
+	rw      *payloadRewriter
+	out     io.Writer
+	results []RestoreResult
+
+	rewrites []Rewrite // of the item's payload, until its result is recorded
+}
+
+func newRestoreRun(kind string, opts *RestoreOptions) *restoreRun {
+	r := &restoreRun{kind: kind, dryRun: opts.dryRun(), exec: opts.exec(), idx: NewResourceIndex(), names: opts.names(), suffix: opts.collision(kind) == CollisionSuffix, ids: opts.identities(), out: os.Stdout}
+	if opts != nil && opts.Index != nil {
+		r.idx = opts.Index
+	}
+	if opts != nil && opts.out != nil {
+		r.out = opts.out
+	}
+	return r
+}
+
+// each restores items 0..n-1 with the kind's parallelism (see forEach).
+// Every item records into its own run, which prints to that item's output;
+// their results are appended in item order. By default the restore stops at
+// the first error fn returns.
+func (r *restoreRun) each(n int, fn func(i int, item *restoreRun) error) error {
+	items := make([]*restoreRun, n)
+	err := forEach(n, r.exec.workers(r.kind), r.exec.failFast(true), func(i int, out io.Writer) error {
+		items[i] = &restoreRun{kind: r.kind, dryRun: r.dryRun, exec: r.exec, idx: r.idx, names: r.names, suffix: r.suffix, ids: r.ids, rw: r.rw, out: out}
+		return fn(i, items[i])
+	})
+	for _, it := range items {
+		if it != nil {
+			r.results = append(r.results, it.results...)
+		}
+	}
+	return err
+}
+
+// rename returns the target name of a source item of the run's kind.
+func (r *restoreRun) rename(source string) string {
+	name := r.names.Name(r.kind, source)
+	if name != source {
+		r.printf("Renaming %s '%s' -> '%s'\n", r.kind, source, name)
+	}
+	return name
+}
+
+// collide is called when the target name of a source item is taken. With
+// the suffix strategy, unless a restore created the existing item, it
+// returns a free name "{name}-2", ... and true; references to the item follow
+// it. Otherwise it returns false and the existing item is skipped or updated
+// as the mode says. taken tells whether a name exists in the target.
+func (r *restoreRun) collide(source, name string, taken func(name string) (bool, error)) (string, bool, error) {
+	if !r.suffix || r.names.owns(r.kind, name) {
+		return name, false, nil
+	}
+	alt, err := r.names.claimSuffixed(r.kind, source, name, taken)
+	if err != nil {
+		return name, false, err
+	}
+	r.printf("'%s' exists in the target, restoring %s as '%s'\n", name, r.kind, alt)
+	return alt, true, nil
+}
+
+// created records in the names ledger that source was created as name.
+func (r *restoreRun) created(source, name string) {
+	if err := r.names.created(r.kind, source, name); err != nil {
+		r.printf("⚠ could not save the names ledger: %v\n", err)
+	}
+}
+
+// rewriteFrom sets up the rewriting of literal source references (see
+// payloadRewriter) from the backup's reference index to the target project.
+func (r *restoreRun) rewriteFrom(refs *RefIndex, targetOrgURL, targetProject, resourceGUID string) error {
+	if refs == nil {
+		r.printf("⚠ No %s in backup; source org and project references in payloads are not rewritten\n", RefIndexFile)
+		return nil
+	}
+	rw, err := newPayloadRewriter(r.idx, r.names, refs, targetOrgURL, targetProject, resourceGUID, func(msg string) {
+		r.printf("⚠ %s\n", msg)
+	})
+	if err != nil {
+		return fmt.Errorf("rewrite: %w", err)
+	}
+	r.rw = rw
+	return nil
+}
+
+// rewrite replaces the literal source references in payload; what it
+// replaced is printed and goes into the item's next result.
+func (r *restoreRun) rewrite(payload map[string]any) {
+	r.rewritten(r.rw.rewrite(payload))
+}
+
+// rewriteValue is rewrite for a single value at field.
+func (r *restoreRun) rewriteValue(field, s string) string {
+	s, rws := r.rw.rewriteValue(field, s)
+	r.rewritten(rws)
+	return s
+}
+
+func (r *restoreRun) rewritten(rws []Rewrite) {
+	for _, rw := range rws {
+		r.printf("Rewrote '%s' -> '%s' in %s (%d×)\n", rw.From, rw.To, rw.Field, rw.Count)
+	}
+	r.rewrites = append(r.rewrites, rws...)
+}
+
+func (r *restoreRun) printf(format string, a ...any) {
+	fmt.Fprintf(r.out, format, a...)
+}
+
+func (r *restoreRun) println(a ...any) {
+	fmt.Fprintln(r.out, a...)
+}
+
+func (r *restoreRun) add(res RestoreResult) {
+	res.Kind = r.kind
+	res.DryRun = r.dryRun
+	res.Rewrites, r.rewrites = r.rewrites, nil
+	r.results = append(r.results, res)
+}
+
+func (r *restoreRun) exists(item, sourceID, targetID string) {
+	r.add(RestoreResult{Item: item, Action: ActionSkipExists, SourceID: sourceID, TargetID: targetID})
+}
+
+func (r *restoreRun) missing(item, sourceID string) {
+	r.add(RestoreResult{Item: item, Action: ActionSkipMissing, SourceID: sourceID})
+}
+
+func (r *restoreRun) unresolvable(item, sourceID, reason string) {
+	r.add(RestoreResult{Item: item, Action: ActionSkipUnresolvable, SourceID: sourceID, Reason: reason})
+}
+
+// planned records the payload of a create / update in a dry run and reports
+// whether the caller must stop before writing.
+func (r *restoreRun) planned(item, sourceID, action string, payload any, warnings []string) bool {
+	if !r.dryRun {
+		return false
+	}
+	r.add(RestoreResult{Item: item, Action: action, SourceID: sourceID, Warnings: warnings, Payload: payload})
+	r.printf("[dry-run] Would %s %s: %s\n", action, r.kind, item)
+	return true
+}
+
+// done records a create / update; err is the error of the write call.
+func (r *restoreRun) done(item, sourceID, action, targetID string, warnings []string, err error) {
+	res := RestoreResult{Item: item, Action: action, SourceID: sourceID, TargetID: targetID, Warnings: warnings}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	r.add(res)
+}
+
+// warn adds a warning to the last recorded result, for follow-up steps that
+// fail after the item itself was created.
+func (r *restoreRun) warn(msg string) {
+	if n := len(r.results); n > 0 {
+		r.results[n-1].Warnings = append(r.results[n-1].Warnings, msg)
+	}
+}
+
+// Report aggregates the results of a whole command.
+type Report struct {
+	Results []RestoreResult `json:"results"`
+}
+
+func (p *Report) Add(results ...RestoreResult) {
+	p.Results = append(p.Results, results...)
+}
+
+// Counts returns the number of results per action, plus "failed" for errors.
+func (p *Report) Counts() map[string]int {
+	m := map[string]int{}
+	for _, r := range p.Results {
+		if r.Error != "" {
+			m["failed"]++
+			continue
+		}
+		m[r.Action]++
+	}
+	return m
+}
+
+// Failed returns the results that were skipped as unresolvable or failed.
+func (p *Report) Failed() []RestoreResult {
+	var out []RestoreResult
+	for _, r := range p.Results {
+		if r.Failed() {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// WriteTable prints the results as an aligned table (payloads are left out).
+func (p *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tITEM\tACTION\tTARGET ID\tDETAILS")
+	for _, r := range p.Results {
+		details := r.Reason
+		if r.Error != "" {
+			details = "ERROR: " + r.Error
+		}
+		for _, wn := range r.Warnings {
+			if details != "" {
+				details += "; "
+			}
+			details += "warning: " + wn
+		}
+		if n := len(r.Rewrites); n > 0 {
+			if details != "" {
+				details += "; "
+			}
+			details += fmt.Sprintf("%d field(s) rewritten", n)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Kind, r.Item, r.Action, r.TargetID, oneLine(details))
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the report (including dry-run payloads) to path.
+func (p *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// JUnit XML: one suite per kind, one test case per item.
+// Errors become <failure>, unresolvable skips become <skipped>.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML to path.
+func (p *Report) WriteJUnit(path string) error {
+	byKind := map[string]*junitSuite{}
+	var kinds []string
+	root := junitSuites{}
+
+	for _, r := range p.Results {
+		s, ok := byKind[r.Kind]
+		if !ok {
+			s = &junitSuite{Name: r.Kind}
+			byKind[r.Kind] = s
+			kinds = append(kinds, r.Kind)
+		}
+
+		c := junitCase{Name: r.Item, ClassName: "azdo-vault." + r.Kind}
+		out := "action=" + r.Action
+		if r.SourceID != "" {
+			out += " sourceId=" + r.SourceID
+		}
+		if r.TargetID != "" {
+			out += " targetId=" + r.TargetID
+		}
+		for _, wn := range r.Warnings {
+			out += "\nwarning: " + wn
+		}
+		for _, rw := range r.Rewrites {
+			out += fmt.Sprintf("\nrewrote %s: '%s' -> '%s' (%d×)", rw.Field, rw.From, rw.To, rw.Count)
+		}
+		c.SystemOut = out
+
+		switch {
+		case r.Error != "":
+			c.Failure = &junitMessage{Message: oneLine(r.Error), Text: r.Error}
+			s.Failures++
+			root.Failures++
+		case r.Action == ActionSkipUnresolvable:
+			c.Skipped = &junitMessage{Message: oneLine(r.Reason)}
+			s.Skipped++
+			root.Skipped++
+		}
+		s.Tests++
+		root.Tests++
+		s.Cases = append(s.Cases, c)
+	}
+
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		root.Suites = append(root.Suites, *byKind[k])
+	}
+
+	data, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), data...), 0644)
+}
+
+func oneLine(s string) string {
+	for i, c := range s {
+		if c == '\n' {
+			return s[:i] + " ..."
+		}
+	}
+	return s
+}
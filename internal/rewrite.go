@@ -0,0 +1,290 @@
+package internal
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"azdo-vault/internal/adoclient"
+)
+
+// Rewrite is one literal source reference a restore replaced in a payload.
+type Rewrite struct {
+	Field string `json:"field"` // path in the payload, e.g. process.phases[0].steps[2].inputs.url
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int    `json:"count"` // occurrences in the field
+}
+
+// rewriteRule replaces from with to. URL rules match at the start of a
+// service URL, id rules a whole GUID; both only where the match is not
+// followed by more of a name, so project "Web" leaves "WebApi" alone.
+type rewriteRule struct {
+	from, to string
+	id       bool
+}
+
+// payloadRewriter replaces the literal references to the source org,
+// project, renamed repos and feeds that definitions keep in free text: task
+// inputs, scripts, variables, descriptions, artifact URLs. It rewrites
+//
+//   - the service URLs of the source org (core, release, identity, feeds and
+//     packages; {org}.visualstudio.com too), with the project, a repo
+//     (/_git/{repo}) or a feed (/_packaging/{feed}) after them,
+//   - the source project and feed ids,
+//   - values equal to the source project name under a key containing
+//     "project" (a hardcoded $(System.TeamProject)).
+//
+// A nil *payloadRewriter rewrites nothing.
+type payloadRewriter struct {
+	rules                  []rewriteRule // longest first
+	srcProject, tgtProject string
+}
+
+// newPayloadRewriter builds the rules from the reference index of the
+// backup and the target project. Feeds that cannot be listed in the target
+// only lose their id rules; warn is told why.
+func newPayloadRewriter(
+	idx *ResourceIndex,
+	names *Renamer,
+	refs *RefIndex,
+	targetOrgURL, targetProject, resourceGUID string,
+	warn func(msg string),
+) (*payloadRewriter, error) {
+	if refs == nil || refs.OrgURL == "" || refs.Project == "" {
+		return nil, nil
+	}
+	tgtProj, err := idx.project(targetOrgURL, targetProject, resourceGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &payloadRewriter{srcProject: refs.Project, tgtProject: tgtProj.Name}
+	seen := map[string]bool{}
+	add := func(from, to string, id bool) {
+		key := strings.ToLower(from)
+		if from == "" || from == to || seen[key] {
+			return
+		}
+		seen[key] = true
+		w.rules = append(w.rules, rewriteRule{from: from, to: to, id: id})
+	}
+
+	// project name as written raw and as escaped in a URL path
+	type pair struct{ from, to string }
+	projects := []pair{{refs.Project, tgtProj.Name}}
+	if esc := url.PathEscape(refs.Project); esc != refs.Project {
+		projects = append(projects, pair{esc, url.PathEscape(tgtProj.Name)})
+	}
+
+	var repos, feeds []pair
+	for _, name := range refs.Repos {
+		if tgt := names.Name(KindRepos, name); tgt != name {
+			repos = append(repos, pair{url.PathEscape(name), url.PathEscape(tgt)})
+		}
+	}
+	for _, name := range refs.Feeds {
+		if tgt := names.Name(KindArtifactsFeeds, name); tgt != name {
+			feeds = append(feeds, pair{url.PathEscape(name), url.PathEscape(tgt)})
+		}
+	}
+
+	src, tgt := orgBases(refs.OrgURL), orgBases(targetOrgURL)
+	for i := range src {
+		to := tgt[i][0]
+		for _, from := range src[i] {
+			if from == "" || to == "" {
+				continue
+			}
+			for _, p := range projects {
+				base, tbase := from+"/"+p.from, to+"/"+p.to
+				if i == 0 {
+					for _, r := range repos {
+						add(base+"/_git/"+r.from, tbase+"/_git/"+r.to, false)
+					}
+				}
+				if i >= 3 {
+					for _, f := range feeds {
+						add(base+"/_packaging/"+f.from, tbase+"/_packaging/"+f.to, false)
+					}
+				}
+				add(base, tbase, false)
+			}
+			if i >= 3 {
+				// organization scoped feeds
+				for _, f := range feeds {
+					add(from+"/_packaging/"+f.from, to+"/_packaging/"+f.to, false)
+				}
+			}
+			add(from, to, false)
+		}
+	}
+
+	add(strings.ToLower(refs.ProjectID), tgtProj.Id, true)
+	if len(refs.Feeds) > 0 {
+		targetFeeds, err := idx.feeds(targetOrgURL, targetProject, resourceGUID)
+		if err != nil {
+			warn("feed ids are not rewritten: " + err.Error())
+		}
+		for id, name := range refs.Feeds {
+			if f := FindFeedByName(targetFeeds, names.Name(KindArtifactsFeeds, name)); f != nil {
+				add(id, f.ID, true)
+			}
+		}
+	}
+
+	sort.SliceStable(w.rules, func(i, j int) bool { return len(w.rules[i].from) > len(w.rules[j].from) })
+	return w, nil
+}
+
+// orgBases returns the base URLs of the services of an org, in the order
+// core, release, identity, feeds, packages. The first URL of each is the
+// current one; an Azure DevOps Services org also has a legacy
+// {org}.visualstudio.com one.
+func orgBases(orgURL string) [5][]string {
+	hosts, err := hostsFor(orgURL)
+	if err != nil {
+		// the source org of a backup need not be configured
+		hosts, _ = adoclient.DefaultHosts(orgURL)
+	}
+	trim := func(s string) string { return strings.TrimRight(s, "/") }
+	bases := [5][]string{
+		{trim(hosts.Core)}, {trim(hosts.Release)}, {trim(hosts.Identity)}, {trim(hosts.Feeds)}, {trim(hosts.Feeds)},
+	}
+
+	org, cloud := strings.CutPrefix(strings.ToLower(bases[0][0]), "https://dev.azure.com/")
+	if !cloud || strings.Contains(org, "/") {
+		return bases
+	}
+	org = bases[0][0][len("https://dev.azure.com/"):]
+	bases[4][0] = "https://pkgs.dev.azure.com/" + org
+	for i, sub := range []string{"", ".vsrm", ".vssps", ".feeds", ".pkgs"} {
+		bases[i] = append(bases[i], "https://"+org+sub+".visualstudio.com")
+	}
+	return bases
+}
+
+// rewrite replaces the source references in the string values of payload
+// (maps and slices are walked in place) and returns what it replaced, in
+// field order.
+func (w *payloadRewriter) rewrite(payload map[string]any) []Rewrite {
+	if w == nil || payload == nil {
+		return nil
+	}
+	var out []Rewrite
+	w.walk("", "", payload, &out)
+	return out
+}
+
+// rewriteValue is rewrite for a single string value at field.
+func (w *payloadRewriter) rewriteValue(field, s string) (string, []Rewrite) {
+	if w == nil {
+		return s, nil
+	}
+	var out []Rewrite
+	s = w.rewriteString(field, "", s, &out)
+	return s, out
+}
+
+func (w *payloadRewriter) walk(field, key string, v any, out *[]Rewrite) any {
+	switch t := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f := k
+			if field != "" {
+				f = field + "." + k
+			}
+			t[k] = w.walk(f, k, t[k], out)
+		}
+	case []any:
+		for i := range t {
+			t[i] = w.walk(field+"["+strconv.Itoa(i)+"]", key, t[i], out)
+		}
+	case []map[string]any:
+		for i := range t {
+			w.walk(field+"["+strconv.Itoa(i)+"]", key, t[i], out)
+		}
+	case string:
+		return w.rewriteString(field, key, t, out)
+	}
+	return v
+}
+
+func (w *payloadRewriter) rewriteString(field, key, s string, out *[]Rewrite) string {
+	if s == "" {
+		return s
+	}
+	if strings.Contains(strings.ToLower(key), "project") && strings.EqualFold(s, w.srcProject) && s != w.tgtProject {
+		*out = append(*out, Rewrite{Field: field, From: s, To: w.tgtProject, Count: 1})
+		return w.tgtProject
+	}
+
+	var b strings.Builder
+	counts := map[int]int{}
+	var order []int
+	last := 0
+	for i := 0; i < len(s); {
+		r := w.match(s, i)
+		if r < 0 {
+			i++
+			continue
+		}
+		rule := w.rules[r]
+		b.WriteString(s[last:i])
+		b.WriteString(rule.to)
+		if counts[r] == 0 {
+			order = append(order, r)
+		}
+		counts[r]++
+		i += len(rule.from)
+		last = i
+	}
+	if len(order) == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	for _, r := range order {
+		*out = append(*out, Rewrite{Field: field, From: w.rules[r].from, To: w.rules[r].to, Count: counts[r]})
+	}
+	return b.String()
+}
+
+// match returns the index of the first (longest) rule matching s at i, or -1.
+func (w *payloadRewriter) match(s string, i int) int {
+	c := s[i]
+	atURL := c == 'h' || c == 'H'
+	atID := isHexDigit(c) && (i == 0 || !isNameByte(s[i-1]))
+	if !atURL && !atID {
+		return -1
+	}
+	for r, rule := range w.rules {
+		if rule.id && !atID || !rule.id && !atURL {
+			continue
+		}
+		end := i + len(rule.from)
+		if end > len(s) || !strings.EqualFold(s[i:end], rule.from) {
+			continue
+		}
+		if end < len(s) && isNameByte(s[end]) {
+			continue
+		}
+		return r
+	}
+	return -1
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// isNameByte reports whether c can continue an org, project, repo or feed
+// name or a GUID.
+func isNameByte(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '-' || c == '_' || c == '.' || c == '%'
+}
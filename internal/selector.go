@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Selector picks items by name. It is parsed from the values of a selection
+// flag (--definitions, --repos, ...) or a manifest's select list:
+//
+//	all, *          every item (also the default when only exclusions are given)
+//	api-*           a glob: * and ? match within a name or folder, ** across folders
+//	re:^api-(web|svc)$
+//	                a regular expression
+//	\Team A\**      a folder path: matched against "{folder}\{name}" of items
+//	                that have folders, "\{name}" of the others; \Team A alone
+//	                is \Team A\**
+//	!legacy-*       an exclusion, in any of the forms above; exclusions win
+//	@names.txt      one selector per line of a file (# starts a comment)
+//	=name           exactly name, even if it looks like a pattern
+//
+// Names match case insensitively, like Azure DevOps compares them.
+type Selector struct {
+	include []selectorPattern
+	exclude []selectorPattern
+	all     bool
+}
+
+type selectorPattern struct {
+	text   string
+	re     *regexp.Regexp // nil: exact name
+	folder bool           // matched against the folder path
+}
+
+// ParseSelector parses selection values; nil or empty selects every item.
+func ParseSelector(values []string) (*Selector, error) {
+	s := &Selector{}
+	if err := s.add(values, ""); err != nil {
+		return nil, err
+	}
+	if len(s.include) == 0 {
+		s.all = true
+	}
+	return s, nil
+}
+
+func (s *Selector) add(values []string, file string) error {
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if f, ok := strings.CutPrefix(v, "@"); ok {
+			if file != "" {
+				return fmt.Errorf("%s: @%s: selector files cannot include other files", file, f)
+			}
+			lines, err := readSelectorFile(f)
+			if err != nil {
+				return err
+			}
+			if err := s.add(lines, f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		exclude := false
+		if rest, ok := strings.CutPrefix(v, "!"); ok {
+			exclude, v = true, strings.TrimSpace(rest)
+		}
+		if !exclude && (strings.EqualFold(v, "all") || v == "*") {
+			s.all = true
+			continue
+		}
+		p, err := parseSelectorPattern(v)
+		if err != nil {
+			if file != "" {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			return err
+		}
+		if exclude {
+			s.exclude = append(s.exclude, p)
+		} else {
+			s.include = append(s.include, p)
+		}
+	}
+	return nil
+}
+
+func readSelectorFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("selector file: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("selector file %s: %w", path, err)
+	}
+	return lines, nil
+}
+
+func parseSelectorPattern(v string) (selectorPattern, error) {
+	switch {
+	case v == "":
+		return selectorPattern{}, fmt.Errorf("empty selector")
+	case strings.HasPrefix(v, "="):
+		return selectorPattern{text: v[1:]}, nil
+	case strings.HasPrefix(v, "re:"):
+		re, err := regexp.Compile("(?i)" + v[len("re:"):])
+		if err != nil {
+			return selectorPattern{}, fmt.Errorf("bad selector '%s': %w", v, err)
+		}
+		return selectorPattern{text: v, re: re}, nil
+	}
+
+	p := selectorPattern{text: v, folder: strings.HasPrefix(v, `\`)}
+	switch {
+	case strings.ContainsAny(v, "*?"):
+		p.re = globRegexp(v)
+	case p.folder:
+		// a plain folder selects everything under it
+		p.re = globRegexp(strings.TrimRight(v, `\`) + `\**`)
+	}
+	return p, nil
+}
+
+// globRegexp compiles a glob: ** matches anything, * and ? anything but a
+// folder separator.
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`(?i)^`)
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], `**\`):
+			b.WriteString(`(?:.*\\)?`) // \Team\**\CI also matches \Team\CI
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(`.*`)
+			i++
+		case glob[i] == '*':
+			b.WriteString(`[^\\]*`)
+		case glob[i] == '?':
+			b.WriteString(`[^\\]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString(`$`)
+	return regexp.MustCompile(b.String())
+}
+
+// All reports whether every item is selected.
+func (s *Selector) All() bool {
+	return s == nil || s.all && len(s.exclude) == 0
+}
+
+// Literal returns the names of a selection of exact names only, which need
+// not exist anywhere to be selected.
+func (s *Selector) Literal() ([]string, bool) {
+	if s == nil || s.all || len(s.exclude) > 0 {
+		return nil, false
+	}
+	names := make([]string, 0, len(s.include))
+	for _, p := range s.include {
+		if p.re != nil {
+			return nil, false
+		}
+		names = append(names, p.text)
+	}
+	return names, true
+}
+
+// Folders reports whether a pattern needs the folder of items.
+func (s *Selector) Folders() bool {
+	if s == nil {
+		return false
+	}
+	for _, list := range [][]selectorPattern{s.include, s.exclude} {
+		for _, p := range list {
+			if p.folder {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Match reports whether an item without a folder is selected; names are the
+// names it goes by (e.g. its name and its id).
+func (s *Selector) Match(names ...string) bool {
+	return s.MatchIn("", names...)
+}
+
+// MatchIn reports whether an item in folder (e.g. `\Team A\CI`; "" or `\`
+// is the root) is selected.
+func (s *Selector) MatchIn(folder string, names ...string) bool {
+	if s == nil {
+		return true
+	}
+	if s.matches(s.exclude, folder, names) {
+		return false
+	}
+	return s.all || s.matches(s.include, folder, names)
+}
+
+// matchFile is MatchIn for the backup file fp of an item; the folder is only
+// read from the file when a pattern needs it.
+func (s *Selector) matchFile(fp string, names ...string) bool {
+	folder := ""
+	if s.Folders() {
+		folder = backupFolder(fp)
+	}
+	return s.MatchIn(folder, names...)
+}
+
+func (s *Selector) matches(patterns []selectorPattern, folder string, names []string) bool {
+	folder = strings.TrimRight(folder, `\`)
+	for _, p := range patterns {
+		for _, n := range names {
+			switch {
+			case n == "":
+			case p.folder:
+				if p.re.MatchString(folder + `\` + n) {
+					return true
+				}
+			case p.re != nil:
+				if p.re.MatchString(n) || strings.EqualFold(p.text, n) {
+					return true
+				}
+			case strings.EqualFold(p.text, n):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backupFolder returns the folder of a backed up definition file ("path" of
+// build and release definitions, "folder" of YAML pipelines); "" if it has
+// none or cannot be read, which puts the item at the root.
+func backupFolder(fp string) string {
+	var v struct {
+		Path   string `json:"path"`
+		Folder string `json:"folder"`
+	}
+	data, err := readBackupFile(fp)
+	if err != nil || json.Unmarshal(data, &v) != nil {
+		return ""
+	}
+	if v.Path != "" {
+		return v.Path
+	}
+	return v.Folder
+}
+
+// ResolveSelectorFiles makes the @file selectors of values relative to dir,
+// for selections written in a file such as a manifest.
+func ResolveSelectorFiles(values []string, dir string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		if f, ok := strings.CutPrefix(strings.TrimSpace(v), "@"); ok && !filepath.IsAbs(f) {
+			v = "@" + filepath.Join(dir, f)
+		}
+		out[i] = v
+	}
+	return out
+}
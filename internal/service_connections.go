@@ -3,16 +3,13 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-)
 
-type ServiceEndpointListResponse struct {
-	Count int               `json:"count"`
-	Value []ServiceEndpoint `json:"value"`
-}
+	"azdo-vault/internal/adoclient"
+)
 
 type ServiceEndpoint struct {
 	Id          string         `json:"id,omitempty"`
@@ -68,43 +65,19 @@ func (s ServiceEndpoint) MarshalJSON() ([]byte, error) {
 func ListServiceConnections(orgURL, project, resourceGUID string) ([]ServiceEndpoint, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/serviceendpoint/endpoints?api-version=7.1", orgURL, project)
 
-	cmd := exec.Command(
-		"az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	list, err := adoList[ServiceEndpoint](orgURL, resourceGUID, uri, adoclient.Paging{})
 	if err != nil {
-		return nil, fmt.Errorf("az rest list service connections failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("list service connections failed: %w", err)
 	}
-
-	var resp ServiceEndpointListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing JSON: %w\nRaw:\n%s", err, string(out))
-	}
-
-	return resp.Value, nil
+	return list, nil
 }
 
 func GetServiceConnection(orgURL, project, resourceGUID, endpointId string) (*ServiceEndpoint, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/serviceendpoint/endpoints/%s?api-version=7.1", orgURL, project, endpointId)
 
-	cmd := exec.Command(
-		"az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoGet(orgURL, resourceGUID, uri)
 	if err != nil {
-		return nil, fmt.Errorf("az rest show service connection failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("show service connection failed: %w", err)
 	}
 
 	var ep ServiceEndpoint
@@ -129,46 +102,16 @@ func FindServiceConnectionByName(orgURL, project, resourceGUID, name string) (*S
 }
 
 func CreateServiceConnection(orgURL, project, resourceGUID string, ep ServiceEndpoint, targetProjectID string) (string, error) {
-	if ep.Raw == nil {
-		b, _ := json.Marshal(ep)
-		_ = json.Unmarshal(b, &ep.Raw)
-	}
-
-	sanitizeServiceConnectionForCreate(ep.Raw)
-
-	// inject target project reference (required)
-	ep.Raw["serviceEndpointProjectReferences"] = []map[string]any{
-		{
-			"projectReference": map[string]any{
-				"id":   targetProjectID,
-				"name": project, // target project name
-			},
-			"name":        ep.Name,
-			"description": ep.Description,
-		},
-	}
-
-	bodyBytes, err := json.Marshal(ep.Raw)
+	bodyBytes, err := json.Marshal(serviceConnectionCreateBody(ep, project, targetProjectID))
 	if err != nil {
 		return "", err
 	}
 
 	uri := fmt.Sprintf("%s/%s/_apis/serviceendpoint/endpoints?api-version=7.1", orgURL, project)
 
-	cmd := exec.Command(
-		"az", "rest",
-		"--method", "post",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--headers", "Content-Type=application/json",
-		"--body", string(bodyBytes),
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoSend(orgURL, resourceGUID, "post", uri, bodyBytes)
 	if err != nil {
-		return "", fmt.Errorf("az rest create service connection failed: %w\n%s", err, string(out))
+		return "", fmt.Errorf("create service connection failed: %w", err)
 	}
 
 	var created map[string]any
@@ -181,27 +124,45 @@ func CreateServiceConnection(orgURL, project, resourceGUID string, ep ServiceEnd
 	return "", fmt.Errorf("created service connection but no id returned. Raw:\n%s", string(out))
 }
 
-func BackupServiceConnections(orgURL, project, backupPath string, selected []string, resourceGUID string) error {
+// PUT /_apis/serviceendpoint/endpoints/{endpointId} (organization level)
+func UpdateServiceConnection(orgURL, resourceGUID, endpointID string, payload map[string]any) error {
+	uri := fmt.Sprintf("%s/_apis/serviceendpoint/endpoints/%s?api-version=7.1", strings.TrimRight(orgURL, "/"), endpointID)
+	if _, err := adoSend(orgURL, resourceGUID, "put", uri, payload); err != nil {
+		return fmt.Errorf("update service connection failed: %w", err)
+	}
+	return nil
+}
+
+func BackupServiceConnections(orgURL, project, backupPath string, selected []string, resourceGUID string, exec *ExecOptions) error {
 	all, err := ListServiceConnections(orgURL, project, resourceGUID)
 	if err != nil {
 		return err
 	}
 
-	backupAll := len(selected) == 1 && selected[0] == "all"
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return err
+	}
 	if len(all) == 0 {
 		fmt.Println("No service connections found")
 		return nil
 	}
+	fmt.Printf("Found %d service connections\n", len(all))
 
 	if err := os.MkdirAll(backupPath, 0755); err != nil {
 		return err
 	}
 
+	var items []ServiceEndpoint
 	for _, e := range all {
-		if !backupAll && !contains(selected, e.Name) {
+		if !sel.Match(e.Name) {
 			continue
 		}
+		items = append(items, e)
+	}
 
+	err = forEach(len(items), exec.workers(KindServiceConnections), exec.failFast(true), func(i int, out io.Writer) error {
+		e := items[i]
 		// Get full details (often richer than list output)
 		full, err := GetServiceConnection(orgURL, project, resourceGUID, e.Id)
 		if err != nil {
@@ -213,11 +174,15 @@ func BackupServiceConnections(orgURL, project, backupPath string, selected []str
 		if err != nil {
 			return err
 		}
-		if err := os.WriteFile(fp, data, 0644); err != nil {
+		if err := writeBackupFile(fp, data); err != nil {
 			return err
 		}
 
-		fmt.Println("✔ Backed up service connection:", full.Name)
+		fmt.Fprintln(out, "✔ Backed up service connection:", full.Name)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -227,31 +192,48 @@ func RestoreServiceConnectionsFromBackup(
 	targetOrgURL, targetProject, backupPath string,
 	selected []string,
 	resourceGUID string,
-) error {
+	opts *RestoreOptions,
+) ([]RestoreResult, error) {
+	run := newRestoreRun(KindServiceConnections, opts)
 	files, err := os.ReadDir(backupPath)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 
-	pinfo, err := GetProjectInfo(targetOrgURL, targetProject, resourceGUID)
+	pinfo, err := run.idx.project(targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return err
+		return run.results, err
+	}
+	refs, err := backupRefs(backupPath)
+	if err != nil {
+		return run.results, err
+	}
+	if err := run.rewriteFrom(refs, targetOrgURL, targetProject, resourceGUID); err != nil {
+		return run.results, err
 	}
 
-	restoreAll := len(selected) == 1 && selected[0] == "all"
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return run.results, err
+	}
 
+	var items []os.DirEntry
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name(), ".json") {
 			continue
 		}
 
 		name := strings.TrimSuffix(f.Name(), ".json")
-		if !restoreAll && !contains(selected, name) {
+		if !sel.Match(name) {
 			continue
 		}
+		items = append(items, f)
+	}
 
+	err = run.each(len(items), func(i int, item *restoreRun) error {
+		f := items[i]
 		fp := filepath.Join(backupPath, f.Name())
-		b, err := os.ReadFile(fp)
+		b, err := readBackupFile(fp)
 		if err != nil {
 			return err
 		}
@@ -260,27 +242,124 @@ func RestoreServiceConnectionsFromBackup(
 		if err := json.Unmarshal(b, &ep); err != nil {
 			return err
 		}
+		sourceName := ep.Name
+		setName := func(name string) {
+			ep.Name = name
+			if ep.Raw != nil {
+				ep.Raw["name"] = name
+			}
+		}
+		setName(item.rename(sourceName))
 
-		existing, err := FindServiceConnectionByName(targetOrgURL, targetProject, resourceGUID, ep.Name)
+		existing, err := item.idx.serviceConnection(targetOrgURL, targetProject, resourceGUID, ep.Name)
 		if err != nil {
 			return err
 		}
 		if existing != nil {
-			fmt.Println("✔ Service connection exists, skipping:", ep.Name)
-			continue
+			alt, ok, err := item.collide(sourceName, ep.Name, func(n string) (bool, error) {
+				e, err := item.idx.serviceConnection(targetOrgURL, targetProject, resourceGUID, n)
+				return e != nil, err
+			})
+			if err != nil {
+				return err
+			}
+			if ok {
+				setName(alt)
+				existing = nil
+			}
+		}
+		if existing != nil && !opts.updates(KindServiceConnections) {
+			item.println("✔ Service connection exists, skipping:", ep.Name)
+			item.exists(ep.Name, ep.Id, existing.Id)
+			return nil
+		}
+		if existing == nil && !opts.creates(KindServiceConnections) {
+			item.println("Service connection not in target, skipping:", ep.Name)
+			item.missing(ep.Name, ep.Id)
+			return nil
+		}
+
+		if ep.Raw == nil {
+			b, _ := json.Marshal(ep)
+			_ = json.Unmarshal(b, &ep.Raw)
+		}
+		sanitizeServiceConnectionForCreate(ep.Raw)
+		item.rewrite(ep.Raw)
+
+		if existing != nil {
+			// Secrets are never in the backup: keep the target's authorization.
+			warnings := []string{"authorization kept from target (secrets are not in the backup)"}
+			payload := serviceConnectionUpdateBody(ep, *existing, targetProject, pinfo.Id)
+			if item.planned(ep.Name, ep.Id, ActionUpdate, payload, warnings) {
+				return nil
+			}
+			item.println("Updating service connection:", ep.Name)
+			err := UpdateServiceConnection(targetOrgURL, resourceGUID, existing.Id, payload)
+			if err != nil {
+				item.printf("⚠ Failed to update '%s'.\n%s\n", ep.Name, err.Error())
+			}
+			item.done(ep.Name, ep.Id, ActionUpdate, existing.Id, warnings, err)
+			return nil
+		}
+
+		if item.planned(ep.Name, ep.Id, ActionCreate, serviceConnectionCreateBody(ep, targetProject, pinfo.Id), nil) {
+			return nil
 		}
 
-		fmt.Println("Creating service connection:", ep.Name)
-		_, err = CreateServiceConnection(targetOrgURL, targetProject, resourceGUID, ep, pinfo.Id)
+		item.println("Creating service connection:", ep.Name)
+		id, err := CreateServiceConnection(targetOrgURL, targetProject, resourceGUID, ep, pinfo.Id)
 		if err != nil {
 			// Most common reason: missing secrets / auth params
-			fmt.Printf("⚠ Failed to create '%s'. Likely needs manual re-auth / secrets.\n%s\n", ep.Name, err.Error())
-			continue
+			item.printf("⚠ Failed to create '%s'. Likely needs manual re-auth / secrets.\n%s\n", ep.Name, err.Error())
+			item.done(ep.Name, ep.Id, ActionCreate, "", nil, err)
+			return nil
 		}
+		item.idx.addServiceConnection(targetOrgURL, targetProject, ServiceEndpoint{Id: id, Name: ep.Name, Type: ep.Type, Url: ep.Url})
+		item.done(ep.Name, ep.Id, ActionCreate, id, nil, nil)
+		item.created(sourceName, ep.Name)
+		return nil
+	})
+	if err != nil {
+		return run.results, err
 	}
 
-	fmt.Println("✔ Service connections restore finished (check warnings above)")
-	return nil
+	run.println("✔ Service connections restore finished (check warnings above)")
+	return run.results, nil
+}
+
+// serviceConnectionCreateBody returns the POST body for ep in the target project.
+func serviceConnectionCreateBody(ep ServiceEndpoint, project, targetProjectID string) map[string]any {
+	if ep.Raw == nil {
+		b, _ := json.Marshal(ep)
+		_ = json.Unmarshal(b, &ep.Raw)
+	}
+
+	sanitizeServiceConnectionForCreate(ep.Raw)
+
+	// inject target project reference (required)
+	ep.Raw["serviceEndpointProjectReferences"] = []map[string]any{
+		{
+			"projectReference": map[string]any{
+				"id":   targetProjectID,
+				"name": project, // target project name
+			},
+			"name":        ep.Name,
+			"description": ep.Description,
+		},
+	}
+	return ep.Raw
+}
+
+// serviceConnectionUpdateBody returns the PUT body that replaces existing with ep.
+func serviceConnectionUpdateBody(ep, existing ServiceEndpoint, project, targetProjectID string) map[string]any {
+	body := deepCopyMap(serviceConnectionCreateBody(ep, project, targetProjectID))
+	body["id"] = existing.Id
+	if existing.Raw != nil {
+		if auth, ok := existing.Raw["authorization"]; ok {
+			body["authorization"] = auth
+		}
+	}
+	return body
 }
 
 func sanitizeServiceConnectionForCreate(epRaw map[string]any) {
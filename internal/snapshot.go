@@ -0,0 +1,491 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Every backup run writes a new snapshot of the project:
+//
+//	{BackupRoot}/{org}/{project}/
+//	  snapshots/20261017T020000Z/   kind folders, refs.json, snapshot.json and manifest.json of one run
+//	  latest                        the newest snapshot (a symlink, or a file holding its id)
+//
+// A restore reads each kind from the newest finished snapshot that holds it,
+// so kinds backed up by separate commands restore together. Backups written
+// before snapshots existed sit directly in the project folder; they are read
+// when no snapshot holds a kind.
+const (
+	SnapshotsDir   = "snapshots"
+	LatestSnapshot = "latest"
+
+	snapshotFile     = "snapshot.json"
+	snapshotIDLayout = "20060102T150405Z"
+
+	// staleSnapshotAge is how long an unfinished snapshot may still be
+	// written to; prune removes older ones.
+	staleSnapshotAge = 24 * time.Hour
+)
+
+// Snapshot is one backup run of a project, described by its snapshot.json.
+type Snapshot struct {
+	ID            string    `json:"id"`
+	SchemaVersion int       `json:"schemaVersion,omitempty"` // see BackupSchemaVersion; 0 in snapshot.json means 1
+	StartedAt     time.Time `json:"startedAt"`
+	FinishedAt    time.Time `json:"finishedAt"`
+	Kinds         []string  `json:"kinds"`            // kinds backed up completely
+	Failed        []string  `json:"failed,omitempty"` // kinds whose backup failed
+
+	dir     string
+	org     *OrganizationConfig // the source org; set for snapshots being written
+	project string
+}
+
+// NewSnapshot creates the folder of a new snapshot of project below
+// projectRoot ({BackupRoot}/{org}/{project}).
+func NewSnapshot(projectRoot string, org *OrganizationConfig, project string) (*Snapshot, error) {
+	// a key that cannot sign should fail the backup before it starts
+	if org != nil && org.SigningKeyFile != "" {
+		if _, err := loadSigningKey(org.SigningKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	return newSnapshotAt(projectRoot, time.Now(), org, project)
+}
+
+// newSnapshotAt creates the folder of a snapshot started at t, or the first
+// free second after it.
+func newSnapshotAt(projectRoot string, t time.Time, org *OrganizationConfig, project string) (*Snapshot, error) {
+	if err := os.MkdirAll(filepath.Join(projectRoot, SnapshotsDir), 0755); err != nil {
+		return nil, err
+	}
+	t = t.UTC().Truncate(time.Second)
+	for {
+		id := t.Format(snapshotIDLayout)
+		dir := filepath.Join(projectRoot, SnapshotsDir, id)
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			return &Snapshot{ID: id, SchemaVersion: BackupSchemaVersion, StartedAt: t, dir: dir, org: org, project: project}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		t = t.Add(time.Second) // another run started in the same second
+	}
+}
+
+// Dir returns the folder of the snapshot.
+func (s *Snapshot) Dir() string {
+	return s.dir
+}
+
+// Path returns the folder of kind in the snapshot.
+func (s *Snapshot) Path(kind string) string {
+	return filepath.Join(s.dir, KindDir(kind))
+}
+
+// projectRoot returns the project folder the snapshot belongs to.
+func (s *Snapshot) projectRoot() string {
+	return filepath.Dir(filepath.Dir(s.dir))
+}
+
+// Finished reports whether the run that wrote the snapshot ended.
+func (s *Snapshot) Finished() bool {
+	return !s.FinishedAt.IsZero()
+}
+
+// Has reports whether the snapshot holds a complete backup of kind.
+func (s *Snapshot) Has(kind string) bool {
+	return contains(s.Kinds, kind)
+}
+
+// Finish writes snapshot.json with the kinds the run backed up and the ones
+// that failed, then the manifest of the snapshot, and points latest at the
+// snapshot if any kind was backed up.
+func (s *Snapshot) Finish(kinds, failed []string) error {
+	s.Kinds, s.Failed = kinds, failed
+	s.FinishedAt = time.Now().UTC()
+	if err := s.write(); err != nil {
+		return err
+	}
+	if len(kinds) == 0 {
+		return nil
+	}
+	return setLatest(s.projectRoot(), s.ID)
+}
+
+// write writes snapshot.json and then the manifest, which covers it.
+func (s *Snapshot) write() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, snapshotFile), data, 0644); err != nil {
+		return err
+	}
+	return writeBackupManifest(s)
+}
+
+// setLatest points {projectRoot}/latest at snapshot id: a relative symlink
+// where the file system allows one, else a file holding the id.
+func setLatest(projectRoot, id string) error {
+	link := filepath.Join(projectRoot, LatestSnapshot)
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if os.Symlink(filepath.Join(SnapshotsDir, id), link) == nil {
+		return nil
+	}
+	return os.WriteFile(link, []byte(id+"\n"), 0644)
+}
+
+// latestID returns the snapshot latest points at; "" if there is none.
+func latestID(projectRoot string) string {
+	link := filepath.Join(projectRoot, LatestSnapshot)
+	if target, err := os.Readlink(link); err == nil {
+		return filepath.Base(target)
+	}
+	data, err := os.ReadFile(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// ListSnapshots returns the snapshots of projectRoot, oldest first,
+// including unfinished ones. A project without snapshots has none.
+func ListSnapshots(projectRoot string) ([]*Snapshot, error) {
+	dir := filepath.Join(projectRoot, SnapshotsDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Snapshot
+	for _, e := range entries {
+		t, err := time.Parse(snapshotIDLayout, e.Name())
+		if !e.IsDir() || err != nil {
+			continue
+		}
+		s := &Snapshot{ID: e.Name(), StartedAt: t, dir: filepath.Join(dir, e.Name())}
+		data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+		if err == nil {
+			if err := json.Unmarshal(data, s); err != nil {
+				return nil, fmt.Errorf("failed parsing %s: %w", filepath.Join(s.dir, snapshotFile), err)
+			}
+			s.ID = e.Name()
+			if s.SchemaVersion == 0 {
+				s.SchemaVersion = BackupSchemaSnapshots
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// FindSnapshot returns snapshot id of projectRoot; "" is the one latest
+// points at.
+func FindSnapshot(projectRoot, id string) (*Snapshot, error) {
+	if id == "" {
+		if id = latestID(projectRoot); id == "" {
+			return nil, fmt.Errorf("no finished snapshot in %s", projectRoot)
+		}
+	}
+	snaps, err := ListSnapshots(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snaps {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("no snapshot %s in %s", id, projectRoot)
+}
+
+// BackupPath returns the folder a restore of kind reads from projectRoot:
+// that of the newest finished snapshot holding kind and taken at or before
+// at (any time if at is zero), with the snapshot. Without such a snapshot and
+// without at, it is the folder of a backup written before snapshots existed
+// (which may not exist either) and the snapshot is nil.
+func BackupPath(projectRoot, kind string, at time.Time) (string, *Snapshot, error) {
+	if kind == StepPush {
+		kind = KindRepos
+	}
+	snaps, err := ListSnapshots(projectRoot)
+	if err != nil {
+		return "", nil, err
+	}
+	for i := len(snaps) - 1; i >= 0; i-- {
+		s := snaps[i]
+		if s.Finished() && s.Has(kind) && (at.IsZero() || !s.StartedAt.After(at)) {
+			if err := s.checkSchema(); err != nil {
+				return "", nil, err
+			}
+			return s.Path(kind), s, nil
+		}
+	}
+	if !at.IsZero() {
+		return "", nil, fmt.Errorf("no snapshot of %s taken at or before %s in %s", kind, at.Format(time.RFC3339), projectRoot)
+	}
+	return filepath.Join(projectRoot, KindDir(kind)), nil, nil
+}
+
+// ParseSnapshotTime parses an --at value: a date (2026-09-30, the end of
+// that day), a date and time (2026-09-30T18:00, or with seconds), both in
+// local time, an RFC 3339 time or a snapshot id. "" is the zero time.
+func ParseSnapshotTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{time.RFC3339, snapshotIDLayout} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s' (use 2006-01-02, 2006-01-02T15:04, RFC 3339 or a snapshot id)", value)
+}
+
+// CarryForward copies the newest earlier backup of kind into the snapshot,
+// for a run that backs up only some items of kind: the snapshot then holds
+// the other items as they were. Git mirrors are cloned, their objects hard
+// linked; other files, the encrypted bundles of an encrypted store among
+// them, are copied, since backups rewrite them in place. Encrypted files are
+// encrypted again for the snapshot (see copyBackupFile).
+func (s *Snapshot) CarryForward(kind string) error {
+	prev, _, err := BackupPath(s.projectRoot(), kind, time.Time{})
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(prev); os.IsNotExist(err) {
+		return nil
+	}
+	return copyBackupDir(prev, s.Path(kind))
+}
+
+func copyBackupDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		from, to := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+		switch {
+		case e.IsDir() && isMirror(from):
+			key, err := backupKey(to, true)
+			if err == nil && key != nil {
+				err = errNotEncrypted(from)
+			} else if err == nil {
+				err = copyMirror(from, to)
+			}
+			if err != nil {
+				return err
+			}
+		case e.IsDir():
+			if err := copyBackupDir(from, to); err != nil {
+				return err
+			}
+		case e.Type().IsRegular():
+			if err := copyBackupFile(from, to); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyBackupFile copies a backup file, decrypting it if the store of dst has
+// stopped encrypting since src was written. Encrypted files are sealed to
+// their place (see sealedName), so they are encrypted again for dst; a plain
+// src is not encrypted on the way, since an encrypted store must not hold
+// one.
+func copyBackupFile(src, dst string) error {
+	key, err := backupKey(dst, true)
+	if err != nil {
+		return err
+	}
+	switch encrypted := isEncryptedFile(src); {
+	case key != nil && !encrypted:
+		return errNotEncrypted(src)
+	case key != nil:
+		return resealFile(src, dst)
+	case encrypted:
+		return decryptFile(src, dst)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, info.Mode().Perm())
+}
+
+// mirrorSeeds returns the folders of kind in earlier backups, newest first,
+// for the new mirrors in kindPath to start from: those of the finished
+// snapshots holding kind, then that of a backup written before snapshots
+// existed. It returns none if kindPath is not in a snapshot.
+func mirrorSeeds(kindPath, kind string) []string {
+	snapDir := filepath.Clean(strings.TrimSuffix(filepath.Clean(kindPath), KindDir(kind)))
+	if filepath.Base(filepath.Dir(snapDir)) != SnapshotsDir {
+		return nil
+	}
+	projectRoot := filepath.Dir(filepath.Dir(snapDir))
+	snaps, _ := ListSnapshots(projectRoot)
+
+	var seeds []string
+	for i := len(snaps) - 1; i >= 0; i-- {
+		if s := snaps[i]; s.dir != snapDir && s.Finished() && s.Has(kind) {
+			seeds = append(seeds, s.Path(kind))
+		}
+	}
+	return append(seeds, filepath.Join(projectRoot, KindDir(kind)))
+}
+
+// seedMirror returns the newest mirror dir (e.g. "api.git") in seeds, or
+// its bundle (see backupMirror); "" if none has one.
+func seedMirror(seeds []string, dir string) string {
+	for _, seed := range seeds {
+		fp := filepath.Join(seed, dir)
+		if isMirror(fp) {
+			return fp
+		}
+		if _, err := os.Stat(fp + bundleSuffix); err == nil {
+			return fp + bundleSuffix
+		}
+	}
+	return ""
+}
+
+// RetentionConfig is how many snapshots prune keeps: the newest one of each
+// of the last KeepDaily days, KeepWeekly weeks and KeepMonthly months that
+// have one, counted per kind. The newest snapshot of each kind is always kept.
+type RetentionConfig struct {
+	KeepDaily   int `json:"keepDaily,omitempty"`
+	KeepWeekly  int `json:"keepWeekly,omitempty"`
+	KeepMonthly int `json:"keepMonthly,omitempty"`
+}
+
+// Empty reports whether the policy keeps nothing but the newest snapshots.
+func (r RetentionConfig) Empty() bool {
+	return r.KeepDaily <= 0 && r.KeepWeekly <= 0 && r.KeepMonthly <= 0
+}
+
+// PruneResult is what PruneSnapshots kept and removed.
+type PruneResult struct {
+	Kept    []*Snapshot
+	Removed []*Snapshot
+	Reasons map[string][]string // snapshot id -> why it was kept
+}
+
+// PruneSnapshots removes the snapshots of projectRoot the retention policy
+// does not keep. Periods are calendar days, ISO weeks and months in local
+// time. Each kind is counted on its own, so a kind backed up less often than
+// the others keeps its snapshots. Unfinished snapshots are removed once they
+// are a day old. In a dry run nothing is removed.
+func PruneSnapshots(projectRoot string, keep RetentionConfig, dryRun bool) (*PruneResult, error) {
+	if keep.Empty() {
+		return nil, fmt.Errorf("empty retention policy: set keepDaily, keepWeekly or keepMonthly")
+	}
+	snaps, err := ListSnapshots(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	reasons := map[string][]string{}
+	mark := func(s *Snapshot, why string) {
+		if !contains(reasons[s.ID], why) {
+			reasons[s.ID] = append(reasons[s.ID], why)
+		}
+	}
+	periods := []struct {
+		name string
+		n    int
+		key  func(t time.Time) string
+	}{
+		{"daily", keep.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", keep.KeepWeekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{"monthly", keep.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	for _, kind := range AllKinds {
+		var list []*Snapshot // newest first
+		for i := len(snaps) - 1; i >= 0; i-- {
+			if snaps[i].Finished() && snaps[i].Has(kind) {
+				list = append(list, snaps[i])
+			}
+		}
+		if len(list) == 0 {
+			continue
+		}
+		mark(list[0], "newest "+kind)
+		for _, p := range periods {
+			seen := map[string]bool{}
+			for _, s := range list {
+				k := p.key(s.StartedAt.Local())
+				if seen[k] {
+					continue
+				}
+				if len(seen) >= p.n {
+					break
+				}
+				seen[k] = true
+				mark(s, p.name)
+			}
+		}
+	}
+
+	latest := latestID(projectRoot)
+	res := &PruneResult{Reasons: reasons}
+	for _, s := range snaps {
+		switch {
+		case len(reasons[s.ID]) > 0:
+		case s.ID == latest:
+			mark(s, "latest")
+		case !s.Finished() && time.Since(s.StartedAt) < staleSnapshotAge:
+			mark(s, "unfinished")
+		default:
+			res.Removed = append(res.Removed, s)
+			continue
+		}
+		res.Kept = append(res.Kept, s)
+	}
+
+	if dryRun {
+		return res, nil
+	}
+	for _, s := range res.Removed {
+		if err := os.RemoveAll(s.dir); err != nil {
+			return res, fmt.Errorf("remove snapshot %s: %w", s.ID, err)
+		}
+	}
+	return res, nil
+}
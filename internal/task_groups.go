@@ -2,17 +2,16 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-)
+	"sync"
 
-type TaskGroupListResponse struct {
-	Count int         `json:"count"`
-	Value []TaskGroup `json:"value"`
-}
+	"azdo-vault/internal/adoclient"
+)
 
 type TaskGroup struct {
 	Id           string           `json:"id,omitempty"`
@@ -95,18 +94,40 @@ func (t TaskGroup) MarshalJSON() ([]byte, error) {
 
 func ListTaskGroups(orgURL, project, resourceGUID string) ([]TaskGroup, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/distributedtask/taskgroups?api-version=7.1", orgURL, project)
-	cmd := exec.Command("az", "rest", "--method", "get", "--uri", uri, "--resource", resourceGUID, "--output", "json", "--only-show-errors")
-
-	out, err := cmd.CombinedOutput()
+	list, err := adoList[TaskGroup](orgURL, resourceGUID, uri, adoclient.Paging{})
 	if err != nil {
-		return nil, fmt.Errorf("az rest list taskgroups failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("list taskgroups failed: %w", err)
 	}
+	return list, nil
+}
 
-	var resp TaskGroupListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, err
+func sanitizeTaskGroupForCreate(raw map[string]any) {
+	// server-managed
+	delete(raw, "id")
+	delete(raw, "revision")
+	delete(raw, "createdBy")
+	delete(raw, "modifiedBy")
+	delete(raw, "modifiedOn")
+	delete(raw, "createdOn")
+
+	// links / urls that can vary
+	delete(raw, "_links")
+	delete(raw, "url")
+	delete(raw, "uri")
+}
+
+// UpdateTaskGroup replaces task group id with payload (already sanitized and
+// remapped), based on its current revision in the target.
+func UpdateTaskGroup(orgURL, project, resourceGUID, id string, revision int, payload map[string]any) error {
+	body := deepCopyMap(payload)
+	body["id"] = id
+	body["revision"] = revision
+
+	uri := fmt.Sprintf("%s/%s/_apis/distributedtask/taskgroups/%s?api-version=7.1", orgURL, project, id)
+	if _, err := adoSend(orgURL, resourceGUID, "put", uri, body); err != nil {
+		return fmt.Errorf("update taskgroup failed: %w", err)
 	}
-	return resp.Value, nil
+	return nil
 }
 
 func CreateTaskGroup(orgURL, project, resourceGUID string, tg TaskGroup) (string, error) {
@@ -116,18 +137,7 @@ func CreateTaskGroup(orgURL, project, resourceGUID string, tg TaskGroup) (string
 		_ = json.Unmarshal(b, &tg.Raw)
 	}
 
-	// server-managed
-	delete(tg.Raw, "id")
-	delete(tg.Raw, "revision")
-	delete(tg.Raw, "createdBy")
-	delete(tg.Raw, "modifiedBy")
-	delete(tg.Raw, "modifiedOn")
-	delete(tg.Raw, "createdOn")
-
-	// links / urls that can vary
-	delete(tg.Raw, "_links")
-	delete(tg.Raw, "url")
-	delete(tg.Raw, "uri")
+	sanitizeTaskGroupForCreate(tg.Raw)
 
 	bodyBytes, err := json.Marshal(tg.Raw)
 	if err != nil {
@@ -135,20 +145,9 @@ func CreateTaskGroup(orgURL, project, resourceGUID string, tg TaskGroup) (string
 	}
 
 	uri := fmt.Sprintf("%s/%s/_apis/distributedtask/taskgroups?api-version=7.1", orgURL, project)
-	cmd := exec.Command(
-		"az", "rest",
-		"--method", "post",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--headers", "Content-Type=application/json",
-		"--body", string(bodyBytes),
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoSend(orgURL, resourceGUID, "post", uri, bodyBytes)
 	if err != nil {
-		return "", fmt.Errorf("az rest create taskgroup failed: %w\n%s", err, string(out))
+		return "", fmt.Errorf("create taskgroup failed: %w", err)
 	}
 
 	var created map[string]any
@@ -174,73 +173,51 @@ func FindTaskGroupByName(orgURL, project, resourceGUID, name string) (*TaskGroup
 	return nil, nil
 }
 
-func BackupTaskGroups(orgURL, project, backupPath string, selected []string, resourceGUID string) error {
+func BackupTaskGroups(orgURL, project, backupPath string, selected []string, resourceGUID string, exec *ExecOptions) error {
 	all, err := ListTaskGroups(orgURL, project, resourceGUID)
 	if err != nil {
 		return err
 	}
 
-	backupAll := len(selected) == 1 && selected[0] == "all"
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return err
+	}
 	if len(all) == 0 {
 		fmt.Println("No task groups found")
 		return nil
 	}
+	fmt.Printf("Found %d task groups\n", len(all))
 
 	if err := os.MkdirAll(backupPath, 0755); err != nil {
 		return err
 	}
 
+	var items []TaskGroup
 	for _, tg := range all {
-		if !backupAll && !contains(selected, tg.Name) {
+		if !sel.Match(tg.Name) {
 			continue
 		}
+		items = append(items, tg)
+	}
 
+	err = forEach(len(items), exec.workers(KindTaskGroups), exec.failFast(true), func(i int, out io.Writer) error {
+		tg := items[i]
 		fp := filepath.Join(backupPath, tg.Name+".json")
 		data, err := json.MarshalIndent(tg, "", "  ")
 		if err != nil {
 			return err
 		}
-		if err := os.WriteFile(fp, data, 0644); err != nil {
+		if err := writeBackupFile(fp, data); err != nil {
 			return err
 		}
-		fmt.Println("✔ Backed up task group:", tg.Name)
-	}
-	return nil
-}
-
-// ------------------------------------------------------------
-// Mapping helpers (service connections inside task group steps)
-// ------------------------------------------------------------
-func buildServiceConnectionMaps(
-	sourceOrgURL, sourceProject,
-	targetOrgURL, targetProject,
-	resourceGUID string,
-) (map[string]string, map[string]string, error) {
-
-	src, err := ListServiceConnections(sourceOrgURL, sourceProject, resourceGUID)
-	if err != nil {
-		return nil, nil, fmt.Errorf("list source service connections failed: %w", err)
-	}
-	tgt, err := ListServiceConnections(targetOrgURL, targetProject, resourceGUID)
+		fmt.Fprintln(out, "✔ Backed up task group:", tg.Name)
+		return nil
+	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("list target service connections failed: %w", err)
-	}
-
-	srcIDToName := map[string]string{}
-	for _, e := range src {
-		if e.Id != "" && e.Name != "" {
-			srcIDToName[strings.ToLower(e.Id)] = e.Name
-		}
-	}
-
-	tgtNameToID := map[string]string{}
-	for _, e := range tgt {
-		if e.Id != "" && e.Name != "" {
-			tgtNameToID[e.Name] = e.Id
-		}
+		return err
 	}
-
-	return srcIDToName, tgtNameToID, nil
+	return nil
 }
 
 func looksLikeGUID(s string) bool {
@@ -288,78 +265,279 @@ func RemapTaskGroupServiceConnections(
 	}
 }
 
+// RemapTaskGroupNestedGroups rewrites the ids of task groups used as tasks
+// inside another task group: source id -> name -> target id. It returns the
+// names of the nested groups that are not in the target; their ids are left
+// as they are.
+func RemapTaskGroupNestedGroups(
+	tgRaw map[string]any,
+	srcTaskGroupIDToName map[string]string,
+	tgtTaskGroupNameToID map[string]string,
+) []string {
+	var unresolved []string
+	tasksAny, _ := tgRaw["tasks"].([]any)
+	for _, t := range tasksAny {
+		task, _ := t.(map[string]any)
+		ref, _ := task["task"].(map[string]any)
+		id, _ := ref["id"].(string)
+		if id == "" {
+			continue
+		}
+		if name, ok := srcTaskGroupIDToName[strings.ToLower(id)]; ok {
+			if newID, ok := tgtTaskGroupNameToID[name]; ok {
+				ref["id"] = newID
+			} else {
+				unresolved = append(unresolved, name)
+			}
+		}
+	}
+	return unresolved
+}
+
+// taskGroupLevels orders groups for restore: level 0 uses no other group of
+// the backup, level n only groups of lower levels. Groups of one level can be
+// restored in parallel. Groups in a reference cycle go to the last level.
+func taskGroupLevels(groups []TaskGroup) [][]int {
+	index := map[string]int{}
+	for i, tg := range groups {
+		if tg.Id != "" {
+			index[strings.ToLower(tg.Id)] = i
+		}
+	}
+
+	deps := make([][]int, len(groups))
+	for i, tg := range groups {
+		tasksAny, _ := tg.Raw["tasks"].([]any)
+		for _, t := range tasksAny {
+			task, _ := t.(map[string]any)
+			ref, _ := task["task"].(map[string]any)
+			id, _ := ref["id"].(string)
+			if j, ok := index[strings.ToLower(id)]; ok && j != i {
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+
+	placed := make([]bool, len(groups))
+	var levels [][]int
+	for remaining := len(groups); remaining > 0; {
+		var cur []int
+		for i := range groups {
+			if placed[i] {
+				continue
+			}
+			ready := true
+			for _, j := range deps[i] {
+				if !placed[j] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				cur = append(cur, i)
+			}
+		}
+		if len(cur) == 0 {
+			// cycle: the rest go together; their nested ids cannot resolve
+			for i := range groups {
+				if !placed[i] {
+					cur = append(cur, i)
+				}
+			}
+		}
+		for _, i := range cur {
+			placed[i] = true
+		}
+		remaining -= len(cur)
+		levels = append(levels, cur)
+	}
+	return levels
+}
+
 // ------------------------------------------------------------
 // Restore with remap
 // ------------------------------------------------------------
 
-// NEW SIGNATURE: needs source org/project so we can translate source endpoint IDs -> endpoint name -> target endpoint ID
+// Source endpoint IDs are translated -> endpoint name -> target endpoint ID through the
+// backup's reference index (source org/project are only queried for older backups).
+// Task groups used inside other task groups are restored first, level by level.
 func RestoreTaskGroupsFromBackup(
 	sourceOrgURL, sourceProject string,
 	targetOrgURL, targetProject string,
 	backupPath string,
 	selected []string,
 	resourceGUID string,
-) error {
+	opts *RestoreOptions,
+) ([]RestoreResult, error) {
+	run := newRestoreRun(KindTaskGroups, opts)
 
 	files, err := os.ReadDir(backupPath)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 
-	restoreAll := len(selected) == 1 && selected[0] == "all"
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return run.results, err
+	}
 
-	srcEPIDToName, tgtEPNameToID, err := buildServiceConnectionMaps(
-		sourceOrgURL, sourceProject,
-		targetOrgURL, targetProject,
-		resourceGUID,
-	)
+	refs, err := sourceRefs(backupPath, sourceOrgURL, sourceProject, resourceGUID)
 	if err != nil {
-		return err
+		return run.results, err
+	}
+	if err := run.rewriteFrom(refs, targetOrgURL, targetProject, resourceGUID); err != nil {
+		return run.results, err
+	}
+
+	srcEPIDToName, tgtEPNameToID, err := BuildEndpointMaps(run.idx, run.names, refs, targetOrgURL, targetProject, resourceGUID)
+	if err != nil {
+		return run.results, err
+	}
+	srcTGIDToName, tgtTGNameToID, err := BuildTaskGroupMaps(run.idx, run.names, refs, targetOrgURL, targetProject, resourceGUID)
+	if err != nil {
+		return run.results, err
 	}
+	var tgtTGMu sync.Mutex
 
+	var groups []TaskGroup
+	var sourceNames []string
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name(), ".json") {
 			continue
 		}
 
 		name := strings.TrimSuffix(f.Name(), ".json")
-		if !restoreAll && !contains(selected, name) {
+		if !sel.Match(name) {
 			continue
 		}
 
 		fp := filepath.Join(backupPath, f.Name())
-		b, err := os.ReadFile(fp)
+		b, err := readBackupFile(fp)
 		if err != nil {
-			return err
+			return run.results, err
 		}
 
 		var tg TaskGroup
 		if err := json.Unmarshal(b, &tg); err != nil {
-			return err
+			return run.results, err
 		}
 
 		if tg.Raw == nil {
 			tmp, _ := json.Marshal(tg)
 			_ = json.Unmarshal(tmp, &tg.Raw)
 		}
+		sourceNames = append(sourceNames, tg.Name)
+		tg.Name = run.rename(tg.Name)
+		tg.Raw["name"] = tg.Name
+		if tg.Id != "" {
+			srcTGIDToName[strings.ToLower(tg.Id)] = tg.Name
+		}
+		groups = append(groups, tg)
+	}
 
-		existing, err := FindTaskGroupByName(targetOrgURL, targetProject, resourceGUID, tg.Name)
+	restore := func(i int, item *restoreRun) error {
+		tg := groups[i]
+		existing, err := item.idx.taskGroup(targetOrgURL, targetProject, resourceGUID, tg.Name)
 		if err != nil {
 			return err
 		}
 		if existing != nil {
-			fmt.Println("✔ Task group exists, skipping:", tg.Name)
-			continue
+			alt, ok, err := item.collide(sourceNames[i], tg.Name, func(n string) (bool, error) {
+				e, err := item.idx.taskGroup(targetOrgURL, targetProject, resourceGUID, n)
+				return e != nil, err
+			})
+			if err != nil {
+				return err
+			}
+			if ok {
+				// groups nesting this one are restored in a later level
+				tg.Name, existing = alt, nil
+				tg.Raw["name"] = alt
+				tgtTGMu.Lock()
+				if tg.Id != "" {
+					srcTGIDToName[strings.ToLower(tg.Id)] = alt
+				}
+				tgtTGMu.Unlock()
+			}
+		}
+		if existing != nil && !opts.updates(KindTaskGroups) {
+			item.println("✔ Task group exists, skipping:", tg.Name)
+			item.exists(tg.Name, tg.Id, existing.Id)
+			return nil
+		}
+		if existing == nil && !opts.creates(KindTaskGroups) {
+			item.println("Task group not in target, skipping:", tg.Name)
+			item.missing(tg.Name, tg.Id)
+			return nil
 		}
 
 		RemapTaskGroupServiceConnections(tg.Raw, srcEPIDToName, tgtEPNameToID)
+		tgtTGMu.Lock()
+		unresolved := RemapTaskGroupNestedGroups(tg.Raw, srcTGIDToName, tgtTGNameToID)
+		tgtTGMu.Unlock()
+		// a dry run creates no nested group, so it can only warn
+		var warnings []string
+		for _, name := range unresolved {
+			reason := fmt.Sprintf("nested task group '%s' is not in the target", name)
+			if !item.dryRun {
+				item.printf("⚠ %s, skipping: %s\n", reason, tg.Name)
+				item.unresolvable(tg.Name, tg.Id, reason)
+				return nil
+			}
+			item.printf("⚠ %s\n", reason)
+			warnings = append(warnings, reason)
+		}
 
-		fmt.Println("Creating task group:", tg.Name)
-		if _, err := CreateTaskGroup(targetOrgURL, targetProject, resourceGUID, tg); err != nil {
+		sanitizeTaskGroupForCreate(tg.Raw)
+		item.rewrite(tg.Raw)
+		planned := deepCopyMap(tg.Raw)
+
+		if existing != nil {
+			if item.planned(tg.Name, tg.Id, ActionUpdate, planned, warnings) {
+				return nil
+			}
+			item.println("Updating task group:", tg.Name)
+			err := UpdateTaskGroup(targetOrgURL, targetProject, resourceGUID, existing.Id, intFromAny(existing.Raw["revision"]), planned)
+			item.done(tg.Name, tg.Id, ActionUpdate, existing.Id, nil, err)
 			return err
 		}
+
+		if item.planned(tg.Name, tg.Id, ActionCreate, planned, warnings) {
+			return nil
+		}
+
+		item.println("Creating task group:", tg.Name)
+		id, err := CreateTaskGroup(targetOrgURL, targetProject, resourceGUID, tg)
+		if err != nil {
+			item.done(tg.Name, tg.Id, ActionCreate, "", nil, err)
+			return err
+		}
+		item.idx.addTaskGroup(targetOrgURL, targetProject, TaskGroup{Id: id, Name: tg.Name})
+		item.done(tg.Name, tg.Id, ActionCreate, id, nil, nil)
+		item.created(sourceNames[i], tg.Name)
+
+		tgtTGMu.Lock()
+		tgtTGNameToID[tg.Name] = id
+		tgtTGMu.Unlock()
+		return nil
 	}
 
-	fmt.Println("✔ Task groups restored")
-	return nil
+	var errs []error
+	for _, level := range taskGroupLevels(groups) {
+		err := run.each(len(level), func(i int, item *restoreRun) error {
+			return restore(level[i], item)
+		})
+		if err != nil {
+			if run.exec.failFast(true) {
+				return run.results, err
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return run.results, errors.Join(errs...)
+	}
+
+	run.println("✔ Task groups restored")
+	return run.results, nil
 }
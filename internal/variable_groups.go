@@ -3,10 +3,14 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+
+	"azdo-vault/internal/adoclient"
 )
 
 type VariableGroupList struct {
@@ -26,36 +30,19 @@ type Variable struct {
 	IsSecret bool   `json:"isSecret,omitempty"`
 }
 
+// GET /{project}/_apis/distributedtask/variablegroups
 func ListVariableGroups(orgURL, project string) ([]VariableGroup, error) {
+	uri := fmt.Sprintf("%s/%s/_apis/distributedtask/variablegroups?api-version=7.1", orgURL, project)
 
-	cmd := exec.Command(
-		"az", "pipelines", "variable-group", "list",
-		"--organization", orgURL,
-		"--project", project,
-		"--output", "json",
-	)
-
-	out, err := cmd.Output()
+	list, err := adoList[VariableGroup](orgURL, "", uri, adoclient.Paging{})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("list variable groups failed: %w", err)
 	}
-
-	var groups []VariableGroup
-	err = json.Unmarshal(out, &groups)
-	return groups, err
+	return list, nil
 }
 
 func GetVariableGroup(orgURL, project string, id int) (*VariableGroup, error) {
-
-	cmd := exec.Command(
-		"az", "pipelines", "variable-group", "show",
-		"--group-id", fmt.Sprintf("%d", id),
-		"--organization", orgURL,
-		"--project", project,
-		"--output", "json",
-	)
-
-	out, err := cmd.Output()
+	out, err := getVariableGroupRaw(orgURL, project, id)
 	if err != nil {
 		return nil, err
 	}
@@ -65,100 +52,159 @@ func GetVariableGroup(orgURL, project string, id int) (*VariableGroup, error) {
 	return &group, err
 }
 
-func CreateVariableGroup(
-	orgURL,
-	project,
-	name string,
-	variables map[string]Variable,
-) (int, error) {
+// GET /{project}/_apis/distributedtask/variablegroups/{groupId}
+func getVariableGroupRaw(orgURL, project string, id int) ([]byte, error) {
+	uri := fmt.Sprintf("%s/%s/_apis/distributedtask/variablegroups/%d?api-version=7.1", orgURL, project, id)
 
-	args := []string{
-		"pipelines", "variable-group", "create",
-		"--name", name,
-		"--organization", orgURL,
-		"--project", project,
-		"--authorize", "true",
+	out, err := adoGet(orgURL, "", uri)
+	if err != nil {
+		return nil, fmt.Errorf("get variable group failed: %w", err)
 	}
+	return out, nil
+}
 
+// variableGroupCreateBody returns the POST body for a new group in project.
+// Secret values cannot be read back from the source, so secrets are left out.
+func variableGroupCreateBody(name string, pinfo *ProjectInfo, variables map[string]Variable) map[string]any {
 	// Add non-secret variables
-	varPairs := []string{}
+	vars := map[string]Variable{}
 	for k, v := range variables {
 		if !v.IsSecret {
-			varPairs = append(varPairs, fmt.Sprintf("%s=%s", k, v.Value))
+			vars[k] = v
 		}
 	}
 
-	if len(varPairs) == 0 {
+	if len(vars) == 0 {
 		// Azure requires at least one variable
-		varPairs = append(varPairs, "temp=placeholder")
+		vars["temp"] = Variable{Value: "placeholder"}
 	}
 
-	args = append(args, "--variables")
-	args = append(args, varPairs...)
+	return map[string]any{
+		"name":      name,
+		"type":      "Vsts",
+		"variables": vars,
+		"variableGroupProjectReferences": []map[string]any{
+			{
+				"name": name,
+				"projectReference": map[string]any{
+					"id":   pinfo.Id,
+					"name": pinfo.Name,
+				},
+			},
+		},
+	}
+}
 
-	args = append(args, "--output", "json")
+// CreateVariableGroup creates a group in project; pinfo is that project.
+// POST /_apis/distributedtask/variablegroups, then authorize the group for all pipelines.
+func CreateVariableGroup(
+	orgURL,
+	project string,
+	pinfo *ProjectInfo,
+	name string,
+	variables map[string]Variable,
+) (int, error) {
 
-	cmd := exec.Command("az", args...)
+	payload := variableGroupCreateBody(name, pinfo, variables)
 
-	out, err := cmd.CombinedOutput()
+	uri := fmt.Sprintf("%s/_apis/distributedtask/variablegroups?api-version=7.1", strings.TrimRight(orgURL, "/"))
+	out, err := adoSend(orgURL, "", "post", uri, payload)
 	if err != nil {
-		fmt.Println("AZ ERROR:", string(out))
-		return 0, err
+		return 0, fmt.Errorf("create variable group failed: %w", err)
 	}
 
 	var result VariableGroup
-	err = json.Unmarshal(out, &result)
+	if err := json.Unmarshal(out, &result); err != nil {
+		return 0, err
+	}
+
+	if err := authorizeVariableGroupForAllPipelines(orgURL, project, result.Id); err != nil {
+		fmt.Printf("⚠ Variable group '%s' created but not authorized for all pipelines: %v\n", name, err)
+	}
+
+	return result.Id, nil
+}
 
-	return result.Id, err
+// PATCH /{project}/_apis/pipelines/pipelinePermissions/variablegroup/{id}
+func authorizeVariableGroupForAllPipelines(orgURL, project string, groupID int) error {
+	uri := fmt.Sprintf("%s/%s/_apis/pipelines/pipelinePermissions/variablegroup/%d?api-version=7.1-preview.1", orgURL, project, groupID)
+	payload := map[string]any{
+		"allPipelines": map[string]any{"authorized": true},
+	}
+	_, err := adoSend(orgURL, "", "patch", uri, payload)
+	return err
 }
 
 func AddVariableToGroup(orgURL, project string, groupID int, name, value string, isSecret bool) error {
+	return updateVariableGroupVariables(orgURL, project, groupID, func(vars map[string]any) {
+		if isSecret {
+			vars[name] = map[string]any{"isSecret": true}
+			return
+		}
+		vars[name] = map[string]any{"value": value}
+	})
+}
 
-	args := []string{
-		"pipelines", "variable-group", "variable", "create",
-		"--group-id", fmt.Sprintf("%d", groupID),
-		"--name", name,
-		"--organization", orgURL,
-		"--project", project,
+// updateVariableGroupVariables reads the full group, lets mutate change its
+// variables and writes it back with PUT /_apis/distributedtask/variablegroups/{id}.
+func updateVariableGroupVariables(orgURL, project string, groupID int, mutate func(vars map[string]any)) error {
+	out, err := getVariableGroupRaw(orgURL, project, groupID)
+	if err != nil {
+		return err
 	}
 
-	if isSecret {
-		args = append(args, "--secret", "true")
-	} else {
-		args = append(args, "--value", value)
+	var raw map[string]any
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return fmt.Errorf("failed parsing variable group JSON: %w", err)
 	}
 
-	cmd := exec.Command("az", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	vars, _ := raw["variables"].(map[string]any)
+	if vars == nil {
+		vars = map[string]any{}
+		raw["variables"] = vars
+	}
+	mutate(vars)
 
-	return cmd.Run()
+	uri := fmt.Sprintf("%s/_apis/distributedtask/variablegroups/%d?api-version=7.1", strings.TrimRight(orgURL, "/"), groupID)
+	if _, err := adoSend(orgURL, "", "put", uri, raw); err != nil {
+		return fmt.Errorf("update variable group failed: %w", err)
+	}
+	return nil
 }
 
-func BackupVariableGroups(orgURL, project, backupPath string, selectedGroups []string) error {
+func BackupVariableGroups(orgURL, project, backupPath string, selectedGroups []string, exec *ExecOptions) error {
 
 	groups, err := ListVariableGroups(orgURL, project)
 	if err != nil {
 		return err
 	}
-	backupAll := len(selectedGroups) == 1 && selectedGroups[0] == "all"
+	sel, err := ParseSelector(selectedGroups)
+	if err != nil {
+		return err
+	}
 
 	if len(groups) == 0 {
 		fmt.Println("No variable groups found")
 		return nil
 	}
+	fmt.Printf("Found %d variable groups\n", len(groups))
 
 	err = os.MkdirAll(backupPath, 0755)
 	if err != nil {
 		return err
 	}
 
+	var items []VariableGroup
 	for _, g := range groups {
 
-		if !backupAll && !contains(selectedGroups, g.Name) {
+		if !sel.Match(g.Name) {
 			continue
 		}
+		items = append(items, g)
+	}
 
+	err = forEach(len(items), exec.workers(KindVariableGroups), exec.failFast(true), func(i int, out io.Writer) error {
+		g := items[i]
 		fullGroup, err := GetVariableGroup(orgURL, project, g.Id)
 		if err != nil {
 			return err
@@ -171,12 +217,16 @@ func BackupVariableGroups(orgURL, project, backupPath string, selectedGroups []s
 			return err
 		}
 
-		err = os.WriteFile(filePath, data, 0644)
+		err = writeBackupFile(filePath, data)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("✔ Backed up:", g.Name)
+		fmt.Fprintln(out, "✔ Backed up:", g.Name)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -196,15 +246,33 @@ func RestoreVariableGroupsFromBackup(
 	targetProject,
 	backupPath string,
 	selectedGroups []string,
-) error {
+	opts *RestoreOptions,
+) ([]RestoreResult, error) {
+	run := newRestoreRun(KindVariableGroups, opts)
 
 	files, err := os.ReadDir(backupPath)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 
-	restoreAll := len(selectedGroups) == 1 && selectedGroups[0] == "all"
+	pinfo, err := run.idx.project(targetOrgURL, targetProject, "")
+	if err != nil {
+		return run.results, err
+	}
+	refs, err := backupRefs(backupPath)
+	if err != nil {
+		return run.results, err
+	}
+	if err := run.rewriteFrom(refs, targetOrgURL, targetProject, ""); err != nil {
+		return run.results, err
+	}
 
+	sel, err := ParseSelector(selectedGroups)
+	if err != nil {
+		return run.results, err
+	}
+
+	var items []os.DirEntry
 	for _, f := range files {
 
 		if !strings.HasSuffix(f.Name(), ".json") {
@@ -213,13 +281,17 @@ func RestoreVariableGroupsFromBackup(
 
 		groupName := strings.TrimSuffix(f.Name(), ".json")
 
-		if !restoreAll && !contains(selectedGroups, groupName) {
+		if !sel.Match(groupName) {
 			continue
 		}
+		items = append(items, f)
+	}
 
+	err = run.each(len(items), func(i int, item *restoreRun) error {
+		f := items[i]
 		filePath := filepath.Join(backupPath, f.Name())
 
-		data, err := os.ReadFile(filePath)
+		data, err := readBackupFile(filePath)
 		if err != nil {
 			return err
 		}
@@ -229,37 +301,99 @@ func RestoreVariableGroupsFromBackup(
 		if err != nil {
 			return err
 		}
+		sourceName := group.Name
+		group.Name = item.rename(sourceName)
 
-		existing, err := FindVariableGroupByName(targetOrgURL, targetProject, group.Name)
+		existing, err := item.idx.variableGroup(targetOrgURL, targetProject, group.Name)
 		if err != nil {
 			return err
 		}
+		if existing != nil {
+			alt, ok, err := item.collide(sourceName, group.Name, func(n string) (bool, error) {
+				g, err := item.idx.variableGroup(targetOrgURL, targetProject, n)
+				return g != nil, err
+			})
+			if err != nil {
+				return err
+			}
+			if ok {
+				group.Name, existing = alt, nil
+			}
+		}
+
+		// secret values are never in the backup; they must be set by hand
+		var warnings []string
+		for name, variable := range group.Variables {
+			if variable.IsSecret {
+				warnings = append(warnings, fmt.Sprintf("secret '%s' not restored", name))
+			}
+		}
+		sort.Strings(warnings)
+		sourceID := strconv.Itoa(group.Id)
 
 		var groupID int
 
+		// variable groups are updated in place unless collisions are skipped
+		if existing != nil && opts.collision(KindVariableGroups) == CollisionSkip {
+			item.println("✔ Variable group exists, skipping:", group.Name)
+			item.exists(group.Name, sourceID, strconv.Itoa(existing.Id))
+			return nil
+		}
+
+		varNames := make([]string, 0, len(group.Variables))
+		for name := range group.Variables {
+			varNames = append(varNames, name)
+		}
+		sort.Strings(varNames)
+		for _, name := range varNames {
+			if v := group.Variables[name]; !v.IsSecret {
+				v.Value = item.rewriteValue("variables."+name+".value", v.Value)
+				group.Variables[name] = v
+			}
+		}
+
 		if existing != nil {
-			fmt.Println("Updating existing group:", group.Name)
+			nonSecret := map[string]Variable{}
+			for name, variable := range group.Variables {
+				if !variable.IsSecret {
+					nonSecret[name] = variable
+				}
+			}
+			if item.planned(group.Name, sourceID, ActionUpdate, map[string]any{"variables": nonSecret}, warnings) {
+				return nil
+			}
+
+			item.println("Updating existing group:", group.Name)
 			groupID = existing.Id
 		} else {
-			fmt.Println("Creating group:", group.Name)
+			if item.planned(group.Name, sourceID, ActionCreate, variableGroupCreateBody(group.Name, pinfo, group.Variables), warnings) {
+				return nil
+			}
+
+			item.println("Creating group:", group.Name)
 
 			groupID, err = CreateVariableGroup(
 				targetOrgURL,
 				targetProject,
+				pinfo,
 				group.Name,
 				group.Variables,
 			)
 			if err != nil {
+				item.done(group.Name, sourceID, ActionCreate, "", warnings, err)
 				return err
 			}
+			item.idx.addVariableGroup(targetOrgURL, targetProject, VariableGroup{Id: groupID, Name: group.Name})
+			item.done(group.Name, sourceID, ActionCreate, strconv.Itoa(groupID), warnings, nil)
+			item.created(sourceName, group.Name)
 
-			continue
+			return nil
 		}
 
 		for name, variable := range group.Variables {
 
 			if variable.IsSecret {
-				fmt.Println("⚠ Skipping secret:", name)
+				item.println("⚠ Skipping secret:", name)
 				continue
 			}
 
@@ -281,14 +415,20 @@ func RestoreVariableGroupsFromBackup(
 					false,
 				)
 				if err != nil {
+					item.done(group.Name, sourceID, ActionUpdate, strconv.Itoa(groupID), warnings, err)
 					return err
 				}
 			}
 		}
+		item.done(group.Name, sourceID, ActionUpdate, strconv.Itoa(groupID), warnings, nil)
+		return nil
+	})
+	if err != nil {
+		return run.results, err
 	}
 
-	fmt.Println("✔ Variable groups restored")
-	return nil
+	run.println("✔ Variable groups restored")
+	return run.results, nil
 }
 
 func FindVariableGroupByName(orgURL, project, name string) (*VariableGroup, error) {
@@ -307,16 +447,7 @@ func FindVariableGroupByName(orgURL, project, name string) (*VariableGroup, erro
 }
 
 func UpdateVariableInGroup(orgURL, project string, groupID int, name, value string) error {
-
-	args := []string{
-		"pipelines", "variable-group", "variable", "update",
-		"--group-id", fmt.Sprintf("%d", groupID),
-		"--name", name,
-		"--value", value,
-		"--organization", orgURL,
-		"--project", project,
-	}
-
-	cmd := exec.Command("az", args...)
-	return cmd.Run()
+	return updateVariableGroupVariables(orgURL, project, groupID, func(vars map[string]any) {
+		vars[name] = map[string]any{"value": value}
+	})
 }
@@ -4,12 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
-)
 
-type WikiListResponse struct {
-	Count int    `json:"count"`
-	Value []Wiki `json:"value"`
-}
+	"azdo-vault/internal/adoclient"
+)
 
 type Wiki struct {
 	ID           string         `json:"id,omitempty"`
@@ -60,16 +57,11 @@ func (w Wiki) MarshalJSON() ([]byte, error) {
 func ListWikis(orgURL, project, resourceGUID string) ([]Wiki, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/wiki/wikis?api-version=7.1", strings.TrimRight(orgURL, "/"), project)
 
-	out, err := azRest("get", uri, resourceGUID)
+	list, err := adoList[Wiki](orgURL, resourceGUID, uri, adoclient.Paging{})
 	if err != nil {
 		return nil, fmt.Errorf("list wikis failed: %w", err)
 	}
-
-	var resp WikiListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing wikis json: %w\nRaw:\n%s", err, string(out))
-	}
-	return resp.Value, nil
+	return list, nil
 }
 
 // CreateWiki creates a ProjectWiki or CodeWiki.
@@ -82,7 +74,7 @@ func CreateWiki(orgURL, project, resourceGUID string, payload map[string]any) (*
 		return nil, err
 	}
 
-	out, err := azRestWithBody("post", uri, resourceGUID, string(bodyBytes))
+	out, err := adoSend(orgURL, resourceGUID, "post", uri, bodyBytes)
 	if err != nil {
 		return nil, fmt.Errorf("create wiki failed: %w", err)
 	}
@@ -3,21 +3,26 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func BackupWikis(orgURL, project, backupPath string, selected []string, resourceGUID string) error {
+func BackupWikis(orgURL, project, backupPath string, selected []string, resourceGUID string, exec *ExecOptions) error {
 	wikis, err := ListWikis(orgURL, project, resourceGUID)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("Found %d wikis\n", len(wikis))
 	if err := os.MkdirAll(backupPath, 0755); err != nil {
 		return err
 	}
 
-	backupAll := len(selected) == 1 && strings.EqualFold(selected[0], "all")
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return err
+	}
 
 	// repos map so we can locate wiki repo remoteUrl for ProjectWiki
 	repos, err := ListRepos(orgURL, project)
@@ -29,11 +34,18 @@ func BackupWikis(orgURL, project, backupPath string, selected []string, resource
 		repoByID[strings.ToLower(r.Id)] = r
 	}
 
+	var items []Wiki
 	for _, w := range wikis {
-		if !backupAll && !contains(selected, w.Name) && !contains(selected, w.ID) {
+		if !sel.Match(w.Name, w.ID) {
 			continue
 		}
+		items = append(items, w)
+	}
+
+	seeds := mirrorSeeds(backupPath, KindWikis)
 
+	err = forEach(len(items), exec.workers(KindWikis), exec.failFast(true), func(i int, out io.Writer) error {
+		w := items[i]
 		// write wiki metadata json
 		fn := fmt.Sprintf("%s_%s.json", safeFilePart(w.ID), safeFilePart(w.Name))
 		fp := filepath.Join(backupPath, fn)
@@ -42,27 +54,32 @@ func BackupWikis(orgURL, project, backupPath string, selected []string, resource
 		if err != nil {
 			return err
 		}
-		if err := os.WriteFile(fp, b, 0644); err != nil {
+		if err := writeBackupFile(fp, b); err != nil {
 			return err
 		}
-		fmt.Println("✔ Backed up wiki metadata:", w.Name, "type=", w.Type)
+		fmt.Fprintln(out, "✔ Backed up wiki metadata:", w.Name, "type=", w.Type)
 
 		// If ProjectWiki => mirror clone its backing repo
 		if IsProjectWiki(w) && strings.TrimSpace(w.RepositoryID) != "" {
 			r, err := GetRepoByID(orgURL, project, w.RepositoryID, resourceGUID)
 			if err != nil || strings.TrimSpace(r.RemoteURL) == "" {
-				fmt.Printf("⚠ wiki '%s': repo remoteUrl not found via REST for repositoryId=%s: %v\n",
+				fmt.Fprintf(out, "⚠ wiki '%s': repo remoteUrl not found via REST for repositoryId=%s: %v\n",
 					w.Name, w.RepositoryID, err)
-				continue
+				return nil
 			}
 
-			destRepoDir := filepath.Join(backupPath, safeFilePart(w.Name)+".wiki.git")
-			if err := MirrorClone(r.RemoteURL, destRepoDir); err != nil {
-				fmt.Printf("⚠ wiki '%s': git mirror clone failed: %v\n", w.Name, err)
-				continue
+			mirror := safeFilePart(w.Name) + ".wiki.git"
+			destRepoDir := filepath.Join(backupPath, mirror)
+			if err := backupMirror(r.RemoteURL, destRepoDir, seedMirror(seeds, mirror), out); err != nil {
+				fmt.Fprintf(out, "⚠ wiki '%s': git mirror clone failed: %v\n", w.Name, err)
+				return nil
 			}
-			fmt.Println("✔ Backed up wiki repo:", w.Name, "->", destRepoDir)
+			fmt.Fprintln(out, "✔ Backed up wiki repo:", w.Name, "->", destRepoDir)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("✔ Wikis backup finished")
@@ -74,31 +91,33 @@ func RestoreWikisFromBackup(
 	targetOrgURL, targetProject, backupPath string,
 	selected []string,
 	resourceGUID string,
-) error {
+	opts *RestoreOptions,
+) ([]RestoreResult, error) {
+	run := newRestoreRun(KindWikis, opts)
 	files, err := os.ReadDir(backupPath)
 	if err != nil {
-		return err
+		return run.results, err
+	}
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return run.results, err
 	}
-	restoreAll := len(selected) == 1 && strings.EqualFold(selected[0], "all")
 
-	targetWikis, err := ListWikis(targetOrgURL, targetProject, resourceGUID)
+	targetWikis, err := run.idx.wikis(targetOrgURL, targetProject, resourceGUID)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 
 	// repos for mapping CodeWiki repositoryId (source repo -> name -> target repoId)
-	sourceRepos, err := ListRepos(sourceOrgURL, sourceProject)
+	refs, err := sourceRefs(backupPath, sourceOrgURL, sourceProject, resourceGUID)
 	if err != nil {
-		return fmt.Errorf("restore wikis: list source repos failed: %w", err)
-	}
-	sourceRepoNameByID := map[string]string{}
-	for _, r := range sourceRepos {
-		sourceRepoNameByID[strings.ToLower(r.Id)] = r.Name
+		return run.results, fmt.Errorf("restore wikis: %w", err)
 	}
+	sourceRepoNameByID := refs.Repos
 
-	targetRepos, err := ListRepos(targetOrgURL, targetProject)
+	targetRepos, err := run.idx.repos(targetOrgURL, targetProject)
 	if err != nil {
-		return fmt.Errorf("restore wikis: list target repos failed: %w", err)
+		return run.results, fmt.Errorf("restore wikis: list target repos failed: %w", err)
 	}
 	targetRepoIDByName := map[string]string{}
 	for _, r := range targetRepos {
@@ -109,16 +128,21 @@ func RestoreWikisFromBackup(
 		targetRepoByID[strings.ToLower(r.Id)] = r
 	}
 
+	var items []os.DirEntry
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name(), ".json") {
 			continue
 		}
-		if !restoreAll && !contains(selected, f.Name()) && !contains(selected, strings.TrimSuffix(f.Name(), ".json")) {
+		if !sel.Match(f.Name(), strings.TrimSuffix(f.Name(), ".json")) {
 			continue
 		}
+		items = append(items, f)
+	}
 
+	err = run.each(len(items), func(i int, item *restoreRun) error {
+		f := items[i]
 		fp := filepath.Join(backupPath, f.Name())
-		b, err := os.ReadFile(fp)
+		b, err := readBackupFile(fp)
 		if err != nil {
 			return err
 		}
@@ -128,12 +152,26 @@ func RestoreWikisFromBackup(
 			return err
 		}
 
-		if FindWikiByName(targetWikis, w.Name) != nil {
-			fmt.Println("✔ Wiki exists, skipping:", w.Name)
-			continue
+		sourceName := w.Name
+		w.Name = item.rename(sourceName)
+
+		if existing := FindWikiByName(targetWikis, w.Name); existing != nil {
+			alt, ok, err := item.collide(sourceName, w.Name, func(n string) (bool, error) {
+				current, err := item.idx.wikis(targetOrgURL, targetProject, resourceGUID)
+				return FindWikiByName(current, n) != nil, err
+			})
+			if err != nil {
+				return err
+			}
+			if !ok {
+				item.println("✔ Wiki exists, skipping:", w.Name)
+				item.exists(w.Name, w.ID, existing.ID)
+				return nil
+			}
+			w.Name = alt
 		}
 
-		proj, err := GetProjectInfo(targetOrgURL, targetProject, resourceGUID)
+		proj, err := item.idx.project(targetOrgURL, targetProject, resourceGUID)
 		if err != nil {
 			return err
 		}
@@ -150,13 +188,16 @@ func RestoreWikisFromBackup(
 			// Map repo by name from source repoId -> source repoName -> target repoId
 			srcRepoName := sourceRepoNameByID[strings.ToLower(w.RepositoryID)]
 			if strings.TrimSpace(srcRepoName) == "" {
-				fmt.Printf("⚠ CodeWiki '%s': source repositoryId not found in source project; skipping\n", w.Name)
-				continue
+				item.printf("⚠ CodeWiki '%s': source repositoryId not found in source project; skipping\n", w.Name)
+				item.unresolvable(w.Name, w.ID, "source repositoryId not found in source project")
+				return nil
 			}
+			srcRepoName = item.names.Name(KindRepos, srcRepoName)
 			targetRepoID := targetRepoIDByName[strings.ToLower(srcRepoName)]
 			if strings.TrimSpace(targetRepoID) == "" {
-				fmt.Printf("⚠ CodeWiki '%s': target repo '%s' not found; skipping\n", w.Name, srcRepoName)
-				continue
+				item.printf("⚠ CodeWiki '%s': target repo '%s' not found; skipping\n", w.Name, srcRepoName)
+				item.unresolvable(w.Name, w.ID, fmt.Sprintf("target repo '%s' not found", srcRepoName))
+				return nil
 			}
 
 			payload["repositoryId"] = targetRepoID
@@ -168,43 +209,59 @@ func RestoreWikisFromBackup(
 			}
 		}
 
-		fmt.Println("Creating wiki:", w.Name, "type=", w.Type)
+		if item.planned(w.Name, w.ID, ActionCreate, payload, nil) {
+			return nil
+		}
+
+		item.println("Creating wiki:", w.Name, "type=", w.Type)
 		created, err := CreateWiki(targetOrgURL, targetProject, resourceGUID, payload)
 		if err != nil {
-			fmt.Printf("⚠ Failed creating wiki '%s': %v\n", w.Name, err)
-			continue
+			item.printf("⚠ Failed creating wiki '%s': %v\n", w.Name, err)
+			item.done(w.Name, w.ID, ActionCreate, "", nil, err)
+			return nil
 		}
-		fmt.Println("✔ Created wiki:", created.Name)
+		item.println("✔ Created wiki:", created.Name)
+		item.idx.addWiki(targetOrgURL, targetProject, *created)
+		item.done(w.Name, w.ID, ActionCreate, created.ID, nil, nil)
+		item.created(sourceName, w.Name)
 
 		// If ProjectWiki: push mirrored repo content into created.RepositoryID
 		if IsProjectWiki(w) {
-			srcMirrorDir := filepath.Join(backupPath, safeFilePart(w.Name)+".wiki.git")
-			if _, err := os.Stat(srcMirrorDir); err != nil {
-				fmt.Printf("⚠ ProjectWiki '%s': mirror repo dir not found (%s); skipping git push\n", w.Name, srcMirrorDir)
-				continue
+			srcMirrorDir, done, err := openMirror(filepath.Join(backupPath, safeFilePart(sourceName)+".wiki.git"))
+			if err != nil {
+				item.printf("⚠ ProjectWiki '%s': mirror repo not found (%v); skipping git push\n", w.Name, err)
+				item.warn("mirror repo not found; content not pushed")
+				return nil
 			}
+			defer done()
 
 			if strings.TrimSpace(created.RepositoryID) == "" {
-				fmt.Printf("⚠ ProjectWiki '%s': created wiki missing repositoryId; cannot push\n", w.Name)
-				continue
+				item.printf("⚠ ProjectWiki '%s': created wiki missing repositoryId; cannot push\n", w.Name)
+				item.warn("created wiki missing repositoryId; content not pushed")
+				return nil
 			}
 
 			tr, err := GetRepoByID(targetOrgURL, targetProject, created.RepositoryID, resourceGUID)
 			if err != nil || strings.TrimSpace(tr.RemoteURL) == "" {
-				fmt.Printf("⚠ ProjectWiki '%s': target wiki repo remoteUrl not found via REST for repositoryId=%s: %v\n",
+				item.printf("⚠ ProjectWiki '%s': target wiki repo remoteUrl not found via REST for repositoryId=%s: %v\n",
 					w.Name, created.RepositoryID, err)
-				continue
+				item.warn("target wiki repo remoteUrl not found; content not pushed")
+				return nil
 			}
 
 			if err := MirrorPush(srcMirrorDir, tr.RemoteURL); err != nil {
-				fmt.Printf("⚠ ProjectWiki '%s': git mirror push failed: %v\n", w.Name, err)
-				continue
+				item.printf("⚠ ProjectWiki '%s': git mirror push failed: %v\n", w.Name, err)
+				item.warn("git mirror push failed: " + err.Error())
+				return nil
 			}
-			fmt.Println("✔ Pushed wiki repo:", w.Name)
-
+			item.println("✔ Pushed wiki repo:", w.Name)
 		}
+		return nil
+	})
+	if err != nil {
+		return run.results, err
 	}
 
-	fmt.Println("✔ Wikis restore finished")
-	return nil
+	run.println("✔ Wikis restore finished")
+	return run.results, nil
 }
@@ -3,16 +3,14 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
-)
 
-type PipelineListResponse struct {
-	Count int        `json:"count"`
-	Value []Pipeline `json:"value"`
-}
+	"azdo-vault/internal/adoclient"
+)
 
 type Pipeline struct {
 	Id   int            `json:"id,omitempty"`
@@ -48,41 +46,20 @@ func (p Pipeline) MarshalJSON() ([]byte, error) {
 func ListPipelines(orgURL, project, resourceGUID string) ([]Pipeline, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/pipelines?api-version=7.1", orgURL, project)
 
-	cmd := exec.Command("az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	list, err := adoList[Pipeline](orgURL, resourceGUID, uri, adoclient.Paging{})
 	if err != nil {
-		return nil, fmt.Errorf("az rest list pipelines failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("list pipelines failed: %w", err)
 	}
-
-	var resp PipelineListResponse
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, fmt.Errorf("failed parsing JSON: %w\nRaw:\n%s", err, string(out))
-	}
-	return resp.Value, nil
+	return list, nil
 }
 
 // GET /_apis/pipelines/{id}?api-version=7.1
 func GetPipeline(orgURL, project, resourceGUID string, id int) (map[string]any, error) {
 	uri := fmt.Sprintf("%s/%s/_apis/pipelines/%d?api-version=7.1", orgURL, project, id)
 
-	cmd := exec.Command("az", "rest",
-		"--method", "get",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoGet(orgURL, resourceGUID, uri)
 	if err != nil {
-		return nil, fmt.Errorf("az rest get pipeline failed: %w\n%s", err, string(out))
+		return nil, fmt.Errorf("get pipeline failed: %w", err)
 	}
 
 	var obj map[string]any
@@ -103,34 +80,43 @@ func isYamlPipeline(p map[string]any) bool {
 	return false
 }
 
-func BackupYamlPipelines(orgURL, project, backupPath string, selected []string, resourceGUID string) error {
+func BackupYamlPipelines(orgURL, project, backupPath string, selected []string, resourceGUID string, exec *ExecOptions) error {
 	list, err := ListPipelines(orgURL, project, resourceGUID)
 	if err != nil {
 		return err
 	}
 
-	backupAll := len(selected) == 1 && selected[0] == "all"
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return err
+	}
 	if len(list) == 0 {
 		fmt.Println("No pipelines found")
 		return nil
 	}
+	fmt.Printf("Found %d pipelines\n", len(list))
 
 	if err := os.MkdirAll(backupPath, 0755); err != nil {
 		return err
 	}
 
+	var items []Pipeline
 	for _, p := range list {
-		if !backupAll && !contains(selected, p.Name) {
+		if !sel.MatchIn(pickString(p.Raw["folder"]), p.Name) {
 			continue
 		}
+		items = append(items, p)
+	}
 
+	err = forEach(len(items), exec.workers(KindYamlPipelines), exec.failFast(true), func(i int, out io.Writer) error {
+		p := items[i]
 		full, err := GetPipeline(orgURL, project, resourceGUID, p.Id)
 		if err != nil {
 			return err
 		}
 
 		if !isYamlPipeline(full) {
-			continue
+			return nil
 		}
 
 		fp := filepath.Join(backupPath, p.Name+".json")
@@ -138,11 +124,15 @@ func BackupYamlPipelines(orgURL, project, backupPath string, selected []string,
 		if err != nil {
 			return err
 		}
-		if err := os.WriteFile(fp, data, 0644); err != nil {
+		if err := writeBackupFile(fp, data); err != nil {
 			return err
 		}
 
-		fmt.Println("✔ Backed up YAML pipeline:", p.Name)
+		fmt.Fprintln(out, "✔ Backed up YAML pipeline:", p.Name)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -192,19 +182,9 @@ func CreateYamlPipeline(orgURL, project, resourceGUID string, payload map[string
 
 	uri := fmt.Sprintf("%s/%s/_apis/pipelines?api-version=7.1", orgURL, project)
 
-	cmd := exec.Command("az", "rest",
-		"--method", "post",
-		"--uri", uri,
-		"--resource", resourceGUID,
-		"--headers", "Content-Type=application/json",
-		"--body", string(bodyBytes),
-		"--output", "json",
-		"--only-show-errors",
-	)
-
-	out, err := cmd.CombinedOutput()
+	out, err := adoSend(orgURL, resourceGUID, "post", uri, bodyBytes)
 	if err != nil {
-		return 0, fmt.Errorf("az rest create yaml pipeline failed: %w\n%s", err, string(out))
+		return 0, fmt.Errorf("create yaml pipeline failed: %w", err)
 	}
 
 	var created map[string]any
@@ -222,32 +202,56 @@ func RestoreYamlPipelinesFromBackup(
 	targetOrgURL, targetProject, backupPath string,
 	selected []string,
 	resourceGUID string,
-	targetRepos []Repo,
-) error {
+	opts *RestoreOptions,
+) ([]RestoreResult, error) {
+	run := newRestoreRun(KindYamlPipelines, opts)
 	files, err := os.ReadDir(backupPath)
 	if err != nil {
-		return err
+		return run.results, err
 	}
 
-	restoreAll := len(selected) == 1 && selected[0] == "all"
+	sel, err := ParseSelector(selected)
+	if err != nil {
+		return run.results, err
+	}
 
+	// Need target repos to map repoName -> repoId
+	targetRepos, err := run.idx.repos(targetOrgURL, targetProject)
+	if err != nil {
+		return run.results, fmt.Errorf("failed listing target repos: %w", err)
+	}
 	repoIdByName := map[string]string{}
 	for _, r := range targetRepos {
 		repoIdByName[r.Name] = r.Id
 	}
 
+	refs, err := sourceRefs(backupPath, sourceOrgURL, sourceProject, resourceGUID)
+	if err != nil {
+		return run.results, err
+	}
+	if err := run.rewriteFrom(refs, targetOrgURL, targetProject, resourceGUID); err != nil {
+		return run.results, err
+	}
+
+	var items []os.DirEntry
 	for _, f := range files {
 		if !strings.HasSuffix(f.Name(), ".json") {
 			continue
 		}
 
 		pipelineName := strings.TrimSuffix(f.Name(), ".json")
-		if !restoreAll && !contains(selected, pipelineName) {
+		if !sel.matchFile(filepath.Join(backupPath, f.Name()), pipelineName) {
 			continue
 		}
+		items = append(items, f)
+	}
+
+	err = run.each(len(items), func(i int, item *restoreRun) error {
+		f := items[i]
+		pipelineName := strings.TrimSuffix(f.Name(), ".json")
 
 		fp := filepath.Join(backupPath, f.Name())
-		b, err := os.ReadFile(fp)
+		b, err := readBackupFile(fp)
 		if err != nil {
 			return err
 		}
@@ -257,29 +261,42 @@ func RestoreYamlPipelinesFromBackup(
 			return err
 		}
 
+		sourceID := strconv.Itoa(intFromAny(full["id"]))
 		payload := sanitizeYamlPipelineForCreate(full)
 
+		sourceName := pipelineName
+		pipelineName = item.rename(sourceName)
+		payload["name"] = pipelineName
+		if folder, _ := payload["folder"].(string); folder != "" {
+			if moved := item.names.Folder(folder); moved != folder {
+				item.printf("Moving YAML pipeline '%s' from folder '%s' to '%s'\n", pipelineName, folder, moved)
+				payload["folder"] = moved
+			}
+		}
+
 		repoName, repoID := extractRepoNameAndID(full)
 
 		if repoName == "" && repoID != "" {
-			n, err := ResolveSourceRepoNameByID(sourceOrgURL, sourceProject, repoID, resourceGUID)
-			if err != nil {
-				fmt.Printf("⚠ Skipping pipeline '%s': could not resolve source repo name from id '%s'\n%s\n",
-					pipelineName, repoID, err.Error())
-				continue
+			repoName = refs.repoName(repoID)
+			if repoName == "" {
+				item.printf("⚠ Skipping pipeline '%s': could not resolve source repo name from id '%s'\n", pipelineName, repoID)
+				item.unresolvable(pipelineName, sourceID, fmt.Sprintf("could not resolve source repo name from id '%s'", repoID))
+				return nil
 			}
-			repoName = n
 		}
 
 		if repoName == "" {
-			fmt.Printf("⚠ Skipping pipeline '%s': repo name not found (id='%s')\n", pipelineName, repoID)
-			continue
+			item.printf("⚠ Skipping pipeline '%s': repo name not found (id='%s')\n", pipelineName, repoID)
+			item.unresolvable(pipelineName, sourceID, fmt.Sprintf("repo name not found (id='%s')", repoID))
+			return nil
 		}
 
+		repoName = item.names.Name(KindRepos, repoName)
 		targetRepoID, ok := repoIdByName[repoName]
 		if !ok {
-			fmt.Printf("⚠ Skipping pipeline '%s': repo '%s' not found in target project\n", pipelineName, repoName)
-			continue
+			item.printf("⚠ Skipping pipeline '%s': repo '%s' not found in target project\n", pipelineName, repoName)
+			item.unresolvable(pipelineName, sourceID, fmt.Sprintf("repo '%s' not found in target project", repoName))
+			return nil
 		}
 
 		cfg := payload["configuration"].(map[string]any)
@@ -287,24 +304,106 @@ func RestoreYamlPipelinesFromBackup(
 		repo["id"] = targetRepoID
 		repo["name"] = repoName
 
-		existing, err := FindPipelineByName(targetOrgURL, targetProject, resourceGUID, pipelineName)
+		existing, err := item.idx.pipeline(targetOrgURL, targetProject, resourceGUID, pipelineName)
 		if err != nil {
 			return err
 		}
 		if existing != nil {
-			fmt.Println("✔ YAML pipeline exists, skipping:", pipelineName)
-			continue
+			alt, ok, err := item.collide(sourceName, pipelineName, func(n string) (bool, error) {
+				p, err := item.idx.pipeline(targetOrgURL, targetProject, resourceGUID, n)
+				return p != nil, err
+			})
+			if err != nil {
+				return err
+			}
+			if ok {
+				pipelineName, existing = alt, nil
+				payload["name"] = alt
+			}
+		}
+		if existing != nil && !opts.updates(KindYamlPipelines) {
+			item.println("✔ YAML pipeline exists, skipping:", pipelineName)
+			item.exists(pipelineName, sourceID, strconv.Itoa(existing.Id))
+			return nil
+		}
+		if existing == nil && !opts.creates(KindYamlPipelines) {
+			item.println("YAML pipeline not in target, skipping:", pipelineName)
+			item.missing(pipelineName, sourceID)
+			return nil
 		}
 
-		fmt.Println("Creating YAML pipeline:", pipelineName)
-		if _, err := CreateYamlPipeline(targetOrgURL, targetProject, resourceGUID, payload); err != nil {
-			fmt.Printf("⚠ Failed creating YAML pipeline '%s'.\n%s\n", pipelineName, err.Error())
-			continue
+		item.rewrite(payload)
+
+		if existing != nil {
+			if item.planned(pipelineName, sourceID, ActionUpdate, payload, nil) {
+				return nil
+			}
+			item.println("Updating YAML pipeline:", pipelineName)
+			err := UpdateYamlPipeline(targetOrgURL, targetProject, resourceGUID, existing.Id, payload)
+			if err != nil {
+				item.printf("⚠ Failed updating YAML pipeline '%s'.\n%s\n", pipelineName, err.Error())
+			}
+			item.done(pipelineName, sourceID, ActionUpdate, strconv.Itoa(existing.Id), nil, err)
+			return nil
+		}
+
+		if item.planned(pipelineName, sourceID, ActionCreate, payload, nil) {
+			return nil
+		}
+
+		item.println("Creating YAML pipeline:", pipelineName)
+		id, err := CreateYamlPipeline(targetOrgURL, targetProject, resourceGUID, payload)
+		if err != nil {
+			item.printf("⚠ Failed creating YAML pipeline '%s'.\n%s\n", pipelineName, err.Error())
+			item.done(pipelineName, sourceID, ActionCreate, "", nil, err)
+			return nil
 		}
+		item.idx.addPipeline(targetOrgURL, targetProject, id, pipelineName)
+		item.done(pipelineName, sourceID, ActionCreate, strconv.Itoa(id), nil, nil)
+		item.created(sourceName, pipelineName)
+		return nil
+	})
+	if err != nil {
+		return run.results, err
 	}
 
-	fmt.Println("✔ YAML pipelines restore finished")
-	return nil
+	run.println("✔ YAML pipelines restore finished")
+	return run.results, nil
+}
+
+// UpdateYamlPipeline points pipeline id at the YAML file, repository and
+// folder of payload (as built by sanitizeYamlPipelineForCreate).
+// The Pipelines API has no update, so the build definition behind the
+// pipeline is updated instead, at its current revision.
+func UpdateYamlPipeline(orgURL, project, resourceGUID string, id int, payload map[string]any) error {
+	def, err := GetBuildDefinition(orgURL, project, resourceGUID, id)
+	if err != nil {
+		return err
+	}
+
+	cfg, _ := payload["configuration"].(map[string]any)
+	repo, _ := cfg["repository"].(map[string]any)
+
+	process, _ := def.Raw["process"].(map[string]any)
+	if process == nil {
+		process = map[string]any{"type": 2} // YAML
+		def.Raw["process"] = process
+	}
+	process["yamlFilename"] = cfg["path"]
+
+	defRepo, _ := def.Raw["repository"].(map[string]any)
+	if defRepo == nil {
+		defRepo = map[string]any{"type": "TfsGit"}
+		def.Raw["repository"] = defRepo
+	}
+	defRepo["id"] = repo["id"]
+	defRepo["name"] = repo["name"]
+
+	if folder, ok := payload["folder"].(string); ok && folder != "" {
+		def.Raw["path"] = folder
+	}
+
+	return UpdateBuildDefinition(orgURL, project, resourceGUID, id, def.Revision, def.Raw)
 }
 
 func FindPipelineByName(orgURL, project, resourceGUID, name string) (*Pipeline, error) {